@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/syntax"
 )
@@ -33,6 +34,9 @@ type APIClient struct {
 
 	// optional authenticated account DID for this client. Does not change client behavior; this field is included as a convenience for calling code, logging, etc.
 	AccountDID *syntax.DID
+
+	// Optional policy for retrying failed requests, and rate-limiting requests. If nil, each request is attempted only once (though auth methods may retry internally).
+	Retry *RetryPolicy
 }
 
 // Creates a simple APIClient for the provided host. This is appropriate for use with unauthenticated ("public") atproto API endpoints, or to use as a base client to add authentication.
@@ -135,6 +139,8 @@ func (c *APIClient) Post(ctx context.Context, endpoint syntax.NSID, body any, ou
 
 // Full-featured method for atproto API requests.
 //
+// If the client has a [RetryPolicy] configured, failed requests may be retried, and the final response (or error) is returned.
+//
 // TODO: this does not currently parse API error response JSON body to [APIError], thought it might in the future.
 func (c *APIClient) Do(ctx context.Context, req *APIRequest) (*http.Response, error) {
 
@@ -147,16 +153,62 @@ func (c *APIClient) Do(ctx context.Context, req *APIRequest) (*http.Response, er
 		return nil, err
 	}
 
-	var resp *http.Response
-	if c.Auth != nil {
-		resp, err = c.Auth.DoWithAuth(c.Client, httpReq, req.Endpoint)
-	} else {
-		resp, err = c.Client.Do(httpReq)
+	if c.Retry == nil {
+		return c.doOnce(httpReq, req.Endpoint)
 	}
-	if err != nil {
-		return nil, err
+
+	p := c.Retry
+	host := httpReq.URL.Host
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			httpReq = httpReq.Clone(ctx)
+			if httpReq.GetBody != nil {
+				httpReq.Body, err = httpReq.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("API request retry GetBody failed: %w", err)
+				}
+			}
+		}
+
+		if err := p.waitForHost(ctx, host, req.Endpoint); err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := c.doOnce(httpReq, req.Endpoint)
+		info := RequestAttempt{
+			Host:     host,
+			Method:   httpReq.Method,
+			Endpoint: req.Endpoint,
+			Attempt:  attempt,
+			Duration: time.Since(start),
+			Response: resp,
+			Err:      err,
+		}
+		if p.Hooks.OnAttempt != nil {
+			p.Hooks.OnAttempt(info)
+		}
+		p.updateHost(host, req.Endpoint, resp)
+
+		delay, retry := p.retryDelay(httpReq, req.Idempotent, attempt, resp, err)
+		if !retry {
+			return resp, err
+		}
+		if p.Hooks.OnRetry != nil {
+			p.Hooks.OnRetry(info, delay)
+		}
+		discardResponse(resp)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *APIClient) doOnce(httpReq *http.Request, endpoint syntax.NSID) (*http.Response, error) {
+	if c.Auth != nil {
+		return c.Auth.DoWithAuth(c.Client, httpReq, endpoint)
 	}
-	return resp, nil
+	return c.Client.Do(httpReq)
 }
 
 // Returns a shallow copy of the APIClient with the provided service ref configured as a proxy header.
@@ -171,6 +223,7 @@ func (c *APIClient) WithService(ref string) *APIClient {
 		Auth:       c.Auth,
 		Headers:    hdr,
 		AccountDID: c.AccountDID,
+		Retry:      c.Retry,
 	}
 	return &out
 }
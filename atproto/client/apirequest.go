@@ -36,6 +36,9 @@ type APIRequest struct {
 
 	// Optional HTTP headers (field bay be nil). Only the first value will be included for each header key ("Set" behavior).
 	Headers http.Header
+
+	// Indicates that a Procedure request is safe to repeat, and can be retried by [RetryPolicy]. Query requests are always considered idempotent.
+	Idempotent bool
 }
 
 // Initializes a new request struct. Initializes Headers and QueryParams so they can be manipulated immediately.
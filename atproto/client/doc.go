@@ -10,6 +10,8 @@ The [AuthMethod] interface allows [APIClient] to work with multiple forms of aut
 - [PasswordAuth] is the original PDS user auth method, using access and refresh tokens.
 - [AdminAuth] is simple HTTP Basic authentication for administrative requests, as implemented by many atproto services (Relay, Ozone, PDS, etc).
 
+Requests are attempted only once by default. Setting [APIClient.Retry] to a [RetryPolicy] enables retries of network failures and 429/5xx responses, with exponential backoff and jitter. The policy waits for rate-limit resets indicated by the server ('Retry-After' and 'ratelimit-*' response headers), and can also apply a client-side rate-limit to each host. Queries are always retried, while Procedures are only retried if marked as [APIRequest.Idempotent] (or if the policy is configured to retry all Procedures). The [RetryHooks] callbacks can be used for metrics.
+
 ## Design Notes
 
 Several [AuthMethod] implementations are expected to require retrying entire request at unexpected times. For example, unexpected OAuth DPoP nonce changes, or unexpected password session token refreshes. The auth method may also need to make requests to other servers as part of the refresh process (eg, OAuth when working with a PDS/entryway split). This means that requests should be "retryable" as often as possible. This is mostly a concern for Procedures (HTTP POST) with a non-empty body. The [http.Client] will attempt to "unclose" some common [io.ReadCloser] types (like [bytes.Buffer]), but others may need special handling, using the [APIRequest.GetBody] method. This package will try to make types implementing [io.Seeker] tryable; this helps with things like passing in a open file descriptor for file uploads.
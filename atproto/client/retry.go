@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"golang.org/x/time/rate"
+)
+
+// Configuration for automatic retries of API requests, with exponential backoff, support for server rate-limit headers, and optional client-side rate-limits.
+//
+// Query (HTTP GET) requests are always considered safe to retry. Procedure (HTTP POST) requests are only retried if they are marked [APIRequest.Idempotent], or if `RetryProcedures` is set.
+//
+// A single policy can be shared between multiple [APIClient] instances (eg, clients for many different hosts in a bulk tool, or copies from [APIClient.WithService]); client-side rate-limits are tracked per-host, and server rate-limits per-host and per-endpoint (PDS rate-limit headers are specific to a route, and often to an account). The struct should not be copied after first use.
+type RetryPolicy struct {
+	// Maximum number of retries after the initial attempt. Zero means requests are not retried (though client-side rate-limits still apply).
+	MaxRetries int
+
+	// Base delay for exponential backoff (doubled on each retry), and the maximum delay. Actual delays include random jitter.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Maximum time to wait for a server rate-limit to reset (from 'Retry-After' or 'ratelimit-reset' headers). If the server indicates a longer wait, the response is returned to the caller instead of retrying.
+	MaxRateLimitWait time.Duration
+
+	// Retry all Procedure requests, not just those marked as idempotent. Only appropriate if the calling code is sure that repeated requests are safe (eg, record writes with explicit rkey and swap CIDs).
+	RetryProcedures bool
+
+	// Optional client-side rate-limit (token bucket) for requests to each host. Zero means no client-side limit.
+	HostRateLimit rate.Limit
+	HostBurst     int
+
+	// Optional callbacks for metrics and logging
+	Hooks RetryHooks
+
+	lk    sync.Mutex
+	hosts map[string]*hostLimitState
+}
+
+// Callback functions which are invoked during requests, for metrics or logging. All fields are optional. Callbacks are called synchronously in the request path, and should return quickly.
+type RetryHooks struct {
+	// Called after every HTTP request attempt, successful or not. The response body should not be read.
+	OnAttempt func(info RequestAttempt)
+
+	// Called when a request is going to be retried, with the delay before the next attempt.
+	OnRetry func(info RequestAttempt, delay time.Duration)
+
+	// Called when a request is delayed because of a client-side rate-limit, or a server rate-limit which was previously exhausted.
+	OnThrottle func(host string, endpoint syntax.NSID, delay time.Duration)
+}
+
+// Details of a single HTTP request attempt, passed to [RetryHooks].
+type RequestAttempt struct {
+	Host     string
+	Method   string
+	Endpoint syntax.NSID
+	// Zero for the initial attempt; incremented for each retry
+	Attempt  int
+	Duration time.Duration
+	Response *http.Response
+	Err      error
+}
+
+type hostLimitState struct {
+	limiter *rate.Limiter
+	// server rate-limit for each endpoint was exhausted, until this time
+	blockedUntil map[syntax.NSID]time.Time
+}
+
+// Returns a policy with reasonable defaults for API clients: a few retries with short backoff, waiting up to a minute for server rate-limit resets, and no client-side rate-limit.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:       3,
+		MinBackoff:       500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		MaxRateLimitWait: 60 * time.Second,
+	}
+}
+
+func (p *RetryPolicy) hostState(host string) *hostLimitState {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if p.hosts == nil {
+		p.hosts = make(map[string]*hostLimitState)
+	}
+	hs, ok := p.hosts[host]
+	if !ok {
+		hs = &hostLimitState{}
+		if p.HostRateLimit > 0 {
+			burst := p.HostBurst
+			if burst <= 0 {
+				burst = 1
+			}
+			hs.limiter = rate.NewLimiter(p.HostRateLimit, burst)
+		}
+		p.hosts[host] = hs
+	}
+	return hs
+}
+
+// blocks until the host can be sent another request, based on client-side rate-limit and server rate-limit headers seen previously
+func (p *RetryPolicy) waitForHost(ctx context.Context, host string, endpoint syntax.NSID) error {
+	hs := p.hostState(host)
+
+	p.lk.Lock()
+	wait := time.Until(hs.blockedUntil[endpoint])
+	p.lk.Unlock()
+
+	// if the server limit won't reset for a long time, go ahead and let the server respond
+	if wait > 0 && wait <= p.MaxRateLimitWait {
+		if p.Hooks.OnThrottle != nil {
+			p.Hooks.OnThrottle(host, endpoint, wait)
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if hs.limiter != nil {
+		start := time.Now()
+		if err := hs.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		if d := time.Since(start); d > time.Millisecond && p.Hooks.OnThrottle != nil {
+			p.Hooks.OnThrottle(host, endpoint, d)
+		}
+	}
+	return nil
+}
+
+// records server rate-limit state for the endpoint from response headers
+func (p *RetryPolicy) updateHost(host string, endpoint syntax.NSID, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	reset, ok := rateLimitReset(resp, time.Now())
+	if !ok {
+		return
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.Header.Get("ratelimit-remaining") != "0" {
+		return
+	}
+	hs := p.hostState(host)
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if hs.blockedUntil == nil {
+		hs.blockedUntil = make(map[syntax.NSID]time.Time)
+	}
+	if reset.After(hs.blockedUntil[endpoint]) {
+		hs.blockedUntil[endpoint] = reset
+	}
+}
+
+// computes how long to wait before retrying a request, or returns false if it should not be retried
+func (p *RetryPolicy) retryDelay(req *http.Request, idempotent bool, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxRetries {
+		return 0, false
+	}
+	if req.Method != http.MethodGet && !idempotent && !p.RetryProcedures {
+		return 0, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// request body can't be re-read
+		return 0, false
+	}
+
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+		// auth methods may return API errors directly (eg, failed session refresh)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return 0, false
+		}
+		return p.backoff(attempt), true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		if reset, ok := rateLimitReset(resp, time.Now()); ok {
+			wait := time.Until(reset)
+			if wait > p.MaxRateLimitWait {
+				return 0, false
+			}
+			return max(wait, 0), true
+		}
+		return p.backoff(attempt), true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return p.backoff(attempt), true
+	}
+	return 0, false
+}
+
+// exponential backoff, with "equal" jitter (random delay between half and all of the computed backoff)
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff << attempt
+	if d <= 0 || (p.MaxBackoff > 0 && d > p.MaxBackoff) {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// Determines when a server rate-limit resets, from the 'Retry-After' header (seconds or HTTP date), or 'ratelimit-reset' header (as sent by PDS implementations: UNIX timestamp in seconds; small values are interpreted as seconds from now).
+func rateLimitReset(resp *http.Response, now time.Time) (time.Time, bool) {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return now.Add(time.Duration(n) * time.Second), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t, true
+		}
+	}
+	if v := resp.Header.Get("ratelimit-reset"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			// anything before 2001 is not a timestamp
+			if n < 1_000_000_000 {
+				return now.Add(time.Duration(n) * time.Second), true
+			}
+			return time.Unix(n, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drains and closes a response body, so the connection can be re-used for a retry
+func discardResponse(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
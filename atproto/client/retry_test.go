@@ -0,0 +1,273 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:       3,
+		MinBackoff:       time.Millisecond,
+		MaxBackoff:       5 * time.Millisecond,
+		MaxRateLimitWait: 2 * time.Second,
+	}
+}
+
+// test server which fails the first `failures` requests with the given status code and headers
+func failingServer(failures int64, status int, hdr http.Header) (*httptest.Server, *atomic.Int64) {
+	var count atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := count.Add(1)
+		if r.Body != nil {
+			b, _ := io.ReadAll(r.Body)
+			if r.Method == http.MethodPost && string(b) != `{"a":"b"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		if n <= failures {
+			for k := range hdr {
+				w.Header().Set(k, hdr.Get(k))
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"Failure"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	return srv, &count
+}
+
+func TestRetryQuery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	endpoint := syntax.NSID("com.example.get")
+
+	srv, count := failingServer(2, http.StatusBadGateway, nil)
+	defer srv.Close()
+
+	var attempts, retries int
+	c := NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	c.Retry.Hooks.OnAttempt = func(info RequestAttempt) { attempts++ }
+	c.Retry.Hooks.OnRetry = func(info RequestAttempt, delay time.Duration) {
+		assert.Equal(endpoint, info.Endpoint)
+		assert.Equal(http.StatusBadGateway, info.Response.StatusCode)
+		retries++
+	}
+
+	var out map[string]any
+	assert.NoError(c.Get(ctx, endpoint, nil, &out))
+	assert.Equal(true, out["ok"])
+	assert.Equal(int64(3), count.Load())
+	assert.Equal(3, attempts)
+	assert.Equal(2, retries)
+
+	// gives up after max retries, returning the final response
+	srv, count = failingServer(10, http.StatusInternalServerError, nil)
+	defer srv.Close()
+	c = NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	err := c.Get(ctx, endpoint, nil, nil)
+	assert.Error(err)
+	assert.Equal(500, err.(*APIError).StatusCode)
+	assert.Equal(int64(4), count.Load())
+
+	// client errors are not retried
+	srv, count = failingServer(1, http.StatusBadRequest, nil)
+	defer srv.Close()
+	c = NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	assert.Error(c.Get(ctx, endpoint, nil, nil))
+	assert.Equal(int64(1), count.Load())
+}
+
+func TestRetryProcedure(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	endpoint := syntax.NSID("com.example.post")
+	body := map[string]string{"a": "b"}
+
+	// procedures are not retried by default
+	srv, count := failingServer(1, http.StatusServiceUnavailable, nil)
+	defer srv.Close()
+	c := NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	assert.Error(c.Post(ctx, endpoint, body, nil))
+	assert.Equal(int64(1), count.Load())
+
+	// unless marked idempotent; body is re-sent on retry
+	srv, count = failingServer(1, http.StatusServiceUnavailable, nil)
+	defer srv.Close()
+	c = NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	req := NewAPIRequest(MethodProcedure, endpoint, bytes.NewReader([]byte(`{"a":"b"}`)))
+	req.Headers.Set("Content-Type", "application/json")
+	req.Idempotent = true
+	resp, err := c.Do(ctx, req)
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(int64(2), count.Load())
+
+	// or the policy retries all procedures
+	srv, count = failingServer(1, http.StatusServiceUnavailable, nil)
+	defer srv.Close()
+	c = NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	c.Retry.RetryProcedures = true
+	assert.NoError(c.Post(ctx, endpoint, body, nil))
+	assert.Equal(int64(2), count.Load())
+}
+
+func TestRetryRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	endpoint := syntax.NSID("com.example.get")
+
+	hdr := http.Header{}
+	hdr.Set("Retry-After", "1")
+	srv, count := failingServer(1, http.StatusTooManyRequests, hdr)
+	defer srv.Close()
+
+	var delays []time.Duration
+	c := NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	c.Retry.Hooks.OnRetry = func(info RequestAttempt, delay time.Duration) { delays = append(delays, delay) }
+	start := time.Now()
+	assert.NoError(c.Get(ctx, endpoint, nil, nil))
+	assert.Equal(int64(2), count.Load())
+	assert.GreaterOrEqual(time.Since(start), 900*time.Millisecond)
+	assert.Len(delays, 1)
+	assert.Greater(delays[0], 500*time.Millisecond)
+
+	// waits longer than the policy allows are not retried
+	hdr.Set("Retry-After", "3600")
+	srv, count = failingServer(1, http.StatusTooManyRequests, hdr)
+	defer srv.Close()
+	c = NewAPIClient(srv.URL)
+	c.Retry = testRetryPolicy()
+	assert.Error(c.Get(ctx, endpoint, nil, nil))
+	assert.Equal(int64(1), count.Load())
+}
+
+func TestRetryHostLimits(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	endpoint := syntax.NSID("com.example.get")
+
+	// an exhausted server rate-limit delays subsequent requests to the same host and endpoint
+	var count atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			w.Header().Set("ratelimit-limit", "1")
+			w.Header().Set("ratelimit-remaining", "0")
+			w.Header().Set("ratelimit-reset", "1")
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var throttled int
+	policy := testRetryPolicy()
+	policy.Hooks.OnThrottle = func(host string, endpoint syntax.NSID, delay time.Duration) { throttled++ }
+	c := NewAPIClient(srv.URL)
+	c.Retry = policy
+	assert.NoError(c.Get(ctx, endpoint, nil, nil))
+	// policy is shared by copied clients
+	assert.NoError(c.WithService("did:web:api.example.com#service").Get(ctx, endpoint, nil, nil))
+	assert.Equal(1, throttled)
+
+	// client-side token bucket
+	policy = testRetryPolicy()
+	policy.HostRateLimit = 20
+	policy.HostBurst = 1
+	c = NewAPIClient(srv.URL)
+	c.Retry = policy
+	start := time.Now()
+	for range 5 {
+		assert.NoError(c.Get(ctx, endpoint, nil, nil))
+	}
+	assert.GreaterOrEqual(time.Since(start), 150*time.Millisecond)
+}
+
+func TestRetryEndpointLimits(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	limited := syntax.NSID("com.example.limited")
+	other := syntax.NSID("com.example.other")
+
+	// server rate-limits are per-route, so an exhausted limit on one endpoint doesn't delay others on the same host
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/xrpc/"+limited.String() {
+			w.Header().Set("ratelimit-limit", "1")
+			w.Header().Set("ratelimit-remaining", "0")
+			w.Header().Set("ratelimit-reset", "1")
+		} else {
+			w.Header().Set("ratelimit-limit", "100")
+			w.Header().Set("ratelimit-remaining", "99")
+			w.Header().Set("ratelimit-reset", "1")
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	throttled := map[syntax.NSID]int{}
+	policy := testRetryPolicy()
+	policy.Hooks.OnThrottle = func(host string, endpoint syntax.NSID, delay time.Duration) { throttled[endpoint]++ }
+	c := NewAPIClient(srv.URL)
+	c.Retry = policy
+
+	assert.NoError(c.Get(ctx, limited, nil, nil))
+	start := time.Now()
+	for range 3 {
+		assert.NoError(c.Get(ctx, other, nil, nil))
+	}
+	assert.Less(time.Since(start), 500*time.Millisecond)
+	assert.Equal(0, throttled[other])
+
+	assert.NoError(c.Get(ctx, limited, nil, nil))
+	assert.Equal(1, throttled[limited])
+}
+
+func TestRateLimitReset(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1_700_000_000, 0)
+
+	resp := &http.Response{Header: http.Header{}}
+	_, ok := rateLimitReset(resp, now)
+	assert.False(ok)
+
+	resp.Header.Set("ratelimit-reset", "1700000030")
+	reset, ok := rateLimitReset(resp, now)
+	assert.True(ok)
+	assert.Equal(30*time.Second, reset.Sub(now))
+
+	resp.Header.Set("ratelimit-reset", "15")
+	reset, ok = rateLimitReset(resp, now)
+	assert.True(ok)
+	assert.Equal(15*time.Second, reset.Sub(now))
+
+	// Retry-After takes priority
+	resp.Header.Set("Retry-After", "5")
+	reset, ok = rateLimitReset(resp, now)
+	assert.True(ok)
+	assert.Equal(5*time.Second, reset.Sub(now))
+
+	resp.Header.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	reset, ok = rateLimitReset(resp, now)
+	assert.True(ok)
+	assert.Equal(time.Minute, reset.Sub(now))
+}
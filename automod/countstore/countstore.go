@@ -30,6 +30,10 @@ const (
 // one to the count for the hour, one to the count for the day, and one to the all-time count.
 // The "IncrementPeriod" method allows only incrementing a single period bucket. Care must be taken to match the "GetCount" period with the incremented period when using this variant.
 //
+// The "*Window" methods implement sliding-window counts, as an alternative to fixed calendar periods: a count for "the last 5 minutes" includes events up to 5 minutes ago, regardless of hour or day boundaries. See the Window type for details on precision. As with "IncrementPeriod", the window used for "GetCountWindow" must match the window used to increment.
+//
+// The "IncrementBatch" method persists many increments at once (in a single round-trip, for network-backed implementations).
+//
 // The exact implementation and precision of the "*Distinct" methods may vary:
 // in the MemCountStore implementation, it is precise (it's based on large maps);
 // in the RedisCountStore implementation, it uses the Redis "pfcount" feature,
@@ -45,9 +49,26 @@ type CountStore interface {
 	GetCount(ctx context.Context, name, val, period string) (int, error)
 	Increment(ctx context.Context, name, val string) error
 	IncrementPeriod(ctx context.Context, name, val, period string) error
-	// TODO: batch increment method
+	GetCountWindow(ctx context.Context, name, val string, window Window) (int, error)
+	IncrementWindow(ctx context.Context, name, val string, window Window) error
 	GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error)
 	IncrementDistinct(ctx context.Context, name, bucket, val string) error
+	IncrementBatch(ctx context.Context, incs []CountIncrement, distinct []DistinctIncrement) error
+}
+
+// A single counter increment, as part of a batch. At most one of "Period" and "Window" should be set; if neither is, all periods are incremented (like "Increment").
+type CountIncrement struct {
+	Name   string
+	Val    string
+	Period *string
+	Window *Window
+}
+
+// A single "distinct" counter increment, as part of a batch.
+type DistinctIncrement struct {
+	Name   string
+	Bucket string
+	Val    string
 }
 
 func periodBucket(name, val, period string) string {
@@ -2,6 +2,7 @@ package countstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
 )
@@ -48,6 +49,56 @@ func (s MemCountStore) IncrementPeriod(ctx context.Context, name, val, period st
 	return nil
 }
 
+func (s MemCountStore) GetCountWindow(ctx context.Context, name, val string, window Window) (int, error) {
+	if err := window.Validate(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, k := range windowBuckets(name, val, window, time.Now()) {
+		v, ok := s.Counts.Load(k)
+		if ok {
+			total += v
+		}
+	}
+	return total, nil
+}
+
+func (s MemCountStore) IncrementWindow(ctx context.Context, name, val string, window Window) error {
+	if err := window.Validate(); err != nil {
+		return err
+	}
+	idx := window.bucketIndex(time.Now())
+	s.Counts.Compute(windowBucket(name, val, window, idx), func(oldVal int, _ bool) (int, bool) {
+		return oldVal + 1, false
+	})
+	// opportunistically clean up the sub-bucket which just fell out of the window
+	s.Counts.Delete(windowBucket(name, val, window, idx-window.numBuckets()))
+	return nil
+}
+
+func (s MemCountStore) IncrementBatch(ctx context.Context, incs []CountIncrement, distinct []DistinctIncrement) error {
+	for _, inc := range incs {
+		var err error
+		switch {
+		case inc.Window != nil:
+			err = s.IncrementWindow(ctx, inc.Name, inc.Val, *inc.Window)
+		case inc.Period != nil:
+			err = s.IncrementPeriod(ctx, inc.Name, inc.Val, *inc.Period)
+		default:
+			err = s.Increment(ctx, inc.Name, inc.Val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, inc := range distinct {
+		if err := s.IncrementDistinct(ctx, inc.Name, inc.Bucket, inc.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s MemCountStore) GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error) {
 	v, ok := s.DistinctCounts.Load(periodBucket(name, bucket, period))
 	if !ok {
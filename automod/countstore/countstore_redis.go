@@ -2,6 +2,7 @@ package countstore
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -44,34 +45,29 @@ func (s *RedisCountStore) GetCount(ctx context.Context, name, val, period string
 }
 
 func (s *RedisCountStore) Increment(ctx context.Context, name, val string) error {
-
-	var key string
-
 	// increment multiple counters in a single redis round-trip
 	multi := s.Client.Pipeline()
-
-	key = redisCountPrefix + periodBucket(name, val, PeriodHour)
-	multi.Incr(ctx, key)
-	multi.Expire(ctx, key, 2*time.Hour)
-
-	key = redisCountPrefix + periodBucket(name, val, PeriodDay)
-	multi.Incr(ctx, key)
-	multi.Expire(ctx, key, 48*time.Hour)
-
-	key = redisCountPrefix + periodBucket(name, val, PeriodTotal)
-	multi.Incr(ctx, key)
-	// no expiration for total
-
+	s.pipelineIncrement(ctx, multi, name, val)
 	_, err := multi.Exec(ctx)
 	return err
 }
 
+func (s *RedisCountStore) pipelineIncrement(ctx context.Context, multi redis.Pipeliner, name, val string) {
+	for _, p := range []string{PeriodHour, PeriodDay, PeriodTotal} {
+		s.pipelineIncrementPeriod(ctx, multi, name, val, p)
+	}
+}
+
 // Variant of Increment() which only acts on a single specified time period. The intended us of this variant is to control the total number of counters persisted, by using a relatively short time period, for which the counters will expire.
 func (s *RedisCountStore) IncrementPeriod(ctx context.Context, name, val, period string) error {
-
 	// multiple ops in a single redis round-trip
 	multi := s.Client.Pipeline()
+	s.pipelineIncrementPeriod(ctx, multi, name, val, period)
+	_, err := multi.Exec(ctx)
+	return err
+}
 
+func (s *RedisCountStore) pipelineIncrementPeriod(ctx context.Context, multi redis.Pipeliner, name, val, period string) {
 	key := redisCountPrefix + periodBucket(name, val, period)
 	multi.Incr(ctx, key)
 
@@ -81,7 +77,77 @@ func (s *RedisCountStore) IncrementPeriod(ctx context.Context, name, val, period
 	case PeriodDay:
 		multi.Expire(ctx, key, 48*time.Hour)
 	}
+	// no expiration for total
+}
 
+func (s *RedisCountStore) GetCountWindow(ctx context.Context, name, val string, window Window) (int, error) {
+	if err := window.Validate(); err != nil {
+		return 0, err
+	}
+	buckets := windowBuckets(name, val, window, time.Now())
+	keys := make([]string, len(buckets))
+	for i, b := range buckets {
+		keys[i] = redisCountPrefix + b
+	}
+	vals, err := s.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, v := range vals {
+		// missing keys are nil
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		c, err := strconv.Atoi(str)
+		if err != nil {
+			return 0, err
+		}
+		total += c
+	}
+	return total, nil
+}
+
+func (s *RedisCountStore) IncrementWindow(ctx context.Context, name, val string, window Window) error {
+	if err := window.Validate(); err != nil {
+		return err
+	}
+	multi := s.Client.Pipeline()
+	s.pipelineIncrementWindow(ctx, multi, name, val, window)
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisCountStore) pipelineIncrementWindow(ctx context.Context, multi redis.Pipeliner, name, val string, window Window) {
+	key := redisCountPrefix + windowBucket(name, val, window, window.bucketIndex(time.Now()))
+	multi.Incr(ctx, key)
+	// sub-bucket is needed until it falls out of the window
+	multi.Expire(ctx, key, window.Size+window.Precision)
+}
+
+// Persists all the increments in a single redis round-trip.
+func (s *RedisCountStore) IncrementBatch(ctx context.Context, incs []CountIncrement, distinct []DistinctIncrement) error {
+	if len(incs) == 0 && len(distinct) == 0 {
+		return nil
+	}
+	multi := s.Client.Pipeline()
+	for _, inc := range incs {
+		switch {
+		case inc.Window != nil:
+			if err := inc.Window.Validate(); err != nil {
+				return err
+			}
+			s.pipelineIncrementWindow(ctx, multi, inc.Name, inc.Val, *inc.Window)
+		case inc.Period != nil:
+			s.pipelineIncrementPeriod(ctx, multi, inc.Name, inc.Val, *inc.Period)
+		default:
+			s.pipelineIncrement(ctx, multi, inc.Name, inc.Val)
+		}
+	}
+	for _, inc := range distinct {
+		s.pipelineIncrementDistinct(ctx, multi, inc.Name, inc.Bucket, inc.Val)
+	}
 	_, err := multi.Exec(ctx)
 	return err
 }
@@ -98,11 +164,15 @@ func (s *RedisCountStore) GetCountDistinct(ctx context.Context, name, val, perio
 }
 
 func (s *RedisCountStore) IncrementDistinct(ctx context.Context, name, bucket, val string) error {
-
-	var key string
-
 	// increment multiple counters in a single redis round-trip
 	multi := s.Client.Pipeline()
+	s.pipelineIncrementDistinct(ctx, multi, name, bucket, val)
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisCountStore) pipelineIncrementDistinct(ctx context.Context, multi redis.Pipeliner, name, bucket, val string) {
+	var key string
 
 	key = redisDistinctPrefix + periodBucket(name, bucket, PeriodHour)
 	multi.PFAdd(ctx, key, val)
@@ -115,7 +185,4 @@ func (s *RedisCountStore) IncrementDistinct(ctx context.Context, name, bucket, v
 	key = redisDistinctPrefix + periodBucket(name, bucket, PeriodTotal)
 	multi.PFAdd(ctx, key, val)
 	// no expiration for total
-
-	_, err := multi.Exec(ctx)
-	return err
 }
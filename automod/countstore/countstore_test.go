@@ -104,3 +104,104 @@ func TestMemCountStoreConcurrent(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(1, c)
 }
+
+func TestWindowValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, w := range []Window{WindowFiveMinutes, WindowHour, WindowDay} {
+		assert.NoError(w.Validate())
+	}
+	assert.Error(Window{Size: time.Minute, Precision: time.Millisecond}.Validate())
+	assert.Error(Window{Size: 90 * time.Second, Precision: time.Minute}.Validate())
+	assert.Error(Window{Size: time.Second, Precision: time.Minute}.Validate())
+	assert.Error(Window{Size: 48 * time.Hour, Precision: time.Second}.Validate())
+}
+
+func TestWindowBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	w := Window{Size: 5 * time.Minute, Precision: time.Minute}
+	t1 := time.Date(2024, 1, 1, 23, 59, 30, 0, time.UTC)
+	t2 := time.Date(2024, 1, 2, 0, 1, 10, 0, time.UTC)
+
+	b1 := windowBuckets("test", "val", w, t1)
+	b2 := windowBuckets("test", "val", w, t2)
+	assert.Equal(5, len(b1))
+	assert.Equal(5, len(b2))
+	// last bucket is the current one; windows straddling midnight overlap
+	assert.Equal(windowBucket("test", "val", w, w.bucketIndex(t1)), b1[4])
+	assert.Equal(b1[2:], b2[:3])
+}
+
+func TestMemCountStoreWindow(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCountStore()
+	w := Window{Size: time.Hour, Precision: time.Minute}
+
+	c, err := cs.GetCountWindow(ctx, "test1", "val1", w)
+	assert.NoError(err)
+	assert.Equal(0, c)
+
+	assert.NoError(cs.IncrementWindow(ctx, "test1", "val1", w))
+	assert.NoError(cs.IncrementWindow(ctx, "test1", "val1", w))
+
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", w)
+	assert.NoError(err)
+	assert.Equal(2, c)
+
+	// windows with a different size or precision are independent counters
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", Window{Size: 5 * time.Minute, Precision: time.Minute})
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", WindowFiveMinutes)
+	assert.NoError(err)
+	assert.Equal(0, c)
+
+	// incrementing a smaller window doesn't expire sub-buckets of the larger one
+	assert.NoError(cs.IncrementWindow(ctx, "test1", "val1", Window{Size: time.Minute, Precision: time.Minute}))
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", w)
+	assert.NoError(err)
+	assert.Equal(2, c)
+
+	// old sub-buckets are not counted
+	cs.Counts.Store(windowBucket("test1", "val1", w, w.bucketIndex(time.Now().Add(-2*time.Hour))), 100)
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", w)
+	assert.NoError(err)
+	assert.Equal(2, c)
+
+	_, err = cs.GetCountWindow(ctx, "test1", "val1", Window{Size: time.Minute, Precision: time.Hour})
+	assert.Error(err)
+}
+
+func TestMemCountStoreBatch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCountStore()
+	day := PeriodDay
+	incs := []CountIncrement{
+		{Name: "test1", Val: "val1"},
+		{Name: "test1", Val: "val1", Period: &day},
+		{Name: "test1", Val: "val1", Window: &WindowHour},
+	}
+	distinct := []DistinctIncrement{
+		{Name: "test2", Bucket: "val2", Val: "one"},
+		{Name: "test2", Bucket: "val2", Val: "two"},
+	}
+	assert.NoError(cs.IncrementBatch(ctx, incs, distinct))
+
+	c, err := cs.GetCount(ctx, "test1", "val1", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(1, c)
+	c, err = cs.GetCount(ctx, "test1", "val1", PeriodDay)
+	assert.NoError(err)
+	assert.Equal(2, c)
+	c, err = cs.GetCountWindow(ctx, "test1", "val1", WindowHour)
+	assert.NoError(err)
+	assert.Equal(1, c)
+	c, err = cs.GetCountDistinct(ctx, "test2", "val2", PeriodHour)
+	assert.NoError(err)
+	assert.Equal(2, c)
+}
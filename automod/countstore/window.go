@@ -0,0 +1,62 @@
+package countstore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Max number of sub-buckets per window; bounds the cost of reading a window count.
+const maxWindowBuckets = 1440
+
+// A sliding time window for counting events.
+//
+// Increments are recorded in fixed sub-buckets of duration "Precision", and a window count is the sum of the most recent Size/Precision sub-buckets (including the current, partially-filled, sub-bucket). This means the effective window is between Size-Precision and Size. Smaller precision gives more accurate counts, at the cost of more storage and slower reads.
+//
+// Counters are namespaced by both window size and precision, and sub-buckets expire once they are older than the window size. The same window must be used to increment and read a counter; counters with the same name but different windows are independent.
+type Window struct {
+	Size      time.Duration
+	Precision time.Duration
+}
+
+var (
+	WindowFiveMinutes = Window{Size: 5 * time.Minute, Precision: 10 * time.Second}
+	WindowHour        = Window{Size: time.Hour, Precision: time.Minute}
+	WindowDay         = Window{Size: 24 * time.Hour, Precision: 15 * time.Minute}
+)
+
+func (w Window) Validate() error {
+	if w.Precision < time.Second {
+		return fmt.Errorf("counter window precision must be at least one second: %s", w.Precision)
+	}
+	if w.Size < w.Precision || w.Size%w.Precision != 0 {
+		return fmt.Errorf("counter window size must be a multiple of precision: size=%s precision=%s", w.Size, w.Precision)
+	}
+	if w.numBuckets() > maxWindowBuckets {
+		return fmt.Errorf("counter window has too many buckets (max %d): size=%s precision=%s", maxWindowBuckets, w.Size, w.Precision)
+	}
+	return nil
+}
+
+func (w Window) numBuckets() int64 {
+	return int64(w.Size / w.Precision)
+}
+
+// index of the sub-bucket containing the given time
+func (w Window) bucketIndex(t time.Time) int64 {
+	return t.UnixNano() / int64(w.Precision)
+}
+
+func windowBucket(name, val string, w Window, idx int64) string {
+	return fmt.Sprintf("%s/%s/w%d-%d/%d", name, val, int64(w.Size/time.Second), int64(w.Precision/time.Second), idx)
+}
+
+// keys for all sub-buckets currently in the window, oldest first
+func windowBuckets(name, val string, w Window, now time.Time) []string {
+	cur := w.bucketIndex(now)
+	n := w.numBuckets()
+	out := make([]string, 0, n)
+	for idx := cur - n + 1; idx <= cur; idx++ {
+		out = append(out, windowBucket(name, val, w, idx))
+	}
+	return out
+}
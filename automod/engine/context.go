@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
 )
 
 // The primary interface exposed to rules. All other contexts derive from this "base" struct.
@@ -131,6 +134,18 @@ func (c *BaseContext) GetCount(name, val, period string) int {
 	return out
 }
 
+// Returns the count for the sliding time window. The same window should be used to increment the counter (see IncrementWindow).
+func (c *BaseContext) GetCountWindow(name, val string, window countstore.Window) int {
+	out, err := c.engine.Counters.GetCountWindow(c.Ctx, name, val, window)
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
 func (c *BaseContext) GetCountDistinct(name, bucket, period string) int {
 	out, err := c.engine.Counters.GetCountDistinct(c.Ctx, name, bucket, period)
 	if err != nil {
@@ -194,6 +209,74 @@ func (c *AccountContext) GetAccountRelationship(other syntax.DID) AccountRelatio
 	return *rel
 }
 
+// returns the number of mutual follows (accounts which this account follows, and which follow it back) known to the graph cache. Returns zero if the graph cache is not configured.
+//
+// NOTE: the graph cache is partial, so this is a lower bound. Follows are fetched from the AppView in the background, so may not be reflected until later events for the account.
+func (c *AccountContext) GetMutualFollowCount() int {
+	if c.engine.Graph == nil {
+		return 0
+	}
+	did := c.Account.Identity.DID
+	c.engine.startGraphHydration(c.Ctx, did)
+	out, err := c.engine.Graph.CountMutual(c.Ctx, graphstore.EdgeFollow, did.String())
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
+// returns the number of accounts known to follow both this account and the other account. Returns zero if the graph cache is not configured.
+func (c *AccountContext) GetSharedFollowerCount(other syntax.DID) int {
+	if c.engine.Graph == nil {
+		return 0
+	}
+	did := c.Account.Identity.DID
+	c.engine.startGraphHydration(c.Ctx, did)
+	c.engine.startGraphHydration(c.Ctx, other)
+	out, err := c.engine.Graph.CountSharedIncoming(c.Ctx, graphstore.EdgeFollow, did.String(), other.String())
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
+// returns the accounts seen following the target account (on the firehose) within the given time period, oldest first. Returns nil if the graph cache is not configured.
+func (c *BaseContext) GetRecentFollowers(target syntax.DID, period time.Duration) []syntax.DID {
+	if c.engine.Graph == nil {
+		return nil
+	}
+	dids, err := c.engine.Graph.GetIncomingSince(c.Ctx, graphstore.EdgeFollow, target.String(), time.Now().Add(-period))
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return nil
+	}
+	out := make([]syntax.DID, 0, len(dids))
+	for _, d := range dids {
+		did, err := syntax.ParseDID(d)
+		if err != nil {
+			continue
+		}
+		out = append(out, did)
+	}
+	return out
+}
+
+// returns the number of follows of the target account by new accounts (see GraphNewAccountAge) over the past day. A high count is a signal of coordinated follow-farming. Returns zero if the graph cache is not configured.
+func (c *BaseContext) GetNewAccountFollowCount(target syntax.DID) int {
+	if c.engine.Graph == nil {
+		return 0
+	}
+	return c.GetCountWindow(newAccountFollowCounter, target.String(), newAccountFollowWindow)
+}
+
 // fetch account metadata for the given DID. if there is any problem with lookup, returns nil.
 //
 // TODO: should this take an AtIdentifier instead?
@@ -230,6 +313,10 @@ func (c *BaseContext) IncrementPeriod(name, val string, period string) {
 	c.effects.IncrementPeriod(name, val, period)
 }
 
+func (c *BaseContext) IncrementWindow(name, val string, window countstore.Window) {
+	c.effects.IncrementWindow(name, val, window)
+}
+
 func (c *BaseContext) Notify(srv string) {
 	c.effects.Notify(srv)
 }
@@ -290,6 +377,11 @@ func (c *RecordContext) TakedownRecord() {
 	c.effects.TakedownRecord()
 }
 
+// Returns true if a rule has already enqueued a takedown of this record. Rules run concurrently with each other (eg, blob rules), so this only reliably reflects rules which ran earlier (eg, record rules, when checked from a blob rule).
+func (c *RecordContext) IsRecordTakedown() bool {
+	return c.effects.IsRecordTakedown()
+}
+
 func (c *RecordContext) EscalateRecord() {
 	c.effects.EscalateRecord()
 }
@@ -2,12 +2,15 @@ package engine
 
 import (
 	"sync"
+
+	"github.com/bluesky-social/indigo/automod/countstore"
 )
 
 type CounterRef struct {
 	Name   string
 	Val    string
 	Period *string
+	Window *countstore.Window
 }
 
 type CounterDistinctRef struct {
@@ -83,6 +86,13 @@ func (e *Effects) IncrementPeriod(name, val string, period string) {
 	e.CounterIncrements = append(e.CounterIncrements, CounterRef{Name: name, Val: val, Period: &period})
 }
 
+// Enqueues the named counter to be incremented at the end of all rule processing, in sliding-window sub-buckets (instead of calendar periods).
+func (e *Effects) IncrementWindow(name, val string, window countstore.Window) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.CounterIncrements = append(e.CounterIncrements, CounterRef{Name: name, Val: val, Window: &window})
+}
+
 // Enqueues the named "distinct value" counter based on the supplied string value ("val") to be incremented at the end of all rule processing. Will automatically increment for all time periods.
 func (e *Effects) IncrementDistinct(name, bucket, val string) {
 	e.mu.Lock()
@@ -233,9 +243,18 @@ func (e *Effects) ReportRecord(reason, comment string) {
 
 // Enqueues the record to be taken down at the end of rule processing.
 func (e *Effects) TakedownRecord() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.RecordTakedown = true
 }
 
+// Whether a rule has already enqueued a takedown of the record during this round of rule processing.
+func (e *Effects) IsRecordTakedown() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.RecordTakedown
+}
+
 // Enqueues the record to be "escalated" for mod review at the end of rule processing.
 func (e *Effects) EscalateRecord() {
 	e.RecordEscalate = true
@@ -13,6 +13,7 @@ import (
 	"github.com/bluesky-social/indigo/automod/cachestore"
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/xrpc"
 )
@@ -38,6 +39,10 @@ type Engine struct {
 	AdminClient *xrpc.Client
 	// used to fetch blobs from upstream PDS instances
 	BlobClient *http.Client
+	// if configured, labels are emitted directly (eg, by a local labeler service), in addition to any Ozone persistence; optional
+	Labeler LabelEmitter
+	// partial cache of the social graph (follows and blocks), used for graph signals in rules; optional
+	Graph graphstore.GraphStore
 
 	// internal configuration
 	Config EngineConfig
@@ -263,6 +268,10 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 	}
 	rc := NewRecordContext(ctx, eng, *am, op)
 	rc.Logger.Debug("processing record")
+	if err := eng.updateGraph(&rc); err != nil {
+		// graph cache is best-effort; don't block rule execution
+		rc.Logger.Warn("failed to update graph cache", "err", err)
+	}
 	switch op.Action {
 	case CreateOp, UpdateOp:
 		if err := eng.Rules.CallRecordRules(&rc); err != nil {
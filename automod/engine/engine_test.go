@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"testing"
+	"time"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -49,3 +50,161 @@ func TestEngineBasics(t *testing.T) {
 	op.RecordCBOR = p2cbor
 	assert.NoError(eng.ProcessRecordOp(ctx, op))
 }
+
+func TestCollectionRules(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	am1 := AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+
+	var seen []string
+	eng.Rules.AddCollectionRule("app.bsky.graph.list", TypedRecordRule(func(c *RecordContext, list *appbsky.GraphList) error {
+		seen = append(seen, list.Name)
+		c.AddRecordFlag("list-rule")
+		return nil
+	}))
+	// mismatched type; wrapper should error (which gets logged), not panic
+	eng.Rules.AddCollectionRule("app.bsky.graph.list", TypedRecordRule(func(c *RecordContext, sp *appbsky.GraphStarterpack) error {
+		seen = append(seen, "wrong")
+		return nil
+	}))
+
+	cid1 := syntax.CID("cid123")
+	list := appbsky.GraphList{
+		LexiconTypeID: "app.bsky.graph.list",
+		Name:          "some list",
+		Purpose:       &[]string{"app.bsky.graph.defs#curatelist"}[0],
+		CreatedAt:     "2024-01-01T00:00:00Z",
+	}
+	buf := new(bytes.Buffer)
+	assert.NoError(list.MarshalCBOR(buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        am1.Identity.DID,
+		Collection: syntax.NSID("app.bsky.graph.list"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+	c1 := NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(eng.Rules.CallRecordRules(&c1))
+	assert.Equal([]string{"some list"}, seen)
+	assert.Equal([]string{"list-rule"}, ExtractEffects(&c1.BaseContext).RecordFlags)
+
+	// record $type doesn't match collection: rules are skipped, but processing continues
+	op.Collection = syntax.NSID("app.bsky.graph.starterpack")
+	eng.Rules.AddCollectionRule("app.bsky.graph.starterpack", TypedRecordRule(func(c *RecordContext, sp *appbsky.GraphStarterpack) error {
+		seen = append(seen, sp.Name)
+		return nil
+	}))
+	c2 := NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(eng.Rules.CallRecordRules(&c2))
+	assert.Equal(1, len(seen))
+}
+
+func TestGraphSignals(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	target := syntax.DID("did:plc:target111")
+	now := time.Now()
+
+	followOp := func(did syntax.DID, rkey, subject string) RecordOp {
+		rec := appbsky.GraphFollow{
+			LexiconTypeID: "app.bsky.graph.follow",
+			Subject:       subject,
+			CreatedAt:     syntax.DatetimeNow().String(),
+		}
+		buf := new(bytes.Buffer)
+		assert.NoError(rec.MarshalCBOR(buf))
+		cid := syntax.CID("cid123")
+		return RecordOp{
+			Action:     CreateOp,
+			DID:        did,
+			Collection: syntax.NSID("app.bsky.graph.follow"),
+			RecordKey:  syntax.RecordKey(rkey),
+			CID:        &cid,
+			RecordCBOR: buf.Bytes(),
+		}
+	}
+
+	// several new accounts follow the same target
+	followers := []syntax.DID{"did:plc:new111", "did:plc:new222", "did:plc:new333"}
+	for _, did := range followers {
+		am := AccountMeta{
+			Identity:  &identity.Identity{DID: did},
+			CreatedAt: &now,
+		}
+		c := NewRecordContext(ctx, &eng, am, followOp(did, "3kfollow1", target.String()))
+		assert.NoError(eng.updateGraph(&c))
+		assert.NoError(eng.persistCounters(ctx, c.effects))
+	}
+	// target follows one of them back
+	am := AccountMeta{Identity: &identity.Identity{DID: target}}
+	c := NewRecordContext(ctx, &eng, am, followOp(target, "3kfollow2", followers[0].String()))
+	assert.NoError(eng.updateGraph(&c))
+	assert.NoError(eng.persistCounters(ctx, c.effects))
+
+	assert.Equal(3, c.GetNewAccountFollowCount(target))
+	assert.Equal(0, c.GetNewAccountFollowCount(followers[0]))
+	assert.Equal(followers, c.GetRecentFollowers(target, time.Hour))
+	assert.Equal(1, c.GetMutualFollowCount())
+	assert.Equal(0, c.GetSharedFollowerCount(followers[1]))
+	assert.NoError(c.Err)
+
+	// un-follow
+	del := followOp(followers[2], "3kfollow1", "")
+	del.Action = DeleteOp
+	del.CID = nil
+	del.RecordCBOR = nil
+	c = NewRecordContext(ctx, &eng, AccountMeta{Identity: &identity.Identity{DID: followers[2]}}, del)
+	assert.NoError(eng.updateGraph(&c))
+	assert.Equal(followers[:2], c.GetRecentFollowers(target, time.Hour))
+}
+
+type testLabelEmitter struct {
+	applied []string
+	negated []string
+}
+
+func (e *testLabelEmitter) EmitLabels(ctx context.Context, uri string, cid *string, vals []string, negated bool) error {
+	if negated {
+		e.negated = append(e.negated, vals...)
+	} else {
+		e.applied = append(e.applied, vals...)
+	}
+	return nil
+}
+
+func TestLabelEmitterAccountLabels(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	emitter := testLabelEmitter{}
+	eng.Labeler = &emitter
+
+	// labels already applied by some other labeler (as seen via the AppView) are still emitted
+	am := AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+		AccountLabels: []string{"spam"},
+	}
+	c := NewAccountContext(ctx, &eng, am)
+	c.AddAccountLabel("spam")
+	c.AddAccountLabel("spam")
+	// removals are passed through even if the AppView doesn't show the label
+	c.RemoveAccountLabel("rude")
+	assert.NoError(eng.persistAccountModActions(&c))
+	assert.Equal([]string{"spam"}, emitter.applied)
+	assert.Equal([]string{"rude"}, emitter.negated)
+}
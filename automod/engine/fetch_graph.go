@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+)
+
+// Accounts younger than this are considered "new" for the purpose of graph signals
+var GraphNewAccountAge = 7 * 24 * time.Hour
+
+// Max number of pages (of 100 accounts) of follows and followers to fetch from AppView, when hydrating the graph for an account
+var graphHydrationPages = 2
+
+// Max number of graph hydrations running in the background at any time. Additional requests are dropped, and will be retried the next time a rule asks about the account.
+var graphHydrationConcurrency = 8
+
+// Timeout for a single account graph hydration (all pages)
+var graphHydrationTimeout = 30 * time.Second
+
+// DIDs with graph hydration in progress (shared by all engines in the process)
+var (
+	graphHydratingLk sync.Mutex
+	graphHydrating   = make(map[syntax.DID]bool)
+)
+
+// Counter namespace (and window) for follows of a target account by new accounts
+const newAccountFollowCounter = "graph-new-follow"
+
+var newAccountFollowWindow = countstore.WindowDay
+
+// Updates the engine's graph cache from follow and block records. Called for every record op, before rules are run.
+func (eng *Engine) updateGraph(c *RecordContext) error {
+	if eng.Graph == nil {
+		return nil
+	}
+
+	var kind graphstore.EdgeKind
+	switch c.RecordOp.Collection {
+	case "app.bsky.graph.follow":
+		kind = graphstore.EdgeFollow
+	case "app.bsky.graph.block":
+		kind = graphstore.EdgeBlock
+	default:
+		return nil
+	}
+
+	ctx := c.Ctx
+	did := c.RecordOp.DID.String()
+	rkey := c.RecordOp.RecordKey.String()
+
+	if c.RecordOp.Action == DeleteOp {
+		return eng.Graph.RemoveEdge(ctx, kind, did, rkey)
+	}
+
+	var subject string
+	switch kind {
+	case graphstore.EdgeFollow:
+		var follow appbsky.GraphFollow
+		if err := follow.UnmarshalCBOR(bytes.NewReader(c.RecordOp.RecordCBOR)); err != nil {
+			return fmt.Errorf("failed to parse app.bsky.graph.follow record: %v", err)
+		}
+		subject = follow.Subject
+	case graphstore.EdgeBlock:
+		var block appbsky.GraphBlock
+		if err := block.UnmarshalCBOR(bytes.NewReader(c.RecordOp.RecordCBOR)); err != nil {
+			return fmt.Errorf("failed to parse app.bsky.graph.block record: %v", err)
+		}
+		subject = block.Subject
+	}
+	if _, err := syntax.ParseDID(subject); err != nil {
+		return fmt.Errorf("invalid %s subject: %w", kind, err)
+	}
+
+	// NOTE: uses time the event was processed, not the (self-reported) record timestamp
+	if err := eng.Graph.AddEdge(ctx, kind, did, rkey, subject, time.Now()); err != nil {
+		return err
+	}
+
+	if kind == graphstore.EdgeFollow && c.RecordOp.Action == CreateOp && isNewAccount(&c.Account) {
+		c.IncrementWindow(newAccountFollowCounter, subject, newAccountFollowWindow)
+	}
+	return nil
+}
+
+// simplified version of helpers.AccountIsYoungerThan (which can't be imported from here)
+func isNewAccount(am *AccountMeta) bool {
+	created := am.CreatedAt
+	if created == nil && am.Private != nil {
+		created = am.Private.IndexedAt
+	}
+	return created != nil && time.Since(*created) < GraphNewAccountAge
+}
+
+// Starts populating the graph cache with follows and followers of the account from the AppView in the background, if that hasn't been done recently. This helps make graph signals meaningful for accounts whose follows pre-date the firehose consumer.
+//
+// Hydration does not block event processing: rules see whatever is already in the cache, and hydrated edges are available to later events.
+func (eng *Engine) startGraphHydration(ctx context.Context, did syntax.DID) {
+	if eng.Graph == nil || eng.BskyClient == nil {
+		return
+	}
+
+	existing, err := eng.Cache.Get(ctx, "graph-hydrated", did.String())
+	if err != nil {
+		eng.Logger.Warn("failed checking graph hydration cache", "did", did, "err", err)
+		return
+	}
+	if existing != "" {
+		return
+	}
+
+	graphHydratingLk.Lock()
+	if graphHydrating[did] || len(graphHydrating) >= graphHydrationConcurrency {
+		graphHydratingLk.Unlock()
+		return
+	}
+	graphHydrating[did] = true
+	graphHydratingLk.Unlock()
+
+	go func() {
+		defer func() {
+			graphHydratingLk.Lock()
+			delete(graphHydrating, did)
+			graphHydratingLk.Unlock()
+		}()
+		// not derived from the event context, which ends when processing completes
+		ctx, cancel := context.WithTimeout(context.Background(), graphHydrationTimeout)
+		defer cancel()
+		if err := eng.hydrateGraph(ctx, did); err != nil {
+			eng.Logger.Warn("failed to hydrate graph", "did", did, "err", err)
+		}
+	}()
+}
+
+// Fetches follows and followers of the account from the AppView, and adds them to the graph cache. Edges already in the cache keep their creation time.
+func (eng *Engine) hydrateGraph(ctx context.Context, did syntax.DID) error {
+	graphHydrationFetches.Inc()
+	cursor := ""
+	for i := 0; i < graphHydrationPages; i++ {
+		resp, err := appbsky.GraphGetFollows(ctx, eng.BskyClient, did.String(), cursor, 100)
+		if err != nil {
+			return fmt.Errorf("fetching follows: %w", err)
+		}
+		for _, pv := range resp.Follows {
+			// edges from AppView have no known record key or creation time
+			if err := eng.Graph.AddEdge(ctx, graphstore.EdgeFollow, did.String(), "", pv.Did, time.Time{}); err != nil {
+				return err
+			}
+		}
+		if resp.Cursor == nil || *resp.Cursor == "" {
+			break
+		}
+		cursor = *resp.Cursor
+	}
+
+	cursor = ""
+	for i := 0; i < graphHydrationPages; i++ {
+		resp, err := appbsky.GraphGetFollowers(ctx, eng.BskyClient, did.String(), cursor, 100)
+		if err != nil {
+			return fmt.Errorf("fetching followers: %w", err)
+		}
+		for _, pv := range resp.Followers {
+			if err := eng.Graph.AddEdge(ctx, graphstore.EdgeFollow, pv.Did, "", did.String(), time.Time{}); err != nil {
+				return err
+			}
+		}
+		if resp.Cursor == nil || *resp.Cursor == "" {
+			break
+		}
+		cursor = *resp.Cursor
+	}
+
+	return eng.Cache.Set(ctx, "graph-hydrated", did.String(), "true")
+}
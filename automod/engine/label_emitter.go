@@ -0,0 +1,13 @@
+package engine
+
+import (
+	"context"
+)
+
+// Interface for directly emitting (signed) labels, as an alternative to persisting labels via the Ozone moderation service.
+//
+// See the `automod/labeler` package for an implementation.
+type LabelEmitter interface {
+	// Applies (or, if "negated" is true, removes) labels with the given values on a single subject. "uri" is either an account DID or a record AT-URI; "cid" should be nil for accounts.
+	EmitLabels(ctx context.Context, uri string, cid *string, vals []string, negated bool) error
+}
@@ -65,6 +65,11 @@ var accountRelationshipFetches = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "Number of account relationship reads (API calls)",
 })
 
+var graphHydrationFetches = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "automod_graph_hydration_fetches",
+	Help: "Number of account social graph hydrations from AppView (each may be multiple API calls)",
+})
+
 var blobDownloadCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_blob_downloads",
 	Help: "Number of blobs downloaded, by HTTP status code",
@@ -6,31 +6,30 @@ import (
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/keyword"
 )
 
 func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
 	// TODO: dedupe this array
-	for _, ref := range eff.CounterIncrements {
-		if ref.Period != nil {
-			err := eng.Counters.IncrementPeriod(ctx, ref.Name, ref.Val, *ref.Period)
-			if err != nil {
-				return err
-			}
-		} else {
-			err := eng.Counters.Increment(ctx, ref.Name, ref.Val)
-			if err != nil {
-				return err
-			}
+	incs := make([]countstore.CountIncrement, len(eff.CounterIncrements))
+	for i, ref := range eff.CounterIncrements {
+		incs[i] = countstore.CountIncrement{
+			Name:   ref.Name,
+			Val:    ref.Val,
+			Period: ref.Period,
+			Window: ref.Window,
 		}
 	}
-	for _, ref := range eff.CounterDistinctIncrements {
-		err := eng.Counters.IncrementDistinct(ctx, ref.Name, ref.Bucket, ref.Val)
-		if err != nil {
-			return err
+	distinct := make([]countstore.DistinctIncrement, len(eff.CounterDistinctIncrements))
+	for i, ref := range eff.CounterDistinctIncrements {
+		distinct[i] = countstore.DistinctIncrement{
+			Name:   ref.Name,
+			Bucket: ref.Bucket,
+			Val:    ref.Val,
 		}
 	}
-	return nil
+	return eng.Counters.IncrementBatch(ctx, incs, distinct)
 }
 
 // Persists account-level moderation actions: new labels, new tags, new flags, new takedowns, and reports.
@@ -111,6 +110,15 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		eng.Flags.Add(ctx, c.Account.Identity.DID.String(), newFlags)
 	}
 
+	// the labeler de-dupes against its own labels; the de-duped actions above are against labels from all labelers (via the AppView)
+	if eng.Labeler != nil {
+		lblNew, lblRemovals := labelerActions(c.effects.AccountLabels, c.effects.RemovedAccountLabels)
+		if len(lblNew) > 0 || len(lblRemovals) > 0 {
+			c.Logger.Info("emitting account labels", "newLabels", lblNew, "rmdLabels", lblRemovals)
+			eng.emitLabels(ctx, c.Account.Identity.DID.String(), nil, lblNew, lblRemovals)
+		}
+	}
+
 	// if we can't actually talk to service, bail out early
 	if eng.OzoneClient == nil {
 		if anyModActions {
@@ -369,6 +377,15 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		eng.Flags.Add(ctx, atURI, newFlags)
 	}
 
+	if eng.Labeler != nil && c.RecordOp.CID != nil {
+		lblNew, lblRemovals := labelerActions(c.effects.RecordLabels, c.effects.RemovedRecordLabels)
+		if len(lblNew) > 0 || len(lblRemovals) > 0 {
+			c.Logger.Info("emitting record labels", "newLabels", lblNew, "rmdLabels", lblRemovals)
+			cid := c.RecordOp.CID.String()
+			eng.emitLabels(ctx, atURI, &cid, lblNew, lblRemovals)
+		}
+	}
+
 	// exit early
 	if !newAcknowledge && !newEscalation && !newTakedown && len(newLabels) == 0 && len(rmdLabels) == 0 && len(newTags) == 0 && len(newReports) == 0 {
 		return nil
@@ -512,3 +529,29 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 	}
 	return nil
 }
+
+// label effects to pass to the LabelEmitter: everything applied by rules, and any removals which aren't also being applied. The emitter skips labels which are already in the requested state.
+func labelerActions(added, removed []string) ([]string, []string) {
+	newLabels := dedupeStrings(added)
+	rmdLabels := []string{}
+	for _, lbl := range dedupeStrings(removed) {
+		if !keyword.TokenInSet(lbl, newLabels) {
+			rmdLabels = append(rmdLabels, lbl)
+		}
+	}
+	return newLabels, rmdLabels
+}
+
+// helper to apply and remove labels via the configured LabelEmitter. errors are logged, not returned, consistent with Ozone label persistence.
+func (eng *Engine) emitLabels(ctx context.Context, uri string, cid *string, newLabels, rmdLabels []string) {
+	if len(newLabels) > 0 {
+		if err := eng.Labeler.EmitLabels(ctx, uri, cid, newLabels, false); err != nil {
+			eng.Logger.Error("failed to emit labels", "uri", uri, "err", err)
+		}
+	}
+	if len(rmdLabels) > 0 {
+		if err := eng.Labeler.EmitLabels(ctx, uri, cid, rmdLabels, true); err != nil {
+			eng.Logger.Error("failed to emit label negations", "uri", uri, "err", err)
+		}
+	}
+}
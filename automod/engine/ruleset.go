@@ -19,6 +19,8 @@ type RuleSet struct {
 	AccountRules      []AccountRuleFunc
 	BlobRules         []BlobRuleFunc
 	OzoneEventRules   []OzoneEventRuleFunc
+	// Rules for specific record collections, keyed by NSID. The record is decoded using the lexicon type registry (`lexutil.RegisterType`) before rules are called, so any generated API type (or custom lexicon type) can be used. Use `TypedRecordRule` to wrap functions which take a concrete record type.
+	CollectionRules map[string][]CollectionRuleFunc
 }
 
 // Executes all the various record-related rules. Only dispatches execution, does no other de-dupe or pre/post processing.
@@ -55,6 +57,8 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 			}
 		}
 	}
+	// then generic per-collection typed rules. a bad record shouldn't prevent blob rules from running
+	r.callCollectionRules(c)
 	// then blob rules, if any
 	if len(r.BlobRules) == 0 {
 		return nil
@@ -67,6 +71,56 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 	return nil
 }
 
+// Registers a rule to be run for records in the given collection (NSID).
+func (r *RuleSet) AddCollectionRule(nsid string, f CollectionRuleFunc) {
+	if r.CollectionRules == nil {
+		r.CollectionRules = make(map[string][]CollectionRuleFunc)
+	}
+	r.CollectionRules[nsid] = append(r.CollectionRules[nsid], f)
+}
+
+// Wraps a rule function which takes a concrete record type (eg, `*appbsky.GraphList`) as a CollectionRuleFunc. If the decoded record is not of the expected type, the wrapped rule returns an error without calling the function.
+func TypedRecordRule[T lexutil.CBOR](f func(c *RecordContext, rec T) error) CollectionRuleFunc {
+	return func(c *RecordContext, rec lexutil.CBOR) error {
+		val, ok := rec.(T)
+		if !ok {
+			var expected T
+			return fmt.Errorf("unexpected record type for %s: expected %T, got %T", c.RecordOp.Collection, expected, rec)
+		}
+		return f(c, val)
+	}
+}
+
+// Runs any rules for the record's collection. Records which can't be decoded, or have a `$type` which doesn't match the collection, are logged and skipped.
+func (r *RuleSet) callCollectionRules(c *RecordContext) {
+	nsid := c.RecordOp.Collection.String()
+	rules, ok := r.CollectionRules[nsid]
+	if !ok || len(rules) == 0 {
+		return
+	}
+	// records with a $type which doesn't match the collection are valid data, but rules should only ever see the expected type
+	typ, err := lexutil.CborTypeExtract(c.RecordOp.RecordCBOR)
+	if err != nil {
+		c.Logger.Warn("skipping collection rules: failed to parse record", "err", err, "collection", nsid)
+		return
+	}
+	if typ != nsid {
+		c.Logger.Warn("skipping collection rules: record type did not match collection", "type", typ, "collection", nsid)
+		return
+	}
+	rec, err := lexutil.CborDecodeValue(c.RecordOp.RecordCBOR)
+	if err != nil {
+		c.Logger.Warn("skipping collection rules: failed to decode record", "err", err, "collection", nsid)
+		return
+	}
+	for _, f := range rules {
+		err := f(c, rec)
+		if err != nil {
+			c.Logger.Error("collection rule execution failed", "err", err, "collection", nsid)
+		}
+	}
+}
+
 // NOTE: this will probably be removed and merged in to `CallRecordRules`
 func (r *RuleSet) CallRecordDeleteRules(c *RecordContext) error {
 	for _, f := range r.RecordDeleteRules {
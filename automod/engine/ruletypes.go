@@ -10,5 +10,6 @@ type AccountRuleFunc = func(c *AccountContext) error
 type RecordRuleFunc = func(c *RecordContext) error
 type PostRuleFunc = func(c *RecordContext, post *appbsky.FeedPost) error
 type ProfileRuleFunc = func(c *RecordContext, profile *appbsky.ActorProfile) error
+type CollectionRuleFunc = func(c *RecordContext, rec lexutil.CBOR) error
 type BlobRuleFunc = func(c *RecordContext, blob lexutil.LexBlob, data []byte) error
 type OzoneEventRuleFunc = func(c *OzoneEventContext) error
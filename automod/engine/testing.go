@@ -10,6 +10,7 @@ import (
 	"github.com/bluesky-social/indigo/automod/cachestore"
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
 	"github.com/bluesky-social/indigo/automod/setstore"
 )
 
@@ -65,6 +66,7 @@ func EngineTestFixture() Engine {
 		Sets:      sets,
 		Flags:     flags,
 		Cache:     cache,
+		Graph:     graphstore.NewMemGraphStore(),
 		Rules:     rules,
 	}
 	return eng
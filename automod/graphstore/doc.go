@@ -0,0 +1,2 @@
+// Interface for a cache of social graph edges (follows and blocks), and separate implementations using redis and in-process memory.
+package graphstore
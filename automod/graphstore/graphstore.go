@@ -0,0 +1,37 @@
+package graphstore
+
+import (
+	"context"
+	"time"
+)
+
+// Type of social graph edge. Each kind of edge is stored independently.
+type EdgeKind string
+
+var (
+	EdgeFollow EdgeKind = "follow"
+	EdgeBlock  EdgeKind = "block"
+)
+
+// GraphStore is a partial, best-effort cache of the social graph, populated from records seen on the firehose (and from the AppView).
+//
+// Edges are directed: "from" is the account which created the record (eg, the follower), and "to" is the subject. Edges are keyed by the record key of the record that created them, so that delete events (which don't include the record) can be applied. Edges populated from other sources (eg, AppView) may have an empty record key.
+//
+// Because the cache is partial, counts should be interpreted as lower bounds.
+type GraphStore interface {
+	// Adds an edge. If the edge already exists, its creation time is only updated if it was previously unknown (zero); a zero createdAt never overwrites a known time.
+	AddEdge(ctx context.Context, kind EdgeKind, from, rkey, to string, createdAt time.Time) error
+	// Removes the record with the given record key. The edge itself is only removed once no other records (with different record keys) point to the same subject. Removing an unknown record is a no-op.
+	RemoveEdge(ctx context.Context, kind EdgeKind, from, rkey string) error
+	// Returns subjects of edges from this account (eg, accounts it follows)
+	GetOutgoing(ctx context.Context, kind EdgeKind, did string) ([]string, error)
+	// Returns the accounts with edges to this account (eg, its followers)
+	GetIncoming(ctx context.Context, kind EdgeKind, did string) ([]string, error)
+	// Returns the accounts with edges to this account, created since the given time, ordered oldest first
+	GetIncomingSince(ctx context.Context, kind EdgeKind, did string, since time.Time) ([]string, error)
+	CountIncoming(ctx context.Context, kind EdgeKind, did string) (int, error)
+	// Number of accounts with edges in both directions (eg, mutual follows)
+	CountMutual(ctx context.Context, kind EdgeKind, did string) (int, error)
+	// Number of accounts with edges to both of the two accounts (eg, shared followers)
+	CountSharedIncoming(ctx context.Context, kind EdgeKind, a, b string) (int, error)
+}
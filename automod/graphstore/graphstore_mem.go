@@ -0,0 +1,206 @@
+package graphstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// In-process GraphStore. Grows without bound; intended for testing and small deployments.
+type MemGraphStore struct {
+	mu    sync.RWMutex
+	kinds map[EdgeKind]*memGraph
+}
+
+type memGraph struct {
+	// from -> to -> true
+	out map[string]map[string]bool
+	// to -> from -> created
+	in map[string]map[string]time.Time
+	// from -> rkey -> to
+	rkeys map[string]map[string]string
+	// from -> to -> number of record keys for the edge
+	refs map[string]map[string]int
+}
+
+func NewMemGraphStore() *MemGraphStore {
+	return &MemGraphStore{
+		kinds: make(map[EdgeKind]*memGraph),
+	}
+}
+
+// returns the graph for this edge kind, creating it if needed. caller must hold write lock
+func (s *MemGraphStore) graph(kind EdgeKind) *memGraph {
+	g, ok := s.kinds[kind]
+	if !ok {
+		g = &memGraph{
+			out:   make(map[string]map[string]bool),
+			in:    make(map[string]map[string]time.Time),
+			rkeys: make(map[string]map[string]string),
+			refs:  make(map[string]map[string]int),
+		}
+		s.kinds[kind] = g
+	}
+	return g
+}
+
+func (s *MemGraphStore) AddEdge(ctx context.Context, kind EdgeKind, from, rkey, to string, createdAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.graph(kind)
+	if rkey != "" {
+		if prev, ok := g.rkeys[from][rkey]; ok {
+			if prev == to {
+				// record re-processed (eg, update op); don't double-count
+				rkey = ""
+			} else {
+				// record now points to a different subject
+				g.removeRef(from, prev)
+			}
+		}
+	}
+
+	if g.out[from] == nil {
+		g.out[from] = make(map[string]bool)
+	}
+	g.out[from][to] = true
+	if g.in[to] == nil {
+		g.in[to] = make(map[string]time.Time)
+	}
+	// keep the existing creation time, unless it was unknown
+	if prev, ok := g.in[to][from]; !ok || prev.IsZero() {
+		g.in[to][from] = createdAt
+	}
+	if rkey != "" {
+		if g.rkeys[from] == nil {
+			g.rkeys[from] = make(map[string]string)
+		}
+		g.rkeys[from][rkey] = to
+		if g.refs[from] == nil {
+			g.refs[from] = make(map[string]int)
+		}
+		g.refs[from][to]++
+	}
+	return nil
+}
+
+func (s *MemGraphStore) RemoveEdge(ctx context.Context, kind EdgeKind, from, rkey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.graph(kind)
+	to, ok := g.rkeys[from][rkey]
+	if !ok {
+		return nil
+	}
+	delete(g.rkeys[from], rkey)
+	g.removeRef(from, to)
+	return nil
+}
+
+// drops a record key reference to an edge, removing the edge if no other records point to the same subject. caller must hold write lock
+func (g *memGraph) removeRef(from, to string) {
+	g.refs[from][to]--
+	if g.refs[from][to] > 0 {
+		return
+	}
+	delete(g.refs[from], to)
+	delete(g.out[from], to)
+	delete(g.in[to], from)
+}
+
+func (s *MemGraphStore) GetOutgoing(ctx context.Context, kind EdgeKind, did string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []string{}
+	if g, ok := s.kinds[kind]; ok {
+		for to := range g.out[did] {
+			out = append(out, to)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *MemGraphStore) GetIncoming(ctx context.Context, kind EdgeKind, did string) ([]string, error) {
+	return s.GetIncomingSince(ctx, kind, did, time.Time{})
+}
+
+func (s *MemGraphStore) GetIncomingSince(ctx context.Context, kind EdgeKind, did string, since time.Time) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.kinds[kind]
+	if !ok {
+		return []string{}, nil
+	}
+	type edge struct {
+		from    string
+		created time.Time
+	}
+	edges := []edge{}
+	for from, created := range g.in[did] {
+		if !created.Before(since) {
+			edges = append(edges, edge{from, created})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].created.Equal(edges[j].created) {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].created.Before(edges[j].created)
+	})
+	out := make([]string, len(edges))
+	for i, e := range edges {
+		out[i] = e.from
+	}
+	return out, nil
+}
+
+func (s *MemGraphStore) CountIncoming(ctx context.Context, kind EdgeKind, did string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.kinds[kind]
+	if !ok {
+		return 0, nil
+	}
+	return len(g.in[did]), nil
+}
+
+func (s *MemGraphStore) CountMutual(ctx context.Context, kind EdgeKind, did string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.kinds[kind]
+	if !ok {
+		return 0, nil
+	}
+	count := 0
+	for to := range g.out[did] {
+		if _, ok := g.in[did][to]; ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemGraphStore) CountSharedIncoming(ctx context.Context, kind EdgeKind, a, b string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.kinds[kind]
+	if !ok {
+		return 0, nil
+	}
+	count := 0
+	for from := range g.in[a] {
+		if _, ok := g.in[b][from]; ok {
+			count++
+		}
+	}
+	return count, nil
+}
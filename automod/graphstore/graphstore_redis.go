@@ -0,0 +1,188 @@
+package graphstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisGraphPrefix string = "graph/"
+
+// GraphStore backed by redis.
+//
+// Schema, for each edge kind:
+// graph/{kind}/out/{from} : SET of {to}
+// graph/{kind}/in/{to} : ZSET of {from}, scored by edge creation time (unix seconds; zero if unknown)
+// graph/{kind}/rkey/{from} : HASH of {rkey} -> {to}
+//
+// All keys expire after TTL (refreshed on every write), so the graph only reflects recently-active accounts.
+//
+// Counting intersections uses ZINTERCARD, which requires Redis 7.0 or later. On older servers, this falls back to ZINTERSTORE in to a temporary key.
+type RedisGraphStore struct {
+	Client *redis.Client
+	TTL    time.Duration
+
+	// set if the server doesn't support ZINTERCARD
+	noInterCard atomic.Bool
+}
+
+// temporary key for intersections on servers without ZINTERCARD; only used inside MULTI/EXEC, so it is never visible to other clients
+var redisInterTmpKey = redisGraphPrefix + "tmp/intersection"
+
+// adds a member to a sorted set (KEYS[1]) with the given score (ARGV[2]), but only replaces the score of an existing member if it was zero (unknown creation time)
+var zaddKnownScript = `
+local prev = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if prev == false or tonumber(prev) == 0 then
+	return redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+end
+return 0
+`
+
+func NewRedisGraphStore(redisURL string, ttl time.Duration) (*RedisGraphStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	rgs := RedisGraphStore{
+		Client: rdb,
+		TTL:    ttl,
+	}
+	return &rgs, nil
+}
+
+func outKey(kind EdgeKind, did string) string {
+	return fmt.Sprintf("%s%s/out/%s", redisGraphPrefix, kind, did)
+}
+
+func inKey(kind EdgeKind, did string) string {
+	return fmt.Sprintf("%s%s/in/%s", redisGraphPrefix, kind, did)
+}
+
+func rkeyKey(kind EdgeKind, did string) string {
+	return fmt.Sprintf("%s%s/rkey/%s", redisGraphPrefix, kind, did)
+}
+
+func (s *RedisGraphStore) AddEdge(ctx context.Context, kind EdgeKind, from, rkey, to string, createdAt time.Time) error {
+	multi := s.Client.TxPipeline()
+	multi.SAdd(ctx, outKey(kind, from), to)
+	multi.Expire(ctx, outKey(kind, from), s.TTL)
+	var score int64
+	if !createdAt.IsZero() {
+		score = createdAt.Unix()
+	}
+	multi.Eval(ctx, zaddKnownScript, []string{inKey(kind, to)}, from, score)
+	multi.Expire(ctx, inKey(kind, to), s.TTL)
+	if rkey != "" {
+		multi.HSet(ctx, rkeyKey(kind, from), rkey, to)
+		multi.Expire(ctx, rkeyKey(kind, from), s.TTL)
+	}
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisGraphStore) RemoveEdge(ctx context.Context, kind EdgeKind, from, rkey string) error {
+	rkeys, err := s.Client.HGetAll(ctx, rkeyKey(kind, from)).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	to, ok := rkeys[rkey]
+	if !ok {
+		return nil
+	}
+	// other records may still point to the same subject
+	shared := false
+	for k, v := range rkeys {
+		if k != rkey && v == to {
+			shared = true
+			break
+		}
+	}
+	multi := s.Client.TxPipeline()
+	multi.HDel(ctx, rkeyKey(kind, from), rkey)
+	if !shared {
+		multi.SRem(ctx, outKey(kind, from), to)
+		multi.ZRem(ctx, inKey(kind, to), from)
+	}
+	_, err = multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisGraphStore) GetOutgoing(ctx context.Context, kind EdgeKind, did string) ([]string, error) {
+	l, err := s.Client.SMembers(ctx, outKey(kind, did)).Result()
+	if err == redis.Nil {
+		return []string{}, nil
+	}
+	return l, err
+}
+
+func (s *RedisGraphStore) GetIncoming(ctx context.Context, kind EdgeKind, did string) ([]string, error) {
+	l, err := s.Client.ZRange(ctx, inKey(kind, did), 0, -1).Result()
+	if err == redis.Nil {
+		return []string{}, nil
+	}
+	return l, err
+}
+
+func (s *RedisGraphStore) GetIncomingSince(ctx context.Context, kind EdgeKind, did string, since time.Time) ([]string, error) {
+	l, err := s.Client.ZRangeByScore(ctx, inKey(kind, did), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err == redis.Nil {
+		return []string{}, nil
+	}
+	return l, err
+}
+
+func (s *RedisGraphStore) CountIncoming(ctx context.Context, kind EdgeKind, did string) (int, error) {
+	c, err := s.Client.ZCard(ctx, inKey(kind, did)).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return int(c), err
+}
+
+func (s *RedisGraphStore) CountMutual(ctx context.Context, kind EdgeKind, did string) (int, error) {
+	return s.interCard(ctx, outKey(kind, did), inKey(kind, did))
+}
+
+func (s *RedisGraphStore) CountSharedIncoming(ctx context.Context, kind EdgeKind, a, b string) (int, error) {
+	return s.interCard(ctx, inKey(kind, a), inKey(kind, b))
+}
+
+// counts members in the intersection of the given sets or sorted sets (plain sets are treated as sorted sets)
+func (s *RedisGraphStore) interCard(ctx context.Context, keys ...string) (int, error) {
+	if !s.noInterCard.Load() {
+		c, err := s.Client.ZInterCard(ctx, 0, keys...).Result()
+		if err == redis.Nil {
+			return 0, nil
+		} else if err == nil {
+			return int(c), nil
+		} else if !strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+			return 0, err
+		}
+		// server is older than Redis 7.0
+		s.noInterCard.Store(true)
+	}
+
+	multi := s.Client.TxPipeline()
+	// ZINTERSTORE returns the number of members in the result
+	card := multi.ZInterStore(ctx, redisInterTmpKey, &redis.ZStore{Keys: keys})
+	multi.Del(ctx, redisInterTmpKey)
+	if _, err := multi.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+	return int(card.Val()), nil
+}
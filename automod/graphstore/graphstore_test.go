@@ -0,0 +1,116 @@
+package graphstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGraphStore(t *testing.T, gs GraphStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:a", "rk1", "did:plc:b", old))
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:b", "rk1", "did:plc:a", old))
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:a", "rk2", "did:plc:c", now))
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:c", "rk1", "did:plc:b", now))
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:d", "rk1", "did:plc:b", now))
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:d", "rk2", "did:plc:c", now))
+	assert.NoError(gs.AddEdge(ctx, EdgeBlock, "did:plc:c", "rk3", "did:plc:a", now))
+
+	l, err := gs.GetOutgoing(ctx, EdgeFollow, "did:plc:a")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"did:plc:b", "did:plc:c"}, l)
+
+	l, err = gs.GetIncoming(ctx, EdgeFollow, "did:plc:b")
+	assert.NoError(err)
+	assert.Equal([]string{"did:plc:a", "did:plc:c", "did:plc:d"}, l)
+
+	l, err = gs.GetIncomingSince(ctx, EdgeFollow, "did:plc:b", now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"did:plc:c", "did:plc:d"}, l)
+
+	c, err := gs.CountIncoming(ctx, EdgeFollow, "did:plc:b")
+	assert.NoError(err)
+	assert.Equal(3, c)
+
+	c, err = gs.CountMutual(ctx, EdgeFollow, "did:plc:a")
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	// d follows both b and c; a follows both b and c
+	c, err = gs.CountSharedIncoming(ctx, EdgeFollow, "did:plc:b", "did:plc:c")
+	assert.NoError(err)
+	assert.Equal(2, c)
+
+	c, err = gs.CountIncoming(ctx, EdgeBlock, "did:plc:a")
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	// removal by record key
+	assert.NoError(gs.RemoveEdge(ctx, EdgeFollow, "did:plc:b", "rk1"))
+	assert.NoError(gs.RemoveEdge(ctx, EdgeFollow, "did:plc:b", "unknown"))
+	c, err = gs.CountMutual(ctx, EdgeFollow, "did:plc:a")
+	assert.NoError(err)
+	assert.Equal(0, c)
+	c, err = gs.CountIncoming(ctx, EdgeBlock, "did:plc:a")
+	assert.NoError(err)
+	assert.Equal(1, c)
+
+	l, err = gs.GetOutgoing(ctx, EdgeFollow, "did:plc:unknown")
+	assert.NoError(err)
+	assert.Empty(l)
+
+	// edges without a known creation time (eg, from AppView) don't overwrite known times
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:c", "", "did:plc:b", time.Time{}))
+	l, err = gs.GetIncomingSince(ctx, EdgeFollow, "did:plc:b", now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"did:plc:c", "did:plc:d"}, l)
+
+	// but known times replace unknown ones
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:e", "", "did:plc:b", time.Time{}))
+	l, err = gs.GetIncomingSince(ctx, EdgeFollow, "did:plc:b", now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"did:plc:c", "did:plc:d"}, l)
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:e", "rk1", "did:plc:b", now))
+	l, err = gs.GetIncomingSince(ctx, EdgeFollow, "did:plc:b", now.Add(-time.Hour))
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"did:plc:c", "did:plc:d", "did:plc:e"}, l)
+
+	// duplicate records for the same subject: edge remains until all are removed
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:f", "rk1", "did:plc:g", now))
+	assert.NoError(gs.AddEdge(ctx, EdgeFollow, "did:plc:f", "rk2", "did:plc:g", now))
+	assert.NoError(gs.RemoveEdge(ctx, EdgeFollow, "did:plc:f", "rk1"))
+	l, err = gs.GetOutgoing(ctx, EdgeFollow, "did:plc:f")
+	assert.NoError(err)
+	assert.Equal([]string{"did:plc:g"}, l)
+	c, err = gs.CountIncoming(ctx, EdgeFollow, "did:plc:g")
+	assert.NoError(err)
+	assert.Equal(1, c)
+	assert.NoError(gs.RemoveEdge(ctx, EdgeFollow, "did:plc:f", "rk2"))
+	l, err = gs.GetOutgoing(ctx, EdgeFollow, "did:plc:f")
+	assert.NoError(err)
+	assert.Empty(l)
+	c, err = gs.CountIncoming(ctx, EdgeFollow, "did:plc:g")
+	assert.NoError(err)
+	assert.Equal(0, c)
+}
+
+func TestMemGraphStore(t *testing.T) {
+	testGraphStore(t, NewMemGraphStore())
+}
+
+func TestRedisGraphStore(t *testing.T) {
+	t.Skip("live test, need redis running locally")
+
+	gs, err := NewRedisGraphStore("redis://localhost:6379/0", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGraphStore(t, gs)
+}
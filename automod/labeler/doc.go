@@ -0,0 +1,4 @@
+// Signed label output for automod, so that automod can run as a standalone atproto labeler service.
+//
+// Labels emitted by rules are signed with a configured key, persisted in a local LabelStore, and served via the `com.atproto.label.subscribeLabels` and `com.atproto.label.queryLabels` endpoints.
+package labeler
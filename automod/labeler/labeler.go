@@ -0,0 +1,145 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Creates, signs, and persists labels, and broadcasts them to any live subscribers.
+//
+// Implements the `engine.LabelEmitter` interface.
+type Labeler struct {
+	// DID of the labeler service; used as the "src" of all labels
+	SourceDID  syntax.DID
+	SigningKey crypto.PrivateKey
+	Store      LabelStore
+	Logger     *slog.Logger
+
+	// held while checking and appending labels, so concurrent emits for the same subject don't both append
+	emitLk sync.Mutex
+
+	subsLk    sync.Mutex
+	subs      map[uint64]chan SeqLabel
+	nextSubID uint64
+}
+
+func NewLabeler(did syntax.DID, key crypto.PrivateKey, store LabelStore, logger *slog.Logger) *Labeler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Labeler{
+		SourceDID:  did,
+		SigningKey: key,
+		Store:      store,
+		Logger:     logger,
+		subs:       make(map[uint64]chan SeqLabel),
+	}
+}
+
+// whether the label is currently in effect (not negated, and not expired)
+func isActive(l *label.Label) bool {
+	if l.Negated != nil && *l.Negated {
+		return false
+	}
+	if l.ExpiresAt != nil {
+		exp, err := syntax.ParseDatetime(*l.ExpiresAt)
+		if err == nil && exp.Time().Before(time.Now()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Creates, signs, and persists labels with the given values on a single subject. If "negated" is true, the labels are removed instead of applied.
+//
+// Labels which are already in the requested state (eg, already applied to the same subject URI and CID) are skipped.
+func (l *Labeler) EmitLabels(ctx context.Context, uri string, cid *string, vals []string, negated bool) error {
+	l.emitLk.Lock()
+	defer l.emitLk.Unlock()
+
+	for _, val := range vals {
+		existing, err := l.Store.GetLabel(ctx, uri, val)
+		if err != nil {
+			return fmt.Errorf("checking existing label: %w", err)
+		}
+		active := existing != nil && isActive(&existing.Label)
+		if negated && !active {
+			continue
+		}
+		if !negated && active && ptrEqual(existing.Label.CID, cid) {
+			continue
+		}
+
+		lbl := label.Label{
+			Version:   label.ATPROTO_LABEL_VERSION,
+			SourceDID: l.SourceDID.String(),
+			URI:       uri,
+			CID:       cid,
+			Val:       val,
+			CreatedAt: syntax.DatetimeNow().String(),
+		}
+		if negated {
+			neg := true
+			lbl.Negated = &neg
+		}
+		if err := lbl.Sign(l.SigningKey); err != nil {
+			return fmt.Errorf("signing label: %w", err)
+		}
+		seq, err := l.Store.AppendLabel(ctx, &lbl)
+		if err != nil {
+			return fmt.Errorf("persisting label: %w", err)
+		}
+		labelsEmittedCount.WithLabelValues(fmt.Sprint(negated)).Inc()
+		l.Logger.Info("emitted label", "uri", uri, "val", val, "neg", negated, "seq", seq)
+		l.broadcast(SeqLabel{Seq: seq, Label: lbl})
+	}
+	return nil
+}
+
+func ptrEqual(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (l *Labeler) broadcast(sl SeqLabel) {
+	l.subsLk.Lock()
+	defer l.subsLk.Unlock()
+	for id, ch := range l.subs {
+		select {
+		case ch <- sl:
+		default:
+			// slow consumer; disconnect them, and they can reconnect with a cursor
+			l.Logger.Warn("dropping slow label subscriber", "subscriber", id)
+			close(ch)
+			delete(l.subs, id)
+		}
+	}
+}
+
+// Registers a channel which will receive all new labels. The returned cleanup function must be called when done. The channel is closed if the subscriber falls too far behind.
+func (l *Labeler) subscribe() (<-chan SeqLabel, func()) {
+	l.subsLk.Lock()
+	defer l.subsLk.Unlock()
+
+	id := l.nextSubID
+	l.nextSubID++
+	ch := make(chan SeqLabel, 1000)
+	l.subs[id] = ch
+	return ch, func() {
+		l.subsLk.Lock()
+		defer l.subsLk.Unlock()
+		if _, ok := l.subs[id]; ok {
+			close(ch)
+			delete(l.subs, id)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLabeler(t *testing.T, store LabelStore) *Labeler {
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLabeler(syntax.DID("did:plc:labeler111"), priv, store, nil)
+}
+
+func testLabelStore(t *testing.T, store LabelStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	l := testLabeler(t, store)
+	pub, err := l.SigningKey.PublicKey()
+	assert.NoError(err)
+
+	acct := "did:plc:abc111"
+	rec := "at://did:plc:abc111/app.bsky.feed.post/3jzfcijpj2z2a"
+	cid := "bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq"
+
+	assert.NoError(l.EmitLabels(ctx, acct, nil, []string{"spam", "!hide"}, false))
+	// duplicate is a no-op
+	assert.NoError(l.EmitLabels(ctx, acct, nil, []string{"spam"}, false))
+	assert.NoError(l.EmitLabels(ctx, rec, &cid, []string{"porn"}, false))
+	// negating a label which was never applied is a no-op
+	assert.NoError(l.EmitLabels(ctx, rec, &cid, []string{"gore"}, true))
+	assert.NoError(l.EmitLabels(ctx, acct, nil, []string{"spam"}, true))
+
+	head, err := store.LastSeq(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(4), head)
+
+	all, err := store.LabelsSince(ctx, 0, 100)
+	assert.NoError(err)
+	assert.Equal(4, len(all))
+	for i, sl := range all {
+		assert.Equal(int64(i+1), sl.Seq)
+		assert.NoError(sl.Label.VerifySyntax())
+		assert.NoError(sl.Label.VerifySignature(pub))
+	}
+
+	page, err := store.LabelsSince(ctx, 2, 1)
+	assert.NoError(err)
+	assert.Equal(1, len(page))
+	assert.Equal("porn", page[0].Label.Val)
+
+	cur, err := store.GetLabel(ctx, acct, "spam")
+	assert.NoError(err)
+	assert.NotNil(cur)
+	assert.True(*cur.Label.Negated)
+	cur, err = store.GetLabel(ctx, acct, "other")
+	assert.NoError(err)
+	assert.Nil(cur)
+
+	// superseded label not returned by query
+	res, err := store.QueryLabels(ctx, []string{acct}, 0, 100)
+	assert.NoError(err)
+	assert.Equal(2, len(res))
+	assert.Equal("!hide", res[0].Label.Val)
+	assert.Equal(int64(4), res[1].Seq)
+
+	res, err = store.QueryLabels(ctx, []string{"at://did:plc:abc111/*"}, 0, 100)
+	assert.NoError(err)
+	assert.Equal(1, len(res))
+	assert.Equal(rec, res[0].Label.URI)
+
+	res, err = store.QueryLabels(ctx, []string{"*"}, 2, 100)
+	assert.NoError(err)
+	assert.Equal(2, len(res))
+
+	res, err = store.QueryLabels(ctx, []string{"did:plc:zzz"}, 0, 100)
+	assert.NoError(err)
+	assert.Empty(res)
+}
+
+func TestMemLabelStore(t *testing.T) {
+	testLabelStore(t, NewMemLabelStore())
+}
+
+func TestPebbleLabelStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "labels.db")
+
+	store, err := NewPebbleLabelStore(path)
+	assert.NoError(err)
+	testLabelStore(t, store)
+	assert.NoError(store.Close())
+
+	// sequence resumes after re-open
+	store, err = NewPebbleLabelStore(path)
+	assert.NoError(err)
+	defer store.Close()
+	head, err := store.LastSeq(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(4), head)
+}
+
+func TestLabelerEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	l := testLabeler(t, NewMemLabelStore())
+	assert.NoError(l.EmitLabels(ctx, "did:plc:abc111", nil, []string{"spam"}, false))
+	assert.NoError(l.EmitLabels(ctx, "did:plc:abc222", nil, []string{"spam"}, false))
+
+	e := echo.New()
+	l.RegisterHandlers(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels?uriPatterns=did:plc:abc2*")
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var out comatproto.LabelQueryLabels_Output
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&out))
+	resp.Body.Close()
+	assert.Equal(1, len(out.Labels))
+	assert.Equal("did:plc:abc222", out.Labels[0].Uri)
+
+	resp, err = http.Get(srv.URL + "/xrpc/com.atproto.label.queryLabels")
+	assert.NoError(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/xrpc/com.atproto.label.subscribeLabels?cursor=1"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NoError(err)
+	defer conn.Close()
+
+	readLabels := func() *comatproto.LabelSubscribeLabels_Labels {
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(err)
+		var evt events.XRPCStreamEvent
+		assert.NoError(evt.Deserialize(bytes.NewReader(msg)))
+		return evt.LabelLabels
+	}
+
+	// replayed from cursor
+	evt := readLabels()
+	assert.Equal(int64(2), evt.Seq)
+	assert.Equal("did:plc:abc222", evt.Labels[0].Uri)
+
+	// live
+	assert.NoError(l.EmitLabels(ctx, "did:plc:abc333", nil, []string{"spam"}, false))
+	evt = readLabels()
+	assert.Equal(int64(3), evt.Seq)
+	assert.Equal("did:plc:abc333", evt.Labels[0].Uri)
+}
@@ -0,0 +1,129 @@
+package labeler
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/label"
+)
+
+// A label, along with the sequence number assigned when it was persisted.
+type SeqLabel struct {
+	Seq   int64
+	Label label.Label
+}
+
+// Persistent, sequenced storage of signed labels.
+//
+// Labels are append-only: negating or re-applying a label appends a new row, which supersedes any earlier label with the same subject URI and value.
+type LabelStore interface {
+	// Persists the label, assigning and returning a new (monotonically increasing) sequence number.
+	AppendLabel(ctx context.Context, l *label.Label) (int64, error)
+	// Returns up to "limit" labels with sequence number greater than "since", in sequence order. Includes superseded labels.
+	LabelsSince(ctx context.Context, since int64, limit int) ([]SeqLabel, error)
+	// Returns the most recent label for the subject URI and value, or nil if there is none.
+	GetLabel(ctx context.Context, uri, val string) (*SeqLabel, error)
+	// Returns the most recent label for every (URI, value) pair where the URI matches one of the patterns, and sequence number is greater than "since", in sequence order. Patterns are either exact URIs, or a prefix followed by '*'.
+	QueryLabels(ctx context.Context, uriPatterns []string, since int64, limit int) ([]SeqLabel, error)
+	// Returns the sequence number of the most recent label, or zero if there are no labels.
+	LastSeq(ctx context.Context) (int64, error)
+}
+
+// In-process LabelStore, intended for tests and development. Labels are lost on restart.
+type MemLabelStore struct {
+	mu     sync.RWMutex
+	labels []SeqLabel
+	// index in to "labels" of the most recent label for each URI and value
+	current map[string]int
+}
+
+func NewMemLabelStore() *MemLabelStore {
+	return &MemLabelStore{
+		current: make(map[string]int),
+	}
+}
+
+func labelKey(uri, val string) string {
+	return uri + "\x00" + val
+}
+
+func (s *MemLabelStore) AppendLabel(ctx context.Context, l *label.Label) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := int64(len(s.labels) + 1)
+	s.labels = append(s.labels, SeqLabel{Seq: seq, Label: *l})
+	s.current[labelKey(l.URI, l.Val)] = len(s.labels) - 1
+	return seq, nil
+}
+
+func (s *MemLabelStore) LabelsSince(ctx context.Context, since int64, limit int) ([]SeqLabel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if since < 0 {
+		since = 0
+	}
+	if since >= int64(len(s.labels)) {
+		return []SeqLabel{}, nil
+	}
+	end := min(int(since)+limit, len(s.labels))
+	out := make([]SeqLabel, end-int(since))
+	copy(out, s.labels[since:end])
+	return out, nil
+}
+
+func (s *MemLabelStore) GetLabel(ctx context.Context, uri, val string) (*SeqLabel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.current[labelKey(uri, val)]
+	if !ok {
+		return nil, nil
+	}
+	sl := s.labels[idx]
+	return &sl, nil
+}
+
+func (s *MemLabelStore) QueryLabels(ctx context.Context, uriPatterns []string, since int64, limit int) ([]SeqLabel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var idxs []int
+	for key, idx := range s.current {
+		uri, _, _ := strings.Cut(key, "\x00")
+		if s.labels[idx].Seq > since && matchURIPatterns(uriPatterns, uri) {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Ints(idxs)
+	if len(idxs) > limit {
+		idxs = idxs[:limit]
+	}
+	out := make([]SeqLabel, len(idxs))
+	for i, idx := range idxs {
+		out[i] = s.labels[idx]
+	}
+	return out, nil
+}
+
+func (s *MemLabelStore) LastSeq(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.labels)), nil
+}
+
+func matchURIPatterns(patterns []string, uri string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(uri, prefix) {
+				return true
+			}
+		} else if p == uri {
+			return true
+		}
+	}
+	return false
+}
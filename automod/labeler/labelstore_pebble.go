@@ -0,0 +1,216 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/label"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// LabelStore persisted in a local pebble database.
+//
+// Schema:
+// S{uint64 seq} : {label CBOR}
+// U{uri}\x00{val} : {uint64 seq}
+type PebbleLabelStore struct {
+	db *pebble.DB
+
+	// serializes appends, so sequence numbers are assigned in order
+	mu      sync.Mutex
+	lastSeq int64
+}
+
+func NewPebbleLabelStore(path string) (*PebbleLabelStore, error) {
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not open label db: %w", path, err)
+	}
+	s := &PebbleLabelStore{db: db}
+
+	iter, err := db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte{'S'},
+		UpperBound: []byte{'T'},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	if iter.Last() {
+		s.lastSeq = parseSeqKey(iter.Key())
+	}
+	return s, nil
+}
+
+func (s *PebbleLabelStore) Close() error {
+	if err := s.db.Flush(); err != nil {
+		return err
+	}
+	return s.db.Close()
+}
+
+func makeSeqKey(seq int64) []byte {
+	out := make([]byte, 9)
+	out[0] = 'S'
+	binary.BigEndian.PutUint64(out[1:], uint64(seq))
+	return out
+}
+
+func parseSeqKey(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key[1:]))
+}
+
+func makeURIKey(uri, val string) []byte {
+	out := make([]byte, 0, 2+len(uri)+len(val))
+	out = append(out, 'U')
+	out = append(out, uri...)
+	out = append(out, 0)
+	out = append(out, val...)
+	return out
+}
+
+// smallest key which is greater than every key with the given prefix
+func prefixUpperBound(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (s *PebbleLabelStore) AppendLabel(ctx context.Context, l *label.Label) (int64, error) {
+	buf := new(bytes.Buffer)
+	if err := l.MarshalCBOR(buf); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.lastSeq + 1
+	seqBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(seqBytes, uint64(seq))
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(makeSeqKey(seq), buf.Bytes(), nil); err != nil {
+		return 0, err
+	}
+	if err := batch.Set(makeURIKey(l.URI, l.Val), seqBytes, nil); err != nil {
+		return 0, err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return 0, err
+	}
+	s.lastSeq = seq
+	return seq, nil
+}
+
+func (s *PebbleLabelStore) getSeq(seq int64) (*SeqLabel, error) {
+	val, closer, err := s.db.Get(makeSeqKey(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	sl := SeqLabel{Seq: seq}
+	if err := sl.Label.UnmarshalCBOR(bytes.NewReader(val)); err != nil {
+		return nil, fmt.Errorf("decoding label seq=%d: %w", seq, err)
+	}
+	return &sl, nil
+}
+
+func (s *PebbleLabelStore) LabelsSince(ctx context.Context, since int64, limit int) ([]SeqLabel, error) {
+	iter, err := s.db.NewIterWithContext(ctx, &pebble.IterOptions{
+		LowerBound: makeSeqKey(since + 1),
+		UpperBound: []byte{'T'},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	out := []SeqLabel{}
+	for iter.First(); iter.Valid() && len(out) < limit; iter.Next() {
+		sl := SeqLabel{Seq: parseSeqKey(iter.Key())}
+		if err := sl.Label.UnmarshalCBOR(bytes.NewReader(iter.Value())); err != nil {
+			return nil, fmt.Errorf("decoding label seq=%d: %w", sl.Seq, err)
+		}
+		out = append(out, sl)
+	}
+	return out, iter.Error()
+}
+
+func (s *PebbleLabelStore) GetLabel(ctx context.Context, uri, val string) (*SeqLabel, error) {
+	raw, closer, err := s.db.Get(makeURIKey(uri, val))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	seq := int64(binary.BigEndian.Uint64(raw))
+	closer.Close()
+	return s.getSeq(seq)
+}
+
+func (s *PebbleLabelStore) QueryLabels(ctx context.Context, uriPatterns []string, since int64, limit int) ([]SeqLabel, error) {
+	var seqs []int64
+	for _, p := range uriPatterns {
+		var prefix []byte
+		if pre, ok := strings.CutSuffix(p, "*"); ok {
+			prefix = append([]byte{'U'}, pre...)
+		} else {
+			prefix = append(append([]byte{'U'}, p...), 0)
+		}
+		iter, err := s.db.NewIterWithContext(ctx, &pebble.IterOptions{
+			LowerBound: prefix,
+			UpperBound: prefixUpperBound(prefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			seq := int64(binary.BigEndian.Uint64(iter.Value()))
+			if seq > since {
+				seqs = append(seqs, seq)
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// patterns may overlap
+	slices.Sort(seqs)
+	seqs = slices.Compact(seqs)
+	if len(seqs) > limit {
+		seqs = seqs[:limit]
+	}
+
+	out := make([]SeqLabel, 0, len(seqs))
+	for _, seq := range seqs {
+		sl, err := s.getSeq(seq)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *sl)
+	}
+	return out, nil
+}
+
+func (s *PebbleLabelStore) LastSeq(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq, nil
+}
@@ -0,0 +1,16 @@
+package labeler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var labelsEmittedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_labeler_labels_emitted",
+	Help: "Number of signed labels created, by whether they are negations",
+}, []string{"negated"})
+
+var activeSubscribersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "automod_labeler_active_subscribers",
+	Help: "Number of active subscribeLabels WebSocket connections",
+})
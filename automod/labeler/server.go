@@ -0,0 +1,193 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// number of labels read from the store at a time when replaying from a cursor
+const replayPageSize = 500
+
+// Registers the labeler XRPC endpoints on an echo server.
+func (l *Labeler) RegisterHandlers(e *echo.Echo) {
+	e.GET("/xrpc/com.atproto.label.queryLabels", l.HandleQueryLabels)
+	e.GET("/xrpc/com.atproto.label.subscribeLabels", l.HandleSubscribeLabels)
+}
+
+func (l *Labeler) HandleQueryLabels(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	params := c.QueryParams()
+	patterns := params["uriPatterns"]
+	if len(patterns) == 0 {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "uriPatterns is required"})
+	}
+
+	// this labeler only has labels from a single source
+	if sources := params["sources"]; len(sources) > 0 {
+		found := false
+		for _, src := range sources {
+			if src == l.SourceDID.String() {
+				found = true
+			}
+		}
+		if !found {
+			return c.JSON(http.StatusOK, comatproto.LabelQueryLabels_Output{Labels: []*comatproto.LabelDefs_Label{}})
+		}
+	}
+
+	limit := 50
+	if q := c.QueryParam("limit"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || v < 1 || v > 250 {
+			return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "limit must be an integer between 1 and 250"})
+		}
+		limit = v
+	}
+	var cursor int64
+	if q := c.QueryParam("cursor"); q != "" {
+		v, err := strconv.ParseInt(q, 10, 64)
+		if err != nil || v < 0 {
+			return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "invalid cursor"})
+		}
+		cursor = v
+	}
+
+	rows, err := l.Store.QueryLabels(ctx, patterns, cursor, limit)
+	if err != nil {
+		return fmt.Errorf("querying labels: %w", err)
+	}
+	out := comatproto.LabelQueryLabels_Output{
+		Labels: make([]*comatproto.LabelDefs_Label, len(rows)),
+	}
+	for i, sl := range rows {
+		lex := sl.Label.ToLexicon()
+		out.Labels[i] = &lex
+	}
+	if len(rows) == limit {
+		next := strconv.FormatInt(rows[len(rows)-1].Seq, 10)
+		out.Cursor = &next
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func labelsEvent(sl SeqLabel) *events.XRPCStreamEvent {
+	lex := sl.Label.ToLexicon()
+	return &events.XRPCStreamEvent{
+		LabelLabels: &comatproto.LabelSubscribeLabels_Labels{
+			Seq:    sl.Seq,
+			Labels: []*comatproto.LabelDefs_Label{&lex},
+		},
+	}
+}
+
+func writeEvent(conn *websocket.Conn, evt *events.XRPCStreamEvent) error {
+	wc, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if err := evt.Serialize(wc); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return wc.Close()
+}
+
+func (l *Labeler) HandleSubscribeLabels(c echo.Context) error {
+	var since *int64
+	if sinceVal := c.QueryParam("cursor"); sinceVal != "" {
+		sval, err := strconv.ParseInt(sinceVal, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "invalid cursor"})
+		}
+		since = &sval
+	}
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	conn, err := websocket.Upgrade(c.Response(), c.Request(), c.Response().Header(), 10<<10, 10<<10)
+	if err != nil {
+		return fmt.Errorf("upgrading websocket: %w", err)
+	}
+	defer conn.Close()
+
+	activeSubscribersGauge.Inc()
+	defer activeSubscribersGauge.Dec()
+
+	// read (and discard) messages from the client, to process control frames and detect disconnects
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	// subscribe before replaying, so no labels are missed in between; any overlap is skipped below
+	live, cleanup := l.subscribe()
+	defer cleanup()
+
+	var lastSeq int64
+	if since != nil {
+		head, err := l.Store.LastSeq(ctx)
+		if err != nil {
+			return err
+		}
+		if *since > head {
+			msg := "cursor is in the future"
+			return writeEvent(conn, &events.XRPCStreamEvent{Error: &events.ErrorFrame{Error: "FutureCursor", Message: msg}})
+		}
+		lastSeq = *since
+		for {
+			rows, err := l.Store.LabelsSince(ctx, lastSeq, replayPageSize)
+			if err != nil {
+				return fmt.Errorf("replaying labels: %w", err)
+			}
+			for _, sl := range rows {
+				if err := writeEvent(conn, labelsEvent(sl)); err != nil {
+					return nil
+				}
+				lastSeq = sl.Seq
+			}
+			if len(rows) < replayPageSize {
+				break
+			}
+		}
+	}
+
+	pingTicker := time.NewTicker(30 * time.Second)
+	defer pingTicker.Stop()
+	for {
+		select {
+		case sl, ok := <-live:
+			if !ok {
+				l.Logger.Info("label subscriber disconnected (too slow)", "remote_addr", c.RealIP())
+				return nil
+			}
+			if sl.Seq <= lastSeq {
+				continue
+			}
+			if err := writeEvent(conn, labelsEvent(sl)); err != nil {
+				return nil
+			}
+			lastSeq = sl.Seq
+		case <-pingTicker.C:
+			if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
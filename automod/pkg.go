@@ -3,6 +3,7 @@ package automod
 import (
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/engine"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
 )
 
 type Engine = engine.Engine
@@ -13,6 +14,7 @@ type AccountPrivate = engine.AccountPrivate
 type RuleSet = engine.RuleSet
 
 type Notifier = engine.Notifier
+type LabelEmitter = engine.LabelEmitter
 type SlackNotifier = engine.SlackNotifier
 
 type AccountContext = engine.AccountContext
@@ -20,10 +22,13 @@ type RecordContext = engine.RecordContext
 type OzoneEventContext = engine.OzoneEventContext
 type RecordOp = engine.RecordOp
 
+type Window = countstore.Window
+
 type IdentityRuleFunc = engine.IdentityRuleFunc
 type RecordRuleFunc = engine.RecordRuleFunc
 type PostRuleFunc = engine.PostRuleFunc
 type ProfileRuleFunc = engine.ProfileRuleFunc
+type CollectionRuleFunc = engine.CollectionRuleFunc
 type BlobRuleFunc = engine.BlobRuleFunc
 type OzoneEventRuleFunc = engine.OzoneEventRuleFunc
 
@@ -39,7 +44,16 @@ var (
 	PeriodDay   = countstore.PeriodDay
 	PeriodHour  = countstore.PeriodHour
 
+	WindowFiveMinutes = countstore.WindowFiveMinutes
+	WindowHour        = countstore.WindowHour
+	WindowDay         = countstore.WindowDay
+
 	CreateOp = engine.CreateOp
 	UpdateOp = engine.UpdateOp
 	DeleteOp = engine.DeleteOp
 )
+
+// Wraps a rule function which takes a concrete record type as a CollectionRuleFunc. See `engine.TypedRecordRule`.
+func TypedRecordRule[T lexutil.CBOR](f func(c *RecordContext, rec T) error) CollectionRuleFunc {
+	return engine.TypedRecordRule(f)
+}
@@ -60,5 +60,7 @@ func DefaultRules() automod.RuleSet {
 			HarassmentProtectionOzoneEventRule,
 		},
 	}
+	rules.AddCollectionRule("app.bsky.graph.starterpack", automod.TypedRecordRule(BadWordStarterPackRule))
+	rules.AddCollectionRule("app.bsky.graph.follow", automod.TypedRecordRule(FollowFarmingRule))
 	return rules
 }
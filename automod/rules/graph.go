@@ -0,0 +1,39 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/helpers"
+)
+
+var followFarmNewAccountThreshold = 50
+var followFarmNewAccountAge = 7 * 24 * time.Hour
+
+// looks for coordinated follow-farming: many new accounts all following the same target account. The new accounts doing the following get flagged.
+func FollowFarmingRule(c *automod.RecordContext, follow *appbsky.GraphFollow) error {
+	if c.RecordOp.Action != automod.CreateOp || !helpers.AccountIsYoungerThan(&c.AccountContext, followFarmNewAccountAge) {
+		return nil
+	}
+	target, err := syntax.ParseDID(follow.Subject)
+	if err != nil {
+		return nil
+	}
+
+	count := c.GetNewAccountFollowCount(target)
+	if count < followFarmNewAccountThreshold {
+		return nil
+	}
+	c.Logger.Info("follow-farm", "target", target, "new-account-follows", count)
+	c.AddAccountFlag("follow-farm")
+	// only report the first few accounts which cross the threshold, not the whole ring
+	if count < followFarmNewAccountThreshold+5 {
+		recent := c.GetRecentFollowers(target, time.Hour)
+		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("possible follow-farm ring: new account following %s, along with %d other new accounts today (%d followers in past hour)", target, count, len(recent)))
+		c.Notify("slack")
+	}
+	return nil
+}
@@ -116,6 +116,24 @@ func BadWordOtherRecordRule(c *automod.RecordContext) error {
 			text += " " + *generator.Description
 		}
 	}
+	badWordNameTextCheck(c, name, text)
+	return nil
+}
+
+var _ automod.RecordRuleFunc = BadWordOtherRecordRule
+
+// same checks as BadWordOtherRecordRule, for starter packs
+func BadWordStarterPackRule(c *automod.RecordContext, sp *appbsky.GraphStarterpack) error {
+	text := ""
+	if sp.Description != nil {
+		text = *sp.Description
+	}
+	badWordNameTextCheck(c, sp.Name, text)
+	return nil
+}
+
+// helper for checking the name (short, displayed prominently) and description text of non-post records
+func badWordNameTextCheck(c *automod.RecordContext, name, text string) {
 	if name != "" {
 		// check for explicit slurs or bad word tokens
 		word := keyword.SlugContainsExplicitSlur(keyword.Slugify(name))
@@ -154,11 +172,8 @@ func BadWordOtherRecordRule(c *automod.RecordContext) error {
 			}
 		}
 	}
-	return nil
 }
 
-var _ automod.RecordRuleFunc = BadWordOtherRecordRule
-
 // scans the record-key for all records
 func BadWordRecordKeyRule(c *automod.RecordContext) error {
 	// check record key
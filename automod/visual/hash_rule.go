@@ -0,0 +1,94 @@
+package visual
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/automod"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+// Default maximum Hamming distance (out of 64 bits) for a hash to be considered a match.
+const DefaultHashMaxDistance = 8
+
+// Configuration for a single hash list checked by ImageHashMatcher.
+type HashListConfig struct {
+	// Name of the list in the HashStore
+	Name string
+	// Maximum Hamming distance for a match. If zero, DefaultHashMaxDistance is used.
+	MaxDistance int
+	// If true, matching records get reported for mod review.
+	Report bool
+	// If true, matching records (and the matching blob) get taken down.
+	Takedown bool
+}
+
+// Matches image blobs against local lists of perceptual hashes. Does not depend on any external service.
+type ImageHashMatcher struct {
+	Store HashStore
+	Lists []HashListConfig
+
+	// If set, hashes of images in records which are being taken down (either by this rule, or by an earlier record rule) are added to this list, so that re-posts get caught. This list should usually also be included in "Lists".
+	TakedownList string
+}
+
+func NewImageHashMatcher(store HashStore) *ImageHashMatcher {
+	return &ImageHashMatcher{
+		Store: store,
+	}
+}
+
+func (m *ImageHashMatcher) HashMatchBlobRule(c *automod.RecordContext, blob lexutil.LexBlob, data []byte) error {
+
+	if !strings.HasPrefix(blob.MimeType, "image/") {
+		return nil
+	}
+
+	hashes, err := HashImageBytes(data)
+	if err != nil {
+		// many image formats (eg, webp) aren't supported by the standard library; not worth failing the rule
+		c.Logger.Debug("skipping image hash for blob", "cid", blob.Ref.String(), "mimeType", blob.MimeType, "err", err)
+		imageHashCount.WithLabelValues("unsupported").Inc()
+		return nil
+	}
+	imageHashCount.WithLabelValues("hashed").Inc()
+
+	for _, cfg := range m.Lists {
+		maxDist := cfg.MaxDistance
+		if maxDist == 0 {
+			maxDist = DefaultHashMaxDistance
+		}
+		for _, h := range hashes {
+			match, err := m.Store.MatchHash(c.Ctx, cfg.Name, h, maxDist)
+			if err != nil {
+				return fmt.Errorf("matching image hash: %w", err)
+			}
+			if match == nil {
+				continue
+			}
+			imageHashMatchCount.WithLabelValues(cfg.Name).Inc()
+			c.Logger.Info("image hash match", "cid", blob.Ref.String(), "list", cfg.Name, "hash", h.String(), "matched", match.Hash.String(), "distance", match.Distance)
+			c.AddRecordFlag("image-hash-" + cfg.Name)
+			if cfg.Report {
+				c.ReportRecord(automod.ReportReasonOther, fmt.Sprintf("image matched hash list: %s (distance=%d)", cfg.Name, match.Distance))
+			}
+			if cfg.Takedown {
+				c.TakedownRecord()
+				c.TakedownBlob(blob.Ref.String())
+			}
+			// one match per list is enough
+			break
+		}
+	}
+
+	if m.TakedownList != "" && c.IsRecordTakedown() {
+		for _, h := range hashes {
+			if err := m.Store.AddHash(c.Ctx, m.TakedownList, h); err != nil {
+				return fmt.Errorf("recording taken-down image hash: %w", err)
+			}
+		}
+		c.Logger.Info("recorded hashes of taken-down image", "cid", blob.Ref.String(), "list", m.TakedownList)
+	}
+
+	return nil
+}
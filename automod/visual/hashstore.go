@@ -0,0 +1,110 @@
+package visual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Interface for named lists of image hashes, with approximate (Hamming distance) matching.
+//
+// Lists are managed similarly to `setstore.SetStore` sets: they are referenced by name, and a list which doesn't exist is treated as empty.
+type HashStore interface {
+	// Returns the closest hash in the named list which is within maxDistance bits of the provided hash, or nil if there is no such hash.
+	MatchHash(ctx context.Context, name string, hash ImageHash, maxDistance int) (*HashMatch, error)
+	// Adds a hash to the named list, creating the list if needed. Adding a hash already in the list is a no-op.
+	AddHash(ctx context.Context, name string, hash ImageHash) error
+}
+
+type HashMatch struct {
+	List     string
+	Hash     ImageHash
+	Distance int
+}
+
+// In-process HashStore. Matching is a linear scan, which is fine for lists up to tens of thousands of hashes.
+type MemHashStore struct {
+	mu    sync.RWMutex
+	Lists map[string][]ImageHash
+
+	// If non-zero, lists which grow beyond this length via AddHash have their oldest hashes dropped. Lists loaded from files are not truncated.
+	MaxListLength int
+}
+
+func NewMemHashStore() *MemHashStore {
+	return &MemHashStore{
+		Lists: make(map[string][]ImageHash),
+	}
+}
+
+func (s *MemHashStore) MatchHash(ctx context.Context, name string, hash ImageHash, maxDistance int) (*HashMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best *HashMatch
+	for _, h := range s.Lists[name] {
+		dist, ok := hash.Distance(h)
+		if !ok || dist > maxDistance {
+			continue
+		}
+		if best == nil || dist < best.Distance {
+			best = &HashMatch{List: name, Hash: h, Distance: dist}
+		}
+	}
+	return best, nil
+}
+
+func (s *MemHashStore) AddHash(ctx context.Context, name string, hash ImageHash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range s.Lists[name] {
+		if h == hash {
+			return nil
+		}
+	}
+	l := append(s.Lists[name], hash)
+	if s.MaxListLength > 0 && len(l) > s.MaxListLength {
+		l = l[len(l)-s.MaxListLength:]
+	}
+	s.Lists[name] = l
+	return nil
+}
+
+// Loads hash lists from a JSON file. The format is the same as for static sets: an object mapping list names to arrays of hash strings (eg, "phash:c3a1f0e0d0c0b0a0").
+func (s *MemHashStore) LoadFromFileJSON(p string) error {
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	var lists map[string][]string
+	if err := json.Unmarshal(raw, &lists); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, l := range lists {
+		hashes := make([]ImageHash, 0, len(l))
+		for _, raw := range l {
+			h, err := ParseImageHash(raw)
+			if err != nil {
+				return fmt.Errorf("hash list %s: %w", name, err)
+			}
+			hashes = append(hashes, h)
+		}
+		s.Lists[name] = hashes
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package visual
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	// register decoders for common image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Identifies the algorithm used to compute an ImageHash. Hashes of different kinds can not be compared.
+type HashKind string
+
+var (
+	// DCT-based perceptual hash ("pHash"). Robust to re-encoding, scaling, and minor color changes.
+	HashKindPerceptual HashKind = "phash"
+	// Gradient-based difference hash ("dHash"). Cheaper than pHash, and complementary for some edits.
+	HashKindDifference HashKind = "dhash"
+)
+
+// A 64-bit perceptual hash of an image, tagged with the algorithm which produced it.
+//
+// String representation is the kind, a colon, and 16 lower-case hex characters, eg "phash:c3a1f0e0d0c0b0a0".
+type ImageHash struct {
+	Kind  HashKind
+	Value uint64
+}
+
+func (h ImageHash) String() string {
+	return fmt.Sprintf("%s:%016x", h.Kind, h.Value)
+}
+
+// Parses the string representation of an ImageHash (see ImageHash.String)
+func ParseImageHash(raw string) (ImageHash, error) {
+	kind, val, ok := strings.Cut(raw, ":")
+	if !ok {
+		return ImageHash{}, fmt.Errorf("image hash missing kind prefix: %s", raw)
+	}
+	var h ImageHash
+	switch HashKind(kind) {
+	case HashKindPerceptual, HashKindDifference:
+		h.Kind = HashKind(kind)
+	default:
+		return ImageHash{}, fmt.Errorf("unsupported image hash kind: %s", kind)
+	}
+	if len(val) != 16 {
+		return ImageHash{}, fmt.Errorf("image hash value must be 16 hex characters: %s", raw)
+	}
+	v, err := strconv.ParseUint(val, 16, 64)
+	if err != nil {
+		return ImageHash{}, fmt.Errorf("parsing image hash value: %w", err)
+	}
+	h.Value = v
+	return h, nil
+}
+
+// Returns the number of differing bits between two hashes, and whether the hashes are comparable (same kind).
+func (h ImageHash) Distance(other ImageHash) (int, bool) {
+	if h.Kind != other.Kind {
+		return 0, false
+	}
+	return HammingDistance(h.Value, other.Value), true
+}
+
+// Number of differing bits between two 64-bit hash values.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Decodes raw image bytes (JPEG, PNG, or GIF) and computes both perceptual and difference hashes.
+func HashImageBytes(data []byte) ([]ImageHash, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	return []ImageHash{PerceptualHash(img), DifferenceHash(img)}, nil
+}
+
+// Computes a 64-bit DCT perceptual hash ("pHash").
+//
+// The image is reduced to a 32x32 grayscale thumbnail, a 2D DCT is computed, and each of the 64 lowest-frequency coefficients (the top-left 8x8 block) is compared against the median of that block, excluding the DC term.
+func PerceptualHash(img image.Image) ImageHash {
+	const size = 32
+	const block = 8
+
+	pixels := grayscaleThumbnail(img, size, size)
+	coeffs := dct2D(pixels, size)
+
+	low := make([]float64, 0, block*block)
+	for y := 0; y < block; y++ {
+		for x := 0; x < block; x++ {
+			low = append(low, coeffs[y*size+x])
+		}
+	}
+
+	// median excludes the DC (average brightness) term, which would otherwise dominate
+	sorted := make([]float64, len(low)-1)
+	copy(sorted, low[1:])
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var val uint64
+	for i, c := range low {
+		if c > median {
+			val |= 1 << uint(63-i)
+		}
+	}
+	return ImageHash{Kind: HashKindPerceptual, Value: val}
+}
+
+// Computes a 64-bit difference hash ("dHash").
+//
+// The image is reduced to a 9x8 grayscale thumbnail, and each bit records whether a pixel is brighter than its right-hand neighbor.
+func DifferenceHash(img image.Image) ImageHash {
+	const width = 9
+	const height = 8
+
+	pixels := grayscaleThumbnail(img, width, height)
+
+	var val uint64
+	i := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			if pixels[y*width+x] > pixels[y*width+x+1] {
+				val |= 1 << uint(63-i)
+			}
+			i++
+		}
+	}
+	return ImageHash{Kind: HashKindDifference, Value: val}
+}
+
+// Reduces an image to a width x height grid of luminance values, by averaging all source pixels which fall in each cell (box filter).
+func grayscaleThumbnail(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	srcW := bounds.Dx()
+	srcH := bounds.Dy()
+	out := make([]float64, width*height)
+	if srcW == 0 || srcH == 0 {
+		return out
+	}
+
+	for ty := 0; ty < height; ty++ {
+		y0 := bounds.Min.Y + ty*srcH/height
+		y1 := bounds.Min.Y + (ty+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for tx := 0; tx < width; tx++ {
+			x0 := bounds.Min.X + tx*srcW/width
+			x1 := bounds.Min.X + (tx+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum float64
+			var count int
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			out[ty*width+tx] = sum / float64(count)
+		}
+	}
+	return out
+}
+
+// Naive separable 2D DCT-II over a square n x n matrix (row-major). Fine for the small fixed sizes used here.
+func dct2D(in []float64, n int) []float64 {
+	cos := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cos[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += in[y*n+i] * cos[k*n+i]
+			}
+			rows[y*n+k] = sum
+		}
+	}
+
+	out := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for i := 0; i < n; i++ {
+				sum += rows[i*n+x] * cos[k*n+i]
+			}
+			out[k*n+x] = sum
+		}
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package visual
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+// position and brightness of some blurry spots
+var testBlobs = [][3]float64{
+	{0.2, 0.3, 180},
+	{0.7, 0.2, 120},
+	{0.5, 0.8, 200},
+	{0.85, 0.7, 90},
+	{0.1, 0.9, 150},
+}
+
+// synthetic test image with some low-frequency structure
+func testImage(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx := float64(x) / float64(w)
+			fy := float64(y) / float64(h)
+			v := 60 * fx
+			for _, b := range testBlobs {
+				d := (fx-b[0])*(fx-b[0]) + (fy-b[1])*(fy-b[1])
+				v += b[2] * math.Exp(-d/0.01)
+			}
+			v = math.Min(v, 255)
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{R: uint8(v), G: uint8(v / 2), B: uint8(255 - v), A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageHashStability(t *testing.T) {
+	assert := assert.New(t)
+
+	orig := testImage(400, 300, false)
+	other := testImage(400, 300, true)
+
+	// re-encode at a different size as lossy JPEG
+	small := testImage(200, 150, false)
+	jpegBuf := new(bytes.Buffer)
+	assert.NoError(jpeg.Encode(jpegBuf, small, &jpeg.Options{Quality: 60}))
+
+	origHashes, err := HashImageBytes(encodePNG(t, orig))
+	assert.NoError(err)
+	smallHashes, err := HashImageBytes(jpegBuf.Bytes())
+	assert.NoError(err)
+	otherHashes, err := HashImageBytes(encodePNG(t, other))
+	assert.NoError(err)
+
+	for i := range origHashes {
+		dist, ok := origHashes[i].Distance(smallHashes[i])
+		assert.True(ok)
+		assert.LessOrEqual(dist, DefaultHashMaxDistance, origHashes[i].Kind)
+
+		dist, ok = origHashes[i].Distance(otherHashes[i])
+		assert.True(ok)
+		assert.Greater(dist, DefaultHashMaxDistance, origHashes[i].Kind)
+	}
+
+	_, ok := origHashes[0].Distance(origHashes[1])
+	assert.False(ok)
+
+	_, err = HashImageBytes([]byte("not an image"))
+	assert.Error(err)
+}
+
+func TestParseImageHash(t *testing.T) {
+	assert := assert.New(t)
+
+	h, err := ParseImageHash("phash:c3a1f0e0d0c0b0a0")
+	assert.NoError(err)
+	assert.Equal(HashKindPerceptual, h.Kind)
+	assert.Equal(uint64(0xc3a1f0e0d0c0b0a0), h.Value)
+	assert.Equal("phash:c3a1f0e0d0c0b0a0", h.String())
+
+	for _, raw := range []string{"", "c3a1f0e0d0c0b0a0", "ahash:c3a1f0e0d0c0b0a0", "dhash:c3a1", "dhash:zzzzzzzzzzzzzzzz"} {
+		_, err := ParseImageHash(raw)
+		assert.Error(err, raw)
+	}
+}
+
+func TestMemHashStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	hs := NewMemHashStore()
+	h1 := ImageHash{Kind: HashKindPerceptual, Value: 0xff00ff00ff00ff00}
+	assert.NoError(hs.AddHash(ctx, "spam", h1))
+	assert.NoError(hs.AddHash(ctx, "spam", h1))
+	assert.Equal(1, len(hs.Lists["spam"]))
+
+	m, err := hs.MatchHash(ctx, "spam", ImageHash{Kind: HashKindPerceptual, Value: 0xff00ff00ff00ff07}, 4)
+	assert.NoError(err)
+	assert.NotNil(m)
+	assert.Equal(3, m.Distance)
+
+	m, err = hs.MatchHash(ctx, "spam", ImageHash{Kind: HashKindPerceptual, Value: 0xff00ff00ff00ff07}, 2)
+	assert.NoError(err)
+	assert.Nil(m)
+
+	m, err = hs.MatchHash(ctx, "spam", ImageHash{Kind: HashKindDifference, Value: h1.Value}, 4)
+	assert.NoError(err)
+	assert.Nil(m)
+
+	m, err = hs.MatchHash(ctx, "missing", h1, 4)
+	assert.NoError(err)
+	assert.Nil(m)
+
+	// oldest hashes are dropped beyond the max length
+	hs.MaxListLength = 2
+	for i := range 3 {
+		assert.NoError(hs.AddHash(ctx, "recent", ImageHash{Kind: HashKindPerceptual, Value: uint64(i)}))
+	}
+	assert.Equal([]ImageHash{{Kind: HashKindPerceptual, Value: 1}, {Kind: HashKindPerceptual, Value: 2}}, hs.Lists["recent"])
+}
+
+func TestHashMatchBlobRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := engine.EngineTestFixture()
+	am1 := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+	cid1 := syntax.CID("cid123")
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am1.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+	}
+	blobCID, err := cid.Decode("bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity")
+	assert.NoError(err)
+	blob := lexutil.LexBlob{
+		Ref:      lexutil.LexLink(blobCID),
+		MimeType: "image/png",
+	}
+	imgBytes := encodePNG(t, testImage(300, 300, false))
+
+	hs := NewMemHashStore()
+	m := NewImageHashMatcher(hs)
+	m.Lists = []HashListConfig{{Name: "takedowns", Takedown: true}}
+	m.TakedownList = "takedowns"
+
+	// no match against empty list
+	c1 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(m.HashMatchBlobRule(&c1, blob, imgBytes))
+	eff1 := engine.ExtractEffects(&c1.BaseContext)
+	assert.Empty(eff1.RecordFlags)
+	assert.False(eff1.RecordTakedown)
+	assert.Empty(hs.Lists["takedowns"])
+
+	// an earlier rule takes down the record; hashes get recorded
+	c2 := engine.NewRecordContext(ctx, &eng, am1, op)
+	c2.TakedownRecord()
+	assert.NoError(m.HashMatchBlobRule(&c2, blob, imgBytes))
+	assert.Equal(2, len(hs.Lists["takedowns"]))
+
+	// a re-post of the same image is caught
+	c3 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(m.HashMatchBlobRule(&c3, blob, imgBytes))
+	eff3 := engine.ExtractEffects(&c3.BaseContext)
+	assert.Equal([]string{"image-hash-takedowns"}, eff3.RecordFlags)
+	assert.True(eff3.RecordTakedown)
+	assert.Equal([]string{blobCID.String()}, eff3.BlobTakedowns)
+	assert.Equal(2, len(hs.Lists["takedowns"]))
+}
@@ -24,3 +24,13 @@ var abyssAPICount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_abyss_api_count",
 	Help: "Number of abyss image scanning API calls, by HTTP status code",
 }, []string{"status"})
+
+var imageHashCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_image_hash_count",
+	Help: "Number of image blobs processed for perceptual hashing, by outcome",
+}, []string{"outcome"})
+
+var imageHashMatchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_image_hash_match_count",
+	Help: "Number of image hash list matches, by list name",
+}, []string{"list"})
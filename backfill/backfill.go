@@ -14,6 +14,7 @@ import (
 	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
 
@@ -87,6 +88,9 @@ type Backfiller struct {
 	stop chan chan struct{}
 
 	Directory identity.Directory
+
+	// Optional; if set, events passed to HandleEvent are verified (signatures, MST inversion, and prevData chaining) before being processed. Invalid events are dropped, and repos are re-synced when a gap is detected.
+	Verifier *events.RepoVerifier
 }
 
 var (
@@ -522,6 +526,22 @@ func (bf *Backfiller) getRecord(ctx context.Context, r *repo.Repo, op *atproto.S
 }
 
 func (bf *Backfiller) HandleEvent(ctx context.Context, evt *atproto.SyncSubscribeRepos_Commit) error {
+	if bf.Verifier != nil {
+		if err := bf.Verifier.VerifyCommit(ctx, evt); err != nil {
+			switch {
+			case errors.Is(err, events.ErrStaleCommit):
+				return nil
+			case errors.Is(err, events.ErrRepoGap):
+				slog.Info("re-syncing repo after gap in commit chain", "source", "backfiller", "name", bf.Name, "repo", evt.Repo, "err", err)
+				if err := bf.Resync(ctx, evt.Repo); err != nil {
+					return fmt.Errorf("failed to re-sync repo %q: %w", evt.Repo, err)
+				}
+			default:
+				return fmt.Errorf("event failed verification: %w", err)
+			}
+		}
+	}
+
 	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
 	if err != nil {
 		return fmt.Errorf("failed to read event repo: %w", err)
@@ -595,6 +615,33 @@ func (bf *Backfiller) HandleEvent(ctx context.Context, evt *atproto.SyncSubscrib
 	return nil
 }
 
+// Resync re-enqueues a full backfill of a repo, discarding any buffered ops. HandleCreateRecord will be called for every record currently in the repo. If a backfill of the repo is already enqueued or in progress, this is a no-op.
+func (bf *Backfiller) Resync(ctx context.Context, repo string) error {
+	j, err := bf.Store.GetJob(ctx, repo)
+	if errors.Is(err, ErrJobNotFound) || (err == nil && j == nil) {
+		return bf.Store.EnqueueJob(ctx, repo)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch j.State() {
+	case StateEnqueued, StateInProgress:
+		return nil
+	}
+	if err := j.ClearBufferedOps(ctx); err != nil {
+		return err
+	}
+	if err := j.SetRev(ctx, ""); err != nil {
+		return err
+	}
+	if err := j.SetState(ctx, StateEnqueued); err != nil {
+		return err
+	}
+	backfillJobsEnqueued.WithLabelValues(bf.Name).Inc()
+	return nil
+}
+
 func (bf *Backfiller) BufferOp(ctx context.Context, repo string, since *string, rev string, kind repomgr.EventKind, path string, rec *[]byte, cid *cid.Cid) (bool, error) {
 	return bf.BufferOps(ctx, repo, since, rev, []*BufferedOp{{
 		Path:   path,
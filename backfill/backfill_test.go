@@ -6,8 +6,12 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/bluesky-social/indigo/backfill"
+
 	"github.com/ipfs/go-cid"
 	typegen "github.com/whyrusleeping/cbor-gen"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 type testState struct {
@@ -117,3 +121,55 @@ func (ts *testState) handleDelete(ctx context.Context, repo string, path string)
 	ts.lk.Unlock()
 	return nil
 }
+
+func TestResync(t *testing.T) {
+	ctx := context.Background()
+	did := "did:plc:q6gjnaw2blty4crticxkmujt"
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&backfill.GormDBJob{}); err != nil {
+		t.Fatal(err)
+	}
+	store := backfill.NewGormstore(db)
+	noop := func(ctx context.Context, repo string, rev string, path string, rec *[]byte, cid *cid.Cid) error {
+		return nil
+	}
+	bf := backfill.NewBackfiller("resync-test", store, noop, noop, func(ctx context.Context, repo string, rev string, path string) error { return nil }, nil)
+
+	// unknown repos get a new job
+	if err := bf.Resync(ctx, did); err != nil {
+		t.Fatal(err)
+	}
+	job, err := store.GetJob(ctx, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State() != backfill.StateEnqueued {
+		t.Fatalf("expected enqueued job, got: %s", job.State())
+	}
+
+	// completed jobs are reset for a full re-sync
+	if err := job.SetState(ctx, backfill.StateComplete); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.SetRev(ctx, "3l3qo2vuowo2b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bf.Resync(ctx, did); err != nil {
+		t.Fatal(err)
+	}
+	if job.State() != backfill.StateEnqueued || job.Rev() != "" {
+		t.Fatalf("expected enqueued job with no rev, got: %s %q", job.State(), job.Rev())
+	}
+
+	next, err := store.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || next.Repo() != did {
+		t.Fatal("expected job to be returned by store")
+	}
+}
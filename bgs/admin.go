@@ -11,7 +11,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/labstack/echo/v4"
 	dto "github.com/prometheus/client_model/go"
 	"go.opentelemetry.io/otel"
@@ -722,3 +727,176 @@ func (bgs *BGS) handleAdminRequestCrawl(e echo.Context) error {
 
 	return bgs.slurper.SubscribeToPds(ctx, host, true, true, &rateOverrides) // Override Trusted Domain Check
 }
+
+// parseAtRev accepts a rev (TID) or a datetime for the "at" parameter of the repo history endpoints. A datetime is converted to the largest rev at that time.
+func parseAtRev(at string) (string, error) {
+	if at == "" {
+		return "", nil
+	}
+	if _, err := syntax.ParseTID(at); err == nil {
+		return at, nil
+	}
+	dt, err := syntax.ParseDatetimeLenient(at)
+	if err != nil {
+		return "", fmt.Errorf("at must be a rev or a datetime")
+	}
+	return syntax.NewTIDFromTime(dt.Time(), 1023).String(), nil
+}
+
+func historyError(err error) error {
+	switch {
+	case errors.Is(err, repomgr.ErrNoRepoHistory):
+		return &echo.HTTPError{Code: http.StatusNotImplemented, Message: "repo history is not enabled on this relay"}
+	case errors.Is(err, carstore.ErrRevNotArchived):
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "no archived repo state at or before the requested rev"}
+	case errors.Is(err, mst.ErrNotFound):
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "record not found at the requested rev"}
+	}
+	return err
+}
+
+type RepoHistoryCommit struct {
+	Rev  string    `json:"rev"`
+	Cid  string    `json:"cid"`
+	Time time.Time `json:"time"`
+}
+
+type RepoHistoryResponse struct {
+	Commits []RepoHistoryCommit `json:"commits"`
+	Cursor  string              `json:"cursor,omitempty"`
+}
+
+func (bgs *BGS) handleAdminGetRepoHistory(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return fmt.Errorf("must pass a did")
+	}
+	limit := 100
+	if l := e.QueryParam("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v < 1 || v > 1000 {
+			return &echo.HTTPError{Code: 400, Message: "limit must be between 1 and 1000"}
+		}
+		limit = v
+	}
+
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	commits, err := bgs.repoman.RepoHistory(ctx, ai.Uid, e.QueryParam("cursor"), limit)
+	if err != nil {
+		return historyError(err)
+	}
+	out := RepoHistoryResponse{Commits: []RepoHistoryCommit{}}
+	for _, c := range commits {
+		hc := RepoHistoryCommit{Rev: c.Rev, Cid: c.Root.String()}
+		if tid, err := syntax.ParseTID(c.Rev); err == nil {
+			hc.Time = tid.Time()
+		}
+		out.Commits = append(out.Commits, hc)
+	}
+	if len(commits) == limit {
+		out.Cursor = commits[len(commits)-1].Rev
+	}
+	return e.JSON(200, out)
+}
+
+func (bgs *BGS) handleAdminGetRepoAt(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return fmt.Errorf("must pass a did")
+	}
+	at, err := parseAtRev(e.QueryParam("at"))
+	if err != nil {
+		return &echo.HTTPError{Code: 400, Message: err.Error()}
+	}
+
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	// stream the CAR as it is read; history errors (eg, rev not archived) happen before anything is written
+	w := &lazyStreamWriter{resp: e.Response(), contentType: "application/vnd.ipld.car"}
+	if _, err := bgs.repoman.ReadRepoAt(ctx, ai.Uid, at, w); err != nil {
+		if !w.started {
+			return historyError(err)
+		}
+		// too late to send an error status; the truncated response fails CAR parsing on the client
+		return fmt.Errorf("streaming repo CAR: %w", err)
+	}
+	if !w.started {
+		w.start()
+	}
+	return nil
+}
+
+// lazyStreamWriter writes a 200 response header on the first write, so handlers can still return an error status if nothing has been written
+type lazyStreamWriter struct {
+	resp        *echo.Response
+	contentType string
+	started     bool
+}
+
+func (w *lazyStreamWriter) start() {
+	w.resp.Header().Set(echo.HeaderContentType, w.contentType)
+	w.resp.WriteHeader(http.StatusOK)
+	w.started = true
+}
+
+func (w *lazyStreamWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.start()
+	}
+	return w.resp.Write(p)
+}
+
+type RepoRecordAtResponse struct {
+	Uri    string         `json:"uri"`
+	Cid    string         `json:"cid"`
+	Rev    string         `json:"rev"`
+	Commit string         `json:"commit"`
+	Value  map[string]any `json:"value"`
+}
+
+func (bgs *BGS) handleAdminGetRecordAt(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	collection := e.QueryParam("collection")
+	rkey := e.QueryParam("rkey")
+	if did == "" || collection == "" || rkey == "" {
+		return fmt.Errorf("must pass did, collection, and rkey")
+	}
+	at, err := parseAtRev(e.QueryParam("at"))
+	if err != nil {
+		return &echo.HTTPError{Code: 400, Message: err.Error()}
+	}
+
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	commit, rcid, rec, _, err := bgs.repoman.GetRecordAt(ctx, ai.Uid, collection, rkey, at)
+	if err != nil {
+		return historyError(err)
+	}
+	val, err := data.UnmarshalCBOR(rec)
+	if err != nil {
+		return fmt.Errorf("decoding record: %w", err)
+	}
+	return e.JSON(200, RepoRecordAtResponse{
+		Uri:    fmt.Sprintf("at://%s/%s/%s", did, collection, rkey),
+		Cid:    rcid.String(),
+		Rev:    commit.Rev,
+		Commit: commit.Root.String(),
+		Value:  val,
+	})
+}
@@ -307,6 +307,9 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	admin.POST("/repo/compactAll", bgs.handleAdminCompactAllRepos)
 	admin.POST("/repo/reset", bgs.handleAdminResetRepo)
 	admin.POST("/repo/verify", bgs.handleAdminVerifyRepo)
+	admin.GET("/repo/history", bgs.handleAdminGetRepoHistory)
+	admin.GET("/repo/getRepo", bgs.handleAdminGetRepoAt)
+	admin.GET("/repo/getRecord", bgs.handleAdminGetRecordAt)
 
 	// PDS-related Admin API
 	admin.POST("/pds/requestCrawl", bgs.handleAdminRequestCrawl)
@@ -0,0 +1,715 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/cockroachdb/pebble"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-libipfs/blocks"
+	"github.com/ipld/go-car"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+)
+
+var ErrRevNotArchived = errors.New("no archived repo state at or before rev")
+
+// ArchiveStore wraps a CarStore, additionally keeping every commit root and every block a user's repo has contained in a local pebble database, so that repos and records can be read as they were at an earlier rev.
+//
+// Schema:
+// R{uint64 uid}{rev} : {root cid bytes}
+// A{uint64 uid}{cid bytes} : {removed rev}\x00{block bytes}
+// W{uint64 uid} : {wipe rev}
+//
+// Blocks are archived when a commit writes them. Blocks written before archiving was enabled are archived when a commit removes them, or when the repo is wiped; until then they are read from the wrapped store.
+//
+// The removed rev of a block is the last rev which removed it from the repo, and is empty while the block is part of the current repo. Prune drops history older than Retention: the last commit before the cutoff is kept, as the state of the repo at the cutoff, along with every block not removed as of that commit.
+type ArchiveStore struct {
+	Inner CarStore
+
+	// how long superseded history is kept; zero keeps everything
+	Retention time.Duration
+
+	dbPath string
+	db     *pebble.DB
+
+	log *slog.Logger
+}
+
+func NewArchiveStore(inner CarStore, dir string, retention time.Duration) (*ArchiveStore, error) {
+	if err := ensureDir(dir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "archive.pebble")
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: pebble could not open, %w", path, err)
+	}
+	return &ArchiveStore{
+		Inner:     inner,
+		Retention: retention,
+		dbPath:    path,
+		db:        db,
+		log:       slog.Default().With("system", "carstore-archive"),
+	}, nil
+}
+
+func (as *ArchiveStore) Close() error {
+	if err := as.db.Flush(); err != nil {
+		return err
+	}
+	return as.db.Close()
+}
+
+func archiveRevKey(user models.Uid, rev string) []byte {
+	return append(pebbleUidPrefix('R', user), rev...)
+}
+
+func archiveBlockKey(user models.Uid, bcid cid.Cid) []byte {
+	return append(pebbleUidPrefix('A', user), bcid.Bytes()...)
+}
+
+func archiveBlockVal(removed string, data []byte) []byte {
+	out := make([]byte, 0, len(removed)+1+len(data))
+	out = append(out, removed...)
+	out = append(out, 0)
+	return append(out, data...)
+}
+
+func parseArchiveBlockVal(val []byte) (removed string, data []byte, err error) {
+	sep := bytes.IndexByte(val, 0)
+	if sep < 0 {
+		return "", nil, fmt.Errorf("archived block missing rev separator")
+	}
+	return string(val[:sep]), val[sep+1:], nil
+}
+
+// getArchived returns (nil, "", nil) if the block is not archived. The returned data is a copy.
+func (as *ArchiveStore) getArchived(user models.Uid, bcid cid.Cid) ([]byte, string, error) {
+	val, closer, err := as.db.Get(archiveBlockKey(user, bcid))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer closer.Close()
+	removed, data, err := parseArchiveBlockVal(val)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.Clone(data), removed, nil
+}
+
+func (as *ArchiveStore) hasRev(user models.Uid, rev string) (bool, error) {
+	_, closer, err := as.db.Get(archiveRevKey(user, rev))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	closer.Close()
+	return true, nil
+}
+
+// archives a commit in a DeltaSession of the wrapped store, before it is written there
+type archiveShardWriter struct {
+	inner   shardWriter
+	as      *ArchiveStore
+	base    minBlockstore
+	baseCid cid.Cid
+	lastRev string
+}
+
+func (asw *archiveShardWriter) writeNewShard(ctx context.Context, root cid.Cid, rev string, user models.Uid, seq int, blks map[cid.Cid]blockformat.Block, rmcids map[cid.Cid]bool) ([]byte, error) {
+	if err := asw.as.archiveCommit(ctx, user, asw.base, asw.baseCid, asw.lastRev, root, rev, blks, rmcids); err != nil {
+		archiveErrors.Inc()
+		return nil, fmt.Errorf("archiving commit: %w", err)
+	}
+	return asw.inner.writeNewShard(ctx, root, rev, user, seq, blks, rmcids)
+}
+
+func (as *ArchiveStore) archiveCommit(ctx context.Context, user models.Uid, base minBlockstore, baseCid cid.Cid, lastRev string, root cid.Cid, rev string, blks map[cid.Cid]blockformat.Block, rmcids map[cid.Cid]bool) error {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "archiveCommit")
+	defer span.End()
+
+	batch := as.db.NewBatch()
+	defer batch.Close()
+
+	if lastRev == "" {
+		// a full import replaces anything already stored for the user
+		if err := as.snapshotUser(ctx, batch, user, rev); err != nil {
+			return err
+		}
+	} else if baseCid.Defined() {
+		// keep the state the first archived commit was based on
+		ok, err := as.hasRev(user, lastRev)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := batch.Set(archiveRevKey(user, lastRev), baseCid.Bytes(), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for c := range rmcids {
+		if _, ok := blks[c]; ok {
+			continue
+		}
+		data, _, err := as.getArchived(user, c)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			// written before archiving was enabled; copy it before the wrapped store can drop it
+			blk, err := base.Get(ctx, c)
+			if err != nil {
+				if errors.Is(err, ErrNothingThere) || ipld.IsNotFound(err) {
+					archiveMissingBlocks.Inc()
+					as.log.Warn("removed block not found", "uid", user, "cid", c, "rev", rev)
+					continue
+				}
+				return err
+			}
+			data = blk.RawData()
+			archiveBlocksWritten.Inc()
+		}
+		if err := batch.Set(archiveBlockKey(user, c), archiveBlockVal(rev, data), nil); err != nil {
+			return err
+		}
+	}
+	for c, blk := range blks {
+		if err := batch.Set(archiveBlockKey(user, c), archiveBlockVal("", blk.RawData()), nil); err != nil {
+			return err
+		}
+	}
+	archiveBlocksWritten.Add(float64(len(blks)))
+
+	if err := batch.Set(archiveRevKey(user, rev), root.Bytes(), nil); err != nil {
+		return err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	archiveCommits.Inc()
+	return nil
+}
+
+// snapshotUser adds to the batch: the current head of the user in the wrapped store, all of its blocks, and marks every archived block as removed at the given rev
+func (as *ArchiveStore) snapshotUser(ctx context.Context, batch *pebble.Batch, user models.Uid, removedRev string) error {
+	head, err := as.Inner.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return err
+	}
+	if !head.Defined() {
+		// no existing data
+		return nil
+	}
+	headRev, err := as.Inner.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return err
+	}
+	if headRev != "" {
+		if err := batch.Set(archiveRevKey(user, headRev), head.Bytes(), nil); err != nil {
+			return err
+		}
+	}
+
+	lower, upper := pebbleUidBounds('A', user)
+	iter, err := as.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		removed, data, err := parseArchiveBlockVal(iter.Value())
+		if err != nil {
+			return err
+		}
+		if removed != "" {
+			continue
+		}
+		if err := batch.Set(bytes.Clone(iter.Key()), archiveBlockVal(removedRev, data), nil); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := as.Inner.ReadUserCar(ctx, user, "", true, buf); err != nil {
+		return fmt.Errorf("reading current repo: %w", err)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	cr, err := car.NewCarReader(buf)
+	if err != nil {
+		return err
+	}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data, _, err := as.getArchived(user, blk.Cid())
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			continue
+		}
+		if err := batch.Set(archiveBlockKey(user, blk.Cid()), archiveBlockVal(removedRev, blk.RawData()), nil); err != nil {
+			return err
+		}
+		archiveBlocksWritten.Inc()
+	}
+	return nil
+}
+
+// ArchivedCommit is a commit of a user's repo kept by an ArchiveStore
+type ArchivedCommit struct {
+	Rev  string
+	Root cid.Cid
+}
+
+// CommitAt returns the last archived commit at or before the given rev, or the latest archived commit if rev is empty
+func (as *ArchiveStore) CommitAt(ctx context.Context, user models.Uid, rev string) (*ArchivedCommit, error) {
+	lower, upper := pebbleUidBounds('R', user)
+	if rev != "" {
+		// a \x00 suffix sorts after the rev itself, and before any longer rev it is a prefix of
+		upper = append(archiveRevKey(user, rev), 0)
+	}
+	iter, err := as.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	if !iter.Last() {
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRevNotArchived
+	}
+	root, err := cid.Cast(iter.Value())
+	if err != nil {
+		return nil, fmt.Errorf("archived commit bad root, %w", err)
+	}
+	return &ArchivedCommit{Rev: string(iter.Key()[9:]), Root: root}, nil
+}
+
+// History lists archived commits of a user, newest first, strictly before the given rev (if not empty)
+func (as *ArchiveStore) History(ctx context.Context, user models.Uid, before string, limit int) ([]ArchivedCommit, error) {
+	lower, upper := pebbleUidBounds('R', user)
+	if before != "" {
+		upper = archiveRevKey(user, before)
+	}
+	iter, err := as.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var out []ArchivedCommit
+	for iter.Last(); iter.Valid() && len(out) < limit; iter.Prev() {
+		root, err := cid.Cast(iter.Value())
+		if err != nil {
+			return nil, fmt.Errorf("archived commit bad root, %w", err)
+		}
+		out = append(out, ArchivedCommit{Rev: string(iter.Key()[9:]), Root: root})
+	}
+	return out, iter.Error()
+}
+
+// archiveUserView reads archived blocks, falling back to the wrapped store for blocks which have not been archived
+type archiveUserView struct {
+	as   *ArchiveStore
+	uid  models.Uid
+	live minBlockstore
+}
+
+func (av *archiveUserView) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	data, _, err := av.as.getArchived(av.uid, c)
+	if err != nil {
+		return false, err
+	}
+	if data != nil {
+		return true, nil
+	}
+	return av.live.Has(ctx, c)
+}
+
+func (av *archiveUserView) Get(ctx context.Context, c cid.Cid) (blockformat.Block, error) {
+	data, _, err := av.as.getArchived(av.uid, c)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		return blocks.NewBlockWithCid(data, c)
+	}
+	return av.live.Get(ctx, c)
+}
+
+func (av *archiveUserView) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	data, _, err := av.as.getArchived(av.uid, c)
+	if err != nil {
+		return 0, err
+	}
+	if data != nil {
+		return len(data), nil
+	}
+	return av.live.GetSize(ctx, c)
+}
+
+var _ minBlockstore = (*archiveUserView)(nil)
+
+// ReadOnlySessionAt returns a read-only view of the user's repo as of the last archived commit at or before rev, and that commit
+func (as *ArchiveStore) ReadOnlySessionAt(ctx context.Context, user models.Uid, rev string) (*DeltaSession, *ArchivedCommit, error) {
+	commit, err := as.CommitAt(ctx, user, rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	live, err := as.Inner.ReadOnlySession(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &DeltaSession{
+		base: &archiveUserView{
+			as:   as,
+			uid:  user,
+			live: live,
+		},
+		readonly: true,
+		user:     user,
+		baseCid:  commit.Root,
+		lastRev:  commit.Rev,
+	}, commit, nil
+}
+
+// ReadUserCarAt writes the complete repo of a user, as of the last archived commit at or before rev, as a CAR file
+func (as *ArchiveStore) ReadUserCarAt(ctx context.Context, user models.Uid, rev string, w io.Writer) (*ArchivedCommit, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "ReadUserCarAt")
+	defer span.End()
+
+	ds, commit, err := as.ReadOnlySessionAt(ctx, user, rev)
+	if err != nil {
+		return nil, err
+	}
+	if err := car.WriteHeader(&car.CarHeader{
+		Roots:   []cid.Cid{commit.Root},
+		Version: 1,
+	}, w); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[cid.Cid]bool)
+	write := func(c cid.Cid) ([]byte, error) {
+		blk, err := ds.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", c, err)
+		}
+		seen[c] = true
+		if _, err := LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+			return nil, err
+		}
+		return blk.RawData(), nil
+	}
+
+	commitBytes, err := write(commit.Root)
+	if err != nil {
+		return nil, err
+	}
+	var sc repo.SignedCommit
+	if err := sc.UnmarshalCBOR(bytes.NewReader(commitBytes)); err != nil {
+		return nil, fmt.Errorf("parsing commit: %w", err)
+	}
+	stack := []cid.Cid{sc.Data}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[node] {
+			continue
+		}
+		nodeBytes, err := write(node)
+		if err != nil {
+			return nil, err
+		}
+		var nd mst.NodeData
+		if err := nd.UnmarshalCBOR(bytes.NewReader(nodeBytes)); err != nil {
+			return nil, fmt.Errorf("parsing MST node %s: %w", node, err)
+		}
+		if nd.Left != nil {
+			stack = append(stack, *nd.Left)
+		}
+		for _, e := range nd.Entries {
+			if e.Tree != nil {
+				stack = append(stack, *e.Tree)
+			}
+			if !seen[e.Val] {
+				if _, err := write(e.Val); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return commit, nil
+}
+
+// Prune drops archived history older than Retention; see ArchiveStore
+func (as *ArchiveStore) Prune(ctx context.Context) error {
+	if as.Retention <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-as.Retention)
+
+	iter, err := as.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: []byte{'R'}, UpperBound: []byte{'S'}})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	var user models.Uid
+	var old []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) < 9 {
+			continue
+		}
+		uid := models.Uid(binary.BigEndian.Uint64(key[1:9]))
+		if uid != user {
+			if err := as.pruneUser(ctx, user, old, cutoff); err != nil {
+				return err
+			}
+			user = uid
+			old = old[:0]
+		}
+		rev := string(key[9:])
+		tid, err := syntax.ParseTID(rev)
+		if err != nil || !tid.Time().Before(cutoff) {
+			continue
+		}
+		old = append(old, rev)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return as.pruneUser(ctx, user, old, cutoff)
+}
+
+// pruneUser takes the user's archived revs from before the cutoff, in order
+func (as *ArchiveStore) pruneUser(ctx context.Context, user models.Uid, old []string, cutoff time.Time) error {
+	if len(old) == 0 {
+		return nil
+	}
+	keep := old[len(old)-1]
+	drop := old[:len(old)-1]
+	dropWipe := false
+
+	wipeVal, closer, err := as.db.Get(pebbleUidPrefix('W', user))
+	if err != nil && !errors.Is(err, pebble.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		wipeRev := string(wipeVal)
+		closer.Close()
+		if wipeRev <= keep {
+			dropWipe = true
+		} else if tid, err := syntax.ParseTID(wipeRev); err == nil && tid.Time().Before(cutoff) {
+			// the repo was wiped before the cutoff, so nothing from before the wipe needs to be kept
+			drop = old
+			keep = wipeRev
+			dropWipe = true
+		}
+	}
+	if len(drop) == 0 && !dropWipe {
+		return nil
+	}
+
+	batch := as.db.NewBatch()
+	defer batch.Close()
+	for _, rev := range drop {
+		if err := batch.Delete(archiveRevKey(user, rev), nil); err != nil {
+			return err
+		}
+	}
+	if dropWipe {
+		if err := batch.Delete(pebbleUidPrefix('W', user), nil); err != nil {
+			return err
+		}
+	}
+
+	lower, upper := pebbleUidBounds('A', user)
+	iter, err := as.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	nblocks := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		removed, _, err := parseArchiveBlockVal(iter.Value())
+		if err != nil {
+			return err
+		}
+		if removed == "" || removed > keep {
+			continue
+		}
+		if err := batch.Delete(bytes.Clone(iter.Key()), nil); err != nil {
+			return err
+		}
+		nblocks++
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	archivePrunedRevs.Add(float64(len(drop)))
+	archivePrunedBlocks.Add(float64(nblocks))
+	as.log.Debug("pruned archive", "uid", user, "revs", len(drop), "blocks", nblocks)
+	return nil
+}
+
+// Run prunes the archive every interval, until the context is done
+func (as *ArchiveStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := as.Prune(ctx); err != nil {
+				as.log.Error("archive prune failed", "err", err)
+			}
+		}
+	}
+}
+
+func (as *ArchiveStore) wrapSession(ds *DeltaSession) *DeltaSession {
+	ds.cs = &archiveShardWriter{
+		inner:   ds.cs,
+		as:      as,
+		base:    ds.base,
+		baseCid: ds.baseCid,
+		lastRev: ds.lastRev,
+	}
+	return ds
+}
+
+func (as *ArchiveStore) CompactUserShards(ctx context.Context, user models.Uid, skipBigShards bool) (*CompactionStats, error) {
+	return as.Inner.CompactUserShards(ctx, user, skipBigShards)
+}
+
+func (as *ArchiveStore) GetCompactionTargets(ctx context.Context, shardCount int) ([]CompactionTarget, error) {
+	return as.Inner.GetCompactionTargets(ctx, shardCount)
+}
+
+func (as *ArchiveStore) GetUserRepoHead(ctx context.Context, user models.Uid) (cid.Cid, error) {
+	return as.Inner.GetUserRepoHead(ctx, user)
+}
+
+func (as *ArchiveStore) GetUserRepoRev(ctx context.Context, user models.Uid) (string, error) {
+	return as.Inner.GetUserRepoRev(ctx, user)
+}
+
+func (as *ArchiveStore) ImportSlice(ctx context.Context, uid models.Uid, since *string, carslice []byte) (cid.Cid, *DeltaSession, error) {
+	root, ds, err := as.Inner.ImportSlice(ctx, uid, since, carslice)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return root, as.wrapSession(ds), nil
+}
+
+func (as *ArchiveStore) NewDeltaSession(ctx context.Context, user models.Uid, since *string) (*DeltaSession, error) {
+	ds, err := as.Inner.NewDeltaSession(ctx, user, since)
+	if err != nil {
+		return nil, err
+	}
+	return as.wrapSession(ds), nil
+}
+
+func (as *ArchiveStore) ReadOnlySession(user models.Uid) (*DeltaSession, error) {
+	return as.Inner.ReadOnlySession(user)
+}
+
+func (as *ArchiveStore) ReadUserCar(ctx context.Context, user models.Uid, sinceRev string, incremental bool, w io.Writer) error {
+	return as.Inner.ReadUserCar(ctx, user, sinceRev, incremental, w)
+}
+
+func (as *ArchiveStore) Stat(ctx context.Context, usr models.Uid) ([]UserStat, error) {
+	return as.Inner.Stat(ctx, usr)
+}
+
+// WipeUserData archives the user's current repo before wiping it from the wrapped store
+func (as *ArchiveStore) WipeUserData(ctx context.Context, user models.Uid) error {
+	// not a commit rev, but compared against them; assumes the PDS clock is not ahead of ours
+	wipeRev := syntax.NewTIDNow(0).String()
+
+	batch := as.db.NewBatch()
+	defer batch.Close()
+	if err := as.snapshotUser(ctx, batch, user, wipeRev); err != nil {
+		return fmt.Errorf("archiving wiped repo: %w", err)
+	}
+	if err := batch.Set(pebbleUidPrefix('W', user), []byte(wipeRev), nil); err != nil {
+		return err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	return as.Inner.WipeUserData(ctx, user)
+}
+
+var _ CarStore = (*ArchiveStore)(nil)
+
+var archiveCommits = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_archive_commits",
+	Help: "Commits recorded in the carstore archive",
+})
+
+var archiveBlocksWritten = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_archive_blocks_written",
+	Help: "Blocks written to the carstore archive",
+})
+
+var archiveMissingBlocks = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_archive_missing_blocks",
+	Help: "Blocks removed by a commit which could not be found to archive",
+})
+
+var archiveErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_archive_errors",
+	Help: "Commits which failed to be archived",
+})
+
+var archivePrunedRevs = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_archive_pruned_revs",
+	Help: "Archived commits dropped by retention",
+})
+
+var archivePrunedBlocks = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_archive_pruned_blocks",
+	Help: "Archived blocks dropped by retention",
+})
@@ -0,0 +1,201 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// applies a change to user 1's repo, in a new commit
+func editTestRepo(t *testing.T, cs CarStore, head cid.Cid, edit func(rr *repo.Repo) error) (cid.Cid, string) {
+	ctx := context.TODO()
+	rev, err := cs.GetUserRepoRev(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := cs.NewDeltaSession(ctx, 1, &rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := repo.OpenRepo(ctx, ds, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := edit(rr); err != nil {
+		t.Fatal(err)
+	}
+	kmgr := &util.FakeKeyManager{}
+	nroot, nrev, err := rr.Commit(ctx, kmgr.SignForUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.CalcDiff(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, nroot, nrev); err != nil {
+		t.Fatal(err)
+	}
+	return nroot, nrev
+}
+
+func postPaths(t *testing.T, ctx context.Context, rr *repo.Repo) []string {
+	var paths []string
+	if err := rr.ForEach(ctx, "app.bsky.feed.post", func(k string, v cid.Cid) error {
+		paths = append(paths, k)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestArchiveStore(ot *testing.T) {
+	ctx := context.TODO()
+
+	for fname, tf := range backends {
+		ot.Run(fname, func(t *testing.T) {
+			inner, cleanup, err := tf(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+
+			// written before archiving is enabled
+			head, _ := writeTestRepo(t, inner, 3)
+			origRev, err := inner.GetUserRepoRev(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			as, err := NewArchiveStore(inner, t.TempDir(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer as.Close()
+
+			ro, err := inner.ReadOnlySession(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr, err := repo.OpenRepo(ctx, ro, head)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := postPaths(t, ctx, rr)
+			if len(paths) != 3 {
+				t.Fatalf("expected 3 posts, got %d", len(paths))
+			}
+
+			head, editRev := editTestRepo(t, as, head, func(rr *repo.Repo) error {
+				_, err := rr.UpdateRecord(ctx, paths[0], &appbsky.FeedPost{Text: "edited"})
+				return err
+			})
+			head, _ = editTestRepo(t, as, head, func(rr *repo.Repo) error {
+				return rr.DeleteRecord(ctx, paths[1])
+			})
+
+			readPost := func(at string, path string) (string, string) {
+				ds, commit, err := as.ReadOnlySessionAt(ctx, 1, at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rr, err := repo.OpenRepo(ctx, ds, commit.Root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, rec, err := rr.GetRecord(ctx, path)
+				if err != nil {
+					return commit.Rev, ""
+				}
+				return commit.Rev, rec.(*appbsky.FeedPost).Text
+			}
+
+			rev, text := readPost(origRev, paths[0])
+			if rev != origRev || text != "post 0" {
+				t.Fatalf("unexpected original post: %s %q", rev, text)
+			}
+			if _, text := readPost(origRev, paths[1]); text != "post 1" {
+				t.Fatalf("expected deleted post in original repo, got %q", text)
+			}
+			if _, text := readPost(editRev, paths[0]); text != "edited" {
+				t.Fatalf("expected edited post, got %q", text)
+			}
+			if _, text := readPost(editRev, paths[1]); text != "post 1" {
+				t.Fatalf("expected post 1 before delete, got %q", text)
+			}
+			if _, text := readPost("", paths[1]); text != "" {
+				t.Fatalf("expected post 1 to be deleted, got %q", text)
+			}
+
+			// full repo at the original rev
+			buf := new(bytes.Buffer)
+			commit, err := as.ReadUserCarAt(ctx, 1, origRev, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr, err = repo.ReadRepoFromCar(ctx, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rr.SignedCommit().Rev != commit.Rev || len(postPaths(t, ctx, rr)) != 3 {
+				t.Fatal("unexpected repo at original rev")
+			}
+
+			if _, err := as.CommitAt(ctx, 1, "2222222222222"); !errors.Is(err, ErrRevNotArchived) {
+				t.Fatalf("expected ErrRevNotArchived, got %v", err)
+			}
+			hist, err := as.History(ctx, 1, "", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hist) != 3 || hist[0].Root != head || hist[2].Rev != origRev {
+				t.Fatalf("unexpected history: %+v", hist)
+			}
+
+			// with a retention shorter than the age of the history, only the last commit is kept
+			time.Sleep(time.Millisecond)
+			as.Retention = time.Nanosecond
+			if err := as.Prune(ctx); err != nil {
+				t.Fatal(err)
+			}
+			hist, err = as.History(ctx, 1, "", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hist) != 1 || hist[0].Root != head {
+				t.Fatalf("unexpected history after prune: %+v", hist)
+			}
+			if _, err := as.CommitAt(ctx, 1, editRev); !errors.Is(err, ErrRevNotArchived) {
+				t.Fatalf("expected pruned rev, got %v", err)
+			}
+
+			// the last commit survives the repo being wiped, including blocks written before archiving was enabled
+			if err := as.WipeUserData(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, text := readPost("", paths[2]); text != "post 2" {
+				t.Fatalf("expected post 2 after wipe, got %q", text)
+			}
+			buf.Reset()
+			if _, err := as.ReadUserCarAt(ctx, 1, "", buf); err != nil {
+				t.Fatal(err)
+			}
+
+			// ... until the wipe is older than the retention
+			time.Sleep(time.Millisecond)
+			if err := as.Prune(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := as.CommitAt(ctx, 1, ""); !errors.Is(err, ErrRevNotArchived) {
+				t.Fatalf("expected wiped history to be pruned, got %v", err)
+			}
+		})
+	}
+}
@@ -567,6 +567,49 @@ func (cs *FileCarStore) writeNewShardFile(ctx context.Context, user models.Uid,
 	return fname, nil
 }
 
+// checkShardFiles compares the shards in the metadata database against shard files on disk: shards whose file is missing, and files for this user which have no shard (eg, left behind by an interrupted compaction). Used by CheckUserRepo.
+//
+// NOTE: this lists the whole shard directory, so is expensive for large stores.
+func (cs *FileCarStore) checkShardFiles(ctx context.Context, user models.Uid) ([]string, []string, error) {
+	shards, err := cs.meta.GetUserShards(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missing []string
+	known := make(map[string]bool, len(shards))
+	for _, sh := range shards {
+		known[sh.Path] = true
+		if _, err := os.Stat(sh.Path); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, nil, err
+			}
+			missing = append(missing, sh.Path)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(cs.dirForUser(user), fmt.Sprintf("sh-%d-*", user)))
+	if err != nil {
+		return nil, nil, err
+	}
+	var orphans []string
+	for _, fname := range files {
+		if !known[fname] {
+			orphans = append(orphans, fname)
+		}
+	}
+	return missing, orphans, nil
+}
+
+func removeFiles(paths []string) error {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (cs *FileCarStore) deleteShardFile(ctx context.Context, sh *CarShard) error {
 	return os.Remove(sh.Path)
 }
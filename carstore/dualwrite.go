@@ -0,0 +1,156 @@
+package carstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/bluesky-social/indigo/models"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DualWriteCarStore serves all reads from Primary, and mirrors writes to Secondary, for migrating a live service between backends.
+//
+// A write is only mirrored if the user's repo in Secondary is at the same rev the write is based on; users which have not been migrated yet (or fell out of sync) are skipped, and left for a Migrator to copy. Failures writing to Secondary are logged, never returned.
+//
+// To cut over, swap Primary and Secondary: the old backend then keeps receiving writes, for rollback.
+type DualWriteCarStore struct {
+	Primary   CarStore
+	Secondary CarStore
+
+	log *slog.Logger
+}
+
+func NewDualWriteCarStore(primary, secondary CarStore) *DualWriteCarStore {
+	return &DualWriteCarStore{
+		Primary:   primary,
+		Secondary: secondary,
+		log:       slog.Default().With("system", "carstore-dual"),
+	}
+}
+
+// mirrors shards written in a DeltaSession of the primary store
+type dualShardWriter struct {
+	primary shardWriter
+	dual    *DualWriteCarStore
+	lastRev string
+}
+
+func (dsw *dualShardWriter) writeNewShard(ctx context.Context, root cid.Cid, rev string, user models.Uid, seq int, blks map[cid.Cid]blockformat.Block, rmcids map[cid.Cid]bool) ([]byte, error) {
+	out, err := dsw.primary.writeNewShard(ctx, root, rev, user, seq, blks, rmcids)
+	if err != nil {
+		return nil, err
+	}
+	dsw.dual.mirror(ctx, user, dsw.lastRev, root, rev, blks, rmcids)
+	return out, nil
+}
+
+func (d *DualWriteCarStore) mirror(ctx context.Context, user models.Uid, lastRev string, root cid.Cid, rev string, blks map[cid.Cid]blockformat.Block, rmcids map[cid.Cid]bool) {
+	// a fresh import (no base rev) replaces whatever is in the secondary
+	var since *string
+	if lastRev != "" {
+		since = &lastRev
+	}
+	ds, err := d.Secondary.NewDeltaSession(ctx, user, since)
+	if errors.Is(err, ErrRepoBaseMismatch) {
+		dualWriteMirrored.WithLabelValues("skipped").Inc()
+		return
+	}
+	if err != nil {
+		dualWriteMirrored.WithLabelValues("error").Inc()
+		d.log.Error("secondary session failed", "uid", user, "rev", rev, "err", err)
+		return
+	}
+	if since == nil {
+		if err := d.Secondary.WipeUserData(ctx, user); err != nil {
+			dualWriteMirrored.WithLabelValues("error").Inc()
+			d.log.Error("secondary wipe failed", "uid", user, "rev", rev, "err", err)
+			return
+		}
+	}
+	// blocks are immutable, so can be shared between sessions
+	ds.blks = blks
+	ds.rmcids = rmcids
+	if _, err := ds.CloseWithRoot(ctx, root, rev); err != nil {
+		dualWriteMirrored.WithLabelValues("error").Inc()
+		d.log.Error("secondary write failed", "uid", user, "rev", rev, "err", err)
+		return
+	}
+	dualWriteMirrored.WithLabelValues("ok").Inc()
+}
+
+func (d *DualWriteCarStore) wrapSession(ds *DeltaSession) *DeltaSession {
+	ds.cs = &dualShardWriter{
+		primary: ds.cs,
+		dual:    d,
+		lastRev: ds.lastRev,
+	}
+	return ds
+}
+
+func (d *DualWriteCarStore) CompactUserShards(ctx context.Context, user models.Uid, skipBigShards bool) (*CompactionStats, error) {
+	return d.Primary.CompactUserShards(ctx, user, skipBigShards)
+}
+
+func (d *DualWriteCarStore) GetCompactionTargets(ctx context.Context, shardCount int) ([]CompactionTarget, error) {
+	return d.Primary.GetCompactionTargets(ctx, shardCount)
+}
+
+func (d *DualWriteCarStore) GetUserRepoHead(ctx context.Context, user models.Uid) (cid.Cid, error) {
+	return d.Primary.GetUserRepoHead(ctx, user)
+}
+
+func (d *DualWriteCarStore) GetUserRepoRev(ctx context.Context, user models.Uid) (string, error) {
+	return d.Primary.GetUserRepoRev(ctx, user)
+}
+
+func (d *DualWriteCarStore) ImportSlice(ctx context.Context, uid models.Uid, since *string, carslice []byte) (cid.Cid, *DeltaSession, error) {
+	root, ds, err := d.Primary.ImportSlice(ctx, uid, since, carslice)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return root, d.wrapSession(ds), nil
+}
+
+func (d *DualWriteCarStore) NewDeltaSession(ctx context.Context, user models.Uid, since *string) (*DeltaSession, error) {
+	ds, err := d.Primary.NewDeltaSession(ctx, user, since)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrapSession(ds), nil
+}
+
+func (d *DualWriteCarStore) ReadOnlySession(user models.Uid) (*DeltaSession, error) {
+	return d.Primary.ReadOnlySession(user)
+}
+
+func (d *DualWriteCarStore) ReadUserCar(ctx context.Context, user models.Uid, sinceRev string, incremental bool, w io.Writer) error {
+	return d.Primary.ReadUserCar(ctx, user, sinceRev, incremental, w)
+}
+
+func (d *DualWriteCarStore) Stat(ctx context.Context, usr models.Uid) ([]UserStat, error) {
+	return d.Primary.Stat(ctx, usr)
+}
+
+func (d *DualWriteCarStore) WipeUserData(ctx context.Context, user models.Uid) error {
+	if err := d.Primary.WipeUserData(ctx, user); err != nil {
+		return err
+	}
+	if err := d.Secondary.WipeUserData(ctx, user); err != nil {
+		return fmt.Errorf("secondary wipe: %w", err)
+	}
+	return nil
+}
+
+var _ CarStore = (*DualWriteCarStore)(nil)
+
+var dualWriteMirrored = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "carstore_dual_write_mirrored",
+	Help: "Shards written to the secondary carstore in dual-write mode, by result",
+}, []string{"result"})
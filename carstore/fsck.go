@@ -0,0 +1,259 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+)
+
+// FsckReport is the result of checking the stored blocks of a single user's repo.
+type FsckReport struct {
+	Uid  models.Uid `json:"uid"`
+	Head string     `json:"head,omitempty"`
+	Rev  string     `json:"rev,omitempty"`
+
+	// stats
+	Blocks       int   `json:"blocks"`
+	Bytes        int64 `json:"bytes"`
+	MSTNodes     int   `json:"mstNodes"`
+	Records      int   `json:"records"`
+	Unreferenced int   `json:"unreferenced"`
+
+	// problems
+	HeadMismatch  bool     `json:"headMismatch,omitempty"`
+	RevMismatch   bool     `json:"revMismatch,omitempty"`
+	Missing       []string `json:"missing,omitempty"`
+	Corrupt       []string `json:"corrupt,omitempty"`
+	MissingShards []string `json:"missingShards,omitempty"`
+	OrphanShards  []string `json:"orphanShards,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// OK returns true if no problems were found. Unreferenced blocks (eg, from deleted records not yet compacted away) are not a problem.
+func (r *FsckReport) OK() bool {
+	return !r.HeadMismatch && !r.RevMismatch && len(r.Missing) == 0 && len(r.Corrupt) == 0 && len(r.MissingShards) == 0 && len(r.OrphanShards) == 0 && len(r.Errors) == 0
+}
+
+// implemented by stores which keep blocks in shard files (FileCarStore)
+type shardFileChecker interface {
+	checkShardFiles(ctx context.Context, user models.Uid) (missing []string, orphans []string, err error)
+}
+
+// CheckUserRepo reads all stored blocks for a user and verifies that every block is hash-correct, and that the commit, every MST node, and every record reachable from the current head are present.
+//
+// Problems with the stored data are recorded in the report; the returned error is only for failures to run the check at all.
+func CheckUserRepo(ctx context.Context, cs CarStore, user models.Uid) (*FsckReport, error) {
+	rep := &FsckReport{Uid: user}
+
+	head, err := cs.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("getting repo head: %w", err)
+	}
+	rev, err := cs.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("getting repo rev: %w", err)
+	}
+	if head.Defined() {
+		rep.Head = head.String()
+	}
+	rep.Rev = rev
+
+	if sfc, ok := cs.(shardFileChecker); ok {
+		missing, orphans, err := sfc.checkShardFiles(ctx, user)
+		if err != nil {
+			return nil, fmt.Errorf("checking shard files: %w", err)
+		}
+		rep.MissingShards = missing
+		rep.OrphanShards = orphans
+	}
+
+	if !head.Defined() {
+		return rep, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, user, "", true, buf); err != nil {
+		// eg, a missing or truncated shard file
+		rep.Errors = append(rep.Errors, fmt.Sprintf("reading repo: %s", err))
+		return rep, nil
+	}
+	if buf.Len() == 0 {
+		// stores write nothing at all (not even a CAR header) when they have no blocks
+		rep.Missing = append(rep.Missing, head.String())
+		return rep, nil
+	}
+	blks, root, err := readCarBlocks(buf, rep)
+	if err != nil {
+		rep.Errors = append(rep.Errors, fmt.Sprintf("parsing repo car: %s", err))
+		return rep, nil
+	}
+	if root.Defined() && root != head {
+		rep.HeadMismatch = true
+	}
+
+	walkRepoBlocks(blks, head, rep)
+	return rep, nil
+}
+
+// reads every block in a CAR file into a map, recording stats and blocks whose data does not match their CID in the report. Corrupt blocks are not included in the map.
+func readCarBlocks(r io.Reader, rep *FsckReport) (map[cid.Cid][]byte, cid.Cid, error) {
+	blks := make(map[cid.Cid][]byte)
+	carr, err := car.NewCarReader(r)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	if len(carr.Header.Roots) != 1 {
+		return nil, cid.Undef, fmt.Errorf("car header must have a single root (has %d)", len(carr.Header.Roots))
+	}
+	for {
+		blk, err := carr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, cid.Undef, err
+		}
+		bcid := blk.Cid()
+		if _, ok := blks[bcid]; ok {
+			// FileCarStore may have the same block in more than one shard
+			continue
+		}
+		data := blk.RawData()
+		rep.Blocks++
+		rep.Bytes += int64(len(data))
+		computed, err := bcid.Prefix().Sum(data)
+		if err != nil || !computed.Equals(bcid) {
+			rep.Corrupt = append(rep.Corrupt, bcid.String())
+			continue
+		}
+		blks[bcid] = data
+	}
+	return blks, carr.Header.Roots[0], nil
+}
+
+// walks the commit and MST from head, recording missing blocks and stats in the report
+func walkRepoBlocks(blks map[cid.Cid][]byte, head cid.Cid, rep *FsckReport) {
+	reachable := make(map[cid.Cid]bool)
+	missing := func(c cid.Cid) {
+		rep.Missing = append(rep.Missing, c.String())
+	}
+
+	commitBytes, ok := blks[head]
+	if !ok {
+		missing(head)
+		return
+	}
+	reachable[head] = true
+	var commit repo.SignedCommit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(commitBytes)); err != nil {
+		rep.Errors = append(rep.Errors, fmt.Sprintf("parsing commit %s: %s", head, err))
+		return
+	}
+	if rep.Rev != "" && commit.Rev != rep.Rev {
+		rep.RevMismatch = true
+	}
+
+	stack := []cid.Cid{commit.Data}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[node] {
+			continue
+		}
+		nodeBytes, ok := blks[node]
+		if !ok {
+			missing(node)
+			continue
+		}
+		reachable[node] = true
+		rep.MSTNodes++
+
+		var nd mst.NodeData
+		if err := nd.UnmarshalCBOR(bytes.NewReader(nodeBytes)); err != nil {
+			rep.Errors = append(rep.Errors, fmt.Sprintf("parsing MST node %s: %s", node, err))
+			continue
+		}
+		if nd.Left != nil {
+			stack = append(stack, *nd.Left)
+		}
+		for _, e := range nd.Entries {
+			if e.Tree != nil {
+				stack = append(stack, *e.Tree)
+			}
+			rep.Records++
+			if reachable[e.Val] {
+				// identical records share a block
+				continue
+			}
+			if _, ok := blks[e.Val]; !ok {
+				missing(e.Val)
+				continue
+			}
+			reachable[e.Val] = true
+		}
+	}
+
+	rep.Unreferenced = len(blks) - len(reachable)
+}
+
+// RepairUserRepo replaces all stored data for a user with a complete repo CAR file (eg, from com.atproto.sync.getRepo on the account's PDS).
+//
+// The CAR is fully checked before anything is deleted. This does not verify the commit signature, and does not emit any events; it is intended for offline repair, with the relay stopped.
+func RepairUserRepo(ctx context.Context, cs CarStore, user models.Uid, did string, repoCar []byte) error {
+	rep := &FsckReport{Uid: user}
+	blks, root, err := readCarBlocks(bytes.NewReader(repoCar), rep)
+	if err != nil {
+		return fmt.Errorf("parsing repo car: %w", err)
+	}
+	if !root.Defined() {
+		return fmt.Errorf("repo car has no root")
+	}
+	walkRepoBlocks(blks, root, rep)
+	if !rep.OK() {
+		return fmt.Errorf("fetched repo is not complete (missing=%d corrupt=%d errors=%v)", len(rep.Missing), len(rep.Corrupt), rep.Errors)
+	}
+	var commit repo.SignedCommit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(blks[root])); err != nil {
+		return fmt.Errorf("parsing commit: %w", err)
+	}
+	if commit.Did != did {
+		return fmt.Errorf("repo is for wrong account: %s", commit.Did)
+	}
+
+	return replaceUserRepo(ctx, cs, user, repoCar, root, commit.Rev)
+}
+
+// replaceUserRepo wipes all stored data for a user, then imports a full repo CAR as a single new shard
+func replaceUserRepo(ctx context.Context, cs CarStore, user models.Uid, repoCar []byte, root cid.Cid, rev string) error {
+	if err := cs.WipeUserData(ctx, user); err != nil {
+		return fmt.Errorf("wiping user data: %w", err)
+	}
+	if sfc, ok := cs.(shardFileChecker); ok {
+		// WipeUserData only removes shards it knows about
+		_, orphans, err := sfc.checkShardFiles(ctx, user)
+		if err != nil {
+			return err
+		}
+		if err := removeFiles(orphans); err != nil {
+			return err
+		}
+	}
+
+	_, ds, err := cs.ImportSlice(ctx, user, nil, repoCar)
+	if err != nil {
+		return fmt.Errorf("importing repo: %w", err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, root, rev); err != nil {
+		return fmt.Errorf("writing repo: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// writes a repo with nposts records, one commit per record
+func writeTestRepo(t *testing.T, cs CarStore, nposts int) (cid.Cid, []cid.Cid) {
+	ctx := context.TODO()
+	ds, err := cs.NewDeltaSession(ctx, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, rev, err := setupRepo(ctx, ds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	var recs []cid.Cid
+	for i := 0; i < nposts; i++ {
+		ds, err := cs.NewDeltaSession(ctx, 1, &rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := repo.OpenRepo(ctx, ds, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, _, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+			Text: fmt.Sprintf("post %d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rc)
+		kmgr := &util.FakeKeyManager{}
+		head, rev, err = rr.Commit(ctx, kmgr.SignForUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.CalcDiff(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return head, recs
+}
+
+func TestFsckAndRepair(ot *testing.T) {
+	ctx := context.TODO()
+
+	for fname, tf := range backends {
+		ot.Run(fname, func(t *testing.T) {
+			cs, cleanup, err := tf(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+
+			head, recs := writeTestRepo(t, cs, 5)
+
+			rep, err := CheckUserRepo(ctx, cs, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rep.OK() {
+				t.Fatalf("unexpected problems: %+v", rep)
+			}
+			if rep.Head != head.String() {
+				t.Fatalf("wrong head in report: %s", rep.Head)
+			}
+			if rep.Records != len(recs) {
+				t.Fatalf("expected %d records, got %d", len(recs), rep.Records)
+			}
+			if rep.MSTNodes < 1 {
+				t.Fatal("expected at least one MST node")
+			}
+
+			// nothing stored for another user
+			rep, err = CheckUserRepo(ctx, cs, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rep.OK() || rep.Blocks != 0 {
+				t.Fatalf("unexpected report for empty repo: %+v", rep)
+			}
+
+			buf := new(bytes.Buffer)
+			if err := cs.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+				t.Fatal(err)
+			}
+			repoCar := buf.Bytes()
+
+			if err := RepairUserRepo(ctx, cs, 1, "did:other", repoCar); err == nil {
+				t.Fatal("expected error repairing with repo for wrong account")
+			}
+			if err := RepairUserRepo(ctx, cs, 1, "did:foo", repoCar[:len(repoCar)/2]); err == nil {
+				t.Fatal("expected error repairing with truncated repo")
+			}
+
+			if err := RepairUserRepo(ctx, cs, 1, "did:foo", repoCar); err != nil {
+				t.Fatal(err)
+			}
+			rep, err = CheckUserRepo(ctx, cs, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rep.OK() || rep.Head != head.String() || rep.Records != len(recs) {
+				t.Fatalf("unexpected report after repair: %+v", rep)
+			}
+		})
+	}
+}
+
+func TestFsckPebbleMissingBlock(t *testing.T) {
+	ctx := context.TODO()
+
+	ps := &PebbleStore{log: slogForTest(t)}
+	if err := ps.Open(filepath.Join(t.TempDir(), "blocks.pebble")); err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	_, recs := writeTestRepo(t, ps, 3)
+
+	rev, closer, err := ps.db.Get(pebbleCidKey(1, recs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bkey := pebbleBlockKey(1, string(rev), recs[1])
+	closer.Close()
+	if err := ps.db.Delete(bkey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := CheckUserRepo(ctx, ps, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.OK() {
+		t.Fatal("expected problems with missing block")
+	}
+	if len(rep.Missing) != 1 || rep.Missing[0] != recs[1].String() {
+		t.Fatalf("unexpected missing blocks: %v", rep.Missing)
+	}
+}
+
+func TestFsckOrphanShardFile(t *testing.T) {
+	ctx := context.TODO()
+
+	cs, cleanup, err := testCarStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	fcs := cs.(*FileCarStore)
+
+	writeTestRepo(t, cs, 2)
+
+	orphan := filepath.Join(fcs.dirForUser(1), fnameForShard(1, 999))
+	if err := os.WriteFile(orphan, []byte("junk"), 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := CheckUserRepo(ctx, cs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.OrphanShards) != 1 || rep.OrphanShards[0] != orphan {
+		t.Fatalf("unexpected orphan shards: %v", rep.OrphanShards)
+	}
+}
@@ -0,0 +1,242 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var ErrMigrationMismatch = errors.New("migrated repo does not match source")
+
+// MigrateUser copies a user's repo from src to dst, replacing anything already in dst, then verifies the head and rev in dst. Users already at the same head and rev in dst are skipped, so re-running a migration is cheap.
+//
+// Returns true if the user was copied (not skipped).
+func MigrateUser(ctx context.Context, src, dst CarStore, user models.Uid) (bool, error) {
+	head, err := src.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return false, fmt.Errorf("source head: %w", err)
+	}
+	rev, err := src.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return false, fmt.Errorf("source rev: %w", err)
+	}
+
+	if err := VerifyMigratedUser(ctx, src, dst, user); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ErrMigrationMismatch) {
+		return false, err
+	}
+
+	if !head.Defined() {
+		// nothing in source (eg, taken down since the destination was written)
+		if err := dst.WipeUserData(ctx, user); err != nil {
+			return false, fmt.Errorf("wiping destination: %w", err)
+		}
+		return true, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := src.ReadUserCar(ctx, user, "", true, buf); err != nil {
+		return false, fmt.Errorf("reading source repo: %w", err)
+	}
+	repoCar := buf.Bytes()
+
+	// don't copy broken repos; they should be repaired (see CheckUserRepo) instead
+	rep := &FsckReport{Uid: user, Rev: rev}
+	blks, _, err := readCarBlocks(bytes.NewReader(repoCar), rep)
+	if err != nil {
+		return false, fmt.Errorf("parsing source repo: %w", err)
+	}
+	walkRepoBlocks(blks, head, rep)
+	if !rep.OK() {
+		return false, fmt.Errorf("source repo is not complete (missing=%d corrupt=%d errors=%v)", len(rep.Missing), len(rep.Corrupt), rep.Errors)
+	}
+
+	if err := replaceUserRepo(ctx, dst, user, repoCar, head, rev); err != nil {
+		return false, err
+	}
+	return true, VerifyMigratedUser(ctx, src, dst, user)
+}
+
+// VerifyMigratedUser checks that the head and rev of a user's repo are the same in both stores. Returns an error wrapping ErrMigrationMismatch if they differ.
+func VerifyMigratedUser(ctx context.Context, src, dst CarStore, user models.Uid) error {
+	srcHead, err := src.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return fmt.Errorf("source head: %w", err)
+	}
+	srcRev, err := src.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return fmt.Errorf("source rev: %w", err)
+	}
+	dstHead, err := dst.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return fmt.Errorf("destination head: %w", err)
+	}
+	dstRev, err := dst.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return fmt.Errorf("destination rev: %w", err)
+	}
+	if srcHead != dstHead || srcRev != dstRev {
+		return fmt.Errorf("%w: uid=%d head %s != %s, rev %q != %q", ErrMigrationMismatch, user, dstHead, srcHead, dstRev, srcRev)
+	}
+	return nil
+}
+
+// UserLister returns up to limit uids greater than after, in ascending order
+type UserLister func(ctx context.Context, after models.Uid, limit int) ([]models.Uid, error)
+
+// Migrator copies all users from one carstore to another, tracking progress so an interrupted migration can resume.
+type Migrator struct {
+	Src CarStore
+	Dst CarStore
+	Log *slog.Logger
+
+	// optional, held while each user is copied so that live writes don't race with the copy (eg, repomgr.RepoManager.LockUser)
+	LockUser func(ctx context.Context, user models.Uid) func()
+	// optional, file in which the last completed uid is saved
+	CursorPath string
+	// only compare heads and revs; don't copy anything
+	VerifyOnly bool
+	// number of attempts per user; copies can fail verification if the repo was written to during the copy
+	Attempts int
+}
+
+type MigrationStats struct {
+	Checked    int
+	Copied     int
+	Mismatched int
+	Failed     int
+}
+
+func NewMigrator(src, dst CarStore) *Migrator {
+	return &Migrator{
+		Src:      src,
+		Dst:      dst,
+		Log:      slog.Default().With("system", "carstore-migrate"),
+		Attempts: 3,
+	}
+}
+
+// Run migrates every user returned by users, starting after the saved cursor (if any). Per-user failures are logged and counted, not returned.
+func (m *Migrator) Run(ctx context.Context, users UserLister) (*MigrationStats, error) {
+	cursor, err := m.loadCursor()
+	if err != nil {
+		return nil, err
+	}
+	if cursor > 0 {
+		m.Log.Info("resuming migration", "after", cursor)
+	}
+
+	stats := &MigrationStats{}
+	for {
+		uids, err := users(ctx, cursor, 500)
+		if err != nil {
+			return stats, fmt.Errorf("listing users: %w", err)
+		}
+		if len(uids) == 0 {
+			break
+		}
+		for _, uid := range uids {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+			m.migrateOne(ctx, uid, stats)
+			cursor = uid
+		}
+		if err := m.saveCursor(cursor); err != nil {
+			return stats, err
+		}
+		m.Log.Info("migration progress", "uid", cursor, "checked", stats.Checked, "copied", stats.Copied, "mismatched", stats.Mismatched, "failed", stats.Failed)
+	}
+	return stats, nil
+}
+
+func (m *Migrator) migrateOne(ctx context.Context, uid models.Uid, stats *MigrationStats) {
+	stats.Checked++
+	if m.LockUser != nil {
+		unlock := m.LockUser(ctx, uid)
+		defer unlock()
+	}
+
+	if m.VerifyOnly {
+		err := VerifyMigratedUser(ctx, m.Src, m.Dst, uid)
+		if errors.Is(err, ErrMigrationMismatch) {
+			stats.Mismatched++
+			migrateUsers.WithLabelValues("mismatch").Inc()
+			m.Log.Warn("repo mismatch", "uid", uid, "err", err)
+		} else if err != nil {
+			stats.Failed++
+			migrateUsers.WithLabelValues("error").Inc()
+			m.Log.Error("verify failed", "uid", uid, "err", err)
+		} else {
+			migrateUsers.WithLabelValues("ok").Inc()
+		}
+		return
+	}
+
+	attempts := max(m.Attempts, 1)
+	var err error
+	for i := 0; i < attempts; i++ {
+		var copied bool
+		copied, err = MigrateUser(ctx, m.Src, m.Dst, uid)
+		if err == nil {
+			if copied {
+				stats.Copied++
+				migrateUsers.WithLabelValues("copied").Inc()
+			} else {
+				migrateUsers.WithLabelValues("skipped").Inc()
+			}
+			return
+		}
+		if !errors.Is(err, ErrMigrationMismatch) {
+			break
+		}
+	}
+	stats.Failed++
+	migrateUsers.WithLabelValues("error").Inc()
+	m.Log.Error("migrate failed", "uid", uid, "err", err)
+}
+
+func (m *Migrator) loadCursor() (models.Uid, error) {
+	if m.CursorPath == "" {
+		return 0, nil
+	}
+	b, err := os.ReadFile(m.CursorPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: bad migration cursor: %w", m.CursorPath, err)
+	}
+	return models.Uid(v), nil
+}
+
+func (m *Migrator) saveCursor(cursor models.Uid) error {
+	if m.CursorPath == "" {
+		return nil
+	}
+	tmp := m.CursorPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(uint64(cursor), 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.CursorPath)
+}
+
+var migrateUsers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "carstore_migrate_users",
+	Help: "Users processed by carstore migration, by result",
+}, []string{"result"})
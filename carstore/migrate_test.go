@@ -0,0 +1,175 @@
+package carstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// adds a post to user 1's repo, in a new commit
+func addTestPost(t *testing.T, cs CarStore, head cid.Cid) cid.Cid {
+	ctx := context.TODO()
+	rev, err := cs.GetUserRepoRev(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := cs.NewDeltaSession(ctx, 1, &rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := repo.OpenRepo(ctx, ds, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{Text: "another"}); err != nil {
+		t.Fatal(err)
+	}
+	kmgr := &util.FakeKeyManager{}
+	nroot, nrev, err := rr.Commit(ctx, kmgr.SignForUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.CalcDiff(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, nroot, nrev); err != nil {
+		t.Fatal(err)
+	}
+	return nroot
+}
+
+func TestMigrateAndDualWrite(ot *testing.T) {
+	ctx := context.TODO()
+
+	for fname, tf := range backends {
+		ot.Run(fname, func(t *testing.T) {
+			src, cleanup, err := tf(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			dst, dstCleanup, err := testPebbleCarStore(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dstCleanup()
+
+			head, recs := writeTestRepo(t, src, 3)
+
+			// not migrated yet, so dual-write skips this user
+			dual := NewDualWriteCarStore(src, dst)
+			head = addTestPost(t, dual, head)
+			dstHead, err := dst.GetUserRepoHead(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dstHead.Defined() {
+				t.Fatal("unmigrated user should not be written to secondary")
+			}
+
+			copied, err := MigrateUser(ctx, src, dst, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !copied {
+				t.Fatal("expected user to be copied")
+			}
+			copied, err = MigrateUser(ctx, src, dst, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied {
+				t.Fatal("expected already-migrated user to be skipped")
+			}
+
+			// subsequent writes are mirrored
+			for i := 0; i < 3; i++ {
+				head = addTestPost(t, dual, head)
+			}
+			if err := VerifyMigratedUser(ctx, src, dst, 1); err != nil {
+				t.Fatal(err)
+			}
+			rep, err := CheckUserRepo(ctx, dst, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rep.OK() || rep.Head != head.String() || rep.Records != len(recs)+4 {
+				t.Fatalf("unexpected destination report: %+v", rep)
+			}
+
+			// writes directly to the source are detected
+			head = addTestPost(t, src, head)
+			if err := VerifyMigratedUser(ctx, src, dst, 1); err == nil {
+				t.Fatal("expected mismatch")
+			}
+			// ... and then skipped by dual-write, since the secondary is behind
+			addTestPost(t, dual, head)
+			if err := VerifyMigratedUser(ctx, src, dst, 1); err == nil {
+				t.Fatal("expected mismatch")
+			}
+		})
+	}
+}
+
+func TestMigratorResume(t *testing.T) {
+	ctx := context.TODO()
+
+	src, cleanup, err := testSqliteCarStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	dst, dstCleanup, err := testPebbleCarStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dstCleanup()
+
+	writeTestRepo(t, src, 2)
+
+	users := func(ctx context.Context, after models.Uid, limit int) ([]models.Uid, error) {
+		var out []models.Uid
+		// user 2 has no data
+		for _, uid := range []models.Uid{1, 2} {
+			if uid > after {
+				out = append(out, uid)
+			}
+		}
+		return out, nil
+	}
+
+	m := NewMigrator(src, dst)
+	m.CursorPath = filepath.Join(t.TempDir(), "cursor")
+	stats, err := m.Run(ctx, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Checked != 2 || stats.Copied != 1 || stats.Failed != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// resumes after the saved cursor
+	stats, err = m.Run(ctx, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Checked != 0 {
+		t.Fatalf("expected nothing to do after resume: %+v", stats)
+	}
+
+	m = NewMigrator(src, dst)
+	m.VerifyOnly = true
+	stats, err = m.Run(ctx, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Checked != 2 || stats.Mismatched != 0 {
+		t.Fatalf("unexpected verify stats: %+v", stats)
+	}
+}
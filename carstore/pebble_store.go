@@ -0,0 +1,573 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/cockroachdb/pebble"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-libipfs/blocks"
+	"github.com/ipld/go-car"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PebbleStore keeps repo blocks in a local pebble database. No external metadata database is needed.
+//
+// Schema:
+// B{uint64 uid}{rev}\x00{cid bytes} : {block bytes}
+// C{uint64 uid}{cid bytes} : {rev}
+// H{uint64 uid} : {uint64 seq}{uint64 unix ms}{rev}\x00{root cid bytes}
+//
+// Like SQLiteStore, each (uid,cid) is stored once, under the most recent rev which wrote it. B keys sort by rev within a user, so ReadUserCar since a rev is a single range scan, and WipeUserData is a few range deletes.
+type PebbleStore struct {
+	dbPath string
+	db     *pebble.DB
+
+	log *slog.Logger
+
+	lastShardCache lastShardCache
+}
+
+func NewPebbleStore(csdir string) (*PebbleStore, error) {
+	if err := ensureDir(csdir); err != nil {
+		return nil, err
+	}
+	out := new(PebbleStore)
+	err := out.Open(filepath.Join(csdir, "blocks.pebble"))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (ps *PebbleStore) Open(path string) error {
+	if ps.log == nil {
+		ps.log = slog.Default()
+	}
+	ps.log.Debug("open db", "path", path)
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return fmt.Errorf("%s: pebble could not open, %w", path, err)
+	}
+	ps.db = db
+	ps.dbPath = path
+	ps.lastShardCache.source = ps
+	ps.lastShardCache.Init()
+	return nil
+}
+
+func (ps *PebbleStore) Close() error {
+	if err := ps.db.Flush(); err != nil {
+		return err
+	}
+	return ps.db.Close()
+}
+
+func (ps *PebbleStore) CarStore() CarStore {
+	return ps
+}
+
+func pebbleUidPrefix(prefix byte, user models.Uid) []byte {
+	out := make([]byte, 9)
+	out[0] = prefix
+	binary.BigEndian.PutUint64(out[1:], uint64(user))
+	return out
+}
+
+// returns [lower, upper) bounds covering all keys of a user under the given prefix
+func pebbleUidBounds(prefix byte, user models.Uid) ([]byte, []byte) {
+	// uid max value would overflow, but uids are assigned sequentially from 1
+	return pebbleUidPrefix(prefix, user), pebbleUidPrefix(prefix, user+1)
+}
+
+func pebbleBlockKey(user models.Uid, rev string, bcid cid.Cid) []byte {
+	cb := bcid.Bytes()
+	out := make([]byte, 0, 9+len(rev)+1+len(cb))
+	out = append(out, pebbleUidPrefix('B', user)...)
+	out = append(out, rev...)
+	out = append(out, 0)
+	out = append(out, cb...)
+	return out
+}
+
+func parsePebbleBlockKey(key []byte) (rev string, bcid cid.Cid, err error) {
+	if len(key) < 10 {
+		return "", cid.Undef, fmt.Errorf("block key too short")
+	}
+	rest := key[9:]
+	sep := bytes.IndexByte(rest, 0)
+	if sep < 0 {
+		return "", cid.Undef, fmt.Errorf("block key missing rev separator")
+	}
+	bcid, err = cid.Cast(rest[sep+1:])
+	if err != nil {
+		return "", cid.Undef, fmt.Errorf("block key bad cid, %w", err)
+	}
+	return string(rest[:sep]), bcid, nil
+}
+
+func pebbleCidKey(user models.Uid, bcid cid.Cid) []byte {
+	return append(pebbleUidPrefix('C', user), bcid.Bytes()...)
+}
+
+type pebbleHead struct {
+	Seq     int
+	Created time.Time
+	Rev     string
+	Root    cid.Cid
+}
+
+func (h *pebbleHead) marshal() []byte {
+	rb := h.Root.Bytes()
+	out := make([]byte, 16, 16+len(h.Rev)+1+len(rb))
+	binary.BigEndian.PutUint64(out[0:8], uint64(h.Seq))
+	binary.BigEndian.PutUint64(out[8:16], uint64(h.Created.UnixMilli()))
+	out = append(out, h.Rev...)
+	out = append(out, 0)
+	out = append(out, rb...)
+	return out
+}
+
+func parsePebbleHead(val []byte) (*pebbleHead, error) {
+	if len(val) < 17 {
+		return nil, fmt.Errorf("head value too short")
+	}
+	rest := val[16:]
+	sep := bytes.IndexByte(rest, 0)
+	if sep < 0 {
+		return nil, fmt.Errorf("head value missing rev separator")
+	}
+	root, err := cid.Cast(rest[sep+1:])
+	if err != nil {
+		return nil, fmt.Errorf("head value bad root, %w", err)
+	}
+	return &pebbleHead{
+		Seq:     int(binary.BigEndian.Uint64(val[0:8])),
+		Created: time.UnixMilli(int64(binary.BigEndian.Uint64(val[8:16]))),
+		Rev:     string(rest[:sep]),
+		Root:    root,
+	}, nil
+}
+
+// getHead returns nil (and no error) if there is no data for the user
+func (ps *PebbleStore) getHead(user models.Uid) (*pebbleHead, error) {
+	val, closer, err := ps.db.Get(pebbleUidPrefix('H', user))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return parsePebbleHead(val)
+}
+
+// writeNewShard needed for DeltaSession.CloseWithRoot
+func (ps *PebbleStore) writeNewShard(ctx context.Context, root cid.Cid, rev string, user models.Uid, seq int, blks map[cid.Cid]blockformat.Block, rmcids map[cid.Cid]bool) ([]byte, error) {
+	pbWriteNewShard.Inc()
+	ps.log.Debug("write shard", "uid", user, "root", root, "rev", rev, "nblocks", len(blks))
+	ctx, span := otel.Tracer("carstore").Start(ctx, "writeNewShard")
+	defer span.End()
+
+	buf := new(bytes.Buffer)
+	hnw, err := WriteCarHeader(buf, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write car header: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("blocks", len(blks)))
+
+	batch := ps.db.NewBatch()
+	defer batch.Close()
+	for bcid, block := range blks {
+		// build shard for output firehose
+		if _, err := LdWrite(buf, bcid.Bytes(), block.RawData()); err != nil {
+			return nil, fmt.Errorf("failed to write block: %w", err)
+		}
+
+		// a block re-written by a later rev moves to that rev, same as the sqlite ON CONFLICT update
+		ckey := pebbleCidKey(user, bcid)
+		oldRev, closer, err := ps.db.Get(ckey)
+		if err == nil {
+			if string(oldRev) != rev {
+				if err := batch.Delete(pebbleBlockKey(user, string(oldRev), bcid), nil); err != nil {
+					closer.Close()
+					return nil, fmt.Errorf("pb block move, %w", err)
+				}
+			}
+			closer.Close()
+		} else if !errors.Is(err, pebble.ErrNotFound) {
+			return nil, fmt.Errorf("pb block lookup, %w", err)
+		}
+
+		blockbytes := block.RawData()
+		if err := batch.Set(pebbleBlockKey(user, rev, bcid), blockbytes, nil); err != nil {
+			return nil, fmt.Errorf("pb block store, %w", err)
+		}
+		if err := batch.Set(ckey, []byte(rev), nil); err != nil {
+			return nil, fmt.Errorf("pb block index, %w", err)
+		}
+		ps.log.Debug("put block", "uid", user, "cid", bcid, "size", len(blockbytes))
+	}
+
+	head := pebbleHead{
+		Seq:     seq,
+		Created: time.Now(),
+		Rev:     rev,
+		Root:    root,
+	}
+	if err := batch.Set(pebbleUidPrefix('H', user), head.marshal(), nil); err != nil {
+		return nil, fmt.Errorf("pb head store, %w", err)
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return nil, fmt.Errorf("pb block commit, %w", err)
+	}
+
+	shard := CarShard{
+		Root:      models.DbCID{CID: root},
+		DataStart: hnw,
+		Seq:       seq,
+		Usr:       user,
+		Rev:       rev,
+	}
+
+	ps.lastShardCache.put(&shard)
+
+	return buf.Bytes(), nil
+}
+
+// GetLastShard needed for NewDeltaSession indirectly through lastShardCache
+func (ps *PebbleStore) GetLastShard(ctx context.Context, uid models.Uid) (*CarShard, error) {
+	pbGetLastShard.Inc()
+	head, err := ps.getHead(uid)
+	if err != nil {
+		return nil, fmt.Errorf("last shard err, %w", err)
+	}
+	if head == nil {
+		return nil, nil
+	}
+	return &CarShard{
+		CreatedAt: head.Created,
+		Root:      models.DbCID{CID: head.Root},
+		Seq:       head.Seq,
+		Usr:       uid,
+		Rev:       head.Rev,
+	}, nil
+}
+
+func (ps *PebbleStore) CompactUserShards(ctx context.Context, user models.Uid, skipBigShards bool) (*CompactionStats, error) {
+	// nothing to compact; blocks are stored individually
+	return nil, nil
+}
+
+func (ps *PebbleStore) GetCompactionTargets(ctx context.Context, shardCount int) ([]CompactionTarget, error) {
+	return nil, nil
+}
+
+func (ps *PebbleStore) GetUserRepoHead(ctx context.Context, user models.Uid) (cid.Cid, error) {
+	lastShard, err := ps.lastShardCache.get(ctx, user)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if lastShard == nil {
+		return cid.Undef, nil
+	}
+	return lastShard.Root.CID, nil
+}
+
+func (ps *PebbleStore) GetUserRepoRev(ctx context.Context, user models.Uid) (string, error) {
+	lastShard, err := ps.lastShardCache.get(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	if lastShard == nil {
+		return "", nil
+	}
+	return lastShard.Rev, nil
+}
+
+func (ps *PebbleStore) ImportSlice(ctx context.Context, uid models.Uid, since *string, carslice []byte) (cid.Cid, *DeltaSession, error) {
+	// TODO: same as FileCarStore and SQLiteStore, re-unify
+	ctx, span := otel.Tracer("carstore").Start(ctx, "ImportSlice")
+	defer span.End()
+
+	carr, err := car.NewCarReader(bytes.NewReader(carslice))
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	if len(carr.Header.Roots) != 1 {
+		return cid.Undef, nil, fmt.Errorf("invalid car file, header must have a single root (has %d)", len(carr.Header.Roots))
+	}
+
+	ds, err := ps.NewDeltaSession(ctx, uid, since)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("new delta session failed: %w", err)
+	}
+
+	for {
+		blk, err := carr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return cid.Undef, nil, err
+		}
+
+		if err := ds.Put(ctx, blk); err != nil {
+			return cid.Undef, nil, err
+		}
+	}
+
+	return carr.Header.Roots[0], ds, nil
+}
+
+func (ps *PebbleStore) NewDeltaSession(ctx context.Context, user models.Uid, since *string) (*DeltaSession, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "NewSession")
+	defer span.End()
+
+	lastShard, err := ps.lastShardCache.get(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("NewDeltaSession, lsc, %w", err)
+	}
+
+	if lastShard == nil {
+		lastShard = &zeroShard
+	}
+
+	if since != nil && *since != lastShard.Rev {
+		return nil, fmt.Errorf("revision mismatch: %s != %s: %w", *since, lastShard.Rev, ErrRepoBaseMismatch)
+	}
+
+	return &DeltaSession{
+		blks: make(map[cid.Cid]blockformat.Block),
+		base: &sqliteUserView{
+			uid: user,
+			sqs: ps,
+		},
+		user:    user,
+		baseCid: lastShard.Root.CID,
+		cs:      ps,
+		seq:     lastShard.Seq + 1,
+		lastRev: lastShard.Rev,
+	}, nil
+}
+
+func (ps *PebbleStore) ReadOnlySession(user models.Uid) (*DeltaSession, error) {
+	return &DeltaSession{
+		base: &sqliteUserView{
+			uid: user,
+			sqs: ps,
+		},
+		readonly: true,
+		user:     user,
+		cs:       ps,
+	}, nil
+}
+
+// ReadUserCar writes all blocks of a user stored at revs after sinceRev
+// incremental is only ever called true
+func (ps *PebbleStore) ReadUserCar(ctx context.Context, user models.Uid, sinceRev string, incremental bool, shardOut io.Writer) error {
+	pbGetCar.Inc()
+	ctx, span := otel.Tracer("carstore").Start(ctx, "ReadUserCar")
+	defer span.End()
+
+	head, err := ps.getHead(user)
+	if err != nil {
+		return fmt.Errorf("rcar head, %w", err)
+	}
+	if head == nil {
+		return nil
+	}
+
+	// revs are terminated by \x00 in keys, so {sinceRev}\x01 sorts after every key at exactly sinceRev and before any later rev
+	lower := append(pebbleUidPrefix('B', user), sinceRev...)
+	lower = append(lower, 1)
+	_, upper := pebbleUidBounds('B', user)
+	iter, err := ps.db.NewIterWithContext(ctx, &pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: upper,
+	})
+	if err != nil {
+		return fmt.Errorf("rcar iter, %w", err)
+	}
+	defer iter.Close()
+
+	nblocks := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		_, bcid, err := parsePebbleBlockKey(iter.Key())
+		if err != nil {
+			return fmt.Errorf("rcar bad key, %w", err)
+		}
+		if nblocks == 0 {
+			if err := car.WriteHeader(&car.CarHeader{
+				Roots:   []cid.Cid{head.Root},
+				Version: 1,
+			}, shardOut); err != nil {
+				return fmt.Errorf("rcar bad header, %w", err)
+			}
+		}
+		nblocks++
+		if _, err := LdWrite(shardOut, bcid.Bytes(), iter.Value()); err != nil {
+			return fmt.Errorf("rcar bad write, %w", err)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("rcar iter, %w", err)
+	}
+	ps.log.Debug("read car", "nblocks", nblocks, "since", sinceRev)
+	return nil
+}
+
+// Stat is only used in a debugging admin handler. There are no shards, so this returns just the current head.
+func (ps *PebbleStore) Stat(ctx context.Context, usr models.Uid) ([]UserStat, error) {
+	head, err := ps.getHead(usr)
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		return nil, nil
+	}
+	return []UserStat{{
+		Seq:     head.Seq,
+		Root:    head.Root.String(),
+		Created: head.Created,
+	}}, nil
+}
+
+func (ps *PebbleStore) WipeUserData(ctx context.Context, user models.Uid) error {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "WipeUserData")
+	defer span.End()
+
+	batch := ps.db.NewBatch()
+	defer batch.Close()
+	for _, prefix := range []byte{'B', 'C'} {
+		lower, upper := pebbleUidBounds(prefix, user)
+		if err := batch.DeleteRange(lower, upper, nil); err != nil {
+			return fmt.Errorf("wipe range, %w", err)
+		}
+	}
+	if err := batch.Delete(pebbleUidPrefix('H', user), nil); err != nil {
+		return fmt.Errorf("wipe head, %w", err)
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return fmt.Errorf("wipe commit, %w", err)
+	}
+	ps.lastShardCache.remove(user)
+	pbUsersWiped.Inc()
+	return nil
+}
+
+// HasUidCid needed for NewDeltaSession userView
+func (ps *PebbleStore) HasUidCid(ctx context.Context, user models.Uid, bcid cid.Cid) (bool, error) {
+	pbHas.Inc()
+	_, closer, err := ps.db.Get(pebbleCidKey(user, bcid))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("hasUC err, %w", err)
+	}
+	closer.Close()
+	return true, nil
+}
+
+// getBlockBytes returns a copy of the stored block, or ErrNothingThere
+func (ps *PebbleStore) getBlockBytes(user models.Uid, bcid cid.Cid) ([]byte, error) {
+	rev, closer, err := ps.db.Get(pebbleCidKey(user, bcid))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, ErrNothingThere
+	}
+	if err != nil {
+		return nil, err
+	}
+	bkey := pebbleBlockKey(user, string(rev), bcid)
+	closer.Close()
+
+	val, closer, err := ps.db.Get(bkey)
+	if errors.Is(err, pebble.ErrNotFound) {
+		// index without block; only possible with a damaged database
+		return nil, ErrNothingThere
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return bytes.Clone(val), nil
+}
+
+func (ps *PebbleStore) getBlock(ctx context.Context, user models.Uid, bcid cid.Cid) (blockformat.Block, error) {
+	pbGetBlock.Inc()
+	blockb, err := ps.getBlockBytes(user, bcid)
+	if err != nil {
+		if errors.Is(err, ErrNothingThere) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("getb err, %w", err)
+	}
+	return blocks.NewBlockWithCid(blockb, bcid)
+}
+
+func (ps *PebbleStore) getBlockSize(ctx context.Context, user models.Uid, bcid cid.Cid) (int64, error) {
+	pbGetBlockSize.Inc()
+	blockb, err := ps.getBlockBytes(user, bcid)
+	if errors.Is(err, ErrNothingThere) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("getbs err, %w", err)
+	}
+	return int64(len(blockb)), nil
+}
+
+// ensure we implement the interfaces
+var _ CarStore = (*PebbleStore)(nil)
+var _ sqliteUserViewInner = (*PebbleStore)(nil)
+
+var pbUsersWiped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_users_wiped",
+	Help: "Users wiped in pebble backend",
+})
+
+var pbGetBlock = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_get_block",
+	Help: "get block pebble backend",
+})
+
+var pbGetBlockSize = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_get_block_size",
+	Help: "get block size pebble backend",
+})
+
+var pbGetCar = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_get_car",
+	Help: "get car pebble backend",
+})
+
+var pbHas = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_has",
+	Help: "check block presence pebble backend",
+})
+
+var pbGetLastShard = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_get_last_shard",
+	Help: "get last shard pebble backend",
+})
+
+var pbWriteNewShard = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_pb_write_shard",
+	Help: "write shard blocks pebble backend",
+})
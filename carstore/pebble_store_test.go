@@ -0,0 +1,157 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+)
+
+func TestPebbleStoreSinceAndWipe(t *testing.T) {
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "blocks.pebble")
+
+	ps := &PebbleStore{log: slogForTest(t)}
+	if err := ps.Open(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := ps.NewDeltaSession(ctx, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, rev, err := setupRepo(ctx, ds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	var revs []string
+	var diffs []map[cid.Cid]bool
+	for i := 0; i < 3; i++ {
+		ds, err := ps.NewDeltaSession(ctx, 1, &rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := repo.OpenRepo(ctx, ds, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+			Text: fmt.Sprintf("post %d", i),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		kmgr := &util.FakeKeyManager{}
+		head, rev, err = rr.Commit(ctx, kmgr.SignForUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.CalcDiff(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+		written := make(map[cid.Cid]bool)
+		for c := range ds.blks {
+			written[c] = true
+		}
+		if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, rev)
+		diffs = append(diffs, written)
+	}
+
+	// only blocks written after revs[1] are returned
+	buf := new(bytes.Buffer)
+	if err := ps.ReadUserCar(ctx, 1, revs[1], true, buf); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := car.NewCarReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Header.Roots[0] != head {
+		t.Fatalf("wrong car root %s, expected %s", cr.Header.Roots[0], head)
+	}
+	nblocks := 0
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			break
+		}
+		if !diffs[2][blk.Cid()] {
+			t.Fatalf("unexpected block %s in partial car", blk.Cid())
+		}
+		nblocks++
+	}
+	if nblocks != len(diffs[2]) {
+		t.Fatalf("expected %d blocks, got %d", len(diffs[2]), nblocks)
+	}
+
+	// nothing after the current rev
+	buf = new(bytes.Buffer)
+	if err := ps.ReadUserCar(ctx, 1, rev, true, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected empty car, got %d bytes", buf.Len())
+	}
+
+	// head survives re-open
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ps = &PebbleStore{log: slogForTest(t)}
+	if err := ps.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	gotRev, err := ps.GetUserRepoRev(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotRev != rev {
+		t.Fatalf("wrong rev after re-open: %s", gotRev)
+	}
+	gotHead, err := ps.GetUserRepoHead(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotHead != head {
+		t.Fatalf("wrong head after re-open: %s", gotHead)
+	}
+
+	if err := ps.WipeUserData(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	has, err := ps.HasUidCid(ctx, 1, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("root block still present after wipe")
+	}
+	gotHead, err = ps.GetUserRepoHead(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotHead.Defined() {
+		t.Fatal("head still present after wipe")
+	}
+	buf = new(bytes.Buffer)
+	if err := ps.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected empty car after wipe, got %d bytes", buf.Len())
+	}
+}
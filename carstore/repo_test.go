@@ -71,11 +71,22 @@ func testSqliteCarStore(t testing.TB) (CarStore, func(), error) {
 	return sqs, func() {}, nil
 }
 
+func testPebbleCarStore(t testing.TB) (CarStore, func(), error) {
+	ps := &PebbleStore{}
+	ps.log = slogForTest(t)
+	err := ps.Open(filepath.Join(t.TempDir(), "blocks.pebble"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return ps, func() { _ = ps.Close() }, nil
+}
+
 type testFactory func(t testing.TB) (CarStore, func(), error)
 
 var backends = map[string]testFactory{
 	"cartore": testCarStore,
 	"sqlite":  testSqliteCarStore,
+	"pebble":  testPebbleCarStore,
 }
 
 func testFlatfsBs() (blockstore.Blockstore, func(), error) {
@@ -212,12 +212,10 @@ func (sqs *SQLiteStore) GetUserRepoHead(ctx context.Context, user models.Uid) (c
 	if err != nil {
 		return cid.Undef, err
 	}
+	// unlike FileCarStore, shards from GetLastShard have no ID
 	if lastShard == nil {
 		return cid.Undef, nil
 	}
-	if lastShard.ID == 0 {
-		return cid.Undef, nil
-	}
 
 	return lastShard.Root.CID, nil
 }
@@ -231,9 +229,6 @@ func (sqs *SQLiteStore) GetUserRepoRev(ctx context.Context, user models.Uid) (st
 	if lastShard == nil {
 		return "", nil
 	}
-	if lastShard.ID == 0 {
-		return "", nil
-	}
 
 	return lastShard.Rev, nil
 }
@@ -408,6 +403,9 @@ func (sqs *SQLiteStore) WipeUserData(ctx context.Context, user models.Uid) error
 	if err == nil {
 		err = tx.Commit()
 	}
+	if err == nil {
+		sqs.lastShardCache.remove(user)
+	}
 	return err
 }
 
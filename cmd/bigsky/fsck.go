@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	libbgs "github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+)
+
+var fsckCmd = &cli.Command{
+	Name:  "fsck",
+	Usage: "check stored repos for missing or corrupt blocks, and optionally repair them from the PDS",
+	Description: `Walks every stored repo (or just those given with --did), verifying that all blocks are hash-correct and that the commit, MST, and records reachable from the head are all present.
+Problem repos are printed to stdout as JSON lines. The relay should not be running when using --repair.
+Uses the same database and carstore flags as the relay daemon.`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "did",
+			Usage: "only check these accounts (may be repeated)",
+		},
+		&cli.BoolFlag{
+			Name:  "repair",
+			Usage: "re-fetch problem repos from their PDS and replace stored data",
+		},
+		&cli.Uint64Flag{
+			Name:  "start-uid",
+			Usage: "resume a full check from this uid",
+		},
+		&cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "print a report for every repo, not just those with problems",
+		},
+	},
+	Action: runFsck,
+}
+
+func runFsck(cctx *cli.Context) error {
+	ctx := cctx.Context
+	logger := slog.Default().With("system", "fsck")
+
+	db, err := cliutil.SetupDatabase(cctx.String("db-url"), cctx.Int("max-metadb-connections"))
+	if err != nil {
+		return err
+	}
+	cstore, err := setupCarstore(cctx, filepath.Join(cctx.String("data-dir"), "carstore"))
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	repair := cctx.Bool("repair")
+	var checked, problems, repaired, failed int
+
+	check := func(u *libbgs.User) error {
+		checked++
+		rep, err := carstore.CheckUserRepo(ctx, cstore, u.ID)
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", u.Did, err)
+		}
+		if rep.OK() {
+			if cctx.Bool("verbose") {
+				return out.Encode(map[string]any{"did": u.Did, "report": rep})
+			}
+			return nil
+		}
+		problems++
+		if err := out.Encode(map[string]any{"did": u.Did, "report": rep}); err != nil {
+			return err
+		}
+		if !repair {
+			return nil
+		}
+		if err := repairRepo(cctx, db, cstore, u); err != nil {
+			failed++
+			logger.Error("repair failed", "did", u.Did, "uid", u.ID, "err", err)
+			return nil
+		}
+		repaired++
+		logger.Info("repaired repo", "did", u.Did, "uid", u.ID)
+		return nil
+	}
+
+	if dids := cctx.StringSlice("did"); len(dids) > 0 {
+		for _, did := range dids {
+			var u libbgs.User
+			if err := db.WithContext(ctx).First(&u, "did = ?", did).Error; err != nil {
+				return fmt.Errorf("looking up %s: %w", did, err)
+			}
+			if err := check(&u); err != nil {
+				return err
+			}
+		}
+	} else {
+		cursor := models.Uid(cctx.Uint64("start-uid"))
+		for {
+			var users []libbgs.User
+			if err := db.WithContext(ctx).Model(&libbgs.User{}).Where("id >= ?", cursor).Order("id asc").Limit(500).Find(&users).Error; err != nil {
+				return err
+			}
+			if len(users) == 0 {
+				break
+			}
+			for i := range users {
+				if err := check(&users[i]); err != nil {
+					return err
+				}
+			}
+			cursor = users[len(users)-1].ID + 1
+			logger.Info("fsck progress", "uid", cursor-1, "checked", checked, "problems", problems)
+		}
+	}
+
+	logger.Info("fsck complete", "checked", checked, "problems", problems, "repaired", repaired, "repairFailed", failed)
+	if problems > repaired {
+		return fmt.Errorf("%d repos with unrepaired problems", problems-repaired)
+	}
+	return nil
+}
+
+func repairRepo(cctx *cli.Context, db *gorm.DB, cstore carstore.CarStore, u *libbgs.User) error {
+	ctx := cctx.Context
+
+	var pds models.PDS
+	if err := db.WithContext(ctx).First(&pds, "id = ?", u.PDS).Error; err != nil {
+		return fmt.Errorf("looking up pds (%d): %w", u.PDS, err)
+	}
+	c := models.ClientForPds(&pds)
+	c.Client = util.RobustHTTPClient()
+
+	repoCar, err := comatproto.SyncGetRepo(ctx, c, u.Did, "")
+	if err != nil {
+		return fmt.Errorf("fetching repo from %s: %w", pds.Host, err)
+	}
+	return carstore.RepairUserRepo(ctx, cstore, u.ID, u.Did, repoCar)
+}
@@ -217,6 +217,28 @@ func run(args []string) error {
 			Usage: "enable experimental sqlite carstore",
 			Value: false,
 		},
+		&cli.BoolFlag{
+			Name:    "pebble-carstore",
+			Usage:   "store repo blocks in a local pebble database under data-dir (no carstore-db-url needed)",
+			Value:   false,
+			EnvVars: []string{"RELAY_PEBBLE_CARSTORE"},
+		},
+		&cli.BoolFlag{
+			Name:    "carstore-archive",
+			Usage:   "keep superseded repo blocks and commits, for reading repos and records at earlier revs through the admin API",
+			EnvVars: []string{"RELAY_CARSTORE_ARCHIVE"},
+		},
+		&cli.StringFlag{
+			Name:    "carstore-archive-dir",
+			Usage:   "directory for the repo history archive (default: carstore-archive in data-dir)",
+			EnvVars: []string{"RELAY_CARSTORE_ARCHIVE_DIR"},
+		},
+		&cli.DurationFlag{
+			Name:    "carstore-archive-retention",
+			Usage:   "how long superseded repo history is kept; 0 keeps everything",
+			Value:   30 * 24 * time.Hour,
+			EnvVars: []string{"RELAY_CARSTORE_ARCHIVE_RETENTION"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "scylla-carstore",
 			Usage:   "scylla server addresses for storage backend, comma separated",
@@ -230,7 +252,13 @@ func run(args []string) error {
 		},
 	}
 
+	app.Flags = append(app.Flags, carstoreNextFlags...)
+
 	app.Action = runBigsky
+	app.Commands = []*cli.Command{
+		fsckCmd,
+		migrateCarstoreCmd,
+	}
 	return app.Run(os.Args)
 }
 
@@ -335,60 +363,44 @@ func runBigsky(cctx *cli.Context) error {
 		}
 	}
 
-	var cstore carstore.CarStore
-	scyllaAddrs := cctx.StringSlice("scylla-carstore")
-	sqliteStore := cctx.Bool("ex-sqlite-carstore")
-	if len(scyllaAddrs) != 0 {
-		slog.Info("starting scylla carstore", "addrs", scyllaAddrs)
-		cstore, err = carstore.NewScyllaStore(scyllaAddrs, "cs")
-	} else if sqliteStore {
-		slog.Info("starting sqlite carstore", "dir", csdir)
-		cstore, err = carstore.NewSqliteStore(csdir)
-	} else if cctx.Bool("non-archival") {
-		csdburl := cctx.String("carstore-db-url")
-		slog.Info("setting up non-archival carstore database", "url", csdburl)
-		csdb, err := cliutil.SetupDatabase(csdburl, cctx.Int("max-carstore-connections"))
-		if err != nil {
-			return err
+	cstore, err := setupCarstore(cctx, csdir)
+	if err != nil {
+		return err
+	}
+
+	var dualStore *carstore.DualWriteCarStore
+	nextStore, err := setupNextCarstore(cctx)
+	if err != nil {
+		return err
+	}
+	if nextStore != nil {
+		if cctx.Bool("carstore-next-primary") && cctx.Bool("carstore-next-migrate") {
+			// the migrator copies from the old carstore to carstore-next, wiping any repo which is missing from the source; once carstore-next is primary, the old carstore may be behind
+			return fmt.Errorf("--carstore-next-migrate can not be used with --carstore-next-primary")
 		}
-		if cctx.Bool("db-tracing") {
-			if err := csdb.Use(tracing.NewPlugin()); err != nil {
-				return err
-			}
+		if cctx.Bool("carstore-next-primary") {
+			dualStore = carstore.NewDualWriteCarStore(nextStore, cstore)
+		} else {
+			dualStore = carstore.NewDualWriteCarStore(cstore, nextStore)
 		}
-		cs, err := carstore.NewNonArchivalCarstore(csdb)
-		if err != nil {
-			return err
+		cstore = dualStore
+	}
+
+	if cctx.Bool("carstore-archive") {
+		archiveDir := cctx.String("carstore-archive-dir")
+		if archiveDir == "" {
+			archiveDir = filepath.Join(datadir, "carstore-archive")
 		}
-		cstore = cs
-	} else {
-		// make standard FileCarStore
-		csdburl := cctx.String("carstore-db-url")
-		slog.Info("setting up carstore database", "url", csdburl)
-		csdb, err := cliutil.SetupDatabase(csdburl, cctx.Int("max-carstore-connections"))
+		slog.Info("starting carstore archive", "dir", archiveDir, "retention", cctx.Duration("carstore-archive-retention"))
+		archive, err := carstore.NewArchiveStore(cstore, archiveDir, cctx.Duration("carstore-archive-retention"))
 		if err != nil {
 			return err
 		}
-		if cctx.Bool("db-tracing") {
-			if err := csdb.Use(tracing.NewPlugin()); err != nil {
-				return err
-			}
-		}
-		csdirs := []string{csdir}
-		if paramDirs := cctx.StringSlice("carstore-shard-dirs"); len(paramDirs) > 0 {
-			csdirs = paramDirs
-		}
-
-		for _, csd := range csdirs {
-			if err := os.MkdirAll(filepath.Dir(csd), os.ModePerm); err != nil {
-				return err
-			}
-		}
-		cstore, err = carstore.NewCarStore(csdb, csdirs)
-	}
-
-	if err != nil {
-		return err
+		defer archive.Close()
+		archiveCtx, archiveCancel := context.WithCancel(context.Background())
+		defer archiveCancel()
+		go archive.Run(archiveCtx, time.Hour)
+		cstore = archive
 	}
 
 	// DID RESOLUTION
@@ -423,6 +435,23 @@ func runBigsky(cctx *cli.Context) error {
 
 	repoman := repomgr.NewRepoManager(cstore, kmgr)
 
+	if dualStore != nil && cctx.Bool("carstore-next-migrate") {
+		migrateCtx, migrateCancel := context.WithCancel(context.Background())
+		defer migrateCancel()
+		// always old carstore -> carstore-next (never combined with --carstore-next-primary)
+		m := carstore.NewMigrator(dualStore.Primary, nextStore)
+		m.LockUser = repoman.LockUser
+		m.CursorPath = filepath.Join(datadir, "carstore-migrate.cursor")
+		go func() {
+			stats, err := m.Run(migrateCtx, dbUserLister(db))
+			if err != nil {
+				slog.Error("carstore migration stopped", "err", err)
+				return
+			}
+			slog.Info("carstore migration complete", "checked", stats.Checked, "copied", stats.Copied, "failed", stats.Failed)
+		}()
+	}
+
 	var persister events.EventPersistence
 
 	if dpd := cctx.String("disk-persister-dir"); dpd != "" {
@@ -568,3 +597,64 @@ func runBigsky(cctx *cli.Context) error {
 
 	return nil
 }
+
+// setupCarstore opens the carstore backend selected by flags
+func setupCarstore(cctx *cli.Context, csdir string) (carstore.CarStore, error) {
+	var cstore carstore.CarStore
+	var err error
+	scyllaAddrs := cctx.StringSlice("scylla-carstore")
+	sqliteStore := cctx.Bool("ex-sqlite-carstore")
+	if len(scyllaAddrs) != 0 {
+		slog.Info("starting scylla carstore", "addrs", scyllaAddrs)
+		cstore, err = carstore.NewScyllaStore(scyllaAddrs, "cs")
+	} else if sqliteStore {
+		slog.Info("starting sqlite carstore", "dir", csdir)
+		cstore, err = carstore.NewSqliteStore(csdir)
+	} else if cctx.Bool("pebble-carstore") {
+		slog.Info("starting pebble carstore", "dir", csdir)
+		cstore, err = carstore.NewPebbleStore(csdir)
+	} else if cctx.Bool("non-archival") {
+		csdburl := cctx.String("carstore-db-url")
+		slog.Info("setting up non-archival carstore database", "url", csdburl)
+		csdb, err := cliutil.SetupDatabase(csdburl, cctx.Int("max-carstore-connections"))
+		if err != nil {
+			return nil, err
+		}
+		if cctx.Bool("db-tracing") {
+			if err := csdb.Use(tracing.NewPlugin()); err != nil {
+				return nil, err
+			}
+		}
+		cs, err := carstore.NewNonArchivalCarstore(csdb)
+		if err != nil {
+			return nil, err
+		}
+		cstore = cs
+	} else {
+		// make standard FileCarStore
+		csdburl := cctx.String("carstore-db-url")
+		slog.Info("setting up carstore database", "url", csdburl)
+		csdb, err := cliutil.SetupDatabase(csdburl, cctx.Int("max-carstore-connections"))
+		if err != nil {
+			return nil, err
+		}
+		if cctx.Bool("db-tracing") {
+			if err := csdb.Use(tracing.NewPlugin()); err != nil {
+				return nil, err
+			}
+		}
+		csdirs := []string{csdir}
+		if paramDirs := cctx.StringSlice("carstore-shard-dirs"); len(paramDirs) > 0 {
+			csdirs = paramDirs
+		}
+
+		for _, csd := range csdirs {
+			if err := os.MkdirAll(filepath.Dir(csd), os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+		return carstore.NewCarStore(csdb, csdirs)
+	}
+
+	return cstore, err
+}
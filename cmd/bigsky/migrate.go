@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	libbgs "github.com/bluesky-social/indigo/bgs"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+)
+
+var carstoreNextFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "carstore-next",
+		Usage:   "carstore backend being migrated to (pebble, sqlite, or scylla); enables dual-write in the daemon",
+		EnvVars: []string{"RELAY_CARSTORE_NEXT"},
+	},
+	&cli.StringFlag{
+		Name:    "carstore-next-dir",
+		Usage:   "directory for a pebble or sqlite carstore-next (default: carstore-next in data-dir)",
+		EnvVars: []string{"RELAY_CARSTORE_NEXT_DIR"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "carstore-next-scylla",
+		Usage:   "scylla server addresses for a scylla carstore-next, comma separated",
+		EnvVars: []string{"RELAY_CARSTORE_NEXT_SCYLLA_NODES"},
+	},
+	&cli.BoolFlag{
+		Name:    "carstore-next-primary",
+		Usage:   "serve reads from carstore-next, and dual-write to the old carstore (for rollback)",
+		EnvVars: []string{"RELAY_CARSTORE_NEXT_PRIMARY"},
+	},
+	&cli.BoolFlag{
+		Name:    "carstore-next-migrate",
+		Usage:   "copy all repos to carstore-next in the background while the daemon runs; not allowed with --carstore-next-primary",
+		EnvVars: []string{"RELAY_CARSTORE_NEXT_MIGRATE"},
+	},
+}
+
+var migrateCarstoreCmd = &cli.Command{
+	Name:  "migrate-carstore",
+	Usage: "copy all repos from the configured carstore to --carstore-next",
+	Description: `Offline migration between carstore backends; the relay should be stopped. To migrate a running relay, use the --carstore-next-migrate daemon flag instead.
+Accounts already at the same head and rev in the destination are skipped, and progress is saved to --cursor-file, so an interrupted migration can be resumed.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "cursor-file",
+			Usage: "file to save migration progress to (default: carstore-migrate.cursor in data-dir)",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-only",
+			Usage: "only compare repo heads and revs between the two carstores",
+		},
+	},
+	Action: runMigrateCarstore,
+}
+
+// setupNextCarstore opens the carstore-next backend, or returns nil if none is configured
+func setupNextCarstore(cctx *cli.Context) (carstore.CarStore, error) {
+	kind := cctx.String("carstore-next")
+	dir := cctx.String("carstore-next-dir")
+	if dir == "" {
+		dir = filepath.Join(cctx.String("data-dir"), "carstore-next")
+	}
+	switch kind {
+	case "":
+		return nil, nil
+	case "pebble":
+		slog.Info("starting next pebble carstore", "dir", dir)
+		return carstore.NewPebbleStore(dir)
+	case "sqlite":
+		slog.Info("starting next sqlite carstore", "dir", dir)
+		return carstore.NewSqliteStore(dir)
+	case "scylla":
+		addrs := cctx.StringSlice("carstore-next-scylla")
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("--carstore-next-scylla is required for scylla carstore-next")
+		}
+		slog.Info("starting next scylla carstore", "addrs", addrs)
+		return carstore.NewScyllaStore(addrs, "cs")
+	default:
+		return nil, fmt.Errorf("unsupported carstore-next backend: %s", kind)
+	}
+}
+
+func migrateCursorPath(cctx *cli.Context) string {
+	if p := cctx.String("cursor-file"); p != "" {
+		return p
+	}
+	return filepath.Join(cctx.String("data-dir"), "carstore-migrate.cursor")
+}
+
+// dbUserLister lists relay accounts from the main database
+func dbUserLister(db *gorm.DB) carstore.UserLister {
+	return func(ctx context.Context, after models.Uid, limit int) ([]models.Uid, error) {
+		var uids []models.Uid
+		err := db.WithContext(ctx).Model(&libbgs.User{}).Where("id > ?", after).Order("id asc").Limit(limit).Pluck("id", &uids).Error
+		return uids, err
+	}
+}
+
+func runMigrateCarstore(cctx *cli.Context) error {
+	ctx := cctx.Context
+
+	db, err := cliutil.SetupDatabase(cctx.String("db-url"), cctx.Int("max-metadb-connections"))
+	if err != nil {
+		return err
+	}
+	src, err := setupCarstore(cctx, filepath.Join(cctx.String("data-dir"), "carstore"))
+	if err != nil {
+		return err
+	}
+	dst, err := setupNextCarstore(cctx)
+	if err != nil {
+		return err
+	}
+	if dst == nil {
+		return fmt.Errorf("--carstore-next is required")
+	}
+
+	m := carstore.NewMigrator(src, dst)
+	m.VerifyOnly = cctx.Bool("verify-only")
+	if !m.VerifyOnly {
+		m.CursorPath = migrateCursorPath(cctx)
+	}
+	stats, err := m.Run(ctx, dbUserLister(db))
+	if err != nil {
+		return err
+	}
+	slog.Info("migration complete", "checked", stats.Checked, "copied", stats.Copied, "mismatched", stats.Mismatched, "failed", stats.Failed)
+	if stats.Failed > 0 || stats.Mismatched > 0 {
+		return fmt.Errorf("%d repos failed, %d mismatched", stats.Failed, stats.Mismatched)
+	}
+	return nil
+}
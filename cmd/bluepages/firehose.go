@@ -20,24 +20,56 @@ import (
 
 var firehoseCursorKey = "bluepages/firehoseSeq"
 
+// Subscribes to the firehose, and purges (and refreshes) cached identity data based on #identity and #account events. Reconnects (with backoff) if the connection fails, resuming from the last received sequence number.
 func (srv *Server) RunFirehoseConsumer(ctx context.Context, host string, parallelism int) error {
 
 	cur, err := srv.ReadLastCursor(ctx)
 	if err != nil {
 		return err
 	}
+	if cur > 0 {
+		atomic.StoreInt64(&srv.lastSeq, cur)
+	}
 
-	dialer := websocket.DefaultDialer
 	u, err := url.Parse(host)
 	if err != nil {
 		return fmt.Errorf("invalid Host URI: %w", err)
 	}
 	u.Path = "xrpc/com.atproto.sync.subscribeRepos"
-	if cur != 0 {
+
+	backoff := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		start := time.Now()
+		if err := srv.consumeFirehose(ctx, host, *u, parallelism); err != nil {
+			srv.logger.Warn("firehose connection failed", "upstream", host, "err", err)
+		}
+		if time.Since(start) > time.Minute {
+			backoff = 0
+		}
+		backoff++
+		delay := time.Duration(min(backoff*backoff, 60)) * time.Second
+		srv.logger.Info("reconnecting to firehose", "upstream", host, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (srv *Server) consumeFirehose(ctx context.Context, host string, u url.URL, parallelism int) error {
+	if cur := atomic.LoadInt64(&srv.lastSeq); cur > 0 {
 		u.RawQuery = fmt.Sprintf("cursor=%d", cur)
 	}
-	srv.logger.Info("subscribing to repo event stream", "upstream", host, "cursor", cur)
-	con, _, err := dialer.Dial(u.String(), http.Header{
+	srv.logger.Info("subscribing to repo event stream", "upstream", host, "cursor", u.RawQuery)
+	dialer := websocket.DefaultDialer
+	con, _, err := dialer.DialContext(ctx, u.String(), http.Header{
 		"User-Agent": []string{fmt.Sprintf("bluepages/%s", versioninfo.Short())},
 	})
 	if err != nil {
@@ -47,37 +79,62 @@ func (srv *Server) RunFirehoseConsumer(ctx context.Context, host string, paralle
 	rsc := &events.RepoStreamCallbacks{
 		RepoIdentity: func(evt *comatproto.SyncSubscribeRepos_Identity) error {
 			atomic.StoreInt64(&srv.lastSeq, evt.Seq)
+			firehoseEvents.WithLabelValues("identity").Inc()
 			ctx := context.Background()
-			srv.logger.Info("flushing cache due to #identity firehose event", "did", evt.Did, "handle", evt.Handle, "seq", evt.Seq, "err", err)
+			srv.logger.Info("refreshing cache due to #identity firehose event", "did", evt.Did, "handle", evt.Handle, "seq", evt.Seq)
 
 			did, err := syntax.ParseDID(evt.Did)
 			if err != nil {
 				srv.logger.Warn("invalid DID in #identity event", "did", evt.Did, "seq", evt.Seq, "err", err)
 				return nil
 			}
-			if err := srv.dir.PurgeDID(ctx, did); err != nil {
-				srv.logger.Error("failed to purge DID from cache", "did", evt.Did, "seq", evt.Seq, "err", err)
-				return nil
+			var handle *syntax.Handle
+			if evt.Handle != nil {
+				h, err := syntax.ParseHandle(*evt.Handle)
+				if err != nil {
+					srv.logger.Warn("invalid handle in #identity event", "did", evt.Did, "handle", evt.Handle, "seq", evt.Seq, "err", err)
+				} else {
+					handle = &h
+				}
 			}
-			if evt.Handle == nil {
-				return nil
+			if err := srv.dir.RefreshIdentity(ctx, did, handle); err != nil {
+				srv.logger.Error("failed to refresh identity in cache", "did", evt.Did, "handle", evt.Handle, "seq", evt.Seq, "err", err)
 			}
-			handle, err := syntax.ParseHandle(*evt.Handle)
+			return nil
+		},
+		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
+			atomic.StoreInt64(&srv.lastSeq, evt.Seq)
+			firehoseEvents.WithLabelValues("account").Inc()
+			ctx := context.Background()
+
+			did, err := syntax.ParseDID(evt.Did)
 			if err != nil {
-				srv.logger.Warn("invalid handle in #identity event", "did", evt.Did, "handle", evt.Handle, "seq", evt.Seq, "err", err)
+				srv.logger.Warn("invalid DID in #account event", "did", evt.Did, "seq", evt.Seq, "err", err)
 				return nil
 			}
-			if err := srv.dir.PurgeHandle(ctx, handle); err != nil {
-				srv.logger.Error("failed to purge handle from cache", "did", evt.Did, "handle", evt.Handle, "seq", evt.Seq, "err", err)
+			status := ""
+			if evt.Status != nil {
+				status = *evt.Status
+			}
+			if !evt.Active && status == "" {
+				status = "deactivated"
+			}
+			srv.logger.Debug("updating account status due to #account firehose event", "did", evt.Did, "active", evt.Active, "status", status, "seq", evt.Seq)
+			if err := srv.dir.SetAccountStatus(ctx, did, evt.Active, status); err != nil {
+				srv.logger.Error("failed to update account status in cache", "did", evt.Did, "seq", evt.Seq, "err", err)
 				return nil
 			}
+			// a deleted account may have had its DID tombstoned; re-resolve
+			if status == "deleted" {
+				if err := srv.dir.RefreshIdentity(ctx, did, nil); err != nil {
+					srv.logger.Error("failed to refresh identity in cache", "did", evt.Did, "seq", evt.Seq, "err", err)
+				}
+			}
 			return nil
 		},
 	}
 
-	var scheduler events.Scheduler
-	// use a fixed-parallelism scheduler if configured
-	scheduler = parallel.NewScheduler(
+	scheduler := parallel.NewScheduler(
 		parallelism,
 		1000,
 		host,
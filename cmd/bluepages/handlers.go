@@ -121,11 +121,7 @@ func (srv *Server) resolveIdentityFromHandle(c echo.Context, handle syntax.Handl
 		})
 	}
 
-	return c.JSON(200, comatproto.IdentityDefs_IdentityInfo{
-		Did:    ident.DID.String(),
-		Handle: handle.String(),
-		DidDoc: rawDoc,
-	})
+	return srv.identityInfoResponse(c, ident.DID, handle, rawDoc)
 }
 
 // helper for resolveIdentity
@@ -165,11 +161,37 @@ func (srv *Server) resolveIdentityFromDID(c echo.Context, did syntax.DID) error
 		handle = syntax.HandleInvalid
 	}
 
-	return c.JSON(200, comatproto.IdentityDefs_IdentityInfo{
-		Did:    ident.DID.String(),
-		Handle: handle.String(),
-		DidDoc: rawDoc,
-	})
+	return srv.identityInfoResponse(c, ident.DID, handle, rawDoc)
+}
+
+// Output of resolveIdentity (and refreshIdentity). Extends the Lexicon `identityInfo` object with account hosting status, when known to be inactive (from firehose #account events).
+type IdentityInfoWithStatus struct {
+	comatproto.IdentityDefs_IdentityInfo
+	// only included if the account is known to be inactive
+	Active *bool `json:"active,omitempty"`
+	// eg, "takendown", "suspended", "deactivated", "deleted"
+	Status *string `json:"status,omitempty"`
+}
+
+// helper for resolveIdentity
+func (srv *Server) identityInfoResponse(c echo.Context, did syntax.DID, handle syntax.Handle, rawDoc json.RawMessage) error {
+	out := IdentityInfoWithStatus{
+		IdentityDefs_IdentityInfo: comatproto.IdentityDefs_IdentityInfo{
+			Did:    did.String(),
+			Handle: handle.String(),
+			DidDoc: rawDoc,
+		},
+	}
+	status, err := srv.dir.GetAccountStatus(c.Request().Context(), did)
+	if err != nil {
+		// status is best-effort; don't fail the request
+		srv.logger.Warn("failed to read account status", "did", did, "err", err)
+	} else if status != nil {
+		active := false
+		out.Active = &active
+		out.Status = &status.Status
+	}
+	return c.JSON(200, out)
 }
 
 // GET /xrpc/com.atproto.identity.resolveIdentity
@@ -26,3 +26,8 @@ var didResolutionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Help:    "Time to resolve a DID",
 	Buckets: prometheus.ExponentialBucketsRange(0.001, 2, 15),
 }, []string{"directory", "status"})
+
+var firehoseEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bluepages_firehose_events",
+	Help: "Firehose events processed, by type",
+}, []string{"type"})
@@ -317,3 +317,88 @@ func (d *RedisResolver) PurgeDID(ctx context.Context, did syntax.DID) error {
 	}
 	return err
 }
+
+// Account hosting status, as reported by #account events on the firehose. Only inactive accounts are stored.
+type accountStatusEntry struct {
+	Updated time.Time
+	Status  string
+}
+
+// How long to remember inactive account status. This is long because updates come only from the firehose; status is cleared by a later #account event with active=true.
+var accountStatusTTL = 30 * 24 * time.Hour
+
+// Records the hosting status of an account. If the account is active, any stored status is cleared.
+func (d *RedisResolver) SetAccountStatus(ctx context.Context, did syntax.DID, active bool, status string) error {
+	key := "bluepages/status/" + did.String()
+	if active {
+		err := d.didCache.Delete(ctx, key)
+		if err == cache.ErrCacheMiss {
+			return nil
+		}
+		return err
+	}
+	return d.didCache.Set(&cache.Item{
+		Ctx: ctx,
+		Key: key,
+		Value: accountStatusEntry{
+			Updated: time.Now(),
+			Status:  status,
+		},
+		TTL: accountStatusTTL,
+	})
+}
+
+// Returns the status of an inactive account (eg, "takendown", "deactivated"), or nil if the account is active or the status is unknown.
+func (d *RedisResolver) GetAccountStatus(ctx context.Context, did syntax.DID) (*accountStatusEntry, error) {
+	var entry accountStatusEntry
+	err := d.didCache.Get(ctx, "bluepages/status/"+did.String(), &entry)
+	if err == cache.ErrCacheMiss {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("account status cache read failed: %w", err)
+	}
+	return &entry, nil
+}
+
+// Returns the handle declared in the cached DID document (if any), without resolving. Used to purge the old handle when an identity changes.
+func (d *RedisResolver) cachedDeclaredHandle(ctx context.Context, did syntax.DID) (syntax.Handle, bool) {
+	var entry didEntry
+	err := d.didCache.Get(ctx, "bluepages/did/"+did.String(), &entry)
+	if err != nil || entry.Err != nil || entry.RawDoc == nil {
+		return "", false
+	}
+	var doc identity.DIDDocument
+	if err := json.Unmarshal(entry.RawDoc, &doc); err != nil {
+		return "", false
+	}
+	ident := identity.ParseIdentity(&doc)
+	handle, err := ident.DeclaredHandle()
+	if err != nil {
+		return "", false
+	}
+	return handle, true
+}
+
+// Purges cached data for an account following an identity change: the DID document, the previously declared handle, and (optionally) a new handle. Then re-resolves the DID and new handle, so the cache is warm with fresh data.
+func (d *RedisResolver) RefreshIdentity(ctx context.Context, did syntax.DID, newHandle *syntax.Handle) error {
+	if oldHandle, ok := d.cachedDeclaredHandle(ctx, did); ok {
+		if err := d.PurgeHandle(ctx, oldHandle); err != nil {
+			return err
+		}
+	}
+	if err := d.PurgeDID(ctx, did); err != nil {
+		return err
+	}
+	if newHandle != nil {
+		if err := d.PurgeHandle(ctx, *newHandle); err != nil {
+			return err
+		}
+	}
+
+	// errors here are cached, and don't need to be returned
+	d.ResolveDIDRaw(ctx, did)
+	if newHandle != nil {
+		d.ResolveHandle(ctx, *newHandle)
+	}
+	return nil
+}
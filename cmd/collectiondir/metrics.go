@@ -30,6 +30,26 @@ var pebbleNew = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "collectiondir_pebble_new_total",
 })
 
+var pebbleRemoved = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "collectiondir_pebble_removed_total",
+	Help: "number of (did, collection) pairs removed",
+})
+
+var firehoseAccountEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "collectiondir_firehose_account_events",
+	Help: "number of #account events received from upstream firehose, by status",
+}, []string{"status"})
+
+var verifyQueued = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "collectiondir_verify_queued_total",
+	Help: "number of repo re-verification requests, by outcome (queued, dup, deferred, dropped)",
+}, []string{"outcome"})
+
+var verifyResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "collectiondir_verify_results_total",
+	Help: "number of repo re-verifications via describeRepo, by result",
+}, []string{"result"})
+
 var pdsCrawledCounter = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "collectiondir_pds_crawled_total",
 })
@@ -61,9 +61,9 @@ func parseByDidKey(key []byte) (did string, collectionId uint32) {
 	if key[0] != 'D' {
 		panic(fmt.Sprintf("by did key wanted D got %v", key[0]))
 	}
-	last4 := len(key) - 5
+	last4 := len(key) - 4
 	collectionId = binary.BigEndian.Uint32(key[last4:])
-	did = string(key[1 : last4+1])
+	did = string(key[1:last4])
 	return did, collectionId
 }
 
@@ -358,6 +358,120 @@ func (pcd *PebbleCollectionDirectory) MaybeSetCollection(did, collection string)
 	return nil
 }
 
+// didPrefixBounds returns iterator bounds covering D{did}{uint32 collectionId} rows for exactly this did
+func didPrefixBounds(did string) (lower, upper []byte) {
+	lower = make([]byte, 1+len(did))
+	lower[0] = 'D'
+	copy(lower[1:], did)
+	upper = makeByDidKey(did, 0xffffffff)
+	upper = append(upper, 0)
+	return lower, upper
+}
+
+// GetDidCollections returns the collections currently recorded for a did
+func (pcd *PebbleCollectionDirectory) GetDidCollections(ctx context.Context, did string) ([]string, error) {
+	lower, upper := didPrefixBounds(did)
+	iter, err := pcd.db.NewIterWithContext(ctx, &pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: upper,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("did iter start, %w", err)
+	}
+	defer iter.Close()
+	var out []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) != 1+len(did)+4 {
+			// a longer did which shares this did as a prefix
+			continue
+		}
+		_, collectionId := parseByDidKey(key)
+		pcd.collectionsLock.Lock()
+		collection, ok := pcd.collectionNames[collectionId]
+		pcd.collectionsLock.Unlock()
+		if !ok {
+			pcd.log.Warn("unknown collection id", "did", did, "id", collectionId)
+			continue
+		}
+		out = append(out, collection)
+	}
+	return out, iter.Error()
+}
+
+// deleteDidCollection adds deletion of both rows for a (did, collectionId) pair to a batch, if present. Returns true if the pair was present.
+func (pcd *PebbleCollectionDirectory) deleteDidCollection(batch *pebble.Batch, did string, collectionId uint32) (bool, error) {
+	dkey := makeByDidKey(did, collectionId)
+	value, closer, err := pcd.db.Get(dkey)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("pebble get err, %w", err)
+	}
+	seenMs := int64(binary.BigEndian.Uint64(value))
+	closer.Close()
+	if err := batch.Delete(makePrimaryPebbleRow(collectionId, did, seenMs), nil); err != nil {
+		return false, err
+	}
+	if err := batch.Delete(dkey, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveCollection removes a (did, collection) pair. Returns false if it was not present.
+func (pcd *PebbleCollectionDirectory) RemoveCollection(did, collection string) (bool, error) {
+	collectionId, err := pcd.CollectionToId(collection, false)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	batch := pcd.db.NewBatch()
+	defer batch.Close()
+	found, err := pcd.deleteDidCollection(batch, did, collectionId)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		return false, fmt.Errorf("pebble commit err, %w", err)
+	}
+	pebbleRemoved.Inc()
+	return true, nil
+}
+
+// RemoveDid removes all collections for a did. Returns the number of collections removed.
+func (pcd *PebbleCollectionDirectory) RemoveDid(ctx context.Context, did string) (int, error) {
+	collections, err := pcd.GetDidCollections(ctx, did)
+	if err != nil {
+		return 0, err
+	}
+	batch := pcd.db.NewBatch()
+	defer batch.Close()
+	count := 0
+	for _, collection := range collections {
+		collectionId, err := pcd.CollectionToId(collection, false)
+		if err != nil {
+			return 0, err
+		}
+		found, err := pcd.deleteDidCollection(batch, did, collectionId)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		return 0, fmt.Errorf("pebble commit err, %w", err)
+	}
+	pebbleRemoved.Add(float64(count))
+	return count, nil
+}
+
 func (pcd *PebbleCollectionDirectory) SetFromResults(results <-chan DidCollection) {
 	errcount := 0
 	for result := range results {
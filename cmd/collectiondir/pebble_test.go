@@ -6,9 +6,13 @@ import (
 	"log/slog"
 	"strings"
 	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/vfs"
+	"github.com/hashicorp/golang-lru/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -105,3 +109,126 @@ func TestPebbleCollectionDirectory(t *testing.T) {
 	}
 	assert.Equal(2, len(wat))
 }
+
+func TestPebbleCollectionDirectoryRemove(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	pcd := newMem(t)
+	defer pcd.Close()
+
+	rows, err := csv.NewReader(strings.NewReader(testDataCsv)).ReadAll()
+	assert.NoError(err)
+	for _, row := range rows {
+		assert.NoError(pcd.MaybeSetCollection(row[0], row[1]))
+	}
+	// did which has another did as a prefix
+	assert.NoError(pcd.MaybeSetCollection("eve2", "post"))
+
+	collections, err := pcd.GetDidCollections(ctx, "eve")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"post", "like", "other"}, collections)
+
+	found, err := pcd.RemoveCollection("bob", "other")
+	assert.NoError(err)
+	assert.True(found)
+	found, err = pcd.RemoveCollection("bob", "other")
+	assert.NoError(err)
+	assert.False(found)
+	found, err = pcd.RemoveCollection("bob", "unknown")
+	assert.NoError(err)
+	assert.False(found)
+
+	count, err := pcd.RemoveDid(ctx, "eve")
+	assert.NoError(err)
+	assert.Equal(3, count)
+
+	stats, err := pcd.GetCollectionStats()
+	assert.NoError(err)
+	assert.Equal(uint64(4), stats.CollectionCounts["post"])
+	assert.Equal(uint64(1), stats.CollectionCounts["like"])
+	assert.Equal(uint64(0), stats.CollectionCounts["other"])
+
+	wat, _, err := pcd.ReadCollection(ctx, "post", "", 1000)
+	assert.NoError(err)
+	dids := []string{}
+	for _, row := range wat {
+		dids = append(dids, row.Did)
+	}
+	assert.ElementsMatch([]string{"alice", "bob", "carol", "eve2"}, dids)
+
+	// verifier reconciles to the described set of collections
+	changed := []string{}
+	rv := NewRepoVerifier(nil, pcd, 1, 10, pcd.log)
+	rv.OnChange = func(did string) { changed = append(changed, did) }
+	assert.NoError(rv.ApplyCollections(ctx, "alice", []string{"post", "other"}))
+	assert.NoError(rv.ApplyCollections(ctx, "carol", []string{"post"}))
+	collections, err = pcd.GetDidCollections(ctx, "alice")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"post", "other"}, collections)
+	assert.Equal([]string{"alice"}, changed)
+}
+
+func TestRepoVerifierQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	rv := NewRepoVerifier(nil, nil, 1, 10, slog.Default())
+	rv.Delay = 0
+	rv.MinInterval = 100 * time.Millisecond
+
+	// only pending once
+	rv.Queue("alice")
+	rv.Queue("alice")
+	assert.Equal(1, len(rv.queue))
+	<-rv.queue
+	rv.pendingLock.Lock()
+	delete(rv.pending, "alice")
+	rv.verified.Add("alice", time.Now())
+	rv.pendingLock.Unlock()
+
+	// recently verified; deferred until the interval has passed
+	rv.Queue("alice")
+	rv.Queue("alice")
+	assert.Equal(0, len(rv.queue))
+	assert.Eventually(func() bool { return len(rv.queue) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestHandleAccount(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	pcd := newMem(t)
+	defer pcd.Close()
+	assert.NoError(pcd.MaybeSetCollection("did:plc:alice", "post"))
+
+	counts, err := lru.New[string, int](10)
+	assert.NoError(err)
+	cs := &collectionServer{
+		ctx:                 ctx,
+		pcd:                 pcd,
+		log:                 pcd.log,
+		didCollectionCounts: counts,
+	}
+	status := "deactivated"
+	deactivated := &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:alice", Active: false, Status: &status}
+
+	// pruning disabled without a verifier
+	cs.handleAccount(deactivated)
+	collections, err := pcd.GetDidCollections(ctx, "did:plc:alice")
+	assert.NoError(err)
+	assert.Equal([]string{"post"}, collections)
+
+	cs.verifier = NewRepoVerifier(nil, pcd, 1, 10, pcd.log)
+	cs.handleAccount(deactivated)
+	collections, err = pcd.GetDidCollections(ctx, "did:plc:alice")
+	assert.NoError(err)
+	assert.Empty(collections)
+
+	// reactivation queues the account for re-verification, which adds it back
+	cs.handleAccount(&comatproto.SyncSubscribeRepos_Account{Did: "did:plc:alice", Active: true})
+	assert.Equal(1, len(cs.verifier.queue))
+	assert.NoError(cs.verifier.ApplyCollections(ctx, <-cs.verifier.queue, []string{"post"}))
+	collections, err = pcd.GetDidCollections(ctx, "did:plc:alice")
+	assert.NoError(err)
+	assert.Equal([]string{"post"}, collections)
+}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/util/svcutil"
@@ -91,6 +92,12 @@ var serveCmd = &cli.Command{
 			Value:   1000,
 			EnvVars: []string{"COLLECTIONS_MAX_DID_COLLECTIONS"},
 		},
+		&cli.Float64Flag{
+			Name:    "verify-qps",
+			Usage:   "queries-per-second limit (total) for re-verifying repos via describeRepo after deletes and reactivations; 0 to disable all pruning",
+			Value:   20,
+			EnvVars: []string{"COLLECTIONS_VERIFY_QPS"},
+		},
 		&cli.StringFlag{
 			Name:    "sets-json-path",
 			Usage:   "file path of JSON file containing static word sets",
@@ -157,6 +164,9 @@ type collectionServer struct {
 
 	didCollectionCounts *lru.Cache[string, int]
 
+	// re-checks repos after deletes; nil if disabled
+	verifier *RepoVerifier
+
 	badwords BadwordChecker
 }
 
@@ -218,6 +228,16 @@ func (cs *collectionServer) run(cctx *cli.Context) error {
 	}
 	cs.statsCacheFresh.L = &cs.statsCacheLock
 
+	if qps := cctx.Float64("verify-qps"); qps > 0 {
+		cs.verifier = NewRepoVerifier(identity.DefaultDirectory(), cs.pcd, qps, 100_000, log.With("system", "verify"))
+		cs.verifier.RatelimitHeader = cs.ratelimitHeader
+		cs.verifier.OnChange = func(did string) {
+			cs.didCollectionCounts.Remove(did)
+		}
+		cs.wg.Add(1)
+		go cs.verifyThread()
+	}
+
 	apiServerEcho, err := cs.createApiServer(cctx.Context, cctx.String("api-listen"))
 	if err != nil {
 		return err
@@ -326,6 +346,17 @@ func (cs *collectionServer) firehoseThread(fh *Firehose, fhevents chan<- *events
 	}
 }
 
+func (cs *collectionServer) verifyThread() {
+	defer cs.wg.Done()
+	defer cs.log.Info("verifyThread exit")
+	ctx, cancel := context.WithCancel(cs.ctx)
+	go func() {
+		<-cs.shutdown
+		cancel()
+	}()
+	cs.verifier.Run(ctx)
+}
+
 // handleFirehose consumes XRPCStreamEvent from firehoseThread(), further parses data and applies
 func (cs *collectionServer) handleFirehose(fhevents <-chan *events.XRPCStreamEvent) {
 	defer cs.wg.Done()
@@ -355,6 +386,9 @@ func (cs *collectionServer) handleFirehose(fhevents <-chan *events.XRPCStreamEve
 				firehoseCommits.Inc()
 				cs.handleCommit(evt.RepoCommit)
 			}
+			if evt.RepoAccount != nil {
+				cs.handleAccount(evt.RepoAccount)
+			}
 		}
 	}
 	if lastSeqSet {
@@ -377,6 +411,39 @@ func (cs *collectionServer) handleCommit(commit *comatproto.SyncSubscribeRepos_C
 				Did:        commit.Repo,
 				Collection: nsid.String(),
 			}
+		} else if op.Action == "delete" && cs.verifier != nil {
+			// the firehose doesn't say whether this was the last record in the collection, so check with the PDS
+			cs.verifier.Queue(commit.Repo)
+		}
+	}
+}
+
+func (cs *collectionServer) handleAccount(acct *comatproto.SyncSubscribeRepos_Account) {
+	status := "active"
+	if acct.Status != nil {
+		status = *acct.Status
+	}
+	firehoseAccountEvents.WithLabelValues(status).Inc()
+	if cs.verifier == nil {
+		// pruning is disabled; without re-verification, reactivated accounts could not be added back
+		return
+	}
+	if acct.Active {
+		// account may be returning from deactivation or takedown; pick its collections back up
+		cs.verifier.Queue(acct.Did)
+		return
+	}
+	switch status {
+	case "deleted", "takendown", "deactivated", "suspended":
+		// account data is not available; drop it from the directory
+		count, err := cs.pcd.RemoveDid(cs.ctx, acct.Did)
+		if err != nil {
+			cs.log.Warn("pcd remove did", "did", acct.Did, "err", err)
+			return
+		}
+		cs.didCollectionCounts.Remove(acct.Did)
+		if count > 0 {
+			cs.log.Info("pruned account", "did", acct.Did, "status", status, "collections", count)
 		}
 	}
 }
@@ -431,6 +498,7 @@ func (cs *collectionServer) createApiServer(ctx context.Context, addr string) (*
 	// admin auth heador required
 	e.POST("/admin/pds/requestCrawl", cs.crawlPds) // same as relay
 	e.GET("/admin/crawlStatus", cs.crawlStatus)
+	e.POST("/admin/repo/verify", cs.verifyRepo)
 
 	e.Listener = li
 	srv := &http.Server{
@@ -964,6 +1032,27 @@ func (cs *collectionServer) clearActiveCrawl(host string) {
 	delete(cs.activeCrawls, host)
 }
 
+// POST /admin/repo/verify?did={}
+// re-checks a repo's collections against its PDS now (synchronously), pruning any which are gone
+// requires header `Authorization: Bearer {admin token}`
+func (cs *collectionServer) verifyRepo(c echo.Context) error {
+	if !cs.isAdmin(c) {
+		return c.JSON(http.StatusForbidden, xrpc.XRPCError{ErrStr: "AdminRequired", Message: "this endpoint requires admin auth"})
+	}
+	if cs.verifier == nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "repo verification is disabled"})
+	}
+	did, err := syntax.ParseDID(c.QueryParam("did"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: fmt.Sprintf("bad did, %s", err.Error())})
+	}
+	err = cs.verifier.VerifyRepo(c.Request().Context(), did.String())
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, xrpc.XRPCError{ErrStr: "UpstreamFailure", Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, CrawlRequestResponse{Message: "ok"})
+}
+
 type CrawlStatusResponse struct {
 	HostCrawls map[string]HostCrawl `json:"host_starts"`
 	ServerTime string               `json:"server_time"`
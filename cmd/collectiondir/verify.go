@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// how long to wait after a delete before re-verifying a repo, so that bursts of deletes (e.g. un-likes) coalesce into one describeRepo call
+const verifyDelay = 30 * time.Second
+
+// minimum time between describeRepo calls for the same repo; requests within this window are deferred until it has passed
+const verifyMinInterval = 5 * time.Minute
+
+// RepoVerifier re-checks the collections of repos against their PDS (via describeRepo) and prunes collections which are no longer present.
+//
+// The firehose only tells us a record was deleted, not whether it was the last record in its collection, so deletes queue the repo for re-verification.
+type RepoVerifier struct {
+	Dir   identity.Directory
+	Pcd   *PebbleCollectionDirectory
+	QPS   float64
+	Log   *slog.Logger
+	Delay time.Duration
+	// minimum time between verifications of the same did
+	MinInterval time.Duration
+
+	// optional, for a collectionServer to invalidate its per-did collection count cache
+	OnChange func(did string)
+	// optional, secret header for friend PDSes
+	RatelimitHeader string
+
+	// did -> time first queued; dids are only queued once while pending
+	pending     map[string]time.Time
+	pendingLock sync.Mutex
+	queue       chan string
+	// did -> time of last verification
+	verified *lru.Cache[string, time.Time]
+	// dids waiting for MinInterval to pass before being queued
+	deferred map[string]bool
+
+	client *http.Client
+}
+
+func NewRepoVerifier(dir identity.Directory, pcd *PebbleCollectionDirectory, qps float64, queueSize int, log *slog.Logger) *RepoVerifier {
+	// only needs to cover dids verified within MinInterval
+	verified, _ := lru.New[string, time.Time](queueSize)
+	return &RepoVerifier{
+		Dir:         dir,
+		Pcd:         pcd,
+		QPS:         qps,
+		Log:         log,
+		Delay:       verifyDelay,
+		MinInterval: verifyMinInterval,
+		pending:     make(map[string]time.Time),
+		queue:       make(chan string, queueSize),
+		verified:    verified,
+		deferred:    make(map[string]bool),
+		client:      util.RobustHTTPClient(),
+	}
+}
+
+// Queue schedules a did for re-verification. Does not block; if the queue is full the request is dropped.
+//
+// A did is only pending once at a time, and is not verified more than once per MinInterval: requests soon after a verification are deferred until the interval has passed.
+func (rv *RepoVerifier) Queue(did string) {
+	rv.pendingLock.Lock()
+	defer rv.pendingLock.Unlock()
+	if _, ok := rv.pending[did]; ok || rv.deferred[did] {
+		verifyQueued.WithLabelValues("dup").Inc()
+		return
+	}
+	if last, ok := rv.verified.Get(did); ok {
+		// Run will wait Delay after queueing, so only defer for the remainder
+		if wait := time.Until(last.Add(rv.MinInterval)) - rv.Delay; wait > 0 {
+			rv.deferred[did] = true
+			time.AfterFunc(wait, func() {
+				rv.pendingLock.Lock()
+				delete(rv.deferred, did)
+				rv.pendingLock.Unlock()
+				rv.Queue(did)
+			})
+			verifyQueued.WithLabelValues("deferred").Inc()
+			return
+		}
+	}
+	select {
+	case rv.queue <- did:
+		rv.pending[did] = time.Now()
+		verifyQueued.WithLabelValues("queued").Inc()
+	default:
+		verifyQueued.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run processes the verification queue until the context is done.
+func (rv *RepoVerifier) Run(ctx context.Context) {
+	limiter := rate.NewLimiter(rate.Limit(rv.QPS), 1)
+	for {
+		var did string
+		select {
+		case <-ctx.Done():
+			return
+		case did = <-rv.queue:
+		}
+
+		rv.pendingLock.Lock()
+		queuedAt := rv.pending[did]
+		rv.pendingLock.Unlock()
+		if wait := rv.Delay - time.Since(queuedAt); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		// clear pending before the describeRepo call, so deletes which happen during verification get another pass (after MinInterval)
+		rv.pendingLock.Lock()
+		delete(rv.pending, did)
+		rv.verified.Add(did, time.Now())
+		rv.pendingLock.Unlock()
+
+		if err := limiter.Wait(ctx); err != nil {
+			return
+		}
+		if err := rv.VerifyRepo(ctx, did); err != nil {
+			rv.Log.Warn("repo verify", "did", did, "err", err)
+		}
+	}
+}
+
+// VerifyRepo fetches the current collections of a repo from its PDS and updates the directory to match. Accounts which are gone from their PDS are removed entirely.
+func (rv *RepoVerifier) VerifyRepo(ctx context.Context, did string) error {
+	atid, err := syntax.ParseAtIdentifier(did)
+	if err != nil {
+		verifyResults.WithLabelValues("bad_did").Inc()
+		return err
+	}
+	ident, err := rv.Dir.Lookup(ctx, *atid)
+	if errors.Is(err, identity.ErrDIDNotFound) {
+		verifyResults.WithLabelValues("gone").Inc()
+		return rv.removeDid(ctx, did)
+	} else if err != nil {
+		verifyResults.WithLabelValues("error").Inc()
+		return fmt.Errorf("resolving did: %w", err)
+	}
+	pdsHost := ident.PDSEndpoint()
+	if pdsHost == "" {
+		verifyResults.WithLabelValues("gone").Inc()
+		return rv.removeDid(ctx, did)
+	}
+
+	rpcClient := xrpc.Client{
+		Host:   pdsHost,
+		Client: rv.client,
+	}
+	if rv.RatelimitHeader != "" {
+		rpcClient.Headers = map[string]string{
+			"x-ratelimit-bypass": rv.RatelimitHeader,
+		}
+	}
+	desc, err := atproto.RepoDescribeRepo(ctx, &rpcClient, did)
+	if err != nil {
+		if isRepoGoneError(err) {
+			verifyResults.WithLabelValues("gone").Inc()
+			return rv.removeDid(ctx, did)
+		}
+		verifyResults.WithLabelValues("error").Inc()
+		return fmt.Errorf("%s: describe repo: %w", pdsHost, err)
+	}
+	verifyResults.WithLabelValues("ok").Inc()
+	return rv.ApplyCollections(ctx, did, desc.Collections)
+}
+
+// isRepoGoneError returns true if a describeRepo error indicates the repo is not (currently) available from the PDS
+func isRepoGoneError(err error) bool {
+	var xerr *xrpc.Error
+	if errors.As(err, &xerr) {
+		var inner *xrpc.XRPCError
+		if errors.As(xerr.Wrapped, &inner) {
+			switch inner.ErrStr {
+			case "RepoNotFound", "RepoDeactivated", "RepoTakendown", "RepoSuspended":
+				return true
+			}
+		}
+		return xerr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// ApplyCollections sets the collections for a did to exactly the given list, removing any others.
+//
+// NOTE: a record created in a new collection between the describeRepo call and this update will be missed until it is next seen on the firehose. That window is small, and the pair would otherwise be stale in the other direction.
+func (rv *RepoVerifier) ApplyCollections(ctx context.Context, did string, collections []string) error {
+	current, err := rv.Pcd.GetDidCollections(ctx, did)
+	if err != nil {
+		return err
+	}
+	want := make(map[string]bool, len(collections))
+	for _, collection := range collections {
+		want[collection] = true
+	}
+	changed := false
+	for _, collection := range current {
+		if want[collection] {
+			delete(want, collection)
+			continue
+		}
+		if _, err := rv.Pcd.RemoveCollection(did, collection); err != nil {
+			return err
+		}
+		rv.Log.Debug("pruned collection", "did", did, "collection", collection)
+		changed = true
+	}
+	for collection := range want {
+		if err := rv.Pcd.MaybeSetCollection(did, collection); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if changed && rv.OnChange != nil {
+		rv.OnChange(did)
+	}
+	return nil
+}
+
+func (rv *RepoVerifier) removeDid(ctx context.Context, did string) error {
+	count, err := rv.Pcd.RemoveDid(ctx, did)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		rv.Log.Info("pruned account", "did", did, "collections", count)
+		if rv.OnChange != nil {
+			rv.OnChange(did)
+		}
+	}
+	return nil
+}
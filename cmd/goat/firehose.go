@@ -77,6 +77,9 @@ var cmdFirehose = &cli.Command{
 		},
 	},
 	Action: runFirehose,
+	Subcommands: []*cli.Command{
+		cmdFirehoseConformance,
+	},
 }
 
 type GoatFirehoseConsumer struct {
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events/conformance"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdFirehoseConformance = &cli.Command{
+	Name:  "conformance",
+	Usage: "write records to the logged-in account and check the resulting firehose events",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "stream-host",
+			Usage: "method, hostname, and port of PDS or Relay to subscribe to (defaults to account's PDS)",
+		},
+		&cli.StringFlag{
+			Name:  "collection",
+			Usage: "record collection (NSID) to write test records to",
+			Value: conformance.DefaultCollection.String(),
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "how long to wait for each event",
+			Value: conformance.DefaultTimeout,
+		},
+		&cli.BoolFlag{
+			Name:  "account-status",
+			Usage: "also deactivate and re-activate the account, and check #account events",
+		},
+		&cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "print passing checks, not just failures",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print full report as JSON",
+		},
+	},
+	Action: runFirehoseConformance,
+}
+
+func runFirehoseConformance(cctx *cli.Context) error {
+	ctx := context.Background()
+
+	slog.SetDefault(configLogger(cctx, os.Stderr))
+
+	client, err := loadAuthClient(ctx)
+	if err == ErrNoAuthSession {
+		return fmt.Errorf("auth required, but not logged in")
+	} else if err != nil {
+		return err
+	}
+
+	collection, err := syntax.ParseNSID(cctx.String("collection"))
+	if err != nil {
+		return err
+	}
+
+	streamHost := cctx.String("stream-host")
+	if streamHost == "" {
+		streamHost = client.Host
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", *userAgent())
+
+	dir := identity.DefaultDirectory()
+	report, err := conformance.Run(ctx, conformance.Config{
+		StreamHost:    streamHost,
+		Client:        client,
+		DID:           syntax.DID(client.Auth.Did),
+		Dir:           dir,
+		Collection:    collection,
+		Timeout:       cctx.Duration("timeout"),
+		AccountStatus: cctx.Bool("account-status"),
+		Header:        header,
+	})
+	if err != nil {
+		return err
+	}
+
+	if cctx.Bool("json") {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, res := range report.Results {
+			if res.Pass && !res.Skipped && !cctx.Bool("verbose") {
+				continue
+			}
+			fmt.Println(res)
+		}
+	}
+
+	failures := len(report.Failures())
+	if failures > 0 {
+		return fmt.Errorf("%d of %d conformance checks failed", failures, len(report.Results))
+	}
+	fmt.Fprintf(os.Stderr, "all %d conformance checks passed\n", len(report.Results))
+	return nil
+}
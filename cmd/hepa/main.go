@@ -104,6 +104,11 @@ func run(args []string) error {
 			Usage:   "file path of JSON file containing static sets",
 			EnvVars: []string{"HEPA_SETS_JSON_PATH"},
 		},
+		&cli.StringFlag{
+			Name:    "image-hashes-json-path",
+			Usage:   "file path of JSON file containing lists of perceptual image hashes, for local image matching",
+			EnvVars: []string{"HEPA_IMAGE_HASHES_JSON_PATH"},
+		},
 		&cli.StringFlag{
 			Name:    "hiveai-api-token",
 			Usage:   "API token for Hive AI image auto-labeling",
@@ -264,6 +269,28 @@ var runCmd = &cli.Command{
 			Usage:   "full URL of slack webhook",
 			EnvVars: []string{"SLACK_WEBHOOK_URL"},
 		},
+		&cli.StringFlag{
+			Name:    "labeler-did",
+			Usage:   "DID of labeler service; if set, labels are signed and served directly (subscribeLabels, queryLabels), in addition to any ozone persistence",
+			EnvVars: []string{"HEPA_LABELER_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-signing-key",
+			Usage:   "private key for signing labels (multibase encoded); must match the labeler DID document",
+			EnvVars: []string{"HEPA_LABELER_SIGNING_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-db-path",
+			Usage:   "path to local pebble database for persisting signed labels",
+			Value:   "./hepa-labels.db",
+			EnvVars: []string{"HEPA_LABELER_DB_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-listen",
+			Usage:   "IP or address, and port, to listen on for labeler XRPC endpoints",
+			Value:   ":3990",
+			EnvVars: []string{"HEPA_LABELER_LISTEN"},
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		ctx := context.Background()
@@ -286,6 +313,7 @@ var runCmd = &cli.Command{
 				PDSHost:              cctx.String("atp-pds-host"),
 				PDSAdminToken:        cctx.String("pds-admin-token"),
 				SetsFileJSON:         cctx.String("sets-json-path"),
+				ImageHashesFileJSON:  cctx.String("image-hashes-json-path"),
 				RedisURL:             cctx.String("redis-url"),
 				SlackWebhookURL:      cctx.String("slack-webhook-url"),
 				HiveAPIToken:         cctx.String("hiveai-api-token"),
@@ -302,6 +330,9 @@ var runCmd = &cli.Command{
 				RecordEventTimeout:   cctx.Duration("record-event-timeout"),
 				IdentityEventTimeout: cctx.Duration("identity-event-timeout"),
 				OzoneEventTimeout:    cctx.Duration("ozone-event-timeout"),
+				LabelerDID:           cctx.String("labeler-did"),
+				LabelerSigningKey:    cctx.String("labeler-signing-key"),
+				LabelerDBPath:        cctx.String("labeler-db-path"),
 			},
 		)
 		if err != nil {
@@ -340,6 +371,16 @@ var runCmd = &cli.Command{
 			}
 		}()
 
+		// labeler XRPC endpoints (if configured)
+		if srv.Labeler != nil {
+			go func() {
+				if err := srv.RunLabeler(cctx.String("labeler-listen")); err != nil {
+					slog.Error("failed to start labeler endpoints", "error", err)
+					panic(fmt.Errorf("failed to start labeler endpoints: %w", err))
+				}
+			}()
+		}
+
 		// firehose event consumer (note this is actually mandatory)
 		relayHost := cctx.String("atp-relay-host")
 		if relayHost != "" {
@@ -379,22 +420,23 @@ func configEphemeralServer(cctx *cli.Context) (*Server, error) {
 	return NewServer(
 		dir,
 		Config{
-			Logger:          logger,
-			BskyHost:        cctx.String("atp-bsky-host"),
-			OzoneHost:       cctx.String("atp-ozone-host"),
-			OzoneDID:        cctx.String("ozone-did"),
-			OzoneAdminToken: cctx.String("ozone-admin-token"),
-			PDSHost:         cctx.String("atp-pds-host"),
-			PDSAdminToken:   cctx.String("pds-admin-token"),
-			SetsFileJSON:    cctx.String("sets-json-path"),
-			RedisURL:        cctx.String("redis-url"),
-			HiveAPIToken:    cctx.String("hiveai-api-token"),
-			AbyssHost:       cctx.String("abyss-host"),
-			AbyssPassword:   cctx.String("abyss-password"),
-			RatelimitBypass: cctx.String("ratelimit-bypass"),
-			RulesetName:     cctx.String("ruleset"),
-			PreScreenHost:   cctx.String("prescreen-host"),
-			PreScreenToken:  cctx.String("prescreen-token"),
+			Logger:              logger,
+			BskyHost:            cctx.String("atp-bsky-host"),
+			OzoneHost:           cctx.String("atp-ozone-host"),
+			OzoneDID:            cctx.String("ozone-did"),
+			OzoneAdminToken:     cctx.String("ozone-admin-token"),
+			PDSHost:             cctx.String("atp-pds-host"),
+			PDSAdminToken:       cctx.String("pds-admin-token"),
+			SetsFileJSON:        cctx.String("sets-json-path"),
+			ImageHashesFileJSON: cctx.String("image-hashes-json-path"),
+			RedisURL:            cctx.String("redis-url"),
+			HiveAPIToken:        cctx.String("hiveai-api-token"),
+			AbyssHost:           cctx.String("abyss-host"),
+			AbyssPassword:       cctx.String("abyss-password"),
+			RatelimitBypass:     cctx.String("ratelimit-bypass"),
+			RulesetName:         cctx.String("ruleset"),
+			PreScreenHost:       cctx.String("prescreen-host"),
+			PreScreenToken:      cctx.String("prescreen-token"),
 		},
 	)
 }
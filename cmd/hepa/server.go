@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod"
@@ -15,12 +16,15 @@ import (
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/engine"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/automod/labeler"
 	"github.com/bluesky-social/indigo/automod/rules"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/automod/visual"
 	"github.com/bluesky-social/indigo/util"
 	"github.com/bluesky-social/indigo/xrpc"
 
+	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
@@ -28,6 +32,8 @@ import (
 type Server struct {
 	Engine      *automod.Engine
 	RedisClient *redis.Client
+	// optional; only configured when running as a standalone labeler
+	Labeler *labeler.Labeler
 
 	logger *slog.Logger
 }
@@ -41,6 +47,7 @@ type Config struct {
 	PDSHost              string
 	PDSAdminToken        string
 	SetsFileJSON         string
+	ImageHashesFileJSON  string
 	RedisURL             string
 	SlackWebhookURL      string
 	HiveAPIToken         string
@@ -57,6 +64,9 @@ type Config struct {
 	RecordEventTimeout   time.Duration
 	IdentityEventTimeout time.Duration
 	OzoneEventTimeout    time.Duration
+	LabelerDID           string
+	LabelerSigningKey    string
+	LabelerDBPath        string
 }
 
 func NewServer(dir identity.Directory, config Config) (*Server, error) {
@@ -118,6 +128,7 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 	var counters countstore.CountStore
 	var cache cachestore.CacheStore
 	var flags flagstore.FlagStore
+	var graph graphstore.GraphStore
 	var rdb *redis.Client
 	if config.RedisURL != "" {
 		// generic client, for cursor state
@@ -149,10 +160,17 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 			return nil, fmt.Errorf("initializing redis flagstore: %v", err)
 		}
 		flags = flg
+
+		grph, err := graphstore.NewRedisGraphStore(config.RedisURL, 7*24*time.Hour)
+		if err != nil {
+			return nil, fmt.Errorf("initializing redis graphstore: %v", err)
+		}
+		graph = grph
 	} else {
 		counters = countstore.NewMemCountStore()
 		cache = cachestore.NewMemCacheStore(5_000, 1*time.Hour)
 		flags = flagstore.NewMemFlagStore()
+		// NOTE: graph cache is not enabled without redis; the in-memory store would grow without bound
 	}
 
 	// IMPORTANT: reminder that these are the indigo-edition rules, not production rules
@@ -174,6 +192,27 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		extraBlobRules = append(extraBlobRules, ac.AbyssScanBlobRule)
 	}
 
+	if config.ImageHashesFileJSON != "" {
+		hashes := visual.NewMemHashStore()
+		if err := hashes.LoadFromFileJSON(config.ImageHashesFileJSON); err != nil {
+			return nil, fmt.Errorf("initializing image hash lists: %v", err)
+		}
+		logger.Info("configuring local image hash matching", "path", config.ImageHashesFileJSON)
+		// hashes of taken-down images are only kept in-process (most recent only), and are used to report (not takedown) re-posts
+		hashes.MaxListLength = 50_000
+		hm := visual.NewImageHashMatcher(hashes)
+		hm.TakedownList = "automod-takedowns"
+		hm.Lists = append(hm.Lists, visual.HashListConfig{Name: hm.TakedownList, Report: true})
+		for name := range hashes.Lists {
+			// the takedown list may also have been seeded from the file
+			if name == hm.TakedownList {
+				continue
+			}
+			hm.Lists = append(hm.Lists, visual.HashListConfig{Name: name, Report: true})
+		}
+		extraBlobRules = append(extraBlobRules, hm.HashMatchBlobRule)
+	}
+
 	var ruleset automod.RuleSet
 	switch config.RulesetName {
 	case "", "default", "no-hive":
@@ -203,6 +242,34 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		bskyClient.Headers = make(map[string]string)
 		bskyClient.Headers["x-ratelimit-bypass"] = config.RatelimitBypass
 	}
+	var lblr *labeler.Labeler
+	if config.LabelerDID != "" {
+		did, err := syntax.ParseDID(config.LabelerDID)
+		if err != nil {
+			return nil, fmt.Errorf("labeler DID supplied was not valid: %v", err)
+		}
+		if config.LabelerSigningKey == "" {
+			return nil, fmt.Errorf("labeler signing key is required when labeler DID is configured")
+		}
+		key, err := crypto.ParsePrivateMultibase(config.LabelerSigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing labeler signing key: %v", err)
+		}
+		var store labeler.LabelStore
+		if config.LabelerDBPath != "" {
+			pstore, err := labeler.NewPebbleLabelStore(config.LabelerDBPath)
+			if err != nil {
+				return nil, fmt.Errorf("initializing labeler store: %v", err)
+			}
+			store = pstore
+		} else {
+			logger.Warn("labeler DB path not configured; labels will only be stored in memory")
+			store = labeler.NewMemLabelStore()
+		}
+		lblr = labeler.NewLabeler(did, key, store, logger.With("subsystem", "labeler"))
+		logger.Info("configured labeler", "did", did.String())
+	}
+
 	blobClient := util.RobustHTTPClient()
 	eng := automod.Engine{
 		Logger:      logger,
@@ -211,6 +278,7 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		Sets:        sets,
 		Flags:       flags,
 		Cache:       cache,
+		Graph:       graph,
 		Rules:       ruleset,
 		Notifier:    notifier,
 		BskyClient:  &bskyClient,
@@ -228,15 +296,29 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		},
 	}
 
+	// NOTE: only assign when configured, to avoid a non-nil interface wrapping a nil pointer
+	if lblr != nil {
+		eng.Labeler = lblr
+	}
+
 	s := &Server{
 		logger:      logger,
 		Engine:      &eng,
 		RedisClient: rdb,
+		Labeler:     lblr,
 	}
 
 	return s, nil
 }
 
+// Serves the labeler XRPC endpoints (subscribeLabels and queryLabels)
+func (s *Server) RunLabeler(listen string) error {
+	e := echo.New()
+	e.HideBanner = true
+	s.Labeler.RegisterHandlers(e)
+	return e.Start(listen)
+}
+
 func (s *Server) RunMetrics(listen string) error {
 	http.Handle("/metrics", promhttp.Handler())
 	return http.ListenAndServe(listen, nil)
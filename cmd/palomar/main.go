@@ -86,6 +86,49 @@ func run(args []string) error {
 			Value:   "palomar_profile",
 			EnvVars: []string{"ES_PROFILE_INDEX"},
 		},
+		&cli.StringFlag{
+			Name:    "es-record-index",
+			Usage:   "ES index for generic record documents (only used with --record-mappings)",
+			Value:   "palomar_record",
+			EnvVars: []string{"ES_RECORD_INDEX"},
+		},
+		&cli.StringFlag{
+			Name:    "record-mappings",
+			Usage:   "path to a JSON file declaring other record types to index for searchRecords (see README)",
+			EnvVars: []string{"PALOMAR_RECORD_MAPPINGS"},
+		},
+		&cli.StringFlag{
+			Name:    "embedded-index-dir",
+			Usage:   "if set, keep the search index in a local database in this directory, instead of using elasticsearch/opensearch",
+			EnvVars: []string{"PALOMAR_EMBEDDED_INDEX_DIR"},
+		},
+		&cli.StringFlag{
+			Name:    "appview-host",
+			Usage:   "AppView to fetch list members from, for the 'list:' query filter (empty to disable)",
+			Value:   "https://public.api.bsky.app",
+			EnvVars: []string{"PALOMAR_APPVIEW_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "embedding-url",
+			Usage:   "OpenAI-compatible embeddings endpoint (eg, 'http://localhost:8080/v1/embeddings'), for semantic search. Disabled if empty",
+			EnvVars: []string{"PALOMAR_EMBEDDING_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "embedding-model",
+			Usage:   "model name to pass to the embeddings endpoint",
+			EnvVars: []string{"PALOMAR_EMBEDDING_MODEL"},
+		},
+		&cli.StringFlag{
+			Name:    "embedding-api-key",
+			Usage:   "bearer token for the embeddings endpoint",
+			EnvVars: []string{"PALOMAR_EMBEDDING_API_KEY"},
+		},
+		&cli.IntFlag{
+			Name:    "embedding-dims",
+			Usage:   "number of dimensions of embedding vectors from the model",
+			Value:   384,
+			EnvVars: []string{"PALOMAR_EMBEDDING_DIMS"},
+		},
 		&cli.StringFlag{
 			Name:    "atp-relay-host",
 			Usage:   "hostname and port of Relay to subscribe to",
@@ -245,9 +288,25 @@ var runCmd = &cli.Command{
 			otel.SetTracerProvider(tp)
 		}
 
-		escli, err := createEsClient(cctx)
-		if err != nil {
-			return fmt.Errorf("failed to get elasticsearch: %w", err)
+		var escli *es.Client
+		var backend search.Backend
+		if indexDir := cctx.String("embedded-index-dir"); indexDir != "" {
+			if err := os.MkdirAll(indexDir, 0755); err != nil {
+				return err
+			}
+			eb, err := search.NewEmbeddedBackend(indexDir, logger)
+			if err != nil {
+				return fmt.Errorf("failed to open embedded index: %w", err)
+			}
+			defer eb.Close()
+			logger.Info("using embedded search index", "dir", indexDir)
+			backend = eb
+		} else {
+			var err error
+			escli, err = createEsClient(cctx)
+			if err != nil {
+				return fmt.Errorf("failed to get elasticsearch: %w", err)
+			}
 		}
 
 		base := identity.BaseDirectory{
@@ -261,10 +320,30 @@ var runCmd = &cli.Command{
 		}
 		dir := identity.NewCacheDirectory(&base, 1_500_000, time.Hour*24, time.Minute*2, time.Minute*5)
 
+		var recordMappings *search.RecordMappings
+		if path := cctx.String("record-mappings"); path != "" {
+			m, err := search.LoadRecordMappings(path)
+			if err != nil {
+				return err
+			}
+			recordMappings = m
+		}
+
 		apiConfig := search.ServerConfig{
-			Logger:       logger,
-			ProfileIndex: cctx.String("es-profile-index"),
-			PostIndex:    cctx.String("es-post-index"),
+			Logger:         logger,
+			ProfileIndex:   cctx.String("es-profile-index"),
+			PostIndex:      cctx.String("es-post-index"),
+			RecordIndex:    cctx.String("es-record-index"),
+			RecordMappings: recordMappings,
+			Backend:        backend,
+		}
+		if appview := cctx.String("appview-host"); appview != "" {
+			apiConfig.Lists = search.NewXRPCListResolver(appview)
+		}
+		var embedder search.Embedder
+		if embeddingURL := cctx.String("embedding-url"); embeddingURL != "" {
+			embedder = search.NewHTTPEmbedder(embeddingURL, cctx.String("embedding-model"), cctx.String("embedding-api-key"), cctx.Int("embedding-dims"))
+			apiConfig.Embedder = embedder
 		}
 
 		srv, err := search.NewServer(escli, &dir, apiConfig)
@@ -283,11 +362,15 @@ var runCmd = &cli.Command{
 				RelayHost:           cctx.String("atp-relay-host"),
 				ProfileIndex:        cctx.String("es-profile-index"),
 				PostIndex:           cctx.String("es-post-index"),
+				RecordIndex:         cctx.String("es-record-index"),
 				Logger:              logger,
 				RelaySyncRateLimit:  cctx.Int("relay-sync-rate-limit"),
 				IndexMaxConcurrency: cctx.Int("index-max-concurrency"),
 				DiscoverRepos:       cctx.Bool("discover-repos"),
 				IndexingRateLimit:   cctx.Int("indexing-rate-limit"),
+				Backend:             backend,
+				Embedder:            embedder,
+				RecordMappings:      recordMappings,
 			}
 
 			idx, err := search.NewIndexer(db, escli, &dir, indexerConfig)
@@ -334,7 +417,7 @@ var runCmd = &cli.Command{
 			// Otherwise, just run the indexer
 			ctx := context.Background()
 			if err := srv.Indexer.EnsureIndices(ctx); err != nil {
-				return fmt.Errorf("failed to create opensearch indices: %w", err)
+				return fmt.Errorf("failed to create search indices: %w", err)
 			}
 			if err := srv.Indexer.RunIndexer(ctx); err != nil {
 				return fmt.Errorf("failed to run indexer: %w", err)
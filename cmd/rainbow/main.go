@@ -95,6 +95,16 @@ func run(args []string) error {
 			Usage:   "forward POST requestCrawl to these hosts (schema and host, no path) in addition to upstream-host. Comma-separated or multiple flags",
 			EnvVars: []string{"RAINBOW_NEXT_CRAWLER", "RELAY_NEXT_CRAWLER"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "fanin-host",
+			Usage:   "merge the firehoses of these hosts (relays or PDSes; schema and host, no path) instead of subscribing to upstream-host, with deduplication and local sequence numbers. Requires persist-db. Comma-separated or multiple flags",
+			EnvVars: []string{"RAINBOW_FANIN_HOSTS"},
+		},
+		&cli.StringFlag{
+			Name:    "fanin-db",
+			Usage:   "path to fan-in cursor and deduplication db (defaults to persist-db with a '.fanin' suffix)",
+			EnvVars: []string{"RAINBOW_FANIN_DB_PATH"},
+		},
 		&cli.StringFlag{
 			Name:    "collectiondir-host",
 			Value:   "http://localhost:2510",
@@ -170,6 +180,7 @@ func runSplitter(cctx *cli.Context) error {
 	upstreamHost := cctx.String("upstream-host")
 	collectionDirHost := cctx.String("collectiondir-host")
 	nextCrawlers := cctx.StringSlice("next-crawler")
+	faninHosts := cctx.StringSlice("fanin-host")
 
 	var spl *splitter.Splitter
 	var err error
@@ -188,8 +199,19 @@ func runSplitter(cctx *cli.Context) error {
 			PebbleOptions:     &ppopts,
 			UserAgent:         fmt.Sprintf("rainbow/%s (atproto-relay)", versioninfo.Short()),
 		}
+		if len(faninHosts) > 0 {
+			conf.FanInHosts = faninHosts
+			conf.FanInDBPath = cctx.String("fanin-db")
+			if conf.FanInDBPath == "" {
+				conf.FanInDBPath = persistPath + ".fanin"
+			}
+			logger.Info("merging firehoses from multiple upstreams", "hosts", faninHosts, "db", conf.FanInDBPath)
+		}
 		spl, err = splitter.NewSplitter(conf, nextCrawlers)
 	} else {
+		if len(faninHosts) > 0 {
+			return fmt.Errorf("fanin-host requires persist-db")
+		}
 		logger.Info("building in-memory splitter")
 		conf := splitter.SplitterConfig{
 			UpstreamHost:      upstreamHost,
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/slurper"
 )
 
 func (r *Relay) SubscribeToHost(ctx context.Context, hostname string, noSSL, adminForce bool) error {
@@ -52,7 +53,7 @@ func (r *Relay) SubscribeToHost(ctx context.Context, hostname string, noSSL, adm
 		return fmt.Errorf("cannot subscribe to banned pds")
 	}
 
-	return r.Slurper.Subscribe(&host)
+	return r.Slurper.Subscribe(slurperHost(&host))
 }
 
 // This function expects to be run when starting up, to re-connect to known active hosts
@@ -66,9 +67,7 @@ func (r *Relay) ResubscribeAllHosts(ctx context.Context) error {
 	for _, host := range all {
 		logger := r.Logger.With("hostID", host.ID, "hostname", host.Hostname)
 		logger.Info("re-subscribing to active host")
-		// make a copy of host
-		host := host
-		err := r.Slurper.Subscribe(&host)
+		err := r.Slurper.Subscribe(slurperHost(&host))
 		if err != nil {
 			logger.Warn("failed to re-subscribe to host", "err", err)
 		}
@@ -77,3 +76,15 @@ func (r *Relay) ResubscribeAllHosts(ctx context.Context) error {
 	}
 	return nil
 }
+
+// converts a host database row to the subset of metadata needed for a subscription
+func slurperHost(host *models.Host) slurper.Host {
+	return slurper.Host{
+		ID:           host.ID,
+		Hostname:     host.Hostname,
+		NoSSL:        host.NoSSL,
+		LastSeq:      host.LastSeq,
+		AccountLimit: host.AccountLimit,
+		Trusted:      host.Trusted,
+	}
+}
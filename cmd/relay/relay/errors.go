@@ -2,11 +2,13 @@ package relay
 
 import (
 	"errors"
+
+	"github.com/bluesky-social/indigo/cmd/relay/slurper"
 )
 
 var (
 	ErrHostNotFound        = errors.New("unknown host or PDS")
-	ErrHostInactive        = errors.New("no active connection to host")
+	ErrHostInactive        = slurper.ErrHostInactive
 	ErrHostNotPDS          = errors.New("server is not a PDS")
 	ErrNewHostsDisabled    = errors.New("new host subscriptions temporarily disabled")
 	ErrAccountNotFound     = errors.New("unknown account")
@@ -9,6 +9,7 @@ import (
 
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/slurper"
 
 	"gorm.io/gorm"
 )
@@ -75,6 +76,11 @@ func (r *Relay) UpdateHostStatus(ctx context.Context, hostID uint64, status mode
 	return r.db.WithContext(ctx).Model(models.Host{}).Where("id = ?", hostID).Update("status", status).Error
 }
 
+// callback for the slurper; status values are the same strings as the database model
+func (r *Relay) persistSlurperHostStatus(ctx context.Context, hostID uint64, status slurper.HostStatus) error {
+	return r.UpdateHostStatus(ctx, hostID, models.HostStatus(status))
+}
+
 func (r *Relay) UpdateHostAccountLimit(ctx context.Context, hostID uint64, accountLimit int64) error {
 
 	if accountLimit < 0 {
@@ -124,7 +130,7 @@ func (r *Relay) UpdateHostAccountLimit(ctx context.Context, hostID uint64, accou
 // Persists all the host cursors in a single database transaction. Also updates status to "active" for hosts which have a positive cursor.
 //
 // Note that in some situations this may have partial success.
-func (r *Relay) PersistHostCursors(ctx context.Context, cursors *[]HostCursor) error {
+func (r *Relay) PersistHostCursors(ctx context.Context, cursors *[]slurper.HostCursor) error {
 	tx := r.db.WithContext(ctx).Begin()
 	for _, cur := range *cursors {
 		if cur.LastSeq <= 0 {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
+	"github.com/bluesky-social/indigo/cmd/relay/slurper"
 	"github.com/bluesky-social/indigo/cmd/relay/stream/eventmgr"
 
 	"github.com/RussellLuo/slidingwindow"
@@ -21,7 +22,7 @@ type Relay struct {
 	db          *gorm.DB
 	Dir         identity.Directory
 	Logger      *slog.Logger
-	Slurper     *Slurper
+	Slurper     *slurper.Slurper
 	Events      *eventmgr.EventManager
 	HostChecker HostChecker
 	Config      RelayConfig
@@ -93,14 +94,14 @@ func NewRelay(db *gorm.DB, evtman *eventmgr.EventManager, dir identity.Directory
 		return nil, err
 	}
 
-	slurpConfig := DefaultSlurperConfig()
+	slurpConfig := slurper.DefaultConfig()
 	slurpConfig.ConcurrencyPerHost = config.ConcurrencyPerHost
 
 	// register callbacks to persist cursors and host state in database
 	slurpConfig.PersistCursorCallback = r.PersistHostCursors
-	slurpConfig.PersistHostStatusCallback = r.UpdateHostStatus
+	slurpConfig.PersistHostStatusCallback = r.persistSlurperHostStatus
 
-	s, err := NewSlurper(r.processRepoEvent, slurpConfig)
+	s, err := slurper.NewSlurper(r.processRepoEvent, slurpConfig)
 	if err != nil {
 		return nil, err
 	}
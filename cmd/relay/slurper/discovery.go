@@ -0,0 +1,49 @@
+package slurper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+// ListHosts pages through `com.atproto.sync.listHosts` on an existing relay, returning the set of upstream hosts which are currently active (or idle). This is a simple way for a service to discover PDS instances to subscribe to directly.
+//
+// The relay's last-seen sequence number for each host is used as the `LastSeq` cursor. Cursors are not comparable across relays, but are from the host itself, so this is a reasonable starting point for a new subscription. `AccountLimit` is set from the relay's account count for the host, which scales event rate limits. Host `ID` fields are not populated.
+func ListHosts(ctx context.Context, c lexutil.LexClient, batchSize int64) ([]Host, error) {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	var hosts []Host
+	cursor := ""
+	for {
+		resp, err := comatproto.SyncListHosts(ctx, c, cursor, batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("listing hosts: %w", err)
+		}
+		for _, h := range resp.Hosts {
+			if h.Status == nil || !(*h.Status == "active" || *h.Status == "idle") {
+				continue
+			}
+			host := Host{
+				Hostname: h.Hostname,
+				NoSSL:    strings.HasPrefix(h.Hostname, "localhost:"),
+			}
+			if h.AccountCount != nil {
+				host.AccountLimit = *h.AccountCount
+			}
+			if h.Seq != nil && *h.Seq > 0 {
+				host.LastSeq = *h.Seq
+			}
+			hosts = append(hosts, host)
+		}
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Hosts) == 0 {
+			break
+		}
+		cursor = *resp.Cursor
+	}
+	return hosts, nil
+}
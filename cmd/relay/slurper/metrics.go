@@ -1,4 +1,4 @@
-package relay
+package slurper
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
@@ -1,4 +1,23 @@
-package relay
+// Package slurper manages firehose subscriptions (WebSocket connections) to a set of upstream hosts, such as PDS instances.
+//
+// This is the subscription sub-system of the relay, but can also be used directly by services which consume events from many hosts (eg, "PDS-direct" mode, without a relay). Each host gets its own connection with automatic redialing and backoff, event rate limits, and a cursor which is tracked and periodically persisted through a callback. Events from all hosts are passed to a single callback, along with the host they came from.
+//
+// A minimal consumer, discovering hosts from an existing relay:
+//
+//	s, err := slurper.NewSlurper(func(ctx context.Context, evt *stream.XRPCStreamEvent, hostname string, hostID uint64) error {
+//		// handle event
+//		return nil
+//	}, slurper.DefaultConfig())
+//
+//	hosts, err := slurper.ListHosts(ctx, &xrpc.Client{Host: "https://bsky.network"}, 500)
+//	for _, h := range hosts {
+//		if err := s.Subscribe(h); err != nil {
+//			// ...
+//		}
+//	}
+//
+// Events are not verified or de-duplicated across hosts; see `indigo:events.RepoVerifier` for commit verification.
+package slurper
 
 import (
 	"context"
@@ -13,7 +32,6 @@ import (
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
-	"github.com/bluesky-social/indigo/cmd/relay/relay/models"
 	"github.com/bluesky-social/indigo/cmd/relay/stream"
 	"github.com/bluesky-social/indigo/cmd/relay/stream/schedulers/parallel"
 	"github.com/bluesky-social/indigo/util/ssrf"
@@ -24,16 +42,55 @@ import (
 
 var ErrFutureCursor = errors.New("host rejected future cursor")
 
+var ErrHostInactive = errors.New("no active connection to host")
+
+// HostStatus is reported to PersistHostStatusFunc when a subscription ends because of a problem with the host. The values match the relay's host status values.
+type HostStatus string
+
+const (
+	// host was not reachable after repeated attempts
+	HostStatusOffline = HostStatus("offline")
+	// host rejected the subscription cursor as being in the future
+	HostStatusIdle = HostStatus("idle")
+	// host is another relay, or was explicitly disconnected with a ban
+	HostStatusBanned = HostStatus("banned")
+)
+
+// Host is an upstream host to subscribe to
+type Host struct {
+	// Opaque identifier, which is passed back in callbacks (eg, a database primary key)
+	ID uint64
+	// Hostname, with port number (if any), but no scheme or path
+	Hostname string
+	// Connect with plain WebSocket (instead of TLS), and skip SSRF protections. Intended for local development.
+	NoSSL bool
+	// Cursor to resume the subscription from. Zero or negative means start from the current end of the stream.
+	LastSeq int64
+	// Used to compute event rate limits (see Slurper.ComputeLimiterCounts)
+	AccountLimit int64
+	Trusted      bool
+}
+
+// returns websocket URL for the host: scheme, hostname, optional port, and path.
+func (h *Host) SubscribeReposURL() string {
+	scheme := "wss"
+	if h.NoSSL {
+		scheme = "ws"
+	}
+	return fmt.Sprintf("%s://%s/xrpc/com.atproto.sync.subscribeRepos", scheme, h.Hostname)
+}
+
+// ProcessMessageFunc is called for every event received from any host. Events from a single host are processed concurrently, but events for the same account are processed in order.
 type ProcessMessageFunc func(ctx context.Context, evt *stream.XRPCStreamEvent, hostname string, hostID uint64) error
 type PersistCursorFunc func(ctx context.Context, cursors *[]HostCursor) error
-type PersistHostStatusFunc func(ctx context.Context, hostID uint64, state models.HostStatus) error
+type PersistHostStatusFunc func(ctx context.Context, hostID uint64, state HostStatus) error
 
-// `Slurper` is the sub-system of the relay which manages active websocket firehose connections to upstream hosts (eg, PDS instances).
+// `Slurper` manages active websocket firehose connections to upstream hosts (eg, PDS instances).
 //
-// It configures rate-limits, tracks cursors, and retries connections. It passes received messages on to the main relay via a callback function. `Slurper` does not talk to the database directly, but does have some callback to persist host state (cursors and hosting status for some error conditions).
+// It configures rate-limits, tracks cursors, and retries connections. It passes received messages on via a callback function. `Slurper` does not talk to a database directly, but does have some callback to persist host state (cursors and hosting status for some error conditions).
 type Slurper struct {
 	processCallback ProcessMessageFunc
-	Config          *SlurperConfig
+	Config          *Config
 
 	subsLk sync.Mutex
 	subs   map[string]*Subscription
@@ -44,7 +101,7 @@ type Slurper struct {
 	logger *slog.Logger
 }
 
-type SlurperConfig struct {
+type Config struct {
 	UserAgent           string
 	ConcurrencyPerHost  int
 	QueueDepthPerHost   int
@@ -57,14 +114,14 @@ type SlurperConfig struct {
 	TrustedPerHourLimit    int64
 	TrustedPerDayLimit     int64
 
-	// callback functions. technically optional but effectively required
+	// optional callback functions. without a cursor callback, subscriptions re-start from the current end of the stream when the process restarts
 	PersistCursorCallback     PersistCursorFunc
 	PersistHostStatusCallback PersistHostStatusFunc
 }
 
-func DefaultSlurperConfig() *SlurperConfig {
+func DefaultConfig() *Config {
 	// NOTE: many of these defaults are overruled by DefaultRelayConfig, or even process CLI arg defaults
-	return &SlurperConfig{
+	return &Config{
 		UserAgent:          "indigo-relay (atproto-relay)",
 		ConcurrencyPerHost: 40,
 		// NOTE: queue depth doesn't do anything with current parallel scheduler implementation
@@ -98,11 +155,15 @@ type Subscription struct {
 
 // pulls lastSeq from underlying scheduler in to this Subscription
 func (sub *Subscription) UpdateSeq() {
+	sub.lk.RLock()
+	sched := sub.scheduler
+	sub.lk.RUnlock()
+
 	// possible for this to get called before a connection has fully been set up
-	if sub.scheduler == nil {
+	if sched == nil {
 		return
 	}
-	seq := sub.scheduler.LastSeq()
+	seq := sched.LastSeq()
 	if seq > 0 {
 		sub.LastSeq.Store(seq)
 	}
@@ -110,8 +171,9 @@ func (sub *Subscription) UpdateSeq() {
 
 func (sub *Subscription) HostCursor() HostCursor {
 	return HostCursor{
-		HostID:  sub.HostID,
-		LastSeq: sub.LastSeq.Load(),
+		HostID:   sub.HostID,
+		Hostname: sub.Hostname,
+		LastSeq:  sub.LastSeq.Load(),
 	}
 }
 
@@ -135,12 +197,12 @@ func (sl *StreamLimiters) Counts() StreamLimiterCounts {
 	}
 }
 
-func NewSlurper(processCallback ProcessMessageFunc, config *SlurperConfig) (*Slurper, error) {
+func NewSlurper(processCallback ProcessMessageFunc, config *Config) (*Slurper, error) {
 	if processCallback == nil {
 		return nil, fmt.Errorf("processCallback is required")
 	}
 	if config == nil {
-		config = DefaultSlurperConfig()
+		config = DefaultConfig()
 	}
 
 	s := &Slurper{
@@ -244,8 +306,8 @@ func (s *Slurper) CheckIfSubscribed(hostname string) bool {
 
 // high-level entry point for opening a subscription (websocket connection). This might be called when adding a new host, or when re-connecting to a previously subscribed host.
 //
-// NOTE: the `host` parameter (a database row) contains metadata about the host at a point in time. Subsequent changes to the database aren't reflected in that struct, and changes to the struct don't get persisted to database.
-func (s *Slurper) Subscribe(host *models.Host) error {
+// NOTE: the `host` parameter contains metadata about the host at a point in time (eg, from a database row). Subsequent changes to the host aren't reflected in the subscription, except via methods like `UpdateLimiters`.
+func (s *Slurper) Subscribe(host Host) error {
 	s.subsLk.Lock()
 	defer s.subsLk.Unlock()
 
@@ -275,7 +337,7 @@ func (s *Slurper) Subscribe(host *models.Host) error {
 	sub.LastSeq.Store(host.LastSeq)
 	s.subs[host.Hostname] = &sub
 
-	go s.subscribeWithRedialer(ctx, host, &sub)
+	go s.subscribeWithRedialer(ctx, &host, &sub)
 
 	return nil
 }
@@ -283,7 +345,7 @@ func (s *Slurper) Subscribe(host *models.Host) error {
 // Main event-loop for a subscription (websocket connection to upstream host), expected to be called as a goroutine.
 //
 // On connection failure (drop or failed initial connection), will attempt re-connects, with backoff.
-func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.Host, sub *Subscription) {
+func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *Host, sub *Subscription) {
 
 	logger := s.logger.With("host", host.Hostname)
 	defer func() {
@@ -331,9 +393,7 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.Host,
 
 			if backoff > 15 {
 				logger.Warn("host does not appear to be online, disabling for now")
-				if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, models.HostStatusOffline); err != nil {
-					logger.Error("failed to update host status", "err", err)
-				}
+				s.persistHostStatus(ctx, sub.HostID, HostStatusOffline)
 				return
 			}
 
@@ -344,9 +404,7 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.Host,
 		serverHdr := resp.Header.Get("Server")
 		if strings.Contains(serverHdr, "atproto-relay") {
 			logger.Warn("subscribed host is atproto relay of some kind, banning", "header", "Server", "value", serverHdr, "url", u)
-			if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, models.HostStatusBanned); err != nil {
-				logger.Error("failed to update host status", "err", err)
-			}
+			s.persistHostStatus(ctx, sub.HostID, HostStatusBanned)
 			return
 		}
 
@@ -377,6 +435,16 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.Host,
 	}
 }
 
+// reports host status via callback (if registered), logging any errors
+func (s *Slurper) persistHostStatus(ctx context.Context, hostID uint64, status HostStatus) {
+	if s.Config.PersistHostStatusCallback == nil {
+		return
+	}
+	if err := s.Config.PersistHostStatusCallback(ctx, hostID, status); err != nil {
+		s.logger.Error("failed to update host status", "hostID", hostID, "status", status, "err", err)
+	}
+}
+
 func sleepForBackoff(b int) time.Duration {
 	if b == 0 {
 		return 0
@@ -440,9 +508,7 @@ func (s *Slurper) handleConnection(ctx context.Context, conn *websocket.Conn, su
 			logger.Warn("error event from upstream", "name", evt.Error, "message", evt.Message)
 			switch evt.Error {
 			case "FutureCursor":
-				if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, models.HostStatusIdle); err != nil {
-					logger.Error("failed updating host status due to future cursor", "err", err)
-				}
+				s.persistHostStatus(ctx, sub.HostID, HostStatusIdle)
 				logger.Warn("dropping connection to host due to future cursor")
 				sub.cancel()
 				return ErrFutureCursor
@@ -465,25 +531,31 @@ func (s *Slurper) handleConnection(ctx context.Context, conn *websocket.Conn, su
 	// NOTE: `InstrumentedRepoStreamCallbacks` is where event limiters get called/enforced
 	instrumentedRSC := stream.NewInstrumentedRepoStreamCallbacks(limiters, rsc.EventHandler)
 
-	sub.scheduler = parallel.NewScheduler(
+	sched := parallel.NewScheduler(
 		s.Config.ConcurrencyPerHost,
 		s.Config.QueueDepthPerHost,
 		conn.RemoteAddr().String(),
 		instrumentedRSC.EventHandler,
 	)
+	// scheduler is read concurrently by the periodic cursor flush
+	sub.lk.Lock()
+	sub.scheduler = sched
+	sub.lk.Unlock()
 	connLogger := s.logger.With("host", sub.Hostname)
-	return stream.HandleRepoStream(ctx, conn, sub.scheduler, connLogger)
+	return stream.HandleRepoStream(ctx, conn, sched, connLogger)
 }
 
 type HostCursor struct {
-	HostID  uint64
-	LastSeq int64
+	HostID uint64
+	// for callers which don't assign host IDs (see ListHosts)
+	Hostname string
+	LastSeq  int64
 }
 
 // persistCursors sends all cursors to callback to be persisted in database (if registered)
 func (s *Slurper) persistCursors(ctx context.Context) error {
 	if s.Config.PersistCursorCallback == nil {
-		s.logger.Warn("skipping cursor persist because no PersistCursorCallback registered")
+		s.logger.Debug("skipping cursor persist because no PersistCursorCallback registered")
 		return nil
 	}
 	start := time.Now()
@@ -527,7 +599,7 @@ func (s *Slurper) KillUpstreamConnection(ctx context.Context, hostname string, b
 	sub.cancel()
 
 	if ban && s.Config.PersistHostStatusCallback != nil {
-		if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, models.HostStatusBanned); err != nil {
+		if err := s.Config.PersistHostStatusCallback(ctx, sub.HostID, HostStatusBanned); err != nil {
 			return fmt.Errorf("failed to set host as banned: %w", err)
 		}
 	}
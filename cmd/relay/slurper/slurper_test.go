@@ -0,0 +1,156 @@
+package slurper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeReposURL(t *testing.T) {
+	assert := assert.New(t)
+
+	h := Host{Hostname: "pds.example.com"}
+	assert.Equal("wss://pds.example.com/xrpc/com.atproto.sync.subscribeRepos", h.SubscribeReposURL())
+
+	h = Host{Hostname: "localhost:2583", NoSSL: true}
+	assert.Equal("ws://localhost:2583/xrpc/com.atproto.sync.subscribeRepos", h.SubscribeReposURL())
+}
+
+func TestListHosts(t *testing.T) {
+	assert := assert.New(t)
+
+	pages := map[string]string{
+		"":  `{"cursor": "2", "hosts": [{"hostname": "pds-one.example.com", "seq": 123, "accountCount": 50, "status": "active"}, {"hostname": "pds-two.example.com", "status": "banned"}]}`,
+		"2": `{"cursor": "4", "hosts": [{"hostname": "localhost:2583", "status": "idle"}, {"hostname": "pds-three.example.com"}]}`,
+		"4": `{"hosts": []}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/xrpc/com.atproto.sync.listHosts", r.URL.Path)
+		assert.Equal("2", r.URL.Query().Get("limit"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(pages[r.URL.Query().Get("cursor")]))
+	}))
+	defer srv.Close()
+
+	hosts, err := ListHosts(context.Background(), &xrpc.Client{Host: srv.URL}, 2)
+	assert.NoError(err)
+	assert.Equal([]Host{
+		{Hostname: "pds-one.example.com", LastSeq: 123, AccountLimit: 50},
+		{Hostname: "localhost:2583", NoSSL: true},
+	}, hosts)
+}
+
+// serves a fixed sequence of #identity events (after the requested cursor) on a subscribeRepos endpoint
+func testHostServer(t *testing.T, did string, seqs []int64) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cursor int64
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			var err error
+			if cursor, err = strconv.ParseInt(c, 10, 64); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for _, seq := range seqs {
+			if seq <= cursor {
+				continue
+			}
+			evt := &stream.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+				Did:  did,
+				Seq:  seq,
+				Time: time.Now().UTC().Format(time.RFC3339),
+			}}
+			wc, err := conn.NextWriter(websocket.BinaryMessage)
+			if err != nil {
+				return
+			}
+			if err := evt.Serialize(wc); err != nil {
+				t.Error(err)
+				return
+			}
+			wc.Close()
+		}
+		// hold the connection open until the client goes away
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestSubscribeMultipleHosts(t *testing.T) {
+	assert := assert.New(t)
+
+	srvOne := testHostServer(t, "did:plc:one", []int64{1, 2, 3})
+	defer srvOne.Close()
+	srvTwo := testHostServer(t, "did:plc:two", []int64{10, 11, 12})
+	defer srvTwo.Close()
+
+	hostOne := Host{ID: 1, Hostname: strings.TrimPrefix(srvOne.URL, "http://"), NoSSL: true}
+	// resume the second host from a cursor
+	hostTwo := Host{ID: 2, Hostname: strings.TrimPrefix(srvTwo.URL, "http://"), NoSSL: true, LastSeq: 10}
+
+	var lk sync.Mutex
+	received := make(map[string][]int64)
+	cursors := make(map[string]int64)
+
+	config := DefaultConfig()
+	config.PersistCursorPeriod = 50 * time.Millisecond
+	config.PersistCursorCallback = func(ctx context.Context, batch *[]HostCursor) error {
+		lk.Lock()
+		defer lk.Unlock()
+		for _, c := range *batch {
+			assert.Equal(map[string]uint64{hostOne.Hostname: 1, hostTwo.Hostname: 2}[c.Hostname], c.HostID)
+			cursors[c.Hostname] = c.LastSeq
+		}
+		return nil
+	}
+	s, err := NewSlurper(func(ctx context.Context, evt *stream.XRPCStreamEvent, hostname string, hostID uint64) error {
+		lk.Lock()
+		defer lk.Unlock()
+		received[hostname] = append(received[hostname], evt.RepoIdentity.Seq)
+		return nil
+	}, config)
+	assert.NoError(err)
+	defer s.Shutdown()
+
+	assert.NoError(s.Subscribe(hostOne))
+	assert.NoError(s.Subscribe(hostTwo))
+	assert.Error(s.Subscribe(hostOne))
+	assert.True(s.CheckIfSubscribed(hostTwo.Hostname))
+	assert.ElementsMatch([]string{hostOne.Hostname, hostTwo.Hostname}, s.GetActiveSubHostnames())
+
+	assert.Eventually(func() bool {
+		lk.Lock()
+		defer lk.Unlock()
+		return cursors[hostOne.Hostname] == 3 && cursors[hostTwo.Hostname] == 12
+	}, 5*time.Second, 20*time.Millisecond)
+
+	lk.Lock()
+	assert.ElementsMatch([]int64{1, 2, 3}, received[hostOne.Hostname])
+	assert.ElementsMatch([]int64{11, 12}, received[hostTwo.Hostname])
+	lk.Unlock()
+
+	assert.NoError(s.KillUpstreamConnection(context.Background(), hostOne.Hostname, false))
+	assert.ErrorIs(s.KillUpstreamConnection(context.Background(), "unknown.example.com", false), ErrHostInactive)
+}
@@ -1,16 +1,17 @@
 package stream
 
 import (
+	"github.com/bluesky-social/indigo/util/promutil"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var eventsFromStreamCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+var eventsFromStreamCounter = promutil.RegisterShared(prometheus.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_repo_stream_events_received_total",
 	Help: "Total number of events received from the stream",
-}, []string{"remote_addr"})
+}, []string{"remote_addr"}))
 
-var bytesFromStreamCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+var bytesFromStreamCounter = promutil.RegisterShared(prometheus.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_repo_stream_bytes_total",
 	Help: "Total bytes received from the stream",
-}, []string{"remote_addr"})
+}, []string{"remote_addr"}))
@@ -0,0 +1,123 @@
+package testing
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// FuzzOptions controls random scenario generation
+type FuzzOptions struct {
+	Accounts int
+	Steps    int
+	// fraction of #commit and #sync messages which are deliberately broken
+	BreakRate float64
+	Lenient   bool
+}
+
+func DefaultFuzzOptions() FuzzOptions {
+	return FuzzOptions{
+		Accounts:  3,
+		Steps:     40,
+		BreakRate: 0.2,
+	}
+}
+
+// GenerateScenario builds a random sequence of valid and broken messages across several accounts: record creates/updates/deletes, #sync, key rotations, status changes and tombstones. The sequence of actions is reproducible for a given seed (keys and signatures are not).
+//
+// Running the scenario checks relay invariants: valid messages are passed through unchanged and in order, broken messages and messages for inactive accounts are dropped, and the relay's stored rev for each account matches the last accepted commit.
+func GenerateScenario(seed int64, opts FuzzOptions) (*Scenario, error) {
+	rng := rand.New(rand.NewSource(seed))
+	b := NewScenarioBuilder(fmt.Sprintf("generated scenario (seed=%d)", seed))
+	b.Lenient = opts.Lenient
+
+	for i := 0; i < opts.Accounts; i++ {
+		if _, err := b.AddAccount(fmt.Sprintf("user%d.example.com", i)); err != nil {
+			return nil, err
+		}
+	}
+	if len(b.Accounts()) == 0 {
+		return nil, fmt.Errorf("at least one account is required")
+	}
+
+	for step := 0; step < opts.Steps; step++ {
+		sr := b.Accounts()[rng.Intn(len(b.Accounts()))]
+		var err error
+		switch n := rng.Intn(100); {
+		case n < 65:
+			err = sr.Commit(randomOps(rng, sr), randomBreakage(rng, opts.BreakRate, sr.Rev() != "", AllBreakages))
+		case n < 72:
+			err = sr.Sync(randomBreakage(rng, opts.BreakRate, true, []Breakage{BreakSignature, BreakFutureRev, BreakSeq}))
+		case n < 79:
+			err = sr.RotateKey()
+		case n < 85:
+			err = sr.EmitIdentity()
+		case n < 98:
+			switch sr.Status {
+			case "active":
+				err = sr.SetStatus("deactivated")
+			case "deactivated":
+				err = sr.SetStatus("active")
+			}
+		default:
+			if sr.Status != "deleted" {
+				err = sr.Tombstone()
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("generating step %d: %w", step, err)
+		}
+	}
+
+	return b.Scenario(), nil
+}
+
+func randomBreakage(rng *rand.Rand, rate float64, hasRev bool, options []Breakage) Breakage {
+	if rng.Float64() >= rate {
+		return BreakNone
+	}
+	brk := options[rng.Intn(len(options))]
+	if brk == BreakStaleRev && !hasRev {
+		return BreakNone
+	}
+	return brk
+}
+
+func randomOps(rng *rand.Rand, sr *ScenarioRepo) []RecordOp {
+	existing := sr.Records()
+	var ops []RecordOp
+	seen := map[string]bool{}
+	for i := rng.Intn(3); i >= 0; i-- {
+		if len(existing) == 0 || rng.Intn(2) == 0 {
+			ops = append(ops, RecordOp{
+				Collection: syntax.NSID("app.bsky.feed.post"),
+				RecordKey:  syntax.RecordKey(syntax.NewTIDFromInteger(uint64(rng.Int63())).String()),
+				Record:     randomPost(rng),
+			})
+			continue
+		}
+		path := existing[rng.Intn(len(existing))]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		nsid, rkey, err := syntax.ParseRepoPath(path)
+		if err != nil {
+			continue
+		}
+		op := RecordOp{Collection: nsid, RecordKey: rkey}
+		if rng.Intn(2) == 0 {
+			op.Record = randomPost(rng)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func randomPost(rng *rand.Rand) map[string]any {
+	return map[string]any{
+		"text":      fmt.Sprintf("post number %d", rng.Intn(1_000_000)),
+		"createdAt": syntax.DatetimeNow().String(),
+	}
+}
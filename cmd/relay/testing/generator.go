@@ -0,0 +1,552 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/cmd/relay/stream"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+// Breakage is a deliberate protocol violation to introduce when generating a message
+type Breakage string
+
+const (
+	BreakNone Breakage = ""
+	// commit signed by a key which isn't in the account's DID document
+	BreakSignature Breakage = "signature"
+	// commit rev is not newer than the previous commit from the account
+	BreakStaleRev Breakage = "stale-rev"
+	// commit rev is too far in the future
+	BreakFutureRev Breakage = "future-rev"
+	// message seq is not greater than the previous message on the stream
+	BreakSeq Breakage = "seq"
+	// prevData doesn't point to the previous MST root
+	BreakPrevData Breakage = "prev-data"
+	// record blocks are left out of the commit diff
+	BreakMissingBlocks Breakage = "missing-blocks"
+)
+
+// AllBreakages lists every Breakage other than BreakNone
+var AllBreakages = []Breakage{BreakSignature, BreakStaleRev, BreakFutureRev, BreakSeq, BreakPrevData, BreakMissingBlocks}
+
+// Whether the relay is expected to drop a message with this breakage. In strict mode the relay currently only logs prevData mismatches and MST inversion failures (including missing record blocks), so those messages are invalid but still passed through.
+func (b Breakage) Drop() bool {
+	switch b {
+	case BreakSignature, BreakStaleRev, BreakFutureRev, BreakSeq:
+		return true
+	default:
+		return false
+	}
+}
+
+var cborPrefix = cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256)
+
+// ScenarioBuilder assembles a Scenario from real, signed repositories, instead of hand-written fixtures
+type ScenarioBuilder struct {
+	Description string
+	Lenient     bool
+
+	seq      int64
+	accounts []*ScenarioRepo
+	messages []ScenarioMessage
+}
+
+func NewScenarioBuilder(description string) *ScenarioBuilder {
+	return &ScenarioBuilder{
+		Description: description,
+		seq:         100,
+	}
+}
+
+// ScenarioRepo is an account and in-memory repository which the builder can generate firehose messages for
+type ScenarioRepo struct {
+	Identity identity.Identity
+	Status   string
+
+	// identity as of account creation, which the directory starts out with
+	initial identity.Identity
+
+	b       *ScenarioBuilder
+	key     crypto.PrivateKey
+	clock   syntax.TIDClock
+	rev     string
+	data    *cid.Cid
+	records map[string]cid.Cid
+}
+
+// RecordOp is a single record mutation to include in a commit. A nil Record means delete.
+type RecordOp struct {
+	Collection syntax.NSID
+	RecordKey  syntax.RecordKey
+	Record     map[string]any
+}
+
+// AddAccount creates a new active account with a fresh signing key and an empty repo
+func (b *ScenarioBuilder) AddAccount(handle string) (*ScenarioRepo, error) {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		return nil, err
+	}
+	h, err := syntax.ParseHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	// random, syntactically valid did:plc
+	raw := make([]byte, 15)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	did := syntax.DID("did:plc:" + strings.ToLower(base32.StdEncoding.EncodeToString(raw)))
+
+	sr := &ScenarioRepo{
+		Identity: identity.Identity{
+			DID:         did,
+			Handle:      h,
+			AlsoKnownAs: []string{"at://" + h.String()},
+			Services: map[string]identity.ServiceEndpoint{
+				"atproto_pds": {
+					Type: "AtprotoPersonalDataServer",
+					URL:  "https://pds.example.com",
+				},
+			},
+		},
+		Status:  "active",
+		b:       b,
+		clock:   syntax.NewTIDClock(0),
+		records: make(map[string]cid.Cid),
+	}
+	if err := sr.setKey(key); err != nil {
+		return nil, err
+	}
+	sr.initial = sr.Identity
+	b.accounts = append(b.accounts, sr)
+	return sr, nil
+}
+
+// Accounts returns all accounts added to the builder, in order
+func (b *ScenarioBuilder) Accounts() []*ScenarioRepo {
+	return b.accounts
+}
+
+// Scenario returns the built scenario. Each account's expected final rev is included, so runs also check the relay's stored repo state.
+func (b *ScenarioBuilder) Scenario() *Scenario {
+	s := Scenario{
+		Description: b.Description,
+		Lenient:     b.Lenient,
+		Messages:    append([]ScenarioMessage{}, b.messages...),
+	}
+	for _, sr := range b.accounts {
+		s.Accounts = append(s.Accounts, ScenarioAccount{
+			Identity: sr.initial,
+			Status:   "active",
+			Rev:      sr.rev,
+		})
+	}
+	return &s
+}
+
+func (b *ScenarioBuilder) nextSeq(brk Breakage) int64 {
+	if brk == BreakSeq {
+		return b.seq
+	}
+	b.seq++
+	return b.seq
+}
+
+// adds a message to the scenario, with the frame's JSON body filled in so the scenario can be saved as a fixture
+func (b *ScenarioBuilder) addMessage(evt *stream.XRPCStreamEvent, msg ScenarioMessage) error {
+	var body any
+	switch {
+	case evt.RepoCommit != nil:
+		msg.Frame.Header = stream.EventHeader{Op: 1, MsgType: "#commit"}
+		body = evt.RepoCommit
+	case evt.RepoSync != nil:
+		msg.Frame.Header = stream.EventHeader{Op: 1, MsgType: "#sync"}
+		body = evt.RepoSync
+	case evt.RepoIdentity != nil:
+		msg.Frame.Header = stream.EventHeader{Op: 1, MsgType: "#identity"}
+		body = evt.RepoIdentity
+	case evt.RepoAccount != nil:
+		msg.Frame.Header = stream.EventHeader{Op: 1, MsgType: "#account"}
+		body = evt.RepoAccount
+	default:
+		return fmt.Errorf("unsupported event type")
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	msg.Frame.Body = raw
+	msg.Frame.Event = evt
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+func (sr *ScenarioRepo) setKey(key crypto.PrivateKey) error {
+	pub, err := key.PublicKey()
+	if err != nil {
+		return err
+	}
+	sr.key = key
+	sr.Identity.Keys = map[string]identity.VerificationMethod{
+		"atproto": {
+			Type:               "Multikey",
+			PublicKeyMultibase: pub.Multibase(),
+		},
+	}
+	return nil
+}
+
+func (sr *ScenarioRepo) active() bool {
+	return sr.Status == "active"
+}
+
+// Rev returns the rev of the last commit the relay should have accepted
+func (sr *ScenarioRepo) Rev() string {
+	return sr.rev
+}
+
+// Records returns the repo paths of all current records, sorted
+func (sr *ScenarioRepo) Records() []string {
+	out := make([]string, 0, len(sr.records))
+	for p := range sr.records {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Commit generates a #commit message with the given record ops, optionally broken. The repo state only advances if the relay is expected to accept the message.
+func (sr *ScenarioRepo) Commit(ops []RecordOp, brk Breakage) error {
+	ctx := context.Background()
+
+	if brk == BreakStaleRev && sr.rev == "" {
+		return fmt.Errorf("stale rev breakage requires an earlier commit")
+	}
+
+	records := make(map[string]cid.Cid, len(sr.records)+len(ops))
+	for k, v := range sr.records {
+		records[k] = v
+	}
+	newBlocks := make(map[cid.Cid][]byte)
+
+	var repoOps []*comatproto.SyncSubscribeRepos_RepoOp
+	for _, op := range ops {
+		path := op.Collection.String() + "/" + op.RecordKey.String()
+		prev, exists := records[path]
+		rop := comatproto.SyncSubscribeRepos_RepoOp{Path: path}
+		if exists {
+			p := lexutil.LexLink(prev)
+			rop.Prev = &p
+		}
+
+		if op.Record == nil {
+			if !exists {
+				return fmt.Errorf("can not delete missing record: %s", path)
+			}
+			rop.Action = "delete"
+			delete(records, path)
+		} else {
+			if _, ok := op.Record["$type"]; !ok {
+				op.Record["$type"] = op.Collection.String()
+			}
+			recBytes, err := data.MarshalCBOR(op.Record)
+			if err != nil {
+				return err
+			}
+			c, err := cborPrefix.Sum(recBytes)
+			if err != nil {
+				return err
+			}
+			newBlocks[c] = recBytes
+			records[path] = c
+			l := lexutil.LexLink(c)
+			rop.Cid = &l
+			rop.Action = "create"
+			if exists {
+				rop.Action = "update"
+			}
+		}
+		repoOps = append(repoOps, &rop)
+	}
+
+	// the diff includes the full tree, which is always enough to invert the ops
+	tree, err := mst.LoadTreeFromMap(records)
+	if err != nil {
+		return err
+	}
+	bs := newDiffBlockstore()
+	root, err := tree.WriteDiffBlocks(ctx, bs)
+	if err != nil {
+		return err
+	}
+	if brk != BreakMissingBlocks {
+		for c, b := range newBlocks {
+			blk, err := blocks.NewBlockWithCid(b, c)
+			if err != nil {
+				return err
+			}
+			if err := bs.Put(ctx, blk); err != nil {
+				return err
+			}
+		}
+	}
+
+	rev := sr.clock.Next().String()
+	switch brk {
+	case BreakStaleRev:
+		rev = sr.rev
+	case BreakFutureRev:
+		rev = syntax.NewTID(time.Now().Add(time.Hour).UnixMicro(), 0).String()
+	}
+
+	key := sr.key
+	if brk == BreakSignature {
+		key, err = crypto.GeneratePrivateKeyK256()
+		if err != nil {
+			return err
+		}
+	}
+	commitCID, err := sr.writeCommit(ctx, bs, *root, rev, key)
+	if err != nil {
+		return err
+	}
+
+	carBytes, err := bs.CAR(ctx, commitCID)
+	if err != nil {
+		return err
+	}
+
+	evt := comatproto.SyncSubscribeRepos_Commit{
+		Repo:   sr.Identity.DID.String(),
+		Rev:    rev,
+		Seq:    sr.b.nextSeq(brk),
+		Time:   syntax.DatetimeNow().String(),
+		Commit: lexutil.LexLink(commitCID),
+		Blocks: carBytes,
+		Ops:    repoOps,
+	}
+	if sr.rev != "" {
+		since := sr.rev
+		evt.Since = &since
+	}
+	if sr.data != nil {
+		pd := lexutil.LexLink(*sr.data)
+		evt.PrevData = &pd
+	}
+	if brk == BreakPrevData {
+		// any CID other than the previous root will do
+		pd := lexutil.LexLink(*root)
+		evt.PrevData = &pd
+	}
+
+	drop := brk.Drop() || !sr.active()
+	if !drop {
+		sr.rev = rev
+		sr.data = root
+		sr.records = records
+	}
+
+	return sr.b.addMessage(&stream.XRPCStreamEvent{RepoCommit: &evt}, ScenarioMessage{
+		Drop:    drop,
+		Invalid: brk != BreakNone,
+	})
+}
+
+// signs a commit object for the current tree and writes it to the blockstore
+func (sr *ScenarioRepo) writeCommit(ctx context.Context, bs *diffBlockstore, root cid.Cid, rev string, key crypto.PrivateKey) (cid.Cid, error) {
+	commit := repo.Commit{
+		DID:     sr.Identity.DID.String(),
+		Version: repo.ATPROTO_REPO_VERSION,
+		Data:    root,
+		Rev:     rev,
+	}
+	if err := commit.Sign(key); err != nil {
+		return cid.Undef, err
+	}
+	buf := new(bytes.Buffer)
+	if err := commit.MarshalCBOR(buf); err != nil {
+		return cid.Undef, err
+	}
+	c, err := cborPrefix.Sum(buf.Bytes())
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// blockstore which remembers the full CIDs of blocks written to it (the underlying datastore only keeps multihashes)
+type diffBlockstore struct {
+	blockstore.Blockstore
+	cids []cid.Cid
+}
+
+func newDiffBlockstore() *diffBlockstore {
+	return &diffBlockstore{Blockstore: blockstore.NewBlockstore(datastore.NewMapDatastore())}
+}
+
+func (bs *diffBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	bs.cids = append(bs.cids, blk.Cid())
+	return bs.Blockstore.Put(ctx, blk)
+}
+
+func (bs *diffBlockstore) CAR(ctx context.Context, root cid.Cid) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, buf); err != nil {
+		return nil, err
+	}
+	for _, c := range bs.cids {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if err := carutil.LdWrite(buf, c.Bytes(), blk.RawData()); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Sync generates a #sync message for the current repo state, with a fresh signed commit. Only signature, future-rev and seq breakages apply to #sync messages.
+func (sr *ScenarioRepo) Sync(brk Breakage) error {
+	ctx := context.Background()
+
+	switch brk {
+	case BreakNone, BreakSignature, BreakFutureRev, BreakSeq:
+	default:
+		return fmt.Errorf("breakage not supported for #sync: %s", brk)
+	}
+
+	tree, err := mst.LoadTreeFromMap(sr.records)
+	if err != nil {
+		return err
+	}
+	root, err := tree.RootCID()
+	if err != nil {
+		return err
+	}
+
+	rev := sr.clock.Next().String()
+	if brk == BreakFutureRev {
+		rev = syntax.NewTID(time.Now().Add(time.Hour).UnixMicro(), 0).String()
+	}
+	key := sr.key
+	if brk == BreakSignature {
+		key, err = crypto.GeneratePrivateKeyK256()
+		if err != nil {
+			return err
+		}
+	}
+
+	// #sync blocks only contain the commit object
+	bs := newDiffBlockstore()
+	commitCID, err := sr.writeCommit(ctx, bs, *root, rev, key)
+	if err != nil {
+		return err
+	}
+	carBytes, err := bs.CAR(ctx, commitCID)
+	if err != nil {
+		return err
+	}
+
+	evt := comatproto.SyncSubscribeRepos_Sync{
+		Did:    sr.Identity.DID.String(),
+		Rev:    rev,
+		Seq:    sr.b.nextSeq(brk),
+		Time:   syntax.DatetimeNow().String(),
+		Blocks: carBytes,
+	}
+
+	drop := brk.Drop() || !sr.active()
+	if !drop {
+		sr.rev = rev
+		sr.data = root
+	}
+
+	return sr.b.addMessage(&stream.XRPCStreamEvent{RepoSync: &evt}, ScenarioMessage{
+		Drop:    drop,
+		Invalid: brk != BreakNone,
+	})
+}
+
+// RotateKey replaces the account's signing key, and emits an #identity message. The identity directory is updated just before the message is sent.
+func (sr *ScenarioRepo) RotateKey() error {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		return err
+	}
+	if err := sr.setKey(key); err != nil {
+		return err
+	}
+	return sr.EmitIdentity()
+}
+
+// EmitIdentity emits an #identity message for the account's current identity
+func (sr *ScenarioRepo) EmitIdentity() error {
+	ident := sr.Identity
+	h := ident.Handle.String()
+	evt := comatproto.SyncSubscribeRepos_Identity{
+		Did:    ident.DID.String(),
+		Handle: &h,
+		Seq:    sr.b.nextSeq(BreakNone),
+		Time:   syntax.DatetimeNow().String(),
+	}
+	return sr.b.addMessage(&stream.XRPCStreamEvent{RepoIdentity: &evt}, ScenarioMessage{
+		Update:   true,
+		Identity: &ident,
+	})
+}
+
+// SetStatus emits an #account message changing the upstream account status (eg, "active", "deactivated", "deleted")
+func (sr *ScenarioRepo) SetStatus(status string) error {
+	evt := comatproto.SyncSubscribeRepos_Account{
+		Did:    sr.Identity.DID.String(),
+		Active: status == "active",
+		Seq:    sr.b.nextSeq(BreakNone),
+		Time:   syntax.DatetimeNow().String(),
+	}
+	if status != "active" {
+		s := status
+		evt.Status = &s
+	}
+	sr.Status = status
+	return sr.b.addMessage(&stream.XRPCStreamEvent{RepoAccount: &evt}, ScenarioMessage{
+		Update: true,
+	})
+}
+
+// Tombstone marks the account as deleted. The relay drops any further commits.
+func (sr *ScenarioRepo) Tombstone() error {
+	return sr.SetStatus("deleted")
+}
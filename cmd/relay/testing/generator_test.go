@@ -0,0 +1,111 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPost(text string) map[string]any {
+	return map[string]any{
+		"text":      text,
+		"createdAt": syntax.DatetimeNow().String(),
+	}
+}
+
+func TestGeneratedBreakages(t *testing.T) {
+	ctx := context.Background()
+
+	for _, brk := range AllBreakages {
+		t.Run(string(brk), func(t *testing.T) {
+			assert := assert.New(t)
+
+			b := NewScenarioBuilder("single broken commit: " + string(brk))
+			sr, err := b.AddAccount("alice.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rkey := syntax.RecordKey("3lmxjza3nva27")
+			assert.NoError(sr.Commit([]RecordOp{{Collection: "app.bsky.feed.post", RecordKey: rkey, Record: testPost("first")}}, BreakNone))
+			assert.NoError(sr.Commit([]RecordOp{{Collection: "app.bsky.feed.post", RecordKey: rkey, Record: testPost("edited")}}, brk))
+			assert.NoError(sr.Commit([]RecordOp{{Collection: "app.bsky.feed.post", RecordKey: rkey}}, BreakNone))
+
+			s := b.Scenario()
+			assert.Equal(3, len(s.Messages))
+			assert.Equal(brk.Drop(), s.Messages[1].Drop)
+			assert.True(s.Messages[1].Invalid)
+			assert.NoError(RunScenario(ctx, s))
+		})
+	}
+}
+
+func TestGeneratedAccountLifecycle(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	b := NewScenarioBuilder("key rotation and tombstone")
+	sr, err := b.AddAccount("alice.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(rkey string) []RecordOp {
+		return []RecordOp{{Collection: "app.bsky.feed.post", RecordKey: syntax.RecordKey(rkey), Record: testPost(rkey)}}
+	}
+
+	assert.NoError(sr.Commit(post("3lmxjza3nva22"), BreakNone))
+	assert.NoError(sr.RotateKey())
+	assert.NoError(sr.Commit(post("3lmxjza3nva23"), BreakNone))
+	assert.NoError(sr.Sync(BreakNone))
+	assert.NoError(sr.SetStatus("deactivated"))
+	assert.NoError(sr.Commit(post("3lmxjza3nva24"), BreakNone))
+	assert.NoError(sr.SetStatus("active"))
+	assert.NoError(sr.Commit(post("3lmxjza3nva25"), BreakNone))
+	assert.NoError(sr.Tombstone())
+	assert.NoError(sr.Commit(post("3lmxjza3nva26"), BreakNone))
+
+	s := b.Scenario()
+	assert.True(s.Messages[5].Drop)
+	assert.True(s.Messages[9].Drop)
+	assert.Equal("deleted", sr.Status)
+	assert.NoError(RunScenario(ctx, s))
+
+	// generated scenarios round-trip through JSON, like hand-written fixtures
+	raw, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(t.TempDir(), "scenario.json")
+	if err := os.WriteFile(fpath, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(LoadAndRunScenario(ctx, fpath))
+}
+
+func FuzzRelayScenario(f *testing.F) {
+	for _, seed := range []int64{1, 2, 3} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		ctx := context.Background()
+		opts := DefaultFuzzOptions()
+		opts.Lenient = seed%2 == 0
+
+		s, err := GenerateScenario(seed, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RunScenario(ctx, s); err != nil {
+			// save the scenario so the failure can be replayed as a fixture
+			raw, _ := json.MarshalIndent(s, "", "  ")
+			fpath := filepath.Join(os.TempDir(), "relay-fuzz-failure.json")
+			_ = os.WriteFile(fpath, raw, 0644)
+			t.Fatalf("seed %d: %s (scenario saved to %s)", seed, err, fpath)
+		}
+	})
+}
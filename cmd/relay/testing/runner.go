@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/cmd/relay/relay"
@@ -144,9 +145,13 @@ func RunScenario(ctx context.Context, s *Scenario) error {
 	}
 	defer c.Shutdown()
 
+	// events are accumulated (not cleared) so that messages which should have been dropped show up as extra events
+	expected := 0
 	for i, msg := range s.Messages {
 		slog.Info("sending test message", "index", i)
-		c.Clear()
+		if msg.Identity != nil {
+			dir.Insert(*msg.Identity)
+		}
 		evt, err := msg.Frame.XRPCStreamEvent()
 		if err != nil {
 			return fmt.Errorf("preparing XRPCStreamEvent: %w", err)
@@ -155,22 +160,46 @@ func RunScenario(ctx context.Context, s *Scenario) error {
 			return fmt.Errorf("failed sending test event: %w", err)
 		}
 		if !msg.Drop {
-			evts, err := c.ConsumeEvents(1)
+			expected++
+			evts, err := c.ConsumeEvents(expected)
 			if err != nil {
 				return err
 			}
-			if len(evts) != 1 {
-				return fmt.Errorf("consumed unexpected additional events: %d", len(evts))
+			if len(evts) != expected {
+				return fmt.Errorf("consumed unexpected additional events (message %d): %d", i, len(evts)-expected)
 			}
-			if !EqualEvents(evt, evts[0]) {
-				evt.RepoCommit.Blocks = nil
-				evts[0].RepoCommit.Blocks = nil
-				fmt.Printf("%+v\n", evt.RepoCommit)
-				fmt.Printf("%+v\n", evts[0].RepoCommit)
-				return fmt.Errorf("events didn't match")
+			if !EqualEvents(evt, evts[expected-1]) {
+				if evt.RepoCommit != nil && evts[expected-1].RepoCommit != nil {
+					fmt.Printf("%+v\n", *evt.RepoCommit)
+					fmt.Printf("%+v\n", *evts[expected-1].RepoCommit)
+				}
+				return fmt.Errorf("events didn't match (message %d)", i)
 			}
-		} else {
-			// TODO: verify nothing returned? especially if last message in set
+		}
+	}
+
+	// give any trailing messages which should have been dropped a chance to come through
+	if len(s.Messages) > 0 && s.Messages[len(s.Messages)-1].Drop {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if n := c.Count(); n != expected {
+		return fmt.Errorf("relay passed through %d events, expected %d", n, expected)
+	}
+
+	for _, acc := range s.Accounts {
+		if acc.Rev == "" {
+			continue
+		}
+		ra, err := sr.Relay.GetAccount(ctx, acc.Identity.DID)
+		if err != nil {
+			return err
+		}
+		repo, err := sr.Relay.GetAccountRepo(ctx, ra.UID)
+		if err != nil {
+			return err
+		}
+		if repo.Rev != acc.Rev {
+			return fmt.Errorf("relay has rev %s for %s, expected %s", repo.Rev, acc.Identity.DID, acc.Rev)
 		}
 	}
 	return nil
@@ -20,6 +20,9 @@ type Scenario struct {
 type ScenarioAccount struct {
 	Identity identity.Identity `json:"identity"`
 	Status   string            `json:"status"`
+
+	// if set, the relay's stored repo rev for this account is checked after all messages have been sent
+	Rev string `json:"rev,omitempty"`
 }
 
 type ScenarioMessage struct {
@@ -33,6 +36,9 @@ type ScenarioMessage struct {
 
 	// whether account state / identity directory be updated
 	Update bool `json:"update"`
+
+	// if set, replaces the account's identity in the directory just before the message is sent (eg, key rotation)
+	Identity *identity.Identity `json:"identity,omitempty"`
 }
 
 // wrapper type appropriate for JSON encoding of firehose events
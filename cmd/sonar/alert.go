@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// AlertWebhook posts alerts as JSON to a webhook URL. The body has a "text" field, so Slack incoming webhooks work as-is, and the structured alert in an "alert" field.
+type AlertWebhook struct {
+	URL    string
+	Client *http.Client
+}
+
+type alertWebhookBody struct {
+	Text  string `json:"text"`
+	Alert Alert  `json:"alert"`
+}
+
+func NewAlertWebhook(url string) *AlertWebhook {
+	return &AlertWebhook{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (aw *AlertWebhook) Send(ctx context.Context, alert Alert) error {
+	prefix := "✅"
+	if alert.Firing {
+		prefix = "🚨"
+	}
+	body, err := json.Marshal(alertWebhookBody{
+		Text:  fmt.Sprintf("%s sonar: %s", prefix, alert.Message),
+		Alert: alert,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, aw.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := aw.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook POST failed: status=%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type AnomalyKind string
+
+const (
+	AnomalySpike AnomalyKind = "spike"
+	AnomalyQuiet AnomalyKind = "quiet"
+	AnomalyMix   AnomalyKind = "collection-mix"
+	AnomalyLag   AnomalyKind = "lag"
+)
+
+type Alert struct {
+	Kind    AnomalyKind `json:"kind"`
+	Host    string      `json:"host"`
+	Firing  bool        `json:"firing"`
+	Message string      `json:"message"`
+	Time    time.Time   `json:"time"`
+}
+
+type AnomalyConfig struct {
+	// length of the window events are counted over
+	Window time.Duration
+	// alert if the events in a window exceed the baseline rate by this factor
+	SpikeFactor float64
+	// hosts need at least this many events per window (baseline) to be checked for going quiet or mix changes; also the minimum count for a spike
+	MinEvents int
+	// alert after this many consecutive windows with no events, for a host with a baseline of at least MinEvents
+	QuietWindows int
+	// alert if a collection's share of ops grows by this much (0 to 1) over its baseline share
+	MixShift float64
+	// alert if the average lag between commit (rev) and receipt by sonar in a window exceeds this
+	LagThreshold time.Duration
+	// weight of each new window in the baseline (exponential moving average)
+	BaselineAlpha float64
+	// windows of history needed before a host is checked
+	WarmupWindows int
+}
+
+var DefaultAnomalyConfig = AnomalyConfig{
+	Window:        time.Minute,
+	SpikeFactor:   5,
+	MinEvents:     20,
+	QuietWindows:  5,
+	MixShift:      0.5,
+	LagThreshold:  2 * time.Minute,
+	BaselineAlpha: 0.1,
+	WarmupWindows: 10,
+}
+
+type hostWindow struct {
+	// current window
+	events      int
+	ops         int
+	collections map[string]int
+	lagSum      time.Duration
+	lagCount    int
+
+	// history
+	windows  int
+	baseline float64
+	baseMix  map[string]float64
+	quiet    int
+	firing   map[AnomalyKind]bool
+}
+
+// AnomalyDetector tracks per-host event rates against a moving baseline, and emits alerts when a host's traffic looks abnormal, and again when it recovers.
+type AnomalyDetector struct {
+	Config AnomalyConfig
+
+	hosts map[string]*hostWindow
+	lk    sync.Mutex
+}
+
+func NewAnomalyDetector(config AnomalyConfig) *AnomalyDetector {
+	return &AnomalyDetector{
+		Config: config,
+		hosts:  make(map[string]*hostWindow),
+	}
+}
+
+func (ad *AnomalyDetector) host(host string) *hostWindow {
+	hw, ok := ad.hosts[host]
+	if !ok {
+		hw = &hostWindow{
+			collections: make(map[string]int),
+			baseMix:     make(map[string]float64),
+			firing:      make(map[AnomalyKind]bool),
+		}
+		ad.hosts[host] = hw
+	}
+	return hw
+}
+
+// ObserveEvent counts a non-commit event from a host
+func (ad *AnomalyDetector) ObserveEvent(host string) {
+	if host == hostUnknown || host == hostOther {
+		return
+	}
+	ad.lk.Lock()
+	defer ad.lk.Unlock()
+	ad.host(host).events++
+}
+
+// ObserveCommit counts a commit event from a host, with the collections of its ops and the lag between the commit and when it was received. A negative lag (clock skew) is ignored.
+func (ad *AnomalyDetector) ObserveCommit(host string, collections []string, lag time.Duration) {
+	if host == hostUnknown || host == hostOther {
+		return
+	}
+	ad.lk.Lock()
+	defer ad.lk.Unlock()
+	hw := ad.host(host)
+	hw.events++
+	for _, c := range collections {
+		hw.collections[c]++
+		hw.ops++
+	}
+	if lag >= 0 {
+		hw.lagSum += lag
+		hw.lagCount++
+	}
+}
+
+// Tick closes the current window for every host, returning any alerts which started firing or resolved.
+func (ad *AnomalyDetector) Tick(now time.Time) []Alert {
+	ad.lk.Lock()
+	defer ad.lk.Unlock()
+
+	cfg := ad.Config
+	var alerts []Alert
+	transition := func(hw *hostWindow, host string, kind AnomalyKind, active bool, msg string) {
+		if active == hw.firing[kind] {
+			return
+		}
+		hw.firing[kind] = active
+		if !active {
+			msg = fmt.Sprintf("%s recovered: %s", host, kind)
+		}
+		alerts = append(alerts, Alert{Kind: kind, Host: host, Firing: active, Message: msg, Time: now})
+	}
+
+	hostNames := make([]string, 0, len(ad.hosts))
+	for host := range ad.hosts {
+		hostNames = append(hostNames, host)
+	}
+	sort.Strings(hostNames)
+
+	for _, host := range hostNames {
+		hw := ad.hosts[host]
+		count := float64(hw.events)
+		warm := hw.windows >= cfg.WarmupWindows
+		established := warm && hw.baseline >= float64(cfg.MinEvents)
+
+		// rate spike
+		spike := warm && hw.events >= cfg.MinEvents && count > cfg.SpikeFactor*hw.baseline
+		transition(hw, host, AnomalySpike, spike, fmt.Sprintf("%s event rate spike: %d events in %s (baseline %.1f)", host, hw.events, cfg.Window, hw.baseline))
+
+		// host going quiet
+		if hw.events == 0 {
+			hw.quiet++
+		} else {
+			hw.quiet = 0
+		}
+		quiet := (established || hw.firing[AnomalyQuiet]) && hw.quiet >= cfg.QuietWindows
+		transition(hw, host, AnomalyQuiet, quiet, fmt.Sprintf("%s has gone quiet: no events for %d windows of %s (baseline %.1f)", host, hw.quiet, cfg.Window, hw.baseline))
+
+		// unusual collection mix
+		var mixCollection string
+		var mixShare, mixBase float64
+		if established && hw.ops >= cfg.MinEvents {
+			for c, n := range hw.collections {
+				share := float64(n) / float64(hw.ops)
+				if share-hw.baseMix[c] >= cfg.MixShift && share > mixShare {
+					mixCollection, mixShare, mixBase = c, share, hw.baseMix[c]
+				}
+			}
+		}
+		transition(hw, host, AnomalyMix, mixCollection != "", fmt.Sprintf("%s unusual collection mix: %s is %.0f%% of ops (baseline %.0f%%)", host, mixCollection, mixShare*100, mixBase*100))
+
+		// commit-to-receipt lag
+		var avgLag time.Duration
+		if hw.lagCount > 0 {
+			avgLag = hw.lagSum / time.Duration(hw.lagCount)
+		}
+		lagging := hw.lagCount > 0 && avgLag > cfg.LagThreshold
+		if hw.lagCount == 0 && hw.firing[AnomalyLag] {
+			// no commits to measure; keep the current state
+			lagging = true
+		}
+		transition(hw, host, AnomalyLag, lagging, fmt.Sprintf("%s commit-to-receipt lag: average %s over %d commits", host, avgLag.Round(time.Second), hw.lagCount))
+
+		// update baselines. a sustained change in traffic becomes the new normal (and resolves the alert) over time, but an outage does not
+		if !hw.firing[AnomalyQuiet] {
+			if hw.windows == 0 {
+				hw.baseline = count
+			} else {
+				hw.baseline += cfg.BaselineAlpha * (count - hw.baseline)
+			}
+		}
+		if hw.ops > 0 {
+			for c := range hw.collections {
+				if _, ok := hw.baseMix[c]; !ok {
+					hw.baseMix[c] = 0
+				}
+			}
+			for c, base := range hw.baseMix {
+				share := float64(hw.collections[c]) / float64(hw.ops)
+				if hw.windows == 0 {
+					hw.baseMix[c] = share
+				} else {
+					hw.baseMix[c] = base + cfg.BaselineAlpha*(share-base)
+				}
+			}
+		}
+		hw.windows++
+		hostBaselineGauge.WithLabelValues(host).Set(hw.baseline)
+
+		hw.events = 0
+		hw.ops = 0
+		hw.collections = make(map[string]int)
+		hw.lagSum = 0
+		hw.lagCount = 0
+	}
+	return alerts
+}
+
+// Run closes a window every Config.Window, sending alerts, until the context is done.
+func (ad *AnomalyDetector) Run(ctx context.Context, send func(context.Context, Alert) error, logger *slog.Logger) {
+	ticker := time.NewTicker(ad.Config.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, alert := range ad.Tick(now) {
+				state := "resolved"
+				if alert.Firing {
+					state = "firing"
+				}
+				anomalyAlertsCounter.WithLabelValues(string(alert.Kind), state).Inc()
+				logger.Warn("anomaly", "kind", alert.Kind, "host", alert.Host, "firing", alert.Firing, "message", alert.Message)
+				if send == nil {
+					continue
+				}
+				if err := send(ctx, alert); err != nil {
+					logger.Error("failed to send alert", "err", err)
+				}
+			}
+		}
+	}
+}
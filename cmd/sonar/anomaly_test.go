@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAnomalyConfig() AnomalyConfig {
+	cfg := DefaultAnomalyConfig
+	cfg.WarmupWindows = 3
+	cfg.QuietWindows = 2
+	return cfg
+}
+
+// runs a window with n commits to app.bsky.feed.like, plus extra commits to the given collection
+func tickWindow(ad *AnomalyDetector, host string, n int, extra string, nextra int, lag time.Duration) []Alert {
+	for i := 0; i < n; i++ {
+		ad.ObserveCommit(host, []string{"app.bsky.feed.like"}, lag)
+	}
+	for i := 0; i < nextra; i++ {
+		ad.ObserveCommit(host, []string{extra}, lag)
+	}
+	return ad.Tick(time.Now())
+}
+
+func TestAnomalySpike(t *testing.T) {
+	assert := assert.New(t)
+	ad := NewAnomalyDetector(testAnomalyConfig())
+
+	for i := 0; i < 3; i++ {
+		assert.Empty(tickWindow(ad, "pds.example.com", 30, "", 0, time.Second))
+	}
+
+	alerts := tickWindow(ad, "pds.example.com", 300, "", 0, time.Second)
+	assert.Len(alerts, 1)
+	assert.Equal(AnomalySpike, alerts[0].Kind)
+	assert.Equal("pds.example.com", alerts[0].Host)
+	assert.True(alerts[0].Firing)
+
+	// still firing; no new alert
+	assert.Empty(tickWindow(ad, "pds.example.com", 300, "", 0, time.Second))
+
+	alerts = tickWindow(ad, "pds.example.com", 30, "", 0, time.Second)
+	assert.Len(alerts, 1)
+	assert.Equal(AnomalySpike, alerts[0].Kind)
+	assert.False(alerts[0].Firing)
+}
+
+func TestAnomalyQuiet(t *testing.T) {
+	assert := assert.New(t)
+	ad := NewAnomalyDetector(testAnomalyConfig())
+
+	for i := 0; i < 3; i++ {
+		// a small host which is often quiet isn't alerted on
+		ad.ObserveEvent("small.example.com")
+		assert.Empty(tickWindow(ad, "pds.example.com", 30, "", 0, time.Second))
+	}
+
+	assert.Empty(ad.Tick(time.Now()))
+	alerts := ad.Tick(time.Now())
+	assert.Len(alerts, 1)
+	assert.Equal(AnomalyQuiet, alerts[0].Kind)
+	assert.Equal("pds.example.com", alerts[0].Host)
+	assert.True(alerts[0].Firing)
+
+	// baseline is kept through the outage
+	for i := 0; i < 20; i++ {
+		assert.Empty(ad.Tick(time.Now()))
+	}
+
+	alerts = tickWindow(ad, "pds.example.com", 30, "", 0, time.Second)
+	assert.Len(alerts, 1)
+	assert.Equal(AnomalyQuiet, alerts[0].Kind)
+	assert.False(alerts[0].Firing)
+}
+
+func TestAnomalyMixAndLag(t *testing.T) {
+	assert := assert.New(t)
+	ad := NewAnomalyDetector(testAnomalyConfig())
+
+	for i := 0; i < 3; i++ {
+		assert.Empty(tickWindow(ad, "pds.example.com", 30, "app.bsky.feed.post", 10, time.Second))
+	}
+
+	alerts := tickWindow(ad, "pds.example.com", 5, "app.bsky.graph.follow", 35, time.Second)
+	assert.Len(alerts, 1)
+	assert.Equal(AnomalyMix, alerts[0].Kind)
+	assert.Contains(alerts[0].Message, "app.bsky.graph.follow")
+
+	alerts = tickWindow(ad, "pds.example.com", 30, "app.bsky.feed.post", 10, 10*time.Minute)
+	assert.Len(alerts, 2)
+	assert.Equal(AnomalyMix, alerts[0].Kind)
+	assert.False(alerts[0].Firing)
+	assert.Equal(AnomalyLag, alerts[1].Kind)
+	assert.True(alerts[1].Firing)
+
+	// unresolved hosts are ignored
+	for i := 0; i < 100; i++ {
+		ad.ObserveCommit(hostUnknown, []string{"app.bsky.feed.like"}, time.Hour)
+	}
+	alerts = ad.Tick(time.Now())
+	assert.Len(alerts, 0)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+const (
+	// label for repos whose PDS has not been resolved (yet)
+	hostUnknown = "unknown"
+	// label for hosts beyond the max number of tracked hosts
+	hostOther = "other"
+)
+
+// HostResolver maps repo DIDs to the hostname of their PDS, for per-host metrics.
+//
+// Resolution happens in the background so event processing never blocks on the network; events for a DID which hasn't been resolved yet are attributed to "unknown".
+type HostResolver struct {
+	Dir    identity.Directory
+	Logger *slog.Logger
+
+	// did -> PDS hostname (or "unknown" if resolution failed)
+	cache *expirable.LRU[string, string]
+
+	queue       chan string
+	pending     map[string]bool
+	pendingLock sync.Mutex
+
+	// hosts which get their own metric label; bounded to keep metric cardinality under control
+	maxHosts  int
+	hosts     map[string]bool
+	hostsLock sync.Mutex
+}
+
+// how long resolved hosts (and failures) are cached; PDS migrations are also picked up from #identity events
+const hostCacheTTL = 6 * time.Hour
+
+func NewHostResolver(dir identity.Directory, logger *slog.Logger, cacheSize, maxHosts int) *HostResolver {
+	return &HostResolver{
+		Dir:      dir,
+		Logger:   logger,
+		cache:    expirable.NewLRU[string, string](cacheSize, nil, hostCacheTTL),
+		queue:    make(chan string, 10_000),
+		pending:  make(map[string]bool),
+		maxHosts: maxHosts,
+		hosts:    make(map[string]bool),
+	}
+}
+
+// HostFor returns the metric label for the PDS host of a DID. Does not block.
+func (hr *HostResolver) HostFor(did string) string {
+	if host, ok := hr.cache.Get(did); ok {
+		if host == hostUnknown {
+			return host
+		}
+		return hr.label(host)
+	}
+	hr.enqueue(did)
+	return hostUnknown
+}
+
+// Invalidate drops the cached host for a DID (eg, on an #identity event, which may indicate a PDS migration) and queues it for re-resolution.
+func (hr *HostResolver) Invalidate(ctx context.Context, did string) {
+	hr.cache.Remove(did)
+	if atid, err := syntax.ParseAtIdentifier(did); err == nil {
+		if err := hr.Dir.Purge(ctx, *atid); err != nil {
+			hr.Logger.Warn("failed to purge identity cache", "did", did, "err", err)
+		}
+	}
+	hr.enqueue(did)
+}
+
+func (hr *HostResolver) enqueue(did string) {
+	hr.pendingLock.Lock()
+	defer hr.pendingLock.Unlock()
+	if hr.pending[did] {
+		return
+	}
+	select {
+	case hr.queue <- did:
+		hr.pending[did] = true
+	default:
+		// queue full; will be retried on the next event for this DID
+		hostResolutionsCounter.WithLabelValues("dropped").Inc()
+	}
+}
+
+// label returns the host itself if it is (or can become) one of the tracked hosts, otherwise "other"
+func (hr *HostResolver) label(host string) string {
+	hr.hostsLock.Lock()
+	defer hr.hostsLock.Unlock()
+	if hr.hosts[host] {
+		return host
+	}
+	if len(hr.hosts) >= hr.maxHosts {
+		return hostOther
+	}
+	hr.hosts[host] = true
+	trackedHostsGauge.Set(float64(len(hr.hosts)))
+	return host
+}
+
+// Run resolves queued DIDs with the given number of workers, until the context is done.
+func (hr *HostResolver) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case did := <-hr.queue:
+					hr.resolve(ctx, did)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (hr *HostResolver) resolve(ctx context.Context, did string) {
+	defer func() {
+		hr.pendingLock.Lock()
+		delete(hr.pending, did)
+		hr.pendingLock.Unlock()
+	}()
+
+	parsed, err := syntax.ParseDID(did)
+	if err != nil {
+		hostResolutionsCounter.WithLabelValues("invalid").Inc()
+		hr.cache.Add(did, hostUnknown)
+		return
+	}
+	ident, err := hr.Dir.LookupDID(ctx, parsed)
+	if err != nil {
+		hostResolutionsCounter.WithLabelValues("error").Inc()
+		hr.Logger.Debug("failed to resolve DID", "did", did, "err", err)
+		hr.cache.Add(did, hostUnknown)
+		return
+	}
+	host := hostUnknown
+	if u, err := url.Parse(ident.PDSEndpoint()); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	hr.cache.Add(did, host)
+	hostResolutionsCounter.WithLabelValues("ok").Inc()
+}
@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers/sequential"
 	"github.com/gorilla/websocket"
@@ -60,6 +61,59 @@ func main() {
 			Value:   "sonar_cursor.json",
 			EnvVars: []string{"SONAR_CURSOR_FILE"},
 		},
+		&cli.BoolFlag{
+			Name:    "per-host",
+			Usage:   "resolve repos to their PDS host, for per-host metrics and anomaly detection",
+			Value:   false,
+			EnvVars: []string{"SONAR_PER_HOST"},
+		},
+		&cli.IntFlag{
+			Name:    "max-hosts",
+			Usage:   "max number of PDS hosts with their own metric label; the rest are grouped as 'other'",
+			Value:   500,
+			EnvVars: []string{"SONAR_MAX_HOSTS"},
+		},
+		&cli.IntFlag{
+			Name:    "host-cache-size",
+			Usage:   "number of DID to PDS host resolutions to cache",
+			Value:   1_000_000,
+			EnvVars: []string{"SONAR_HOST_CACHE_SIZE"},
+		},
+		&cli.IntFlag{
+			Name:    "resolver-workers",
+			Usage:   "number of concurrent DID resolutions",
+			Value:   20,
+			EnvVars: []string{"SONAR_RESOLVER_WORKERS"},
+		},
+		&cli.StringFlag{
+			Name:    "alert-webhook-url",
+			Usage:   "URL to POST anomaly alerts to (Slack compatible); alerts are only logged if not set",
+			EnvVars: []string{"SONAR_ALERT_WEBHOOK_URL"},
+		},
+		&cli.DurationFlag{
+			Name:    "anomaly-window",
+			Usage:   "length of the window per-host event rates are compared over",
+			Value:   DefaultAnomalyConfig.Window,
+			EnvVars: []string{"SONAR_ANOMALY_WINDOW"},
+		},
+		&cli.Float64Flag{
+			Name:    "spike-factor",
+			Usage:   "alert when a host's events in a window exceed its baseline by this factor",
+			Value:   DefaultAnomalyConfig.SpikeFactor,
+			EnvVars: []string{"SONAR_SPIKE_FACTOR"},
+		},
+		&cli.IntFlag{
+			Name:    "quiet-windows",
+			Usage:   "alert when an active host has no events for this many windows",
+			Value:   DefaultAnomalyConfig.QuietWindows,
+			EnvVars: []string{"SONAR_QUIET_WINDOWS"},
+		},
+		&cli.DurationFlag{
+			Name:    "lag-threshold",
+			Usage:   "alert when a host's average lag between commit and receipt by sonar in a window exceeds this",
+			Value:   DefaultAnomalyConfig.LagThreshold,
+			EnvVars: []string{"SONAR_LAG_THRESHOLD"},
+		},
 	}
 
 	app.Action = runSonar
@@ -100,6 +154,32 @@ func runSonar(cctx *cli.Context) error {
 
 	wg := sync.WaitGroup{}
 
+	if cctx.Bool("per-host") {
+		s.Hosts = NewHostResolver(identity.DefaultDirectory(), logger.With("source", "host_resolver"), cctx.Int("host-cache-size"), cctx.Int("max-hosts"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Hosts.Run(ctx, cctx.Int("resolver-workers"))
+		}()
+
+		cfg := DefaultAnomalyConfig
+		cfg.Window = cctx.Duration("anomaly-window")
+		cfg.SpikeFactor = cctx.Float64("spike-factor")
+		cfg.QuietWindows = cctx.Int("quiet-windows")
+		cfg.LagThreshold = cctx.Duration("lag-threshold")
+		s.Anomalies = NewAnomalyDetector(cfg)
+
+		var send func(context.Context, Alert) error
+		if webhookURL := cctx.String("alert-webhook-url"); webhookURL != "" {
+			send = NewAlertWebhook(webhookURL).Send
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Anomalies.Run(ctx, send, logger.With("source", "anomaly_detector"))
+		}()
+	}
+
 	pool := sequential.NewScheduler(u.Host, s.HandleStreamEvent)
 
 	// Start a goroutine to manage the cursor file, saving the current cursor every 5 seconds.
@@ -72,3 +72,39 @@ var lastRecordCreatedEvtProcessedGapGauge = promauto.NewGaugeVec(prometheus.Gaug
 	Name: "sonar_last_record_created_evt_processed_gap",
 	Help: "The gap between the last record's record timestamp and when it was processed by sonar",
 }, []string{"socket_url"})
+
+// Per-PDS host metrics; hosts are resolved from the repo DID, and capped by --max-hosts
+var hostEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sonar_host_events_total",
+	Help: "The total number of firehose events processed, by PDS host of the repo",
+}, []string{"host", "event_type", "socket_url"})
+
+var hostOpsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sonar_host_ops_total",
+	Help: "The total number of repo operations processed, by PDS host of the repo",
+}, []string{"host", "action", "socket_url"})
+
+var hostCommitLagGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sonar_host_commit_lag_seconds",
+	Help: "The gap between the last commit's rev timestamp and when it was received by sonar, by PDS host of the repo",
+}, []string{"host", "socket_url"})
+
+var hostResolutionsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sonar_host_resolutions_total",
+	Help: "The total number of DID to PDS host resolutions, by result",
+}, []string{"result"})
+
+var trackedHostsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "sonar_tracked_hosts",
+	Help: "The number of PDS hosts with their own metric label",
+})
+
+var hostBaselineGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sonar_host_baseline_events",
+	Help: "The baseline number of events per anomaly window, by PDS host",
+}, []string{"host"})
+
+var anomalyAlertsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sonar_anomaly_alerts_total",
+	Help: "The total number of anomaly alerts, by kind and state (firing or resolved)",
+}, []string{"kind", "state"})
@@ -14,6 +14,7 @@ import (
 	"github.com/araddon/dateparse"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 
 	"github.com/bluesky-social/indigo/events"
@@ -28,6 +29,10 @@ type Sonar struct {
 	ProgMux    sync.Mutex
 	Logger     *slog.Logger
 	CursorFile string
+
+	// optional; break metrics out per PDS host, and detect per-host anomalies
+	Hosts     *HostResolver
+	Anomalies *AnomalyDetector
 }
 
 type Progress struct {
@@ -111,6 +116,7 @@ func (s *Sonar) HandleStreamEvent(ctx context.Context, xe *events.XRPCStreamEven
 		return s.HandleRepoCommit(ctx, xe.RepoCommit)
 	case xe.RepoSync != nil:
 		eventsProcessedCounter.WithLabelValues("sync", s.SocketURL).Inc()
+		s.observeHostEvent("sync", xe.RepoSync.Did)
 		now := time.Now()
 		s.ProgMux.Lock()
 		s.Progress.LastSeq = xe.RepoSync.Seq
@@ -118,6 +124,11 @@ func (s *Sonar) HandleStreamEvent(ctx context.Context, xe *events.XRPCStreamEven
 		s.ProgMux.Unlock()
 	case xe.RepoIdentity != nil:
 		eventsProcessedCounter.WithLabelValues("identity", s.SocketURL).Inc()
+		s.observeHostEvent("identity", xe.RepoIdentity.Did)
+		if s.Hosts != nil {
+			// the account may have migrated to another PDS
+			s.Hosts.Invalidate(ctx, xe.RepoIdentity.Did)
+		}
 		now := time.Now()
 		s.ProgMux.Lock()
 		s.Progress.LastSeq = xe.RepoIdentity.Seq
@@ -125,6 +136,7 @@ func (s *Sonar) HandleStreamEvent(ctx context.Context, xe *events.XRPCStreamEven
 		s.ProgMux.Unlock()
 	case xe.RepoAccount != nil:
 		eventsProcessedCounter.WithLabelValues("account", s.SocketURL).Inc()
+		s.observeHostEvent("account", xe.RepoAccount.Did)
 		now := time.Now()
 		s.ProgMux.Lock()
 		s.Progress.LastSeq = xe.RepoAccount.Seq
@@ -142,6 +154,26 @@ func (s *Sonar) HandleStreamEvent(ctx context.Context, xe *events.XRPCStreamEven
 	return nil
 }
 
+// hostFor returns the PDS host label for a repo, or "" if per-host metrics are disabled
+func (s *Sonar) hostFor(did string) string {
+	if s.Hosts == nil {
+		return ""
+	}
+	return s.Hosts.HostFor(did)
+}
+
+// observeHostEvent records a non-commit event in the per-host metrics and anomaly detector
+func (s *Sonar) observeHostEvent(eventType, did string) {
+	host := s.hostFor(did)
+	if host == "" {
+		return
+	}
+	hostEventsCounter.WithLabelValues(host, eventType, s.SocketURL).Inc()
+	if s.Anomalies != nil {
+		s.Anomalies.ObserveEvent(host)
+	}
+}
+
 func (s *Sonar) HandleRepoCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
 	ctx, span := otel.Tracer("sonar").Start(ctx, "HandleRepoCommit")
 	defer span.End()
@@ -179,8 +211,18 @@ func (s *Sonar) HandleRepoCommit(ctx context.Context, evt *comatproto.SyncSubscr
 	lastEvtProcessedAtGauge.WithLabelValues(s.SocketURL).Set(float64(processedAt.UnixNano()))
 	lastEvtCreatedEvtProcessedGapGauge.WithLabelValues(s.SocketURL).Set(float64(processedAt.Sub(evtCreatedAt).Seconds()))
 
+	host := s.hostFor(evt.Repo)
+	var collections []string
+	if host != "" {
+		hostEventsCounter.WithLabelValues(host, "repo_commit", s.SocketURL).Inc()
+	}
+
 	for _, op := range evt.Ops {
 		collection := strings.Split(op.Path, "/")[0]
+		if host != "" {
+			hostOpsCounter.WithLabelValues(host, op.Action, s.SocketURL).Inc()
+			collections = append(collections, collection)
+		}
 
 		ek := repomgr.EventKind(op.Action)
 		log = log.With("action", op.Action, "collection", collection)
@@ -270,6 +312,18 @@ func (s *Sonar) HandleRepoCommit(ctx context.Context, evt *comatproto.SyncSubscr
 		}
 	}
 
+	if host != "" {
+		// the rev is a TID set by the PDS at commit time; compare against our own clock rather than the (also upstream-supplied) event time, so relay delays are included
+		lag := time.Duration(-1)
+		if rev, err := syntax.ParseTID(evt.Rev); err == nil {
+			lag = processedAt.Sub(rev.Time())
+			hostCommitLagGauge.WithLabelValues(host, s.SocketURL).Set(lag.Seconds())
+		}
+		if s.Anomalies != nil {
+			s.Anomalies.ObserveCommit(host, collections, lag)
+		}
+	}
+
 	eventProcessingDurationHistogram.WithLabelValues(s.SocketURL).Observe(time.Since(processedAt).Seconds())
 	return nil
 }
@@ -0,0 +1,241 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+)
+
+// last known commit for the test account
+type repoState struct {
+	Rev  string
+	Data cid.Cid
+}
+
+// a record op which a write is expected to produce
+type expectedOp struct {
+	Action string
+	Path   string
+	// empty if not known (eg, applyWrites responses from some implementations)
+	Cid string
+}
+
+func (op expectedOp) matches(rop *comatproto.SyncSubscribeRepos_RepoOp) bool {
+	if rop.Action != op.Action || rop.Path != op.Path {
+		return false
+	}
+	if op.Cid != "" && (rop.Cid == nil || rop.Cid.String() != op.Cid) {
+		return false
+	}
+	return true
+}
+
+// returns true if every expected op is in the commit
+func commitMatches(evt *comatproto.SyncSubscribeRepos_Commit, expected []expectedOp) bool {
+	for _, op := range expected {
+		found := false
+		for _, rop := range evt.Ops {
+			if op.matches(rop) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// runs all the checks on a #commit event, records results, and advances the account state
+func (h *harness) checkCommit(ctx context.Context, step string, evt *comatproto.SyncSubscribeRepos_Commit) {
+	rep := h.report
+
+	commit, commitCID, err := repo.LoadCommitFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err == nil {
+		err = checkCommitObject(commit, commitCID, evt.Repo, evt.Rev)
+	}
+	if err == nil && evt.Commit.String() != commitCID.String() {
+		err = fmt.Errorf("commit field (%s) does not match CAR root (%s)", evt.Commit, commitCID)
+	}
+	if err == nil && (evt.TooBig || evt.Rebase) {
+		err = fmt.Errorf("deprecated tooBig or rebase flag set")
+	}
+	if _, terr := syntax.ParseDatetime(evt.Time); err == nil && terr != nil {
+		err = fmt.Errorf("time field: %w", terr)
+	}
+	rep.add(step, "commit-object", err)
+	if commit == nil {
+		// nothing else can be checked
+		return
+	}
+
+	if h.cfg.Dir != nil {
+		rep.add(step, "signature", h.verifySignature(ctx, commit))
+	}
+
+	if h.state != nil {
+		var err error
+		if evt.Rev <= h.state.Rev {
+			err = fmt.Errorf("rev %s is not after previous rev %s", evt.Rev, h.state.Rev)
+		}
+		rep.add(step, "rev-order", err)
+
+		err = nil
+		if evt.Since == nil {
+			err = fmt.Errorf("since field missing")
+		} else if *evt.Since != h.state.Rev {
+			err = fmt.Errorf("since (%s) does not match previous rev (%s)", *evt.Since, h.state.Rev)
+		}
+		rep.add(step, "since", err)
+	}
+
+	err = nil
+	if evt.PrevData == nil {
+		err = fmt.Errorf("prevData field missing")
+	} else if h.state != nil && evt.PrevData.String() != h.state.Data.String() {
+		err = fmt.Errorf("prevData (%s) does not match previous commit data (%s)", evt.PrevData, h.state.Data)
+	}
+	rep.add(step, "prev-data", err)
+
+	rep.add(step, "ops", checkOps(evt))
+	rep.add(step, "record-blocks", checkRecordBlocks(ctx, evt))
+	rep.add(step, "mst-inversion", checkInversion(ctx, evt))
+
+	h.state = &repoState{Rev: commit.Rev, Data: commit.Data}
+}
+
+func checkCommitObject(commit *repo.Commit, commitCID *cid.Cid, did, rev string) error {
+	if err := commit.VerifyStructure(); err != nil {
+		return err
+	}
+	if commit.DID != did {
+		return fmt.Errorf("commit DID (%s) does not match event (%s)", commit.DID, did)
+	}
+	if commit.Rev != rev {
+		return fmt.Errorf("commit rev (%s) does not match event (%s)", commit.Rev, rev)
+	}
+	return nil
+}
+
+func (h *harness) verifySignature(ctx context.Context, commit *repo.Commit) error {
+	ident, err := h.cfg.Dir.LookupDID(ctx, h.cfg.DID)
+	if err != nil {
+		return fmt.Errorf("resolving identity: %w", err)
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return err
+	}
+	return commit.VerifySignature(pub)
+}
+
+// sync 1.1 ops include the previous CID for updates and deletes
+func checkOps(evt *comatproto.SyncSubscribeRepos_Commit) error {
+	if len(evt.Ops) == 0 {
+		return fmt.Errorf("no ops in commit")
+	}
+	for _, op := range evt.Ops {
+		if _, _, err := syntax.ParseRepoPath(op.Path); err != nil {
+			return fmt.Errorf("invalid op path %q: %w", op.Path, err)
+		}
+		switch op.Action {
+		case "create":
+			if op.Cid == nil || op.Prev != nil {
+				return fmt.Errorf("create op for %s must have cid and no prev", op.Path)
+			}
+		case "update":
+			if op.Cid == nil || op.Prev == nil {
+				return fmt.Errorf("update op for %s must have cid and prev", op.Path)
+			}
+		case "delete":
+			if op.Cid != nil || op.Prev == nil {
+				return fmt.Errorf("delete op for %s must have prev and no cid", op.Path)
+			}
+		default:
+			return fmt.Errorf("unknown op action: %s", op.Action)
+		}
+	}
+	return nil
+}
+
+// every created or updated record must be included in the blocks
+func checkRecordBlocks(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	_, r, err := repo.LoadRepoFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return err
+	}
+	for _, op := range evt.Ops {
+		if op.Cid == nil {
+			continue
+		}
+		if _, err := r.RecordStore.Get(ctx, cid.Cid(*op.Cid)); err != nil {
+			return fmt.Errorf("record block missing for %s (%s)", op.Path, op.Cid)
+		}
+	}
+	return nil
+}
+
+var errNotInvertible = errors.New("commit can not be inverted")
+
+// inverting the ops against the MST in the blocks must result in prevData
+func checkInversion(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	// these cases are silently skipped by repo.VerifyCommitMessage
+	if evt.PrevData == nil {
+		return fmt.Errorf("%w: prevData missing", errNotInvertible)
+	}
+	for _, op := range evt.Ops {
+		if (op.Action == "update" || op.Action == "delete") && op.Prev == nil {
+			return fmt.Errorf("%w: %s op without prev", errNotInvertible, op.Action)
+		}
+	}
+	_, err := repo.VerifyCommitMessage(ctx, evt)
+	return err
+}
+
+// checks a #sync event, and resets the account state to its commit
+func (h *harness) checkSync(ctx context.Context, step string, evt *comatproto.SyncSubscribeRepos_Sync) {
+	commit, commitCID, err := repo.LoadCommitFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err == nil {
+		err = checkCommitObject(commit, commitCID, evt.Did, evt.Rev)
+	}
+	if err == nil && h.state != nil && evt.Rev < h.state.Rev {
+		err = fmt.Errorf("#sync rev %s is before previous rev %s", evt.Rev, h.state.Rev)
+	}
+	h.syncSeen = true
+	h.report.add(step, "sync-commit", err)
+	if commit == nil {
+		return
+	}
+	if h.cfg.Dir != nil {
+		h.report.add(step, "signature", h.verifySignature(ctx, commit))
+	}
+	h.state = &repoState{Rev: commit.Rev, Data: commit.Data}
+}
+
+// checks an #account event against the expected status ("active", "deactivated", etc)
+func checkAccount(evt *comatproto.SyncSubscribeRepos_Account, status string) error {
+	if status == "active" {
+		if !evt.Active {
+			return fmt.Errorf("expected active account, got status %v", evt.Status)
+		}
+		if evt.Status != nil && *evt.Status != "active" {
+			return fmt.Errorf("active account with status %s", *evt.Status)
+		}
+		return nil
+	}
+	if evt.Active {
+		return fmt.Errorf("expected inactive account")
+	}
+	if evt.Status == nil || *evt.Status != status {
+		return fmt.Errorf("expected status %s, got %v", status, evt.Status)
+	}
+	return nil
+}
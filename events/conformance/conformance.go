@@ -0,0 +1,311 @@
+// Package conformance is a firehose ("sync 1.1") conformance harness, which can be run against any PDS or relay.
+//
+// The harness subscribes to a `com.atproto.sync.subscribeRepos` endpoint, writes records to a test account through a PDS's XRPC API, and checks the resulting events: commit objects and signatures, `rev`/`since` chaining, `prevData`, block completeness for MST inversion, `#sync` handling, and (optionally) `#account` status transitions.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultCollection is the record collection test records are written to, unless configured otherwise
+var DefaultCollection = syntax.NSID("com.example.conformance.record")
+
+// DefaultTimeout is how long to wait for the event corresponding to each write, unless configured otherwise
+var DefaultTimeout = 30 * time.Second
+
+type Config struct {
+	// Host with the `subscribeRepos` endpoint: a PDS, or a relay which is subscribed to the PDS. HTTP(S) and WS(S) URLs are both accepted.
+	StreamHost string
+	// Authenticated API client for the test account's PDS
+	Client lexutil.LexClient
+	// DID of the test account
+	DID syntax.DID
+	// Optional; when set, commit signatures are verified against the account's current key
+	Dir identity.Directory
+	// Collection test records are written to. Some PDS implementations only accept known record types; the records written are valid `app.bsky.feed.post` records if that collection is used.
+	Collection syntax.NSID
+	// How long to wait for the event corresponding to each write
+	Timeout time.Duration
+	// Whether to deactivate and re-activate the account, checking #account (and any #sync) events
+	AccountStatus bool
+	// Extra headers for the websocket connection (eg, User-Agent)
+	Header http.Header
+	Logger *slog.Logger
+}
+
+// Result is the outcome of a single check, for a single event or step
+type Result struct {
+	Step  string `json:"step"`
+	Check string `json:"check"`
+	Pass  bool   `json:"pass"`
+	// the check could not be exercised (eg, the host never emitted the relevant event). Skipped checks are not failures; Detail says why
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (r Result) String() string {
+	status := "PASS"
+	if r.Skipped {
+		status = "SKIP"
+	} else if !r.Pass {
+		status = "FAIL"
+	}
+	if r.Detail != "" {
+		return fmt.Sprintf("%s %s/%s: %s", status, r.Step, r.Check, r.Detail)
+	}
+	return fmt.Sprintf("%s %s/%s", status, r.Step, r.Check)
+}
+
+type Report struct {
+	StreamHost string   `json:"streamHost"`
+	DID        string   `json:"did"`
+	Results    []Result `json:"results"`
+}
+
+// OK returns true if every check passed
+func (r *Report) OK() bool {
+	return len(r.Failures()) == 0
+}
+
+func (r *Report) Failures() []Result {
+	var out []Result
+	for _, res := range r.Results {
+		if !res.Pass {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// Find returns all results for the given check name, across all steps
+func (r *Report) Find(check string) []Result {
+	var out []Result
+	for _, res := range r.Results {
+		if res.Check == check {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+type harness struct {
+	cfg    Config
+	logger *slog.Logger
+	report *Report
+
+	// events for the test account, in stream order
+	incoming chan *events.XRPCStreamEvent
+
+	// last commit state seen for the test account
+	state *repoState
+	// whether any #sync event was checked
+	syncSeen bool
+
+	seqLk      sync.Mutex
+	lastSeq    int64
+	seqErrors  []string
+	streamErr  error
+	streamDone chan struct{}
+}
+
+// Run executes the conformance steps, and returns a report. An error is only returned if the harness itself could not run (eg, connection failure); failed checks are recorded in the report.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("conformance: API client is required")
+	}
+	if cfg.DID == "" {
+		return nil, fmt.Errorf("conformance: account DID is required")
+	}
+	if cfg.Collection == "" {
+		cfg.Collection = DefaultCollection
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	h := &harness{
+		cfg:    cfg,
+		logger: cfg.Logger.With("system", "conformance", "did", cfg.DID),
+		report: &Report{
+			StreamHost: cfg.StreamHost,
+			DID:        cfg.DID.String(),
+		},
+		incoming:   make(chan *events.XRPCStreamEvent, 1000),
+		streamDone: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	con, err := h.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	if err := h.runSteps(ctx); err != nil {
+		return h.report, err
+	}
+	h.checkTrailing(ctx)
+
+	// the harness has no portable way to force a repo reset (which is what #sync events are for), so this is only checked if the host happens to emit one
+	if !h.syncSeen {
+		h.report.skip("stream", "sync-commit", "no #sync event was emitted, so #sync handling (after a repo reset) was not checked")
+	}
+
+	h.seqLk.Lock()
+	defer h.seqLk.Unlock()
+	if len(h.seqErrors) == 0 {
+		h.report.add("stream", "seq-order", nil)
+	}
+	for _, msg := range h.seqErrors {
+		h.report.add("stream", "seq-order", errors.New(msg))
+	}
+	return h.report, nil
+}
+
+func (r *Report) skip(step, check, detail string) {
+	r.Results = append(r.Results, Result{Step: step, Check: check, Pass: true, Skipped: true, Detail: detail})
+}
+
+func (r *Report) add(step, check string, err error) {
+	res := Result{Step: step, Check: check, Pass: err == nil}
+	if err != nil {
+		res.Detail = err.Error()
+	}
+	r.Results = append(r.Results, res)
+}
+
+func streamURL(host string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream host URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("unsupported stream host URL scheme: %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+	return u.String(), nil
+}
+
+// connects to the stream host (at the current cursor), and starts consuming events in the background
+func (h *harness) subscribe(ctx context.Context) (*websocket.Conn, error) {
+	u, err := streamURL(h.cfg.StreamHost)
+	if err != nil {
+		return nil, err
+	}
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, u, h.cfg.Header)
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to stream host: %w", err)
+	}
+
+	did := h.cfg.DID.String()
+	forward := func(evtDID string, seq int64, xev *events.XRPCStreamEvent) error {
+		h.checkSeq(seq)
+		if evtDID == did {
+			h.incoming <- xev
+		}
+		return nil
+	}
+	rsc := &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			return forward(evt.Repo, evt.Seq, &events.XRPCStreamEvent{RepoCommit: evt})
+		},
+		RepoSync: func(evt *comatproto.SyncSubscribeRepos_Sync) error {
+			return forward(evt.Did, evt.Seq, &events.XRPCStreamEvent{RepoSync: evt})
+		},
+		RepoIdentity: func(evt *comatproto.SyncSubscribeRepos_Identity) error {
+			return forward(evt.Did, evt.Seq, &events.XRPCStreamEvent{RepoIdentity: evt})
+		},
+		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
+			return forward(evt.Did, evt.Seq, &events.XRPCStreamEvent{RepoAccount: evt})
+		},
+	}
+	sched := sequential.NewScheduler("conformance", rsc.EventHandler)
+	go func() {
+		defer close(h.streamDone)
+		err := events.HandleRepoStream(ctx, con, sched, h.logger)
+		if err != nil && ctx.Err() == nil {
+			h.seqLk.Lock()
+			h.streamErr = err
+			h.seqLk.Unlock()
+		}
+	}()
+	return con, nil
+}
+
+// sequence numbers must strictly increase across the whole stream
+func (h *harness) checkSeq(seq int64) {
+	h.seqLk.Lock()
+	defer h.seqLk.Unlock()
+	if seq <= h.lastSeq {
+		h.seqErrors = append(h.seqErrors, fmt.Sprintf("seq %d after %d", seq, h.lastSeq))
+	}
+	h.lastSeq = seq
+}
+
+// waits for the next event for the test account, failing after the configured timeout
+func (h *harness) next(ctx context.Context, timeout time.Duration) (*events.XRPCStreamEvent, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case xev := <-h.incoming:
+		return xev, nil
+	case <-h.streamDone:
+		// events received before the stream closed are still processed
+		select {
+		case xev := <-h.incoming:
+			return xev, nil
+		default:
+		}
+		h.seqLk.Lock()
+		defer h.seqLk.Unlock()
+		if h.streamErr != nil {
+			return nil, fmt.Errorf("event stream failed: %w", h.streamErr)
+		}
+		return nil, fmt.Errorf("event stream closed")
+	case <-t.C:
+		return nil, errTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var errTimeout = errors.New("timed out waiting for event")
+
+// checks any events for the test account which were received after the last step's event, without waiting for more
+func (h *harness) checkTrailing(ctx context.Context) {
+	for {
+		select {
+		case xev := <-h.incoming:
+			h.checkEvent(ctx, "trailing", xev)
+		default:
+			return
+		}
+	}
+}
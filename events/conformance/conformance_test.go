@@ -0,0 +1,180 @@
+package conformance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	itest "github.com/bluesky-social/indigo/testing"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestStreamURL(t *testing.T) {
+	cases := map[string]string{
+		"http://localhost:2583":     "ws://localhost:2583/xrpc/com.atproto.sync.subscribeRepos",
+		"https://relay.example.com": "wss://relay.example.com/xrpc/com.atproto.sync.subscribeRepos",
+		"wss://pds.example.com/":    "wss://pds.example.com/xrpc/com.atproto.sync.subscribeRepos",
+	}
+	for host, expected := range cases {
+		u, err := streamURL(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != expected {
+			t.Fatalf("expected %s, got %s", expected, u)
+		}
+	}
+	if _, err := streamURL("ftp://example.com"); err == nil {
+		t.Fatal("expected error for unsupported scheme")
+	}
+}
+
+func TestCheckOps(t *testing.T) {
+	c, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := lexutil.LexLink(c)
+	path := "app.bsky.feed.post/3l3qo2vuowo2b"
+
+	cases := []struct {
+		op    comatproto.SyncSubscribeRepos_RepoOp
+		valid bool
+	}{
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: path, Cid: &lc}, true},
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "update", Path: path, Cid: &lc, Prev: &lc}, true},
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "delete", Path: path, Prev: &lc}, true},
+		// legacy (pre sync 1.1) ops
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "update", Path: path, Cid: &lc}, false},
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "delete", Path: path}, false},
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: "not-a-path", Cid: &lc}, false},
+		{comatproto.SyncSubscribeRepos_RepoOp{Action: "frobnicate", Path: path, Cid: &lc}, false},
+	}
+	for _, tc := range cases {
+		evt := &comatproto.SyncSubscribeRepos_Commit{Ops: []*comatproto.SyncSubscribeRepos_RepoOp{&tc.op}}
+		err := checkOps(evt)
+		if tc.valid && err != nil {
+			t.Fatalf("expected valid op (%s %s): %s", tc.op.Action, tc.op.Path, err)
+		}
+		if !tc.valid && err == nil {
+			t.Fatalf("expected invalid op (%s %s)", tc.op.Action, tc.op.Path)
+		}
+	}
+}
+
+func TestCheckAccount(t *testing.T) {
+	deactivated := "deactivated"
+	if err := checkAccount(&comatproto.SyncSubscribeRepos_Account{Active: true}, "active"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkAccount(&comatproto.SyncSubscribeRepos_Account{Active: false, Status: &deactivated}, "deactivated"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkAccount(&comatproto.SyncSubscribeRepos_Account{Active: true}, "deactivated"); err == nil {
+		t.Fatal("expected error for active account")
+	}
+	if err := checkAccount(&comatproto.SyncSubscribeRepos_Account{Active: false}, "deactivated"); err == nil {
+		t.Fatal("expected error for missing status")
+	}
+}
+
+// The legacy PDS in this repo does not emit sync 1.1 fields (prevData, op prev), so those checks are expected to fail, while the rest of the harness runs through.
+func TestRunLegacyPDS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	didr := itest.TestPLC(t)
+	p := itest.MustSetupPDS(t, ".tpds", didr)
+	p.Run(t)
+
+	c := &xrpc.Client{Host: p.HTTPHost()}
+	email := "alice@example.com"
+	pass := "password"
+	out, err := comatproto.ServerCreateAccount(ctx, c, &comatproto.ServerCreateAccount_Input{
+		Email:    &email,
+		Handle:   "alice.tpds",
+		Password: &pass,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Auth = &xrpc.AuthInfo{AccessJwt: out.AccessJwt, Did: out.Did, Handle: out.Handle}
+
+	report, err := Run(ctx, Config{
+		StreamHost: p.HTTPHost(),
+		Client:     c,
+		DID:        syntax.DID(out.Did),
+		// the legacy PDS only accepts known record types
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		Timeout:    5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range report.Results {
+		t.Log(res)
+	}
+
+	mustPass := []string{"write", "event", "commit-object", "record-blocks", "rev-order", "since", "seq-order"}
+	for _, check := range mustPass {
+		results := report.Find(check)
+		if len(results) == 0 {
+			t.Fatalf("no results for check %s", check)
+		}
+		for _, res := range results {
+			if !res.Pass {
+				t.Fatalf("expected check to pass: %s", res)
+			}
+		}
+	}
+
+	// create, update, create, apply-writes, delete, cleanup
+	if n := len(report.Find("event")); n != 6 {
+		t.Fatalf("expected 6 steps, got %d", n)
+	}
+	for _, res := range report.Find("prev-data") {
+		if res.Pass {
+			t.Fatalf("expected prev-data check to fail against legacy PDS: %s", res)
+		}
+	}
+	if report.OK() {
+		t.Fatal("expected report to have failures")
+	}
+
+	// no repo reset happens, so #sync handling is reported as not checked
+	syncResults := report.Find("sync-commit")
+	if len(syncResults) != 1 || !syncResults[0].Skipped || !syncResults[0].Pass {
+		t.Fatalf("expected skipped sync-commit check, got %v", syncResults)
+	}
+}
+
+func TestNextDrainsAfterClose(t *testing.T) {
+	h := &harness{
+		incoming:   make(chan *events.XRPCStreamEvent, 10),
+		streamDone: make(chan struct{}),
+	}
+	for _, seq := range []int64{1, 2} {
+		h.incoming <- &events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Seq: seq}}
+	}
+	close(h.streamDone)
+
+	// buffered events are returned before the stream is treated as closed
+	for _, seq := range []int64{1, 2} {
+		xev, err := h.next(context.Background(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if xev.RepoIdentity.Seq != seq {
+			t.Fatalf("expected event %d, got %d", seq, xev.RepoIdentity.Seq)
+		}
+	}
+	if _, err := h.next(context.Background(), time.Second); err == nil {
+		t.Fatal("expected error for closed stream")
+	}
+}
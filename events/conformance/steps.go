@@ -0,0 +1,244 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+// errStepFailed indicates that a step could not be completed, and later steps should be skipped
+var errStepFailed = errors.New("step failed")
+
+type recordRef struct {
+	Path string
+	Rkey string
+	Cid  string
+}
+
+func (h *harness) runSteps(ctx context.Context) error {
+	err := h.steps(ctx)
+	if errors.Is(err, errStepFailed) {
+		return nil
+	}
+	return err
+}
+
+func (h *harness) steps(ctx context.Context) error {
+	first, err := h.createRecord(ctx, "create")
+	if err != nil {
+		return err
+	}
+
+	rkey := syntax.RecordKey(first.Rkey)
+	if _, err := h.putRecord(ctx, "update", rkey); err != nil {
+		return err
+	}
+
+	second, err := h.createRecord(ctx, "create-second")
+	if err != nil {
+		return err
+	}
+
+	third, err := h.applyWrites(ctx, "apply-writes", syntax.RecordKey(second.Rkey))
+	if err != nil {
+		return err
+	}
+
+	if err := h.deleteRecord(ctx, "delete", rkey); err != nil {
+		return err
+	}
+
+	if h.cfg.AccountStatus {
+		if err := h.setAccountStatus(ctx, "deactivate", "deactivated"); err != nil {
+			return err
+		}
+		if err := h.setAccountStatus(ctx, "activate", "active"); err != nil {
+			return err
+		}
+		after, err := h.createRecord(ctx, "create-after-activate")
+		if err != nil {
+			return err
+		}
+		if err := h.deleteRecord(ctx, "cleanup", syntax.RecordKey(after.Rkey)); err != nil {
+			return err
+		}
+	}
+
+	return h.deleteRecord(ctx, "cleanup", syntax.RecordKey(third.Rkey))
+}
+
+func (h *harness) record(text string) map[string]any {
+	return map[string]any{
+		"$type":     h.cfg.Collection.String(),
+		"text":      text,
+		"createdAt": syntax.DatetimeNow().String(),
+	}
+}
+
+func (h *harness) path(rkey syntax.RecordKey) string {
+	return h.cfg.Collection.String() + "/" + rkey.String()
+}
+
+// records the outcome of an API call; returns errStepFailed if it failed
+func (h *harness) write(step string, err error) error {
+	h.report.add(step, "write", err)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", errStepFailed, step, err)
+	}
+	return nil
+}
+
+func refFromURI(uri, cid string) (*recordRef, error) {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record URI in response: %w", err)
+	}
+	return &recordRef{
+		Path: aturi.Collection().String() + "/" + aturi.RecordKey().String(),
+		Rkey: aturi.RecordKey().String(),
+		Cid:  cid,
+	}, nil
+}
+
+func (h *harness) createRecord(ctx context.Context, step string) (*recordRef, error) {
+	body := map[string]any{
+		"repo":       h.cfg.DID.String(),
+		"collection": h.cfg.Collection.String(),
+		"rkey":       syntax.NewTIDNow(0).String(),
+		"record":     h.record("conformance test record"),
+	}
+	var out comatproto.RepoCreateRecord_Output
+	err := h.cfg.Client.LexDo(ctx, lexutil.Procedure, "application/json", "com.atproto.repo.createRecord", nil, body, &out)
+	var ref *recordRef
+	if err == nil {
+		ref, err = refFromURI(out.Uri, out.Cid)
+	}
+	if err := h.write(step, err); err != nil {
+		return nil, err
+	}
+	return ref, h.waitCommit(ctx, step, []expectedOp{{Action: "create", Path: ref.Path, Cid: ref.Cid}})
+}
+
+func (h *harness) putRecord(ctx context.Context, step string, rkey syntax.RecordKey) (*recordRef, error) {
+	body := map[string]any{
+		"repo":       h.cfg.DID.String(),
+		"collection": h.cfg.Collection.String(),
+		"rkey":       rkey.String(),
+		"record":     h.record("updated conformance test record"),
+	}
+	var out comatproto.RepoPutRecord_Output
+	err := h.cfg.Client.LexDo(ctx, lexutil.Procedure, "application/json", "com.atproto.repo.putRecord", nil, body, &out)
+	var ref *recordRef
+	if err == nil {
+		ref, err = refFromURI(out.Uri, out.Cid)
+	}
+	if err := h.write(step, err); err != nil {
+		return nil, err
+	}
+	return ref, h.waitCommit(ctx, step, []expectedOp{{Action: "update", Path: ref.Path, Cid: ref.Cid}})
+}
+
+func (h *harness) deleteRecord(ctx context.Context, step string, rkey syntax.RecordKey) error {
+	body := map[string]any{
+		"repo":       h.cfg.DID.String(),
+		"collection": h.cfg.Collection.String(),
+		"rkey":       rkey.String(),
+	}
+	err := h.cfg.Client.LexDo(ctx, lexutil.Procedure, "application/json", "com.atproto.repo.deleteRecord", nil, body, nil)
+	if err := h.write(step, err); err != nil {
+		return err
+	}
+	return h.waitCommit(ctx, step, []expectedOp{{Action: "delete", Path: h.path(rkey)}})
+}
+
+// creates one record and deletes another, in a single commit
+func (h *harness) applyWrites(ctx context.Context, step string, deleteRkey syntax.RecordKey) (*recordRef, error) {
+	createRkey := syntax.RecordKey(syntax.NewTIDNow(0).String())
+	body := map[string]any{
+		"repo": h.cfg.DID.String(),
+		"writes": []map[string]any{
+			{
+				"$type":      "com.atproto.repo.applyWrites#create",
+				"collection": h.cfg.Collection.String(),
+				"rkey":       createRkey.String(),
+				"value":      h.record("batch conformance test record"),
+			},
+			{
+				"$type":      "com.atproto.repo.applyWrites#delete",
+				"collection": h.cfg.Collection.String(),
+				"rkey":       deleteRkey.String(),
+			},
+		},
+	}
+	// not all implementations return results, so the record CID is not checked
+	err := h.cfg.Client.LexDo(ctx, lexutil.Procedure, "application/json", "com.atproto.repo.applyWrites", nil, body, nil)
+	if err := h.write(step, err); err != nil {
+		return nil, err
+	}
+	ref := &recordRef{Path: h.path(createRkey), Rkey: createRkey.String()}
+	return ref, h.waitCommit(ctx, step, []expectedOp{
+		{Action: "create", Path: ref.Path},
+		{Action: "delete", Path: h.path(deleteRkey)},
+	})
+}
+
+func (h *harness) setAccountStatus(ctx context.Context, step, status string) error {
+	endpoint := "com.atproto.server.activateAccount"
+	var body any
+	if status != "active" {
+		endpoint = "com.atproto.server.deactivateAccount"
+		body = map[string]any{}
+	}
+	err := h.cfg.Client.LexDo(ctx, lexutil.Procedure, "application/json", endpoint, nil, body, nil)
+	if err := h.write(step, err); err != nil {
+		return err
+	}
+	return h.wait(ctx, step, func(xev *events.XRPCStreamEvent) bool {
+		if xev.RepoAccount == nil {
+			return false
+		}
+		h.report.add(step, "account-status", checkAccount(xev.RepoAccount, status))
+		return true
+	})
+}
+
+// waits for a #commit containing the expected ops
+func (h *harness) waitCommit(ctx context.Context, step string, expected []expectedOp) error {
+	return h.wait(ctx, step, func(xev *events.XRPCStreamEvent) bool {
+		return xev.RepoCommit != nil && commitMatches(xev.RepoCommit, expected)
+	})
+}
+
+// checks a #commit or #sync event; other events are only checked by the step waiting for them
+func (h *harness) checkEvent(ctx context.Context, step string, xev *events.XRPCStreamEvent) {
+	switch {
+	case xev.RepoCommit != nil:
+		h.checkCommit(ctx, step, xev.RepoCommit)
+	case xev.RepoSync != nil:
+		h.checkSync(ctx, step, xev.RepoSync)
+	}
+}
+
+// processes events for the test account until one matches. Every #commit and #sync is checked along the way. A timeout is recorded as a failed check, and skips the remaining steps.
+func (h *harness) wait(ctx context.Context, step string, match func(xev *events.XRPCStreamEvent) bool) error {
+	for {
+		xev, err := h.next(ctx, h.cfg.Timeout)
+		if errors.Is(err, errTimeout) {
+			h.report.add(step, "event", err)
+			return fmt.Errorf("%w: %s: %w", errStepFailed, step, err)
+		}
+		if err != nil {
+			return err
+		}
+		h.checkEvent(ctx, step, xev)
+		if match(xev) {
+			h.report.add(step, "event", nil)
+			return nil
+		}
+	}
+}
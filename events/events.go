@@ -226,6 +226,12 @@ func (evt *XRPCStreamEvent) Serialize(wc io.Writer) error {
 	case evt.RepoInfo != nil:
 		header.MsgType = "#info"
 		obj = evt.RepoInfo
+	case evt.LabelLabels != nil:
+		header.MsgType = "#labels"
+		obj = evt.LabelLabels
+	case evt.LabelInfo != nil:
+		header.MsgType = "#info"
+		obj = evt.LabelInfo
 	default:
 		return fmt.Errorf("unrecognized event kind")
 	}
@@ -447,6 +453,8 @@ func (evt *XRPCStreamEvent) Sequence() int64 {
 		return evt.RepoIdentity.Seq
 	case evt.RepoAccount != nil:
 		return evt.RepoAccount.Seq
+	case evt.LabelLabels != nil:
+		return evt.LabelLabels.Seq
 	case evt.RepoInfo != nil:
 		return -1
 	case evt.Error != nil:
@@ -468,6 +476,8 @@ func (evt *XRPCStreamEvent) GetSequence() (int64, bool) {
 		return evt.RepoIdentity.Seq, true
 	case evt.RepoAccount != nil:
 		return evt.RepoAccount.Seq, true
+	case evt.LabelLabels != nil:
+		return evt.LabelLabels.Seq, true
 	case evt.RepoInfo != nil:
 		return -1, false
 	case evt.Error != nil:
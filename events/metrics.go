@@ -1,19 +1,21 @@
 package events
 
 import (
+	"github.com/bluesky-social/indigo/util/promutil"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var eventsFromStreamCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+var eventsFromStreamCounter = promutil.RegisterShared(prometheus.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_repo_stream_events_received_total",
 	Help: "Total number of events received from the stream",
-}, []string{"remote_addr"})
+}, []string{"remote_addr"}))
 
-var bytesFromStreamCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+var bytesFromStreamCounter = promutil.RegisterShared(prometheus.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_repo_stream_bytes_total",
 	Help: "Total bytes received from the stream",
-}, []string{"remote_addr"})
+}, []string{"remote_addr"}))
 
 var eventsEnqueued = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_events_enqueued_for_broadcast_total",
@@ -24,3 +26,8 @@ var eventsBroadcast = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_events_broadcast_total",
 	Help: "Total number of events broadcast to subscribers",
 }, []string{"pool"})
+
+var verifiedEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_repo_stream_verified_events_total",
+	Help: "Total number of firehose events checked by a RepoVerifier, by result",
+}, []string{"result"})
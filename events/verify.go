@@ -0,0 +1,305 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrInvalidCommit is returned for events which fail verification (signature, structure, or MST inversion), and should be dropped
+var ErrInvalidCommit = errors.New("invalid commit")
+
+// ErrStaleCommit is returned for events with a rev at or before the last verified rev for the account, and should be dropped
+var ErrStaleCommit = errors.New("stale commit")
+
+// ErrRepoGap is returned for valid events which do not chain from the last verified commit for the account (eg, events were missed). The event itself can be processed, but the repo should be re-synced.
+var ErrRepoGap = errors.New("gap in repo commit chain")
+
+type verifiedRepo struct {
+	rev  string
+	data cid.Cid
+}
+
+// RepoVerifier does inductive ("sync 1.1") verification of firehose events, for consumers which subscribe directly to PDS hosts, or otherwise don't trust the upstream.
+//
+// For each account, it tracks the rev and MST root (data CID) of the last verified commit. Each #commit must be signed by the account's current key (if a directory is configured), must invert to its own `prevData`, and `prevData` must match the previously verified commit. The first event seen for an account is verified on its own and becomes the baseline.
+//
+// State is kept for a limited number of recently active accounts; when an account is evicted, its next event becomes the new baseline.
+type RepoVerifier struct {
+	// Optional; if set, commit signatures are verified
+	Dir identity.Directory
+	// Pass through legacy commits (missing `prevData` or op `prev` CIDs), which can not be inverted. They reset the account's baseline. If false, they are rejected as invalid.
+	AllowLegacy bool
+
+	// held while an account's state is checked against an event and updated, so that concurrent events for the same account are serialized
+	lk    sync.Mutex
+	repos *lru.Cache[string, *verifiedRepo]
+
+	logger *slog.Logger
+}
+
+// DefaultVerifierAccounts is the number of accounts RepoVerifier keeps state for, if not specified
+const DefaultVerifierAccounts = 1_000_000
+
+// NewRepoVerifier creates a verifier which keeps state for up to maxAccounts accounts (DefaultVerifierAccounts if zero).
+func NewRepoVerifier(dir identity.Directory, maxAccounts int) *RepoVerifier {
+	if maxAccounts <= 0 {
+		maxAccounts = DefaultVerifierAccounts
+	}
+	repos, err := lru.New[string, *verifiedRepo](maxAccounts)
+	if err != nil {
+		// only fails for non-positive size
+		panic(err)
+	}
+	return &RepoVerifier{
+		Dir:    dir,
+		repos:  repos,
+		logger: slog.Default().With("system", "repo-verifier"),
+	}
+}
+
+// SetState records the last known commit for an account (eg, from a backfill or persisted state). This becomes the baseline for subsequent events.
+func (v *RepoVerifier) SetState(did, rev string, data cid.Cid) {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	v.repos.Add(did, &verifiedRepo{rev: rev, data: data})
+}
+
+// Forget drops any state for the account; the next event becomes the baseline
+func (v *RepoVerifier) Forget(did string) {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	v.repos.Remove(did)
+}
+
+// checkStale returns an error if the account has already been verified at or past rev. Must be called with lk held.
+func (v *RepoVerifier) checkStale(did, rev string) (*verifiedRepo, error) {
+	prev, _ := v.repos.Get(did)
+	if prev != nil && rev <= prev.rev {
+		return prev, fmt.Errorf("%w: rev %s not after %s", ErrStaleCommit, rev, prev.rev)
+	}
+	return prev, nil
+}
+
+// peekStale is checkStale for early rejection, before an event is verified; it is checked again (with the lock held throughout) before the state is updated
+func (v *RepoVerifier) peekStale(did, rev string) error {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	_, err := v.checkStale(did, rev)
+	return err
+}
+
+func (v *RepoVerifier) verifySignature(ctx context.Context, did syntax.DID, commit *repo.Commit) error {
+	if v.Dir == nil {
+		return nil
+	}
+	ident, err := v.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("resolving identity: %w", err)
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return err
+	}
+	if err := commit.VerifySignature(pub); err == nil {
+		return nil
+	}
+
+	// the key may have been rotated since the identity was cached
+	if err := v.Dir.Purge(ctx, did.AtIdentifier()); err != nil {
+		v.logger.Warn("failed to purge identity cache", "did", did, "err", err)
+	}
+	ident, err = v.Dir.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("resolving identity: %w", err)
+	}
+	pub, err = ident.PublicKey()
+	if err != nil {
+		return err
+	}
+	return commit.VerifySignature(pub)
+}
+
+// VerifyCommit checks a #commit event, and updates the account's state if it is valid. Returns nil, or an error wrapping ErrRepoGap, ErrStaleCommit, or ErrInvalidCommit.
+func (v *RepoVerifier) VerifyCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	did, err := syntax.ParseDID(evt.Repo)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+
+	if err := v.peekStale(evt.Repo, evt.Rev); err != nil {
+		return err
+	}
+
+	// only the first commit of a new repo can lack prevData (and since), and ops on existing records also need prev CIDs to be inverted. this is checked against prior state for the account below
+	legacy := evt.PrevData == nil && evt.Since != nil
+	for _, op := range evt.Ops {
+		if (op.Action == "update" || op.Action == "delete") && (op.Prev == nil || evt.PrevData == nil) {
+			legacy = true
+		}
+	}
+	if legacy && !v.AllowLegacy {
+		return fmt.Errorf("%w: legacy commit can not be inverted", ErrInvalidCommit)
+	}
+
+	// checks record blocks, and inverts ops against prevData (skipped for legacy commits)
+	if _, err := repo.VerifyCommitMessage(ctx, evt); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+
+	commit, commitCID, err := repo.LoadCommitFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+	if err := commit.VerifyStructure(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+	if commitCID.String() != evt.Commit.String() {
+		return fmt.Errorf("%w: commit CID does not match CAR root", ErrInvalidCommit)
+	}
+	if err := v.verifySignature(ctx, did, commit); err != nil {
+		return fmt.Errorf("%w: signature: %w", ErrInvalidCommit, err)
+	}
+
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	prev, err := v.checkStale(evt.Repo, evt.Rev)
+	if err != nil {
+		return err
+	}
+	// without prevData, the commit can't be chained from prior state
+	if evt.PrevData == nil && prev != nil {
+		legacy = true
+	}
+	if legacy && !v.AllowLegacy {
+		return fmt.Errorf("%w: legacy commit can not be inverted", ErrInvalidCommit)
+	}
+	v.repos.Add(evt.Repo, &verifiedRepo{rev: commit.Rev, data: commit.Data})
+
+	if prev == nil {
+		return nil
+	}
+	if legacy {
+		return fmt.Errorf("%w: legacy commit breaks chain", ErrRepoGap)
+	}
+	if (*cid.Cid)(evt.PrevData).String() != prev.data.String() {
+		return fmt.Errorf("%w: prevData %s does not match %s (rev %s)", ErrRepoGap, evt.PrevData, prev.data, prev.rev)
+	}
+	return nil
+}
+
+// VerifySync checks a #sync event, and resets the account's state to the new commit. Returns an error wrapping ErrRepoGap if the account's repo contents changed (and should be re-synced).
+func (v *RepoVerifier) VerifySync(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Sync) error {
+	did, err := syntax.ParseDID(evt.Did)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+
+	if err := v.peekStale(evt.Did, evt.Rev); err != nil {
+		return err
+	}
+
+	commit, _, err := repo.LoadCommitFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+	if err := commit.VerifyStructure(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCommit, err)
+	}
+	if commit.DID != evt.Did || commit.Rev != evt.Rev {
+		return fmt.Errorf("%w: commit does not match event DID or rev", ErrInvalidCommit)
+	}
+	if err := v.verifySignature(ctx, did, commit); err != nil {
+		return fmt.Errorf("%w: signature: %w", ErrInvalidCommit, err)
+	}
+
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	prev, err := v.checkStale(evt.Did, evt.Rev)
+	if err != nil {
+		return err
+	}
+	v.repos.Add(evt.Did, &verifiedRepo{rev: commit.Rev, data: commit.Data})
+
+	if prev == nil || prev.data.String() != commit.Data.String() {
+		return fmt.Errorf("%w: #sync event", ErrRepoGap)
+	}
+	return nil
+}
+
+// VerifyingRepoStreamCallbacks wraps an event handler, verifying #commit and #sync events with a RepoVerifier before passing them on. Invalid and stale events are dropped; when a gap is detected, OnGap is called (eg, to re-sync the repo with a backfill job) and the event is passed on.
+type VerifyingRepoStreamCallbacks struct {
+	Verifier *RepoVerifier
+	// Optional; called when an account's repo needs to be re-synced
+	OnGap func(ctx context.Context, did string, err error) error
+	Next  func(ctx context.Context, xev *XRPCStreamEvent) error
+}
+
+func NewVerifyingRepoStreamCallbacks(v *RepoVerifier, onGap func(ctx context.Context, did string, err error) error, next func(ctx context.Context, xev *XRPCStreamEvent) error) *VerifyingRepoStreamCallbacks {
+	return &VerifyingRepoStreamCallbacks{
+		Verifier: v,
+		OnGap:    onGap,
+		Next:     next,
+	}
+}
+
+func (vrsc *VerifyingRepoStreamCallbacks) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
+	var did string
+	var err error
+	switch {
+	case xev.RepoCommit != nil:
+		did = xev.RepoCommit.Repo
+		err = vrsc.Verifier.VerifyCommit(ctx, xev.RepoCommit)
+	case xev.RepoSync != nil:
+		did = xev.RepoSync.Did
+		err = vrsc.Verifier.VerifySync(ctx, xev.RepoSync)
+	case xev.RepoIdentity != nil && vrsc.Verifier.Dir != nil:
+		// signing key may have changed
+		if did, perr := syntax.ParseDID(xev.RepoIdentity.Did); perr == nil {
+			if perr := vrsc.Verifier.Dir.Purge(ctx, did.AtIdentifier()); perr != nil {
+				vrsc.Verifier.logger.Warn("failed to purge identity cache", "did", did, "err", perr)
+			}
+		}
+	}
+
+	if err != nil {
+		if !errors.Is(err, ErrRepoGap) {
+			verifiedEventsCounter.WithLabelValues(verifyResultLabel(err)).Inc()
+			vrsc.Verifier.logger.Warn("dropping firehose event which failed verification", "did", did, "err", err)
+			return nil
+		}
+		verifiedEventsCounter.WithLabelValues("gap").Inc()
+		vrsc.Verifier.logger.Info("gap in repo commit chain", "did", did, "err", err)
+		if vrsc.OnGap != nil {
+			if err := vrsc.OnGap(ctx, did, err); err != nil {
+				vrsc.Verifier.logger.Error("failed to handle repo gap", "did", did, "err", err)
+			}
+		}
+	} else if did != "" {
+		verifiedEventsCounter.WithLabelValues("ok").Inc()
+	}
+
+	return vrsc.Next(ctx, xev)
+}
+
+func verifyResultLabel(err error) string {
+	switch {
+	case errors.Is(err, ErrStaleCommit):
+		return "stale"
+	case errors.Is(err, ErrRepoGap):
+		return "gap"
+	default:
+		return "invalid"
+	}
+}
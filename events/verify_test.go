@@ -0,0 +1,422 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+var cborPrefix = cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256)
+
+// minimal signed repo, for generating #commit events
+type testRepo struct {
+	ident   identity.Identity
+	key     crypto.PrivateKey
+	clock   syntax.TIDClock
+	rev     string
+	data    *cid.Cid
+	records map[string]cid.Cid
+}
+
+type testBreakage string
+
+const (
+	breakNone          testBreakage = ""
+	breakSignature     testBreakage = "signature"
+	breakPrevData      testBreakage = "prev-data"
+	breakMissingBlocks testBreakage = "missing-blocks"
+)
+
+func newTestRepo(t *testing.T) (*testRepo, identity.Directory) {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &testRepo{
+		ident: identity.Identity{
+			DID:    syntax.DID("did:plc:verifiertest00000000000"),
+			Handle: syntax.Handle("alice.example.com"),
+			Keys: map[string]identity.VerificationMethod{
+				"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+			},
+		},
+		key:     key,
+		clock:   syntax.NewTIDClock(0),
+		records: make(map[string]cid.Cid),
+	}
+	dir := identity.NewMockDirectory()
+	dir.Insert(tr.ident)
+	return tr, &dir
+}
+
+// blockstore which remembers the full CIDs of blocks written to it, so they can be written out as a CAR
+type carBlockstore struct {
+	blockstore.Blockstore
+	cids []cid.Cid
+}
+
+func (bs *carBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	bs.cids = append(bs.cids, blk.Cid())
+	return bs.Blockstore.Put(ctx, blk)
+}
+
+func (bs *carBlockstore) putBytes(ctx context.Context, b []byte) (cid.Cid, error) {
+	c, err := cborPrefix.Sum(b)
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(b, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, bs.Put(ctx, blk)
+}
+
+// commit generates a #commit event which creates or updates the given records (nil values delete), with the full tree included in the blocks
+func (tr *testRepo) commit(t *testing.T, brk testBreakage, ops map[string]map[string]any) *comatproto.SyncSubscribeRepos_Commit {
+	ctx := context.Background()
+	bs := &carBlockstore{Blockstore: blockstore.NewBlockstore(datastore.NewMapDatastore())}
+	records := make(map[string]cid.Cid, len(tr.records))
+	for k, v := range tr.records {
+		records[k] = v
+	}
+
+	var repoOps []*comatproto.SyncSubscribeRepos_RepoOp
+	var recordBlocks [][]byte
+	for path, rec := range ops {
+		rop := comatproto.SyncSubscribeRepos_RepoOp{Path: path, Action: "create"}
+		if prev, ok := records[path]; ok {
+			p := lexutil.LexLink(prev)
+			rop.Prev = &p
+			rop.Action = "update"
+		}
+		if rec == nil {
+			rop.Action = "delete"
+			delete(records, path)
+		} else {
+			b, err := data.MarshalCBOR(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := cborPrefix.Sum(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordBlocks = append(recordBlocks, b)
+			records[path] = c
+			l := lexutil.LexLink(c)
+			rop.Cid = &l
+		}
+		repoOps = append(repoOps, &rop)
+	}
+
+	tree, err := mst.LoadTreeFromMap(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.WriteDiffBlocks(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brk != breakMissingBlocks {
+		for _, b := range recordBlocks {
+			if _, err := bs.putBytes(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	key := tr.key
+	if brk == breakSignature {
+		if key, err = crypto.GeneratePrivateKeyK256(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rev := tr.clock.Next().String()
+	commit := repo.Commit{DID: tr.ident.DID.String(), Version: repo.ATPROTO_REPO_VERSION, Data: *root, Rev: rev}
+	if err := commit.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := commit.MarshalCBOR(buf); err != nil {
+		t.Fatal(err)
+	}
+	commitCID, err := bs.putBytes(ctx, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carBuf := new(bytes.Buffer)
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{commitCID}, Version: 1}, carBuf); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range bs.cids {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := carutil.LdWrite(carBuf, c.Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evt := &comatproto.SyncSubscribeRepos_Commit{
+		Repo:   tr.ident.DID.String(),
+		Rev:    rev,
+		Time:   syntax.DatetimeNow().String(),
+		Commit: lexutil.LexLink(commitCID),
+		Blocks: carBuf.Bytes(),
+		Ops:    repoOps,
+	}
+	if tr.rev != "" {
+		since := tr.rev
+		evt.Since = &since
+	}
+	if tr.data != nil {
+		pd := lexutil.LexLink(*tr.data)
+		evt.PrevData = &pd
+	}
+	if brk == breakPrevData {
+		pd := lexutil.LexLink(*root)
+		evt.PrevData = &pd
+	}
+
+	tr.rev = rev
+	tr.data = root
+	tr.records = records
+	return evt
+}
+
+// incremented so that updated records always have a new CID
+var testPostVersion int
+
+func testPost(n int) (string, map[string]any) {
+	testPostVersion++
+	path := "app.bsky.feed.post/" + syntax.NewTIDFromInteger(uint64(n)).String()
+	return path, map[string]any{
+		"$type":     "app.bsky.feed.post",
+		"text":      fmt.Sprintf("post %d (version %d)", n, testPostVersion),
+		"createdAt": syntax.DatetimeNow().String(),
+	}
+}
+
+func posts(nums ...int) map[string]map[string]any {
+	out := make(map[string]map[string]any)
+	for _, n := range nums {
+		path, rec := testPost(n)
+		out[path] = rec
+	}
+	return out
+}
+
+func deletes(nums ...int) map[string]map[string]any {
+	out := make(map[string]map[string]any)
+	for _, n := range nums {
+		path, _ := testPost(n)
+		out[path] = nil
+	}
+	return out
+}
+
+// builds a sequence of commits for a single account, returning the #commit events and a directory with the account's identity
+func testCommits(t *testing.T, brks []testBreakage, ops []map[string]map[string]any) ([]*comatproto.SyncSubscribeRepos_Commit, identity.Directory) {
+	tr, dir := newTestRepo(t)
+	var out []*comatproto.SyncSubscribeRepos_Commit
+	for i := range ops {
+		out = append(out, tr.commit(t, brks[i], ops[i]))
+	}
+	return out, dir
+}
+
+func TestRepoVerifierChain(t *testing.T) {
+	ctx := context.Background()
+	none := breakNone
+	commits, dir := testCommits(t,
+		[]testBreakage{none, none, none, none},
+		[]map[string]map[string]any{posts(1), posts(2, 3), posts(1), deletes(2)},
+	)
+
+	v := events.NewRepoVerifier(dir, 0)
+	for i, evt := range commits {
+		if err := v.VerifyCommit(ctx, evt); err != nil {
+			t.Fatalf("commit %d: %s", i, err)
+		}
+	}
+
+	// replayed events are stale
+	if err := v.VerifyCommit(ctx, commits[1]); !errors.Is(err, events.ErrStaleCommit) {
+		t.Fatalf("expected stale commit, got: %v", err)
+	}
+
+	// missing an event is a gap, after which the chain continues
+	v = events.NewRepoVerifier(dir, 0)
+	for i, expected := range []error{nil, events.ErrRepoGap, nil} {
+		evt := commits[[]int{0, 2, 3}[i]]
+		if err := v.VerifyCommit(ctx, evt); !errors.Is(err, expected) {
+			t.Fatalf("step %d: expected %v, got %v", i, expected, err)
+		}
+	}
+}
+
+func TestRepoVerifierInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	for _, brk := range []testBreakage{breakSignature, breakPrevData, breakMissingBlocks} {
+		commits, dir := testCommits(t,
+			[]testBreakage{breakNone, brk},
+			[]map[string]map[string]any{posts(1, 2), posts(1)},
+		)
+		v := events.NewRepoVerifier(dir, 0)
+		if err := v.VerifyCommit(ctx, commits[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.VerifyCommit(ctx, commits[1]); !errors.Is(err, events.ErrInvalidCommit) {
+			t.Fatalf("%s: expected invalid commit, got: %v", brk, err)
+		}
+	}
+
+	// legacy commits can not be inverted
+	commits, dir := testCommits(t,
+		[]testBreakage{breakNone, breakNone},
+		[]map[string]map[string]any{posts(1), posts(2)},
+	)
+	legacy := *commits[1]
+	legacy.PrevData = nil
+	v := events.NewRepoVerifier(dir, 0)
+	if err := v.VerifyCommit(ctx, commits[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.VerifyCommit(ctx, &legacy); !errors.Is(err, events.ErrInvalidCommit) {
+		t.Fatalf("expected invalid legacy commit, got: %v", err)
+	}
+	v.AllowLegacy = true
+	if err := v.VerifyCommit(ctx, &legacy); !errors.Is(err, events.ErrRepoGap) {
+		t.Fatalf("expected legacy commit to break chain, got: %v", err)
+	}
+
+	// missing prevData can't be chained from prior state, even without since
+	legacy.Since = nil
+	v = events.NewRepoVerifier(dir, 0)
+	if err := v.VerifyCommit(ctx, commits[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.VerifyCommit(ctx, &legacy); !errors.Is(err, events.ErrInvalidCommit) {
+		t.Fatalf("expected invalid commit without prevData, got: %v", err)
+	}
+}
+
+func TestRepoVerifierConcurrent(t *testing.T) {
+	ctx := context.Background()
+	commits, dir := testCommits(t,
+		[]testBreakage{breakNone, breakNone},
+		[]map[string]map[string]any{posts(1), posts(2)},
+	)
+	v := events.NewRepoVerifier(dir, 0)
+	if err := v.VerifyCommit(ctx, commits[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same event delivered concurrently is only accepted once
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = v.VerifyCommit(ctx, commits[1])
+		}()
+	}
+	wg.Wait()
+	ok := 0
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		} else if !errors.Is(err, events.ErrStaleCommit) {
+			t.Fatalf("expected stale commit, got: %v", err)
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("expected one verified commit, got %d", ok)
+	}
+}
+
+func TestRepoVerifierEviction(t *testing.T) {
+	ctx := context.Background()
+	commits, dir := testCommits(t,
+		[]testBreakage{breakNone, breakNone},
+		[]map[string]map[string]any{posts(1), posts(2)},
+	)
+	v := events.NewRepoVerifier(dir, 1)
+	if err := v.VerifyCommit(ctx, commits[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.VerifyCommit(ctx, commits[0]); !errors.Is(err, events.ErrStaleCommit) {
+		t.Fatalf("expected stale commit, got: %v", err)
+	}
+
+	// once evicted, the next event for the account becomes the baseline
+	v.SetState("did:plc:other", commits[0].Rev, cid.Undef)
+	if err := v.VerifyCommit(ctx, commits[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyingRepoStreamCallbacks(t *testing.T) {
+	ctx := context.Background()
+	none := breakNone
+	commits, dir := testCommits(t,
+		[]testBreakage{none, none, breakSignature, none},
+		[]map[string]map[string]any{posts(1), posts(2), posts(3), posts(4)},
+	)
+
+	var passed []string
+	var gaps []string
+	vrsc := events.NewVerifyingRepoStreamCallbacks(events.NewRepoVerifier(dir, 0),
+		func(ctx context.Context, did string, err error) error {
+			gaps = append(gaps, did)
+			return nil
+		},
+		func(ctx context.Context, xev *events.XRPCStreamEvent) error {
+			passed = append(passed, xev.RepoCommit.Rev)
+			return nil
+		},
+	)
+
+	// skip the second commit: the third is invalid and dropped, and the fourth is passed through as a gap
+	for _, i := range []int{0, 2, 3} {
+		if err := vrsc.EventHandler(ctx, &events.XRPCStreamEvent{RepoCommit: commits[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(passed) != 2 || passed[0] != commits[0].Rev || passed[1] != commits[3].Rev {
+		t.Fatalf("unexpected events passed through: %v", passed)
+	}
+	if len(gaps) != 1 || gaps[0] != commits[3].Repo {
+		t.Fatalf("expected one gap, got: %v", gaps)
+	}
+}
@@ -0,0 +1,181 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	pdsdata "github.com/bluesky-social/indigo/pds/data"
+	"github.com/bluesky-social/indigo/util"
+	"gorm.io/gorm"
+)
+
+type AccountToken = pdsdata.AccountToken
+
+const (
+	tokenPurposeResetPassword = "reset_password"
+	tokenPurposeDeleteAccount = "delete_account"
+
+	accountTokenLifetime = 15 * time.Minute
+)
+
+var ErrInvalidToken = fmt.Errorf("token is invalid or expired")
+
+// EmailSender delivers account tokens (for password resets and account deletion) to the account's email address. This PDS has no mail integration: by default tokens are only logged.
+type EmailSender func(ctx context.Context, email, purpose, token string) error
+
+func (s *Server) SetEmailSender(f EmailSender) {
+	s.sendEmail = f
+}
+
+func (s *Server) logEmail(ctx context.Context, email, purpose, token string) error {
+	s.log.Info("account token email (not sent)", "email", email, "purpose", purpose, "token", token)
+	return nil
+}
+
+// newAccountToken returns a random token in the same format as the reference PDS ("abcde-fghij")
+func newAccountToken() string {
+	buf := make([]byte, 7)
+	rand.Read(buf)
+	enc := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+	return enc[:5] + "-" + enc[5:10]
+}
+
+func (s *Server) createAccountToken(ctx context.Context, u *User, purpose string) error {
+	// only the newest token for each purpose is valid
+	if err := s.db.Where("uid = ? AND purpose = ?", u.ID, purpose).Delete(&AccountToken{}).Error; err != nil {
+		return err
+	}
+
+	tok := AccountToken{
+		Uid:       u.ID,
+		Purpose:   purpose,
+		Token:     newAccountToken(),
+		ExpiresAt: time.Now().Add(accountTokenLifetime),
+	}
+	if err := s.db.Create(&tok).Error; err != nil {
+		return err
+	}
+
+	return s.sendEmail(ctx, u.Email, purpose, tok.Token)
+}
+
+// useAccountToken checks and consumes a token
+func (s *Server) useAccountToken(ctx context.Context, purpose, token string) (*AccountToken, error) {
+	var tok AccountToken
+	if err := s.db.Find(&tok, "token = ? AND purpose = ?", strings.ToLower(strings.TrimSpace(token)), purpose).Error; err != nil {
+		return nil, err
+	}
+	if tok.ID == 0 || tok.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	if err := s.db.Unscoped().Delete(&tok).Error; err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+func (s *Server) handleComAtprotoServerRequestPasswordReset(ctx context.Context, body *comatprototypes.ServerRequestPasswordReset_Input) error {
+	var u User
+	if err := s.db.Find(&u, "email = ?", body.Email).Error; err != nil {
+		return err
+	}
+	if u.ID == 0 {
+		// don't reveal which emails have accounts
+		return nil
+	}
+
+	return s.createAccountToken(ctx, &u, tokenPurposeResetPassword)
+}
+
+func (s *Server) handleComAtprotoServerResetPassword(ctx context.Context, body *comatprototypes.ServerResetPassword_Input) error {
+	if body.Password == "" {
+		return fmt.Errorf("password is required")
+	}
+
+	tok, err := s.useAccountToken(ctx, tokenPurposeResetPassword, body.Token)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Model(User{}).Where("id = ?", tok.Uid).UpdateColumn("password", body.Password).Error
+}
+
+func (s *Server) handleComAtprotoServerRequestAccountDelete(ctx context.Context) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.createAccountToken(ctx, u, tokenPurposeDeleteAccount)
+}
+
+func (s *Server) handleComAtprotoServerDeleteAccount(ctx context.Context, body *comatprototypes.ServerDeleteAccount_Input) error {
+	u, err := s.lookupUserByDid(ctx, body.Did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNoSuchUser
+		}
+		return err
+	}
+
+	if body.Password != u.Password {
+		return ErrInvalidUsernameOrPassword
+	}
+
+	tok, err := s.useAccountToken(ctx, tokenPurposeDeleteAccount, body.Token)
+	if err != nil {
+		return err
+	}
+	if tok.Uid != u.ID {
+		return ErrInvalidToken
+	}
+
+	return s.DeleteAccount(ctx, u)
+}
+
+// DeleteAccount removes an account's repo, blobs and records, and emits an #account event with "deleted" status. The handle can then be registered again.
+func (s *Server) DeleteAccount(ctx context.Context, u *User) error {
+	if err := s.repoman.TakeDownRepo(ctx, u.ID); err != nil {
+		return fmt.Errorf("wiping repo data: %w", err)
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("uid = ?", u.ID).Delete(&Blob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("uid = ?", u.ID).Delete(&AccountToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("uid = ?", u.ID).Delete(&models.ActorInfo{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&User{}, u.ID).Error
+	})
+	if err != nil {
+		return fmt.Errorf("deleting account: %w", err)
+	}
+
+	// Push an Account event
+	if err := s.events.AddEvent(ctx, &events.XRPCStreamEvent{
+		RepoAccount: &comatprototypes.SyncSubscribeRepos_Account{
+			Did:    u.Did,
+			Active: false,
+			Status: &events.AccountStatusDeleted,
+			Time:   time.Now().Format(util.ISO8601),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to push event: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package pds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	gojwt "github.com/golang-jwt/jwt"
+)
+
+// captureEmails replaces the server's email sender and returns the sent tokens, by purpose
+func captureEmails(s *Server) map[string]string {
+	sent := make(map[string]string)
+	s.SetEmailSender(func(ctx context.Context, email, purpose, token string) error {
+		sent[purpose] = token
+		return nil
+	})
+	return sent
+}
+
+func TestPasswordReset(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	sent := captureEmails(s)
+
+	ctx := context.Background()
+	_, _, o := newTestUser(t, s, "testman.test")
+
+	if err := s.handleComAtprotoServerRequestPasswordReset(ctx, &atproto.ServerRequestPasswordReset_Input{Email: "nobody@foo.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Fatal("token sent for unknown email")
+	}
+
+	if err := s.handleComAtprotoServerRequestPasswordReset(ctx, &atproto.ServerRequestPasswordReset_Input{Email: "testman.test@foo.com"}); err != nil {
+		t.Fatal(err)
+	}
+	token := sent[tokenPurposeResetPassword]
+	if token == "" {
+		t.Fatal("no reset token sent")
+	}
+
+	if err := s.handleComAtprotoServerResetPassword(ctx, &atproto.ServerResetPassword_Input{Token: "aaaaa-bbbbb", Password: "newpass"}); err != ErrInvalidToken {
+		t.Fatalf("expected %s, got %v", ErrInvalidToken, err)
+	}
+	if err := s.handleComAtprotoServerResetPassword(ctx, &atproto.ServerResetPassword_Input{Token: token, Password: "newpass"}); err != nil {
+		t.Fatal(err)
+	}
+	// tokens are single use
+	if err := s.handleComAtprotoServerResetPassword(ctx, &atproto.ServerResetPassword_Input{Token: token, Password: "other"}); err != ErrInvalidToken {
+		t.Fatalf("expected %s, got %v", ErrInvalidToken, err)
+	}
+
+	if _, err := s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: "password"}); err != ErrInvalidUsernameOrPassword {
+		t.Fatalf("expected old password to be rejected, got %v", err)
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: "newpass"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	_, u, o := newTestUser(t, s, "testman.test")
+
+	tok, err := gojwt.Parse(o.RefreshJwt, func(*gojwt.Token) (interface{}, error) {
+		return s.jwtSigningKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), "user", u)
+	ctx = context.WithValue(ctx, "token", tok)
+	if err := s.handleComAtprotoServerDeleteSession(ctx); err == nil {
+		t.Fatal("expected error without refresh scope")
+	}
+
+	ctx = context.WithValue(ctx, "authScope", "com.atproto.refresh")
+	if err := s.handleComAtprotoServerDeleteSession(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := s.isTokenRevoked(ctx, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("expected refresh token to be revoked")
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	sent := captureEmails(s)
+
+	ctx, u, _ := newTestUser(t, s, "testman.test")
+
+	evts, cancel, err := s.events.Subscribe(context.Background(), "test", func(*events.XRPCStreamEvent) bool { return true }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err := s.handleComAtprotoServerRequestAccountDelete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	token := sent[tokenPurposeDeleteAccount]
+	if token == "" {
+		t.Fatal("no deletion token sent")
+	}
+
+	if err := s.handleComAtprotoServerDeleteAccount(ctx, &atproto.ServerDeleteAccount_Input{Did: u.Did, Password: "wrong", Token: token}); err != ErrInvalidUsernameOrPassword {
+		t.Fatalf("expected %s, got %v", ErrInvalidUsernameOrPassword, err)
+	}
+	if err := s.handleComAtprotoServerDeleteAccount(ctx, &atproto.ServerDeleteAccount_Input{Did: u.Did, Password: "password", Token: token}); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		var evt *events.XRPCStreamEvent
+		select {
+		case evt = <-evts:
+		case <-timeout:
+			t.Fatal("timed out waiting for #account event")
+		}
+		if evt.RepoAccount == nil {
+			continue
+		}
+		if evt.RepoAccount.Did != u.Did || evt.RepoAccount.Active || evt.RepoAccount.Status == nil || *evt.RepoAccount.Status != events.AccountStatusDeleted {
+			t.Fatalf("unexpected account event: %+v", evt.RepoAccount)
+		}
+		break
+	}
+
+	if _, err := s.lookupUserByDid(ctx, u.Did); err == nil {
+		t.Fatal("expected deleted user lookup to fail")
+	}
+
+	// the handle is free again
+	newTestUser(t, s, "testman.test")
+}
@@ -0,0 +1,96 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	pdsdata "github.com/bluesky-social/indigo/pds/data"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"github.com/multiformats/go-multihash"
+	"gorm.io/gorm"
+)
+
+type Blob = pdsdata.Blob
+
+// maxBlobSize is the largest blob accepted by uploadBlob
+const maxBlobSize = 50 << 20
+
+// blob CIDs are CIDv1, with the "raw" codec and a sha256 hash
+var blobCidPrefix = cid.NewPrefixV1(cid.Raw, multihash.SHA2_256)
+
+func (s *Server) handleComAtprotoRepoUploadBlob(ctx context.Context, r io.Reader, contentType string) (*comatprototypes.RepoUploadBlob_Output, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxBlobSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading blob: %w", err)
+	}
+	if len(data) > maxBlobSize {
+		return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "blob too large")
+	}
+
+	if contentType == "" || contentType == "*/*" {
+		contentType = http.DetectContentType(data)
+	}
+
+	c, err := blobCidPrefix.Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// uploading the same blob again is a no-op
+	var existing Blob
+	if err := s.db.Find(&existing, "uid = ? AND cid = ?", u.ID, c.String()).Error; err != nil {
+		return nil, err
+	}
+	if existing.ID == 0 {
+		blob := Blob{
+			Uid:      u.ID,
+			Cid:      c.String(),
+			MimeType: contentType,
+			Size:     int64(len(data)),
+			Data:     data,
+		}
+		if err := s.db.Create(&blob).Error; err != nil {
+			return nil, fmt.Errorf("storing blob: %w", err)
+		}
+	}
+
+	return &comatprototypes.RepoUploadBlob_Output{
+		Blob: &lexutil.LexBlob{
+			Ref:      lexutil.LexLink(c),
+			MimeType: contentType,
+			Size:     int64(len(data)),
+		},
+	}, nil
+}
+
+func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cid string, did string) (io.Reader, error) {
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return nil, err
+	}
+
+	var blob Blob
+	if err := s.db.Find(&blob, "uid = ? AND cid = ?", u.ID, cid).Error; err != nil {
+		return nil, err
+	}
+	if blob.ID == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "blob not found")
+	}
+
+	return bytes.NewReader(blob.Data), nil
+}
@@ -25,3 +25,23 @@ type Peering struct {
 	Did      string
 	Approved bool
 }
+
+// Blob is an uploaded blob, stored in the database. Blobs are per-account: the same CID uploaded by two accounts is stored twice.
+type Blob struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Uid       models.Uid `gorm:"uniqueIndex:idx_blob_uid_cid"`
+	Cid       string     `gorm:"uniqueIndex:idx_blob_uid_cid"`
+	MimeType  string
+	Size      int64
+	Data      []byte
+}
+
+// AccountToken is a single-use token for an account action which requires email confirmation (password reset, account deletion)
+type AccountToken struct {
+	gorm.Model
+	Uid       models.Uid `gorm:"index"`
+	Purpose   string
+	Token     string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+}
@@ -4,14 +4,25 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"slices"
 
 	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/carstore"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repomgr"
+	gojwt "github.com/golang-jwt/jwt"
 	"github.com/ipfs/go-cid"
-	"github.com/lestrrat-go/jwx/v2/jwt"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *comatprototypes.ServerCreateAccount_Input) (*comatprototypes.ServerCreateAccount_Output, error) {
@@ -107,26 +118,6 @@ func (s *Server) handleComAtprotoServerCreateInviteCode(ctx context.Context, bod
 	return nil, fmt.Errorf("invite codes not currently supported")
 }
 
-func (s *Server) handleComAtprotoServerRequestAccountDelete(ctx context.Context) error {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoServerDeleteAccount(ctx context.Context, body *comatprototypes.ServerDeleteAccount_Input) error {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoServerRequestPasswordReset(ctx context.Context, body *comatprototypes.ServerRequestPasswordReset_Input) error {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoServerResetPassword(ctx context.Context, body *comatprototypes.ServerResetPassword_Input) error {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoRepoUploadBlob(ctx context.Context, r io.Reader, contentType string) (*comatprototypes.RepoUploadBlob_Output, error) {
-	panic("not yet implemented")
-}
-
 func (s *Server) handleComAtprotoIdentityResolveHandle(ctx context.Context, handle string) (*comatprototypes.IdentityResolveHandle_Output, error) {
 	if handle == "" {
 		return &comatprototypes.IdentityResolveHandle_Output{Did: s.signingKey.Public().DID()}, nil
@@ -211,11 +202,120 @@ func (s *Server) handleComAtprotoRepoGetRecord(ctx context.Context, c string, co
 }
 
 func (s *Server) handleComAtprotoRepoListRecords(ctx context.Context, collection string, cursor string, limit int, repo string, reverse *bool, rkeyEnd string, rkeyStart string) (*comatprototypes.RepoListRecords_Output, error) {
-	panic("not yet implemented")
+	targetUser, err := s.lookupUser(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit < 1 || limit > 100 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 100")
+	}
+
+	type entry struct {
+		rkey string
+		rcid cid.Cid
+	}
+	var entries []entry
+	err = s.repoman.ListRecords(ctx, targetUser.ID, collection, func(rkey string, rcid cid.Cid) error {
+		// rkeyStart and rkeyEnd are deprecated, exclusive bounds
+		if (rkeyStart != "" && rkey <= rkeyStart) || (rkeyEnd != "" && rkey >= rkeyEnd) {
+			return nil
+		}
+		entries = append(entries, entry{rkey: rkey, rcid: rcid})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing records: %w", err)
+	}
+
+	// newest first (for TID keys), unless reversed
+	ascending := reverse != nil && *reverse
+	if !ascending {
+		slices.Reverse(entries)
+	}
+
+	out := &comatprototypes.RepoListRecords_Output{
+		Records: []*comatprototypes.RepoListRecords_Record{},
+	}
+	var last string
+	for _, e := range entries {
+		if cursor != "" && ((ascending && e.rkey <= cursor) || (!ascending && e.rkey >= cursor)) {
+			continue
+		}
+		if len(out.Records) == limit {
+			out.Cursor = &last
+			break
+		}
+		last = e.rkey
+
+		_, rec, err := s.repoman.GetRecord(ctx, targetUser.ID, collection, e.rkey, e.rcid)
+		if err != nil {
+			return nil, fmt.Errorf("repoman GetRecord: %w", err)
+		}
+
+		out.Records = append(out.Records, &comatprototypes.RepoListRecords_Record{
+			Cid:   e.rcid.String(),
+			Uri:   "at://" + targetUser.Did + "/" + collection + "/" + e.rkey,
+			Value: &lexutil.LexiconTypeDecoder{Val: rec},
+		})
+	}
+
+	return out, nil
 }
 
 func (s *Server) handleComAtprotoRepoPutRecord(ctx context.Context, input *comatprototypes.RepoPutRecord_Input) (*comatprototypes.RepoPutRecord_Output, error) {
-	panic("not yet implemented")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Repo != u.Did && input.Repo != u.Handle {
+		return nil, fmt.Errorf("specified repo did not match authed user")
+	}
+
+	if input.Record == nil || input.Record.Val == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "record is required")
+	}
+
+	if _, err := syntax.ParseRecordKey(input.Rkey); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var swapRecord *cid.Cid
+	if input.SwapRecord != nil {
+		// an empty swapRecord means the record must not exist yet
+		c := cid.Undef
+		if *input.SwapRecord != "" {
+			c, err = cid.Decode(*input.SwapRecord)
+			if err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid swapRecord: %s", err))
+			}
+		}
+		swapRecord = &c
+	}
+
+	// checked by the repo manager, with the repo locked
+	var swapCommit *cid.Cid
+	if input.SwapCommit != nil {
+		c, err := cid.Decode(*input.SwapCommit)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid swapCommit: %s", err))
+		}
+		swapCommit = &c
+	}
+
+	rcid, err := s.repoman.PutRecord(ctx, u.ID, input.Collection, input.Rkey, input.Record.Val, swapRecord, swapCommit)
+	if err != nil {
+		if errors.Is(err, repomgr.ErrSwapMismatch) || errors.Is(err, repomgr.ErrSwapCommitMismatch) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "InvalidSwap: "+err.Error())
+		}
+		return nil, fmt.Errorf("record put: %w", err)
+	}
+
+	return &comatprototypes.RepoPutRecord_Output{
+		Uri: "at://" + u.Did + "/" + input.Collection + "/" + input.Rkey,
+		Cid: rcid.String(),
+	}, nil
 }
 
 func (s *Server) handleComAtprotoServerDescribeServer(ctx context.Context) (*comatprototypes.ServerDescribeServer_Output, error) {
@@ -255,7 +355,22 @@ func (s *Server) handleComAtprotoServerCreateSession(ctx context.Context, body *
 }
 
 func (s *Server) handleComAtprotoServerDeleteSession(ctx context.Context) error {
-	panic("not yet implemented")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	scope, ok := ctx.Value("authScope").(string)
+	if !ok || scope != "com.atproto.refresh" {
+		return fmt.Errorf("auth token did not have refresh scope")
+	}
+
+	tok, ok := ctx.Value("token").(*gojwt.Token)
+	if !ok {
+		return fmt.Errorf("internal auth error: token not set post auth check")
+	}
+
+	return s.invalidateToken(ctx, u, tok)
 }
 
 func (s *Server) handleComAtprotoServerGetSession(ctx context.Context) (*comatprototypes.ServerGetSession_Output, error) {
@@ -285,7 +400,7 @@ func (s *Server) handleComAtprotoServerRefreshSession(ctx context.Context) (*com
 		return nil, fmt.Errorf("auth token did not have refresh scope")
 	}
 
-	tok, ok := ctx.Value("token").(*jwt.Token)
+	tok, ok := ctx.Value("token").(*gojwt.Token)
 	if !ok {
 		return nil, fmt.Errorf("internal auth error: token not set post auth check")
 	}
@@ -333,7 +448,46 @@ func (s *Server) handleComAtprotoSyncGetHead(ctx context.Context, did string) (*
 }
 
 func (s *Server) handleComAtprotoSyncGetRecord(ctx context.Context, collection string, commit string, did string, rkey string) (io.Reader, error) {
-	panic("not yet implemented")
+	targetUser, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
+		}
+		return nil, err
+	}
+
+	root, blocks, err := s.repoman.GetRecordProof(ctx, targetUser.ID, collection, rkey)
+	if err != nil {
+		if errors.Is(err, mst.ErrNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "record not found in repo")
+		}
+		return nil, err
+	}
+
+	// only proofs against the current commit are available
+	if commit != "" && commit != root.String() {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "historical commits are not supported")
+	}
+
+	buf := new(bytes.Buffer)
+	hb, err := cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := carstore.LdWrite(buf, hb); err != nil {
+		return nil, err
+	}
+
+	for _, blk := range blocks {
+		if _, err := carstore.LdWrite(buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
 }
 
 func (s *Server) handleComAtprotoSyncGetRepo(ctx context.Context, did string, since string) (io.Reader, error) {
@@ -362,10 +516,6 @@ func (s *Server) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *com
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cid string, did string) (io.Reader, error) {
-	panic("nyi")
-}
-
 func (s *Server) handleComAtprotoSyncListBlobs(ctx context.Context, cursor string, did string, limit int, since string) (*comatprototypes.SyncListBlobs_Output, error) {
 	panic("nyi")
 }
@@ -1,18 +1,26 @@
 package pds
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/carstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/plc"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/ipld/go-car"
 	"github.com/whyrusleeping/go-did"
 	"gorm.io/gorm"
 )
@@ -128,3 +136,224 @@ func TestHandleComAtprotoSessionCreate(t *testing.T) {
 		t.Fatalf("expected error %s, got %s\n", ErrInvalidUsernameOrPassword, err)
 	}
 }
+
+func newTestUser(t *testing.T, s *Server, handle string) (context.Context, *User, *atproto.ServerCreateAccount_Output) {
+	t.Helper()
+
+	e := handle + "@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   handle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return context.WithValue(context.Background(), "user", u), u, o
+}
+
+func TestHandleComAtprotoRepoPutAndListRecords(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx, u, _ := newTestUser(t, s, "testman.test")
+
+	post := func(text string) *lexutil.LexiconTypeDecoder {
+		return &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: text, CreatedAt: "2024-01-02T03:04:05Z"}}
+	}
+
+	var cids []string
+	for _, rkey := range []string{"3kaaaaaaaaaa1", "3kaaaaaaaaaa2", "3kaaaaaaaaaa3"} {
+		out, err := s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+			Repo:       u.Did,
+			Collection: "app.bsky.feed.post",
+			Rkey:       rkey,
+			Record:     post("hello " + rkey),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Uri != "at://"+u.Did+"/app.bsky.feed.post/"+rkey {
+			t.Fatalf("unexpected uri: %s", out.Uri)
+		}
+		cids = append(cids, out.Cid)
+	}
+
+	// update with a stale swapRecord fails, with the current one succeeds
+	empty := ""
+	if _, err := s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Rkey:       "3kaaaaaaaaaa1",
+		Record:     post("edited"),
+		SwapRecord: &empty,
+	}); err == nil {
+		t.Fatal("expected swap mismatch for existing record")
+	}
+	out, err := s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Rkey:       "3kaaaaaaaaaa1",
+		Record:     post("edited"),
+		SwapRecord: &cids[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Cid == cids[0] {
+		t.Fatal("expected record cid to change")
+	}
+
+	// concurrent writes with the same swapCommit: only one lands
+	root, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapCommit := root.String()
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+				Repo:       u.Did,
+				Collection: "app.bsky.feed.post",
+				Rkey:       "3kaaaaaaaaaa2",
+				Record:     post(fmt.Sprintf("swap %d", i)),
+				SwapCommit: &swapCommit,
+			})
+		}()
+	}
+	wg.Wait()
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one write with swapCommit to succeed, got %d", succeeded)
+	}
+
+	list := func(cursor string, reverse bool) *atproto.RepoListRecords_Output {
+		t.Helper()
+		out, err := s.handleComAtprotoRepoListRecords(context.Background(), "app.bsky.feed.post", cursor, 2, u.Did, &reverse, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	page := list("", false)
+	if len(page.Records) != 2 || page.Cursor == nil || *page.Cursor != "3kaaaaaaaaaa2" {
+		t.Fatalf("unexpected first page: %d records", len(page.Records))
+	}
+	if page.Records[0].Uri != "at://"+u.Did+"/app.bsky.feed.post/3kaaaaaaaaaa3" {
+		t.Fatalf("expected newest record first, got %s", page.Records[0].Uri)
+	}
+	page = list(*page.Cursor, false)
+	if len(page.Records) != 1 || page.Cursor != nil {
+		t.Fatalf("unexpected second page: %d records", len(page.Records))
+	}
+	if page.Records[0].Value.Val.(*bsky.FeedPost).Text != "edited" {
+		t.Fatal("expected updated record value")
+	}
+
+	page = list("", true)
+	if page.Records[0].Uri != "at://"+u.Did+"/app.bsky.feed.post/3kaaaaaaaaaa1" {
+		t.Fatalf("expected oldest record first, got %s", page.Records[0].Uri)
+	}
+}
+
+func TestHandleComAtprotoSyncGetRecord(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx, u, _ := newTestUser(t, s, "testman.test")
+	out, err := s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "hello", CreatedAt: "2024-01-02T03:04:05Z"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rkey := out.Uri[strings.LastIndex(out.Uri, "/")+1:]
+
+	r, err := s.handleComAtprotoSyncGetRecord(context.Background(), "app.bsky.feed.post", "", u.Did, rkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blk.Cid().String() == out.Cid {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("record block missing from proof")
+	}
+
+	if _, err := s.handleComAtprotoSyncGetRecord(context.Background(), "app.bsky.feed.post", "", u.Did, "3kaaaaaaaaaa9"); err == nil {
+		t.Fatal("expected error for missing record")
+	}
+}
+
+func TestHandleComAtprotoBlobs(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx, u, _ := newTestUser(t, s, "testman.test")
+
+	data := []byte("\x89PNG\r\n\x1a\nnot really a png")
+	out, err := s.handleComAtprotoRepoUploadBlob(ctx, bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Blob.MimeType != "image/png" || out.Blob.Size != int64(len(data)) {
+		t.Fatalf("unexpected blob metadata: %s %d", out.Blob.MimeType, out.Blob.Size)
+	}
+
+	// uploading again is idempotent
+	again, err := s.handleComAtprotoRepoUploadBlob(ctx, bytes.NewReader(data), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Blob.Ref.String() != out.Blob.Ref.String() {
+		t.Fatal("expected same blob cid")
+	}
+
+	r, err := s.handleComAtprotoSyncGetBlob(context.Background(), out.Blob.Ref.String(), u.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("blob data mismatch")
+	}
+
+	if _, err := s.handleComAtprotoRepoUploadBlob(context.Background(), bytes.NewReader(data), ""); err == nil {
+		t.Fatal("expected upload without auth to fail")
+	}
+}
@@ -29,7 +29,6 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/whyrusleeping/go-did"
 	"gorm.io/gorm"
 )
@@ -50,6 +49,8 @@ type Server struct {
 
 	plc plc.PLCClient
 
+	sendEmail EmailSender
+
 	log *slog.Logger
 }
 
@@ -63,6 +64,9 @@ const serverListenerBootTimeout = 5 * time.Second
 func NewServer(db *gorm.DB, cs carstore.CarStore, serkey *did.PrivKey, handleSuffix, serviceUrl string, didr plc.PLCClient, jwtkey []byte) (*Server, error) {
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Peering{})
+	db.AutoMigrate(&RefreshToken{})
+	db.AutoMigrate(&Blob{})
+	db.AutoMigrate(&AccountToken{})
 
 	evtman := events.NewEventManager(events.NewMemPersister())
 
@@ -92,6 +96,7 @@ func NewServer(db *gorm.DB, cs carstore.CarStore, serkey *did.PrivKey, handleSuf
 
 		log: slog.Default().With("system", "pds"),
 	}
+	s.sendEmail = s.logEmail
 
 	repoman.SetEventHandler(func(ctx context.Context, evt *repomgr.RepoEvent) {
 		if err := ix.HandleRepoEvent(ctx, evt); err != nil {
@@ -226,6 +231,18 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 				return true
 			case "/xrpc/com.atproto.server.describeServer":
 				return true
+			case "/xrpc/com.atproto.server.deleteAccount":
+				return true
+			case "/xrpc/com.atproto.server.requestPasswordReset":
+				return true
+			case "/xrpc/com.atproto.server.resetPassword":
+				return true
+			case "/xrpc/com.atproto.repo.listRecords":
+				return true
+			case "/xrpc/com.atproto.sync.getRecord":
+				return true
+			case "/xrpc/com.atproto.sync.getBlob":
+				return true
 			case "/xrpc/com.atproto.sync.getRepo":
 				fmt.Println("TODO: currently not requiring auth on get repo endpoint")
 				return true
@@ -265,6 +282,19 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 			ctx.Response().WriteHeader(404)
 			return
 		}
+		if errors.Is(err, ErrInvalidUsernameOrPassword) {
+			ctx.Response().WriteHeader(401)
+			return
+		}
+		if errors.Is(err, ErrInvalidToken) {
+			ctx.Response().WriteHeader(400)
+			return
+		}
+		var herr *echo.HTTPError
+		if errors.As(err, &herr) {
+			ctx.Response().WriteHeader(herr.Code)
+			return
+		}
 
 		ctx.Response().WriteHeader(500)
 	}
@@ -372,9 +402,10 @@ func (s *Server) HandleResolveDid(c echo.Context) error {
 
 type User = pdsdata.User
 
+// RefreshToken records a revoked refresh token (by its "jti" claim)
 type RefreshToken struct {
 	gorm.Model
-	Token string
+	Token string `gorm:"index"`
 }
 
 func toTime(i interface{}) (time.Time, error) {
@@ -494,6 +525,16 @@ func (s *Server) userCheckMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return err
 		}
 
+		if scope == "com.atproto.refresh" {
+			revoked, err := s.isTokenRevoked(ctx, user)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return fmt.Errorf("invalid token: revoked")
+			}
+		}
+
 		ctx = context.WithValue(ctx, "authScope", scope)
 		ctx = context.WithValue(ctx, "user", u)
 		ctx = context.WithValue(ctx, "did", did)
@@ -535,8 +576,38 @@ func (s *Server) validateHandle(handle string) error {
 	return nil
 }
 
-func (s *Server) invalidateToken(ctx context.Context, u *User, tok *jwt.Token) error {
-	panic("nyi")
+func tokenID(tok *gojwt.Token) (string, error) {
+	claims, ok := tok.Claims.(gojwt.MapClaims)
+	if !ok {
+		return "", fmt.Errorf("invalid token claims map")
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return "", fmt.Errorf("token has no jti")
+	}
+	return jti, nil
+}
+
+func (s *Server) invalidateToken(ctx context.Context, u *User, tok *gojwt.Token) error {
+	jti, err := tokenID(tok)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Create(&RefreshToken{Token: jti}).Error
+}
+
+func (s *Server) isTokenRevoked(ctx context.Context, tok *gojwt.Token) (bool, error) {
+	jti, err := tokenID(tok)
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := s.db.Model(&RefreshToken{}).Where("token = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
 
 type Peering = pdsdata.Peering
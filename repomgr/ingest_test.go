@@ -243,3 +243,22 @@ func TestDuplicateRecord(t *testing.T) {
 	_ = c
 	_ = rec
 }
+
+func TestNewRepoManagerArchiveStore(t *testing.T) {
+	dir := t.TempDir()
+
+	cs := testCarstore(t, dir, false)
+	archive, err := carstore.NewArchiveStore(cs, filepath.Join(dir, "archive"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	repoman := NewRepoManager(archive, &util.FakeKeyManager{})
+	if !repoman.noArchive {
+		t.Fatal("expected non-archival ingest for a wrapped non-archival carstore")
+	}
+	if repoman.archive != archive {
+		t.Fatal("expected archive store to be used for history")
+	}
+}
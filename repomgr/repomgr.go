@@ -35,8 +35,16 @@ import (
 
 func NewRepoManager(cs carstore.CarStore, kmgr KeyManager) *RepoManager {
 
+	// enables reading repos at earlier revs
+	archive, _ := cs.(*carstore.ArchiveStore)
+
+	// the archive wraps the underlying store, which determines how commits are ingested
+	inner := cs
+	if archive != nil {
+		inner = archive.Inner
+	}
 	var noArchive bool
-	if _, ok := cs.(*carstore.NonArchivalCarstore); ok {
+	if _, ok := inner.(*carstore.NonArchivalCarstore); ok {
 		noArchive = true
 	}
 
@@ -44,6 +52,7 @@ func NewRepoManager(cs carstore.CarStore, kmgr KeyManager) *RepoManager {
 
 	return &RepoManager{
 		cs:        cs,
+		archive:   archive,
 		userLocks: make(map[models.Uid]*userLock),
 		kmgr:      kmgr,
 		log:       slog.Default().With("system", "repomgr"),
@@ -74,6 +83,7 @@ type RepoManager struct {
 
 	log       *slog.Logger
 	noArchive bool
+	archive   *carstore.ArchiveStore
 
 	clk *syntax.TIDClock
 }
@@ -124,6 +134,11 @@ type userLock struct {
 	count int
 }
 
+// LockUser holds the per-user lock used for all repo writes, until the returned function is called. For tools (such as carstore migration) which need to write repo data outside the RepoManager.
+func (rm *RepoManager) LockUser(ctx context.Context, user models.Uid) func() {
+	return rm.lockUser(ctx, user)
+}
+
 func (rm *RepoManager) lockUser(ctx context.Context, user models.Uid) func() {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "userLock")
 	defer span.End()
@@ -294,6 +309,98 @@ func (rm *RepoManager) UpdateRecord(ctx context.Context, user models.Uid, collec
 	return cc, nil
 }
 
+// PutRecord creates or replaces the record at collection/rkey. If swapRecord is non-nil, the write only happens if the current record has that CID (or, if it is cid.Undef, if there is no current record). If swapCommit is non-nil, the write only happens if the current repo commit has that CID. Both are checked with the user's repo locked.
+func (rm *RepoManager) PutRecord(ctx context.Context, user models.Uid, collection, rkey string, rec cbg.CBORMarshaler, swapRecord, swapCommit *cid.Cid) (cid.Cid, error) {
+	ctx, span := otel.Tracer("repoman").Start(ctx, "PutRecord")
+	defer span.End()
+
+	unlock := rm.lockUser(ctx, user)
+	defer unlock()
+
+	rev, err := rm.cs.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	ds, err := rm.cs.NewDeltaSession(ctx, user, &rev)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	head := ds.BaseCid()
+	if swapCommit != nil && *swapCommit != head {
+		return cid.Undef, ErrSwapCommitMismatch
+	}
+	r, err := repo.OpenRepo(ctx, ds, head)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	rpath := collection + "/" + rkey
+	kind := EvtKindUpdateRecord
+	prev, _, err := r.GetRecordBytes(ctx, rpath)
+	if errors.Is(err, mst.ErrNotFound) {
+		kind = EvtKindCreateRecord
+		prev = cid.Undef
+	} else if err != nil {
+		return cid.Undef, err
+	}
+
+	if swapRecord != nil && *swapRecord != prev {
+		return cid.Undef, ErrSwapMismatch
+	}
+
+	var cc cid.Cid
+	if kind == EvtKindCreateRecord {
+		cc, err = r.PutRecord(ctx, rpath, rec)
+	} else {
+		cc, err = r.UpdateRecord(ctx, rpath, rec)
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	nroot, nrev, err := r.Commit(ctx, rm.kmgr.SignForUser)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	rslice, err := ds.CloseWithRoot(ctx, nroot, nrev)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("close with root: %w", err)
+	}
+
+	var oldroot *cid.Cid
+	if head.Defined() {
+		oldroot = &head
+	}
+
+	if rm.events != nil {
+		op := RepoOp{
+			Kind:       kind,
+			Collection: collection,
+			Rkey:       rkey,
+			RecCid:     &cc,
+		}
+
+		if rm.hydrateRecords {
+			op.Record = rec
+		}
+
+		rm.events(ctx, &RepoEvent{
+			User:      user,
+			OldRoot:   oldroot,
+			NewRoot:   nroot,
+			Rev:       nrev,
+			Since:     &rev,
+			Ops:       []RepoOp{op},
+			RepoSlice: rslice,
+		})
+	}
+
+	return cc, nil
+}
+
 func (rm *RepoManager) DeleteRecord(ctx context.Context, user models.Uid, collection, rkey string) error {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "DeleteRecord")
 	defer span.End()
@@ -464,6 +571,33 @@ func (rm *RepoManager) GetRecord(ctx context.Context, user models.Uid, collectio
 	return ocid, val, nil
 }
 
+// ListRecords calls cb with the rkey and CID of every record in a collection, in key order
+func (rm *RepoManager) ListRecords(ctx context.Context, user models.Uid, collection string, cb func(rkey string, rcid cid.Cid) error) error {
+	bs, err := rm.cs.ReadOnlySession(user)
+	if err != nil {
+		return err
+	}
+
+	head, err := rm.cs.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	r, err := repo.OpenRepo(ctx, bs, head)
+	if err != nil {
+		return err
+	}
+
+	prefix := collection + "/"
+	return r.ForEach(ctx, prefix, func(k string, v cid.Cid) error {
+		// ForEach continues past the end of the collection
+		if !strings.HasPrefix(k, prefix) {
+			return repo.ErrDoneIterating
+		}
+		return cb(k[len(prefix):], v)
+	})
+}
+
 func (rm *RepoManager) GetRecordProof(ctx context.Context, user models.Uid, collection string, rkey string) (cid.Cid, []blocks.Block, error) {
 	robs, err := rm.cs.ReadOnlySession(user)
 	if err != nil {
@@ -490,6 +624,49 @@ func (rm *RepoManager) GetRecordProof(ctx context.Context, user models.Uid, coll
 	return head, bs.GetLoggedBlocks(), nil
 }
 
+var ErrNoRepoHistory = errors.New("carstore does not keep repo history")
+
+var ErrSwapMismatch = errors.New("current record CID did not match swapRecord")
+
+var ErrSwapCommitMismatch = errors.New("repo commit did not match swapCommit")
+
+// RepoHistory lists archived commits of a repo, newest first, strictly before the given rev (if not empty)
+func (rm *RepoManager) RepoHistory(ctx context.Context, user models.Uid, before string, limit int) ([]carstore.ArchivedCommit, error) {
+	if rm.archive == nil {
+		return nil, ErrNoRepoHistory
+	}
+	return rm.archive.History(ctx, user, before, limit)
+}
+
+// ReadRepoAt writes the complete repo as of the last archived commit at or before the given rev, as a CAR file, and returns that commit
+func (rm *RepoManager) ReadRepoAt(ctx context.Context, user models.Uid, rev string, w io.Writer) (*carstore.ArchivedCommit, error) {
+	if rm.archive == nil {
+		return nil, ErrNoRepoHistory
+	}
+	return rm.archive.ReadUserCarAt(ctx, user, rev, w)
+}
+
+// GetRecordAt returns a record as of the last archived commit at or before the given rev, with that commit, and the blocks proving the record's inclusion in it
+func (rm *RepoManager) GetRecordAt(ctx context.Context, user models.Uid, collection string, rkey string, rev string) (*carstore.ArchivedCommit, cid.Cid, []byte, []blocks.Block, error) {
+	if rm.archive == nil {
+		return nil, cid.Undef, nil, nil, ErrNoRepoHistory
+	}
+	ds, commit, err := rm.archive.ReadOnlySessionAt(ctx, user, rev)
+	if err != nil {
+		return nil, cid.Undef, nil, nil, err
+	}
+	bs := util.NewLoggingBstore(ds)
+	r, err := repo.OpenRepo(ctx, bs, commit.Root)
+	if err != nil {
+		return nil, cid.Undef, nil, nil, err
+	}
+	rcid, rec, err := r.GetRecordBytes(ctx, collection+"/"+rkey)
+	if err != nil {
+		return nil, cid.Undef, nil, nil, err
+	}
+	return commit, rcid, *rec, bs.GetLoggedBlocks(), nil
+}
+
 func (rm *RepoManager) GetProfile(ctx context.Context, uid models.Uid) (*bsky.ActorProfile, error) {
 	bs, err := rm.cs.ReadOnlySession(uid)
 	if err != nil {
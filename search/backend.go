@@ -0,0 +1,401 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	es "github.com/opensearch-project/opensearch-go/v2"
+	esapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Backend stores search documents and runs queries against them. EsBackend uses an OpenSearch (or Elasticsearch) cluster; EmbeddedBackend keeps a local index on disk, for small deployments and for tests.
+type Backend interface {
+	EnsureIndices(ctx context.Context) error
+
+	IndexPosts(ctx context.Context, docs []PostDoc) error
+	DeletePost(ctx context.Context, docID string) error
+	IndexProfiles(ctx context.Context, docs []ProfileDoc) error
+	UpdateProfileHandle(ctx context.Context, did syntax.DID, handle string) error
+	UpdatePageranks(ctx context.Context, jobs []*PagerankIndexJob) error
+
+	// params.Query must already have been parsed (see ParsePostQuery), with filter syntax removed
+	SearchPosts(ctx context.Context, params *PostSearchParams) (*EsSearchResponse, error)
+	SearchProfiles(ctx context.Context, params *ActorSearchParams) (*EsSearchResponse, error)
+	SearchProfilesTypeahead(ctx context.Context, params *ActorSearchParams) (*EsSearchResponse, error)
+
+	// generic records; see RecordMappings
+	IndexRecords(ctx context.Context, docs []RecordDoc) error
+	DeleteRecord(ctx context.Context, docID string) error
+	SearchRecords(ctx context.Context, params *RecordSearchParams) (*EsSearchResponse, error)
+}
+
+type EsBackend struct {
+	escli        *es.Client
+	postIndex    string
+	profileIndex string
+	logger       *slog.Logger
+
+	// if non-zero, new indices are created with kNN vector fields of this size (see Embedder)
+	EmbeddingDims int
+	// index for generic records (see RecordMappings); not created if empty
+	RecordIndex string
+}
+
+func NewEsBackend(escli *es.Client, postIndex, profileIndex string, logger *slog.Logger) *EsBackend {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &EsBackend{
+		escli:        escli,
+		postIndex:    postIndex,
+		profileIndex: profileIndex,
+		logger:       logger.With("component", "es-backend"),
+	}
+}
+
+func newDefaultEsBackend(escli *es.Client, postIndex, profileIndex, recordIndex string, embedder Embedder, mappings *RecordMappings, logger *slog.Logger) *EsBackend {
+	b := NewEsBackend(escli, postIndex, profileIndex, logger)
+	if embedder != nil {
+		b.EmbeddingDims = embedder.Dimensions()
+	}
+	if mappings.Enabled() {
+		b.RecordIndex = recordIndex
+	}
+	return b
+}
+
+// withEmbeddingMapping adds a kNN vector field to an index schema. Only new indices get this field: the "index.knn" setting can't be changed on an existing index.
+func withEmbeddingMapping(schemaJSON string, dims int) (string, error) {
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		return "", fmt.Errorf("parsing index schema: %w", err)
+	}
+	settings, _ := schema["settings"].(map[string]any)
+	index, _ := settings["index"].(map[string]any)
+	mappings, _ := schema["mappings"].(map[string]any)
+	properties, _ := mappings["properties"].(map[string]any)
+	if index == nil || properties == nil {
+		return "", fmt.Errorf("unexpected index schema structure")
+	}
+	index["knn"] = true
+	properties["embedding"] = map[string]any{
+		"type":      "knn_vector",
+		"dimension": dims,
+		"method": map[string]any{
+			"name":       "hnsw",
+			"space_type": "cosinesimil",
+			"engine":     "lucene",
+		},
+	}
+	out, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (b *EsBackend) EnsureIndices(ctx context.Context) error {
+	type indexSchema struct {
+		Name       string
+		SchemaJSON string
+		// fields whose mappings are also applied to an existing index, for sub-fields which were added to the schema later
+		UpdateFields []string
+	}
+	indices := []indexSchema{
+		{Name: b.postIndex, SchemaJSON: palomarPostSchemaJSON, UpdateFields: []string{"did", "record_rkey"}},
+		{Name: b.profileIndex, SchemaJSON: palomarProfileSchemaJSON},
+	}
+	if b.EmbeddingDims > 0 {
+		// posts and profiles are embedded during indexing
+		for i := range indices {
+			schemaJSON, err := withEmbeddingMapping(indices[i].SchemaJSON, b.EmbeddingDims)
+			if err != nil {
+				return fmt.Errorf("index %s: %w", indices[i].Name, err)
+			}
+			indices[i].SchemaJSON = schemaJSON
+		}
+	}
+	if b.RecordIndex != "" {
+		indices = append(indices, indexSchema{Name: b.RecordIndex, SchemaJSON: palomarRecordSchemaJSON})
+	}
+	for _, index := range indices {
+		resp, err := b.escli.Indices.Exists([]string{index.Name})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.ReadAll(resp.Body)
+		if resp.IsError() && resp.StatusCode != 404 {
+			return fmt.Errorf("failed to check index existence")
+		}
+		if resp.StatusCode == 200 && len(index.UpdateFields) > 0 {
+			if err := b.updateFieldMappings(ctx, index.Name, index.SchemaJSON, index.UpdateFields); err != nil {
+				return err
+			}
+		}
+		if resp.StatusCode == 404 {
+			b.logger.Warn("creating opensearch index", "index", index.Name)
+			if len(index.SchemaJSON) < 2 {
+				return fmt.Errorf("empty schema file (go:embed failed)")
+			}
+			buf := strings.NewReader(index.SchemaJSON)
+			resp, err := b.escli.Indices.Create(
+				index.Name,
+				b.escli.Indices.Create.WithBody(buf))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			errBytes, err := io.ReadAll(resp.Body)
+			if resp.IsError() {
+				b.logger.Error("failed to create index", "index", index.Name, "response", string(errBytes))
+				return fmt.Errorf("failed to create index")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// updateFieldMappings applies the schema's mappings for the given fields to an existing index. Mappings can't be changed in place, but new sub-fields can be added; documents indexed earlier only get values for them when re-indexed.
+func (b *EsBackend) updateFieldMappings(ctx context.Context, index, schemaJSON string, fields []string) error {
+	var schema struct {
+		Mappings struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		return fmt.Errorf("parsing index schema: %w", err)
+	}
+	props := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		m, ok := schema.Mappings.Properties[f]
+		if !ok {
+			return fmt.Errorf("field not in index schema: %s", f)
+		}
+		props[f] = m
+	}
+	body, err := json.Marshal(map[string]any{"properties": props})
+	if err != nil {
+		return err
+	}
+	resp, err := b.escli.Indices.PutMapping(
+		bytes.NewReader(body),
+		b.escli.Indices.PutMapping.WithContext(ctx),
+		b.escli.Indices.PutMapping.WithIndex(index))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	errBytes, err := io.ReadAll(resp.Body)
+	if resp.IsError() {
+		b.logger.Error("failed to update index mappings", "index", index, "response", string(errBytes))
+		return fmt.Errorf("failed to update index mappings")
+	}
+	return err
+}
+
+func (b *EsBackend) bulk(index string, buf *bytes.Buffer) error {
+	res, err := b.escli.Bulk(bytes.NewReader(buf.Bytes()), b.escli.Bulk.WithIndex(index))
+	if err != nil {
+		b.logger.Warn("failed to send bulk indexing request", "err", err)
+		return fmt.Errorf("failed to send bulk indexing request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			b.logger.Warn("failed to read bulk indexing response", "err", err)
+			return fmt.Errorf("failed to read bulk indexing response: %w", err)
+		}
+		b.logger.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
+		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
+	}
+	return nil
+}
+
+func (b *EsBackend) IndexPosts(ctx context.Context, docs []PostDoc) error {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		docBytes, err := json.Marshal(doc)
+		if err != nil {
+			b.logger.Warn("failed to marshal post", "err", err)
+			return err
+		}
+
+		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, doc.DocId(), "\n"))
+		docBytes = append(docBytes, "\n"...)
+
+		buf.Grow(len(indexScript) + len(docBytes))
+		buf.Write(indexScript)
+		buf.Write(docBytes)
+	}
+	return b.bulk(b.postIndex, &buf)
+}
+
+func (b *EsBackend) DeletePost(ctx context.Context, docID string) error {
+	return b.delete(ctx, b.postIndex, docID)
+}
+
+func (b *EsBackend) delete(ctx context.Context, index, docID string) error {
+	req := esapi.DeleteRequest{
+		Index:      index,
+		DocumentID: docID,
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, b.escli)
+	if err != nil {
+		return fmt.Errorf("failed to delete post: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read indexing response: %w", err)
+	}
+	if res.IsError() {
+		b.logger.Warn("opensearch indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
+		return fmt.Errorf("indexing error, code=%d", res.StatusCode)
+	}
+	return nil
+}
+
+func (b *EsBackend) IndexProfiles(ctx context.Context, docs []ProfileDoc) error {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		docBytes, err := json.Marshal(doc)
+		if err != nil {
+			b.logger.Warn("failed to marshal profile", "err", err)
+			return err
+		}
+
+		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, doc.DocId(), "\n"))
+		docBytes = append(docBytes, "\n"...)
+
+		buf.Grow(len(indexScript) + len(docBytes))
+		buf.Write(indexScript)
+		buf.Write(docBytes)
+	}
+	return b.bulk(b.profileIndex, &buf)
+}
+
+func (b *EsBackend) UpdateProfileHandle(ctx context.Context, did syntax.DID, handle string) error {
+	body, err := json.Marshal(map[string]any{
+		"script": map[string]any{
+			"source": "ctx._source.handle = params.handle",
+			"lang":   "painless",
+			"params": map[string]any{
+				"handle": handle,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal update script: %w", err)
+	}
+
+	req := esapi.UpdateRequest{
+		Index:      b.profileIndex,
+		DocumentID: did.String(),
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, b.escli)
+	if err != nil {
+		return fmt.Errorf("failed to send indexing request: %w", err)
+	}
+	defer res.Body.Close()
+	respBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read indexing response: %w", err)
+	}
+	if res.IsError() {
+		b.logger.Warn("opensearch indexing error", "status_code", res.StatusCode, "response", res, "body", string(respBody))
+		return fmt.Errorf("indexing error, code=%d", res.StatusCode)
+	}
+	return nil
+}
+
+// UpdatePageranks uses the OpenSearch bulk API to update the pageranks for the given DIDs
+func (b *EsBackend) UpdatePageranks(ctx context.Context, jobs []*PagerankIndexJob) error {
+	var buf bytes.Buffer
+	for _, pr := range jobs {
+		updateScript := map[string]any{
+			"script": map[string]any{
+				"source": "ctx._source.pagerank = params.pagerank",
+				"lang":   "painless",
+				"params": map[string]any{
+					"pagerank": pr.rank,
+				},
+			},
+		}
+		updateScriptJSON, err := json.Marshal(updateScript)
+		if err != nil {
+			b.logger.Warn("failed to marshal update script", "err", err)
+			return err
+		}
+
+		updateMetaJSON := []byte(fmt.Sprintf(`{"update":{"_id":"%s"}}%s`, pr.did.String(), "\n"))
+		updateScriptJSON = append(updateScriptJSON, "\n"...)
+
+		buf.Grow(len(updateMetaJSON) + len(updateScriptJSON))
+		buf.Write(updateMetaJSON)
+		buf.Write(updateScriptJSON)
+	}
+	return b.bulk(b.profileIndex, &buf)
+}
+
+func (b *EsBackend) SearchPosts(ctx context.Context, params *PostSearchParams) (*EsSearchResponse, error) {
+	return doSearchPosts(ctx, b.escli, b.postIndex, params)
+}
+
+func (b *EsBackend) SearchProfiles(ctx context.Context, params *ActorSearchParams) (*EsSearchResponse, error) {
+	return DoSearchProfiles(ctx, nil, b.escli, b.profileIndex, params)
+}
+
+func (b *EsBackend) SearchProfilesTypeahead(ctx context.Context, params *ActorSearchParams) (*EsSearchResponse, error) {
+	return DoSearchProfilesTypeahead(ctx, b.escli, b.profileIndex, params)
+}
+
+func (b *EsBackend) IndexRecords(ctx context.Context, docs []RecordDoc) error {
+	if b.RecordIndex == "" {
+		return fmt.Errorf("record index not configured")
+	}
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		docBytes, err := json.Marshal(doc)
+		if err != nil {
+			b.logger.Warn("failed to marshal record", "err", err)
+			return err
+		}
+
+		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, doc.DocId(), "\n"))
+		docBytes = append(docBytes, "\n"...)
+
+		buf.Grow(len(indexScript) + len(docBytes))
+		buf.Write(indexScript)
+		buf.Write(docBytes)
+	}
+	return b.bulk(b.RecordIndex, &buf)
+}
+
+func (b *EsBackend) DeleteRecord(ctx context.Context, docID string) error {
+	if b.RecordIndex == "" {
+		return fmt.Errorf("record index not configured")
+	}
+	return b.delete(ctx, b.RecordIndex, docID)
+}
+
+func (b *EsBackend) SearchRecords(ctx context.Context, params *RecordSearchParams) (*EsSearchResponse, error) {
+	if b.RecordIndex == "" {
+		return nil, fmt.Errorf("record index not configured")
+	}
+	return doSearchRecords(ctx, b.escli, b.RecordIndex, params)
+}
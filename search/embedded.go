@@ -0,0 +1,914 @@
+package search
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// EmbeddedBackend is a Backend which keeps documents and an inverted index in a local pebble database, so palomar can run as a single binary without an OpenSearch cluster.
+//
+// It supports the same filters as the OpenSearch backend, but text analysis is simpler: text is lowercased and split on anything which isn't a letter or digit, and runs of CJK characters are indexed as overlapping bigrams. Post results are sorted by creation time, newest first; profile results by a simple score.
+//
+// Schema:
+// p{post doc id} : {PostDoc JSON}
+// a{did} : {ProfileDoc JSON}
+// g{did} : {float64 pagerank}
+// r{record doc id} : {RecordDoc JSON}
+// s{term}\x00{record doc id} : {}
+// t{term}\x00{post doc id} : {}
+// u{term}\x00{did} : {}
+type EmbeddedBackend struct {
+	db     *pebble.DB
+	logger *slog.Logger
+
+	// serializes updates of documents along with their postings
+	lk sync.Mutex
+}
+
+func NewEmbeddedBackend(dir string, logger *slog.Logger) (*EmbeddedBackend, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	dbPath := filepath.Join(dir, "palomar.pebble")
+	db, err := pebble.Open(dbPath, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: pebble could not open, %w", dbPath, err)
+	}
+	return &EmbeddedBackend{
+		db:     db,
+		logger: logger.With("component", "embedded-backend"),
+	}, nil
+}
+
+func (b *EmbeddedBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *EmbeddedBackend) EnsureIndices(ctx context.Context) error {
+	return nil
+}
+
+func embeddedKey(prefix byte, id string) []byte {
+	return append([]byte{prefix}, id...)
+}
+
+func embeddedPostingKey(prefix byte, term, id string) []byte {
+	k := make([]byte, 0, 2+len(term)+len(id))
+	k = append(k, prefix)
+	k = append(k, term...)
+	k = append(k, 0)
+	return append(k, id...)
+}
+
+// returns the upper bound for keys starting with the given prefix
+func embeddedUpperBound(prefix []byte) []byte {
+	ub := bytes.Clone(prefix)
+	for i := len(ub) - 1; i >= 0; i-- {
+		if ub[i] < 0xff {
+			ub[i]++
+			return ub[:i+1]
+		}
+	}
+	return nil
+}
+
+func isCJK(r rune) bool {
+	// the prolonged sound and iteration marks are "common" script, but only occur within CJK text
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || r == 'ー' || r == '々'
+}
+
+// embeddedTokens splits text in to lowercase terms. Runs of CJK characters become overlapping bigrams, so matching part of a run works without a dictionary.
+func embeddedTokens(text string) []string {
+	var out []string
+	var run []rune
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		if len(run) > 1 && isCJK(run[0]) {
+			for i := 0; i+1 < len(run); i++ {
+				out = append(out, string(run[i:i+2]))
+			}
+		} else {
+			out = append(out, string(run))
+		}
+		run = run[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r) {
+			flush()
+			continue
+		}
+		if len(run) > 0 && isCJK(r) != isCJK(run[len(run)-1]) {
+			flush()
+		}
+		run = append(run, r)
+	}
+	flush()
+	return out
+}
+
+// embeddedQuery is a parsed query string. Like the OpenSearch simple query syntax, terms are ANDed, "quoted phrases" must match in order, a "-" prefix negates, and a trailing "*" matches a prefix.
+type embeddedQuery struct {
+	// a term which analyzes to multiple tokens (eg, "en.wikipedia.org", or CJK text) is handled as a phrase
+	phrases        [][]string
+	prefixes       []string
+	excludePhrases [][]string
+}
+
+func parseEmbeddedQuery(raw string) embeddedQuery {
+	quoted := false
+	parts := strings.FieldsFunc(raw, func(r rune) bool {
+		if r == '"' {
+			quoted = !quoted
+		}
+		return r == ' ' && !quoted
+	})
+
+	var q embeddedQuery
+	for _, p := range parts {
+		negated := false
+		if strings.HasPrefix(p, "-") && len(p) > 1 {
+			negated = true
+			p = p[1:]
+		}
+		if !negated && strings.HasSuffix(p, "*") && !strings.HasPrefix(p, "\"") {
+			q.addPrefix(embeddedTokens(strings.TrimSuffix(p, "*")))
+			continue
+		}
+		toks := embeddedTokens(p)
+		if len(toks) == 0 {
+			continue
+		}
+		if negated {
+			q.excludePhrases = append(q.excludePhrases, toks)
+		} else {
+			q.phrases = append(q.phrases, toks)
+		}
+	}
+	return q
+}
+
+// adds a term with multiple tokens, of which the last is a prefix
+func (q *embeddedQuery) addPrefix(toks []string) {
+	if len(toks) == 0 {
+		return
+	}
+	for _, t := range toks[:len(toks)-1] {
+		q.phrases = append(q.phrases, []string{t})
+	}
+	q.prefixes = append(q.prefixes, toks[len(toks)-1])
+}
+
+// requiredTerms returns every token which a matching document must contain
+func (q *embeddedQuery) requiredTerms() []string {
+	var out []string
+	for _, p := range q.phrases {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func containsPhrase(fields [][]string, phrase []string) bool {
+	for _, toks := range fields {
+		for i := 0; i+len(phrase) <= len(toks); i++ {
+			if slices.Equal(toks[i:i+len(phrase)], phrase) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsPrefix(fields [][]string, prefix string) bool {
+	for _, toks := range fields {
+		for _, t := range toks {
+			if strings.HasPrefix(t, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *embeddedQuery) match(fields [][]string) bool {
+	for _, p := range q.phrases {
+		if !containsPhrase(fields, p) {
+			return false
+		}
+	}
+	for _, p := range q.prefixes {
+		if !containsPrefix(fields, p) {
+			return false
+		}
+	}
+	for _, p := range q.excludePhrases {
+		if containsPhrase(fields, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func postFields(doc *PostDoc) [][]string {
+	fields := [][]string{embeddedTokens(doc.Text)}
+	for _, alt := range doc.EmbedImgAltText {
+		fields = append(fields, embeddedTokens(alt))
+	}
+	return fields
+}
+
+// the handle is also indexed whole, like the keyword field in OpenSearch
+func profileFields(doc *ProfileDoc, typeahead bool) [][]string {
+	fields := [][]string{{strings.ToLower(doc.Handle)}, embeddedTokens(doc.Handle)}
+	if doc.DisplayName != nil {
+		fields = append(fields, embeddedTokens(*doc.DisplayName))
+	}
+	if typeahead {
+		return fields
+	}
+	if doc.Description != nil {
+		fields = append(fields, embeddedTokens(*doc.Description))
+	}
+	for _, alt := range doc.ImgAltText {
+		fields = append(fields, embeddedTokens(alt))
+	}
+	return fields
+}
+
+func termSet(fields [][]string) map[string]bool {
+	out := make(map[string]bool)
+	for _, toks := range fields {
+		for _, t := range toks {
+			out[t] = true
+		}
+	}
+	return out
+}
+
+// updates the postings for a document, from the terms of its previous version
+func (b *EmbeddedBackend) updatePostings(batch *pebble.Batch, prefix byte, id string, oldTerms, newTerms map[string]bool) error {
+	for t := range oldTerms {
+		if !newTerms[t] {
+			if err := batch.Delete(embeddedPostingKey(prefix, t, id), nil); err != nil {
+				return err
+			}
+		}
+	}
+	for t := range newTerms {
+		if !oldTerms[t] {
+			if err := batch.Set(embeddedPostingKey(prefix, t, id), nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getDoc[T any](db *pebble.DB, key []byte) (*T, []byte, error) {
+	val, closer, err := db.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closer.Close()
+	raw := bytes.Clone(val)
+	var doc T
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, fmt.Errorf("decoding indexed document: %w", err)
+	}
+	return &doc, raw, nil
+}
+
+func (b *EmbeddedBackend) IndexPosts(ctx context.Context, docs []PostDoc) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	batch := b.db.NewBatch()
+	defer batch.Close()
+	for i := range docs {
+		doc := &docs[i]
+		id := doc.DocId()
+		old, _, err := getDoc[PostDoc](b.db, embeddedKey('p', id))
+		if err != nil {
+			return err
+		}
+		var oldTerms map[string]bool
+		if old != nil {
+			oldTerms = termSet(postFields(old))
+		}
+		if err := b.updatePostings(batch, 't', id, oldTerms, termSet(postFields(doc))); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if err := batch.Set(embeddedKey('p', id), raw, nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *EmbeddedBackend) DeletePost(ctx context.Context, docID string) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	old, _, err := getDoc[PostDoc](b.db, embeddedKey('p', docID))
+	if err != nil || old == nil {
+		return err
+	}
+	batch := b.db.NewBatch()
+	defer batch.Close()
+	if err := b.updatePostings(batch, 't', docID, termSet(postFields(old)), nil); err != nil {
+		return err
+	}
+	if err := batch.Delete(embeddedKey('p', docID), nil); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *EmbeddedBackend) putProfiles(docs []ProfileDoc) error {
+	batch := b.db.NewBatch()
+	defer batch.Close()
+	for i := range docs {
+		doc := &docs[i]
+		id := doc.DocId()
+		old, _, err := getDoc[ProfileDoc](b.db, embeddedKey('a', id))
+		if err != nil {
+			return err
+		}
+		var oldTerms map[string]bool
+		if old != nil {
+			oldTerms = termSet(profileFields(old, false))
+		}
+		if err := b.updatePostings(batch, 'u', id, oldTerms, termSet(profileFields(doc, false))); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if err := batch.Set(embeddedKey('a', id), raw, nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *EmbeddedBackend) IndexProfiles(ctx context.Context, docs []ProfileDoc) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.putProfiles(docs)
+}
+
+func (b *EmbeddedBackend) UpdateProfileHandle(ctx context.Context, did syntax.DID, handle string) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	doc, _, err := getDoc[ProfileDoc](b.db, embeddedKey('a', did.String()))
+	if err != nil || doc == nil {
+		return err
+	}
+	doc.Handle = handle
+	return b.putProfiles([]ProfileDoc{*doc})
+}
+
+func (b *EmbeddedBackend) UpdatePageranks(ctx context.Context, jobs []*PagerankIndexJob) error {
+	batch := b.db.NewBatch()
+	defer batch.Close()
+	for _, pr := range jobs {
+		var val [8]byte
+		binary.BigEndian.PutUint64(val[:], math.Float64bits(pr.rank))
+		if err := batch.Set(embeddedKey('g', pr.did.String()), val[:], nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *EmbeddedBackend) pagerank(did string) (float64, error) {
+	val, closer, err := b.db.Get(embeddedKey('g', did))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+	if len(val) != 8 {
+		return 0, fmt.Errorf("bad pagerank value for %s", did)
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(val)), nil
+}
+
+// scanIDs calls cb with the ID part of every key starting with prefix
+func (b *EmbeddedBackend) scanIDs(prefix []byte, cb func(id string) error) error {
+	iter, err := b.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: embeddedUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		k := iter.Key()[len(prefix):]
+		if i := bytes.IndexByte(k, 0); i >= 0 {
+			k = k[i+1:]
+		}
+		if err := cb(string(k)); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// candidates returns the IDs of documents containing every term and a term with each prefix, or nil (rather than an empty set) if there are no terms, meaning every document is a candidate
+func (b *EmbeddedBackend) candidates(postingPrefix byte, terms, prefixes []string) (map[string]bool, error) {
+	var out map[string]bool
+	intersect := func(scanPrefix []byte) error {
+		found := make(map[string]bool)
+		err := b.scanIDs(scanPrefix, func(id string) error {
+			if out == nil || out[id] {
+				found[id] = true
+			}
+			return nil
+		})
+		out = found
+		return err
+	}
+	for _, t := range terms {
+		if err := intersect(embeddedPostingKey(postingPrefix, t, "")); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range prefixes {
+		if err := intersect(embeddedKey(postingPrefix, p)); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// forEachDoc calls cb for each candidate document, or every document if candidates is nil
+func forEachDoc[T any](b *EmbeddedBackend, docPrefix byte, candidates map[string]bool, cb func(doc *T, raw []byte) error) error {
+	if candidates != nil {
+		for id := range candidates {
+			doc, raw, err := getDoc[T](b.db, embeddedKey(docPrefix, id))
+			if err != nil {
+				return err
+			}
+			if doc == nil {
+				continue
+			}
+			if err := cb(doc, raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	prefix := []byte{docPrefix}
+	iter, err := b.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: embeddedUpperBound(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		raw := bytes.Clone(iter.Value())
+		var doc T
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return fmt.Errorf("decoding indexed document: %w", err)
+		}
+		if err := cb(&doc, raw); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func pageHits(hits []EsSearchHit, offset, size int) *EsSearchResponse {
+	var out EsSearchResponse
+	out.Hits.Total.Value = len(hits)
+	out.Hits.Total.Relation = "eq"
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	hits = hits[offset:]
+	if len(hits) > size {
+		hits = hits[:size]
+	}
+	out.Hits.Hits = hits
+	for _, h := range hits {
+		out.Hits.MaxScore = max(out.Hits.MaxScore, h.Score)
+	}
+	return &out
+}
+
+func (b *EmbeddedBackend) SearchPosts(ctx context.Context, params *PostSearchParams) (*EsSearchResponse, error) {
+	ctx, span := tracer.Start(ctx, "EmbeddedSearchPosts")
+	defer span.End()
+	start := time.Now()
+
+	q := parseEmbeddedQuery(params.Query)
+	if params.relevance() {
+		return b.searchPostsRelevance(params, &q)
+	}
+	candidates, err := b.candidates('t', q.requiredTerms(), q.prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	type postHit struct {
+		key postSortKey
+		hit EsSearchHit
+	}
+	var hits []postHit
+	now := time.Now()
+	err = forEachDoc(b, 'p', candidates, func(doc *PostDoc, raw []byte) error {
+		// like the OpenSearch query, posts without a valid timestamp, or from the future, are never returned
+		if doc.CreatedAt == nil {
+			return nil
+		}
+		createdAt, err := syntax.ParseDatetimeLenient(*doc.CreatedAt)
+		if err != nil || createdAt.Time().After(now) {
+			return nil
+		}
+		if !matchPost(params, &q, doc) {
+			return nil
+		}
+		// sort values are the same as for OpenSearch (see doSearchPosts), so cursors are compatible
+		key := newPostSortKey(createdAt.Time().UnixMilli(), doc.DID, doc.RecordRkey)
+		hits = append(hits, postHit{
+			key: key,
+			hit: EsSearchHit{Index: "post", ID: doc.DocId(), Score: 1, Source: raw, Sort: key.values()},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmpHits := func(a, b postHit) int {
+		return a.key.compare(b.key)
+	}
+	slices.SortFunc(hits, cmpHits)
+
+	offset := params.Offset
+	if params.SearchAfter != nil {
+		key, err := parseSearchAfter(params.SearchAfter)
+		if err != nil {
+			return nil, err
+		}
+		after := postHit{key: key}
+		offset, _ = slices.BinarySearchFunc(hits, after, cmpHits)
+		if offset < len(hits) && cmpHits(hits[offset], after) == 0 {
+			offset++
+		}
+	}
+
+	out := make([]EsSearchHit, len(hits))
+	for i, h := range hits {
+		out[i] = h.hit
+	}
+	resp := pageHits(out, offset, params.Size)
+	resp.Took = int(time.Since(start).Milliseconds())
+	return resp, nil
+}
+
+// matchPost checks a post against the text query (already parsed as q) and filters of params, using the simple text matching of the embedded index
+func matchPost(params *PostSearchParams, q *embeddedQuery, doc *PostDoc) bool {
+	fields := postFields(doc)
+	return params.matchDoc(doc, textMatcher(fields)) && q.match(fields)
+}
+
+// textMatcher returns a matchText callback for PostSearchParams.matchDoc
+func textMatcher(fields [][]string) func(text string) bool {
+	return func(text string) bool {
+		tq := parseEmbeddedQuery(text)
+		return tq.match(fields)
+	}
+}
+
+// searchPostsRelevance ranks posts by similarity to params.QueryVector, like the OpenSearch kNN query: the nearest neighbors are found first, and then filtered. For the "hybrid" sort, lexical matches are also included, with a bonus to their score. Scores are on the same scale as OpenSearch: (1 + cosine similarity) / 2 for neighbors, and a lexical match (which has no BM25 score here) counts as a match at hybridLexicalPivot. Every post is scanned.
+func (b *EmbeddedBackend) searchPostsRelevance(params *PostSearchParams, q *embeddedQuery) (*EsSearchResponse, error) {
+	start := time.Now()
+
+	type scoredPost struct {
+		sim     float64
+		hasVec  bool
+		matches bool
+		lexical bool
+		hit     EsSearchHit
+	}
+	var all []scoredPost
+	now := time.Now()
+	err := forEachDoc(b, 'p', nil, func(doc *PostDoc, raw []byte) error {
+		if doc.CreatedAt == nil {
+			return nil
+		}
+		createdAt, err := syntax.ParseDatetimeLenient(*doc.CreatedAt)
+		if err != nil || createdAt.Time().After(now) {
+			return nil
+		}
+		fields := postFields(doc)
+		all = append(all, scoredPost{
+			sim:     cosineSimilarity(params.QueryVector, doc.Embedding),
+			hasVec:  len(doc.Embedding) > 0,
+			matches: params.matchDoc(doc, textMatcher(fields)),
+			lexical: params.Sort == "hybrid" && q.match(fields),
+			hit:     EsSearchHit{Index: "post", ID: doc.DocId(), Source: raw},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(all, func(a, b scoredPost) int {
+		if c := cmp.Compare(b.sim, a.sim); c != 0 {
+			return c
+		}
+		return strings.Compare(b.hit.ID, a.hit.ID)
+	})
+	var hits []EsSearchHit
+	neighbors := 0
+	for _, p := range all {
+		neighbor := p.hasVec && neighbors < params.knnK()
+		if neighbor {
+			neighbors++
+		}
+		if !p.matches || (!neighbor && !p.lexical) {
+			continue
+		}
+		if neighbor {
+			p.hit.Score += (1 + p.sim) / 2
+		}
+		if p.lexical {
+			p.hit.Score += 0.5
+		}
+		hits = append(hits, p.hit)
+	}
+	slices.SortStableFunc(hits, func(a, b EsSearchHit) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	resp := pageHits(hits, params.Offset, params.Size)
+	resp.Took = int(time.Since(start).Milliseconds())
+	return resp, nil
+}
+
+// profileQuery is a query against either all the text of profiles, or just names (for typeahead)
+type profileQuery struct {
+	q         embeddedQuery
+	typeahead bool
+}
+
+func (b *EmbeddedBackend) searchProfiles(ctx context.Context, params *ActorSearchParams, queries []profileQuery) (*EsSearchResponse, error) {
+	if err := checkParams(params.Offset, params.Size); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	candidates := make(map[string]bool)
+	for _, pq := range queries {
+		// an empty query matches nothing
+		if len(pq.q.phrases) == 0 && len(pq.q.prefixes) == 0 {
+			continue
+		}
+		ids, err := b.candidates('u', pq.q.requiredTerms(), pq.q.prefixes)
+		if err != nil {
+			return nil, err
+		}
+		for id := range ids {
+			candidates[id] = true
+		}
+	}
+
+	var hits []EsSearchHit
+	err := forEachDoc(b, 'a', candidates, func(doc *ProfileDoc, raw []byte) error {
+		if !params.matchDoc(doc) {
+			return nil
+		}
+		score := 0.0
+		for _, pq := range queries {
+			if pq.q.match(profileFields(doc, pq.typeahead)) {
+				score = 1
+				// matches on the name are better than on the description
+				if !pq.typeahead && pq.q.match(profileFields(doc, true)) {
+					score += 1
+				}
+				break
+			}
+		}
+		if score == 0 {
+			return nil
+		}
+		if doc.HasAvatar {
+			score += 0.1
+		}
+		if doc.HasBanner {
+			score += 0.1
+		}
+		rank, err := b.pagerank(doc.DID)
+		if err != nil {
+			return err
+		}
+		score += rank
+		hits = append(hits, EsSearchHit{Index: "profile", ID: doc.DocId(), Score: score, Source: raw})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(hits, func(a, b EsSearchHit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	resp := pageHits(hits, params.Offset, params.Size)
+	resp.Took = int(time.Since(start).Milliseconds())
+	return resp, nil
+}
+
+// the last term is always a prefix when typing
+func typeaheadQuery(raw string) embeddedQuery {
+	q := parseEmbeddedQuery(raw)
+	if len(q.prefixes) == 0 && len(q.phrases) > 0 {
+		last := q.phrases[len(q.phrases)-1]
+		q.phrases = q.phrases[:len(q.phrases)-1]
+		q.addPrefix(last)
+	}
+	return q
+}
+
+func (b *EmbeddedBackend) SearchProfiles(ctx context.Context, params *ActorSearchParams) (*EsSearchResponse, error) {
+	ctx, span := tracer.Start(ctx, "EmbeddedSearchProfiles")
+	defer span.End()
+
+	queries := []profileQuery{{q: parseEmbeddedQuery(params.Query)}}
+	// like the OpenSearch query, a single term also matches as a typeahead prefix
+	if len(strings.Split(params.Query, " ")) == 1 {
+		queries = append(queries, profileQuery{q: typeaheadQuery(params.Query), typeahead: true})
+	}
+	return b.searchProfiles(ctx, params, queries)
+}
+
+func (b *EmbeddedBackend) SearchProfilesTypeahead(ctx context.Context, params *ActorSearchParams) (*EsSearchResponse, error) {
+	ctx, span := tracer.Start(ctx, "EmbeddedSearchProfilesTypeahead")
+	defer span.End()
+
+	return b.searchProfiles(ctx, params, []profileQuery{{q: typeaheadQuery(params.Query), typeahead: true}})
+}
+
+func recordFields(doc *RecordDoc) [][]string {
+	fields := make([][]string, len(doc.Text))
+	for i, text := range doc.Text {
+		fields[i] = embeddedTokens(text)
+	}
+	return fields
+}
+
+func (b *EmbeddedBackend) IndexRecords(ctx context.Context, docs []RecordDoc) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	batch := b.db.NewBatch()
+	defer batch.Close()
+	for i := range docs {
+		doc := &docs[i]
+		id := doc.DocId()
+		old, _, err := getDoc[RecordDoc](b.db, embeddedKey('r', id))
+		if err != nil {
+			return err
+		}
+		var oldTerms map[string]bool
+		if old != nil {
+			oldTerms = termSet(recordFields(old))
+		}
+		if err := b.updatePostings(batch, 's', id, oldTerms, termSet(recordFields(doc))); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if err := batch.Set(embeddedKey('r', id), raw, nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *EmbeddedBackend) DeleteRecord(ctx context.Context, docID string) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	old, _, err := getDoc[RecordDoc](b.db, embeddedKey('r', docID))
+	if err != nil || old == nil {
+		return err
+	}
+	batch := b.db.NewBatch()
+	defer batch.Close()
+	if err := b.updatePostings(batch, 's', docID, termSet(recordFields(old)), nil); err != nil {
+		return err
+	}
+	if err := batch.Delete(embeddedKey('r', docID), nil); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func (b *EmbeddedBackend) SearchRecords(ctx context.Context, params *RecordSearchParams) (*EsSearchResponse, error) {
+	ctx, span := tracer.Start(ctx, "EmbeddedSearchRecords")
+	defer span.End()
+	start := time.Now()
+
+	q := parseEmbeddedQuery(params.Query)
+	candidates, err := b.candidates('s', q.requiredTerms(), q.prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	type recordHit struct {
+		createdAt int64
+		// tie-breakers, lowercased like the keyword field normalizer
+		did, collection, rkey string
+		hit                   EsSearchHit
+	}
+	var hits []recordHit
+	err = forEachDoc(b, 'r', candidates, func(doc *RecordDoc, raw []byte) error {
+		if !params.matchDoc(doc) || !q.match(recordFields(doc)) {
+			return nil
+		}
+		h := recordHit{
+			createdAt:  math.MinInt64,
+			did:        strings.ToLower(doc.DID),
+			collection: strings.ToLower(doc.Collection),
+			rkey:       strings.ToLower(doc.RecordRkey),
+			hit:        EsSearchHit{Index: "record", ID: doc.DocId(), Score: 1, Source: raw},
+		}
+		if doc.CreatedAt != nil {
+			if createdAt, err := syntax.ParseDatetimeLenient(*doc.CreatedAt); err == nil {
+				h.createdAt = createdAt.Time().UnixMilli()
+			}
+		}
+		hits = append(hits, h)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// newest first (records without a date last), like the OpenSearch query
+	slices.SortFunc(hits, func(a, b recordHit) int {
+		if c := cmp.Compare(b.createdAt, a.createdAt); c != 0 {
+			return c
+		}
+		if c := strings.Compare(b.did, a.did); c != 0 {
+			return c
+		}
+		if c := strings.Compare(b.collection, a.collection); c != 0 {
+			return c
+		}
+		return strings.Compare(b.rkey, a.rkey)
+	})
+
+	out := make([]EsSearchHit, len(hits))
+	for i, h := range hits {
+		out[i] = h.hit
+	}
+	resp := pageHits(out, params.Offset, params.Size)
+	resp.Took = int(time.Since(start).Milliseconds())
+	return resp, nil
+}
@@ -0,0 +1,313 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testEmbeddedServer(t *testing.T, dir identity.Directory) *Server {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewEmbeddedBackend(t.TempDir(), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	srv, err := NewServer(nil, dir, ServerConfig{Logger: slog.Default(), Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := NewIndexer(db, nil, dir, IndexerConfig{
+		RelayHost:           "wss://relay.invalid",
+		RelaySyncRateLimit:  1,
+		IndexMaxConcurrency: 1,
+		IndexingRateLimit:   1000,
+		Backend:             backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Indexer = idx
+	return srv
+}
+
+func TestEmbeddedTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"basic", "english", "post"}, embeddedTokens("Basic English post!"))
+	assert.Equal([]string{"en", "wikipedia", "org"}, embeddedTokens("en.wikipedia.org"))
+	assert.Equal([]string{"multilingual", "多言", "言語"}, embeddedTokens("multilingual多言語"))
+	assert.Equal([]string{"ハリ", "リー", "ポッ", "ッタ", "ター"}, embeddedTokens("ハリー・ポッター"))
+	assert.Equal([]string{"ハ"}, embeddedTokens("ハ"))
+}
+
+func TestEmbeddedPostSearch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	ident := identity.Identity{
+		DID:    syntax.DID("did:plc:abc111"),
+		Handle: syntax.Handle("handle.example.com"),
+	}
+	other := identity.Identity{
+		DID:    syntax.DID("did:plc:abc222"),
+		Handle: syntax.Handle("other.example.com"),
+	}
+	dir.Insert(ident)
+	dir.Insert(other)
+	srv := testEmbeddedServer(t, &dir)
+
+	posts := map[string]*appbsky.FeedPost{
+		"3kpnillluaaaa": {Text: "basic english post", CreatedAt: "2024-01-02T03:04:05.006Z"},
+		"3kpnilllu2222": {Text: "another english post", CreatedAt: "2024-01-02T03:04:05.006Z"},
+		"3kpnilllu3333": {
+			Text:      "#cat post with hashtag",
+			CreatedAt: "2024-01-02T03:04:05.006Z",
+			Facets: []*appbsky.RichtextFacet{{
+				Features: []*appbsky.RichtextFacet_Features_Elem{{RichtextFacet_Tag: &appbsky.RichtextFacet_Tag{Tag: "trick"}}},
+				Index:    &appbsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 4},
+			}},
+		},
+		"3kpnilllu4444": {
+			Text:      "@other.example.com post with mention",
+			CreatedAt: "2024-01-02T03:04:05.006Z",
+			Facets: []*appbsky.RichtextFacet{{
+				Features: []*appbsky.RichtextFacet_Features_Elem{{RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{Did: "did:plc:abc222"}}},
+				Index:    &appbsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 18},
+			}},
+		},
+		"3kpnilllu5555": {
+			Text:      "https://bsky.app... post with hashtag #cat",
+			CreatedAt: "2024-01-02T03:04:05.006Z",
+			Facets: []*appbsky.RichtextFacet{{
+				Features: []*appbsky.RichtextFacet_Features_Elem{{RichtextFacet_Link: &appbsky.RichtextFacet_Link{Uri: "htTPS://www.en.wikipedia.org/wiki/CBOR?q=3&a=1&utm_campaign=123"}}},
+				Index:    &appbsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 19},
+			}},
+		},
+		"3kpnilllu6666": {Text: "post with lang (deutsch)", CreatedAt: "2024-01-02T03:04:05.006Z", Langs: []string{"ja", "de-DE"}},
+		"3kpnilllu7777": {Text: "post with old date", CreatedAt: "2020-05-03T03:04:05.006Z"},
+		"3kpnilllu8888": {
+			Text:      "post with parent",
+			CreatedAt: "2024-01-02T03:04:05.006Z",
+			Reply: &appbsky.FeedPost_ReplyRef{
+				Parent: &comatproto.RepoStrongRef{Uri: "at://did:plc:abc111/app.bsky.feed.post/3kpnilllu4444"},
+				Root:   &comatproto.RepoStrongRef{Uri: "at://did:plc:abc111/app.bsky.feed.post/3kpnilllu4444"},
+			},
+		},
+		"3kpnilllu9999": {Text: "東京都 ハリー・ポッター", CreatedAt: "2024-01-02T03:04:05.006Z"},
+	}
+	var jobs []*PostIndexJob
+	for rkey, p := range posts {
+		jobs = append(jobs, &PostIndexJob{did: ident.DID, record: p, rkey: rkey, rcid: cid.Undef})
+	}
+	assert.NoError(srv.Indexer.indexPosts(ctx, jobs))
+
+	count := func(q string) int {
+		out, err := srv.SearchPosts(ctx, &PostSearchParams{Query: q, Size: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(out.Posts)
+	}
+
+	assert.Equal(9, count("*"))
+	assert.Equal(2, count("english"))
+	assert.Equal(2, count("English"))
+	assert.Equal(1, count("\"basic english\""))
+	assert.Equal(0, count("\"english basic\""))
+	assert.Equal(1, count("english -basic"))
+	assert.Equal(2, count("eng*"))
+	assert.Equal(9, count("from:handle.example.com"))
+	assert.Equal(0, count("from:other.example.com"))
+	assert.Equal(1, count("post #trick"))
+	assert.Equal(1, count("post #Trick"))
+	assert.Equal(0, count("post #trick #allMustMatch"))
+	assert.Equal(1, count("@other.example.com"))
+	assert.Equal(1, count("to:handle.example.com"))
+	assert.Equal(1, count("https://en.wikipedia.org/wiki/CBOR?a=1&q=3"))
+	assert.Equal(0, count("\"https://en.wikipedia.org/wiki/CBOR?a=1&q=3\""))
+	assert.Equal(1, count("domain:en.wikipedia.org"))
+	assert.Equal(1, count("lang:de"))
+	assert.Equal(8, count("since:2023-01-01"))
+	assert.Equal(1, count("until:2023-01-01"))
+	assert.Equal(1, count("京都"))
+	assert.Equal(1, count("ハリー"))
+	assert.Equal(0, count("ハ"))
+
+	// boolean queries
+	assert.Equal(4, count("english OR hashtag"))
+	assert.Equal(3, count("(english OR hashtag) -basic"))
+	assert.Equal(4, count("post -(english OR hashtag)"))
+	assert.Equal(8, count("-lang:de"))
+	assert.Equal(1, count("lang:ja,fr"))
+	assert.Equal(9, count("from:other.example.com,handle.example.com"))
+	assert.Equal(0, count("-from:handle.example.com"))
+	assert.Equal(2, count("@other.example.com OR has:link"))
+	assert.Equal(1, count("is:reply"))
+	assert.Equal(0, count("is:quote"))
+	assert.Equal(0, count("embed:at://did:plc:abc111/app.bsky.feed.post/3kpnilllu4444"))
+
+	// newest first, and paged
+	out, err := srv.SearchPosts(ctx, &PostSearchParams{Query: "post", Size: 3})
+	assert.NoError(err)
+	assert.Len(out.Posts, 3)
+	assert.NotNil(out.Cursor)
+	assert.Equal(int64(8), *out.HitsTotal)
+	out, err = srv.SearchPosts(ctx, &PostSearchParams{Query: "post", Size: 10, Offset: 7})
+	assert.NoError(err)
+	assert.Len(out.Posts, 1)
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/3kpnilllu7777", out.Posts[0].Uri)
+
+	// cursor pagination visits every post once, in the same order
+	var all []string
+	params := &PostSearchParams{Query: "post", Size: 3}
+	for {
+		out, err := srv.SearchPosts(ctx, params)
+		assert.NoError(err)
+		for _, p := range out.Posts {
+			all = append(all, p.Uri)
+		}
+		if out.Cursor == nil {
+			break
+		}
+		after, err := DecodePostCursor(*out.Cursor)
+		assert.NoError(err)
+		params = &PostSearchParams{Query: "post", Size: 3, SearchAfter: after}
+	}
+	assert.Len(all, 8)
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/3kpnilllu7777", all[7])
+	_, err = DecodePostCursor("bm90IGpzb24")
+	assert.Error(err)
+	// cursors with the document ID as tie-breaker (instead of DID and record key) are not accepted
+	old, err := EncodePostCursor([]any{int64(1704164645006), "did:plc:abc111_3kpnilllu7777"})
+	assert.NoError(err)
+	_, err = DecodePostCursor(old)
+	assert.Error(err)
+
+	// updates and deletes also update the index
+	assert.NoError(srv.Indexer.indexPosts(ctx, []*PostIndexJob{{
+		did:    ident.DID,
+		record: &appbsky.FeedPost{Text: "edited post", CreatedAt: "2024-01-02T03:04:05.006Z"},
+		rkey:   "3kpnillluaaaa",
+		rcid:   cid.Undef,
+	}}))
+	assert.Equal(1, count("english"))
+	assert.Equal(1, count("edited"))
+	assert.NoError(srv.Indexer.deletePost(ctx, ident.DID, "app.bsky.feed.post/3kpnillluaaaa"))
+	assert.Equal(0, count("edited"))
+	assert.Equal(8, count("*"))
+}
+
+func TestEmbeddedProfileSearch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	srv := testEmbeddedServer(t, &dir)
+
+	alice := identity.Identity{DID: syntax.DID("did:plc:abc111"), Handle: syntax.Handle("alice.example.com")}
+	bob := identity.Identity{DID: syntax.DID("did:plc:abc222"), Handle: syntax.Handle("bob.example.com")}
+	aliceName := "Alice Liddell"
+	aliceDesc := "curiouser and curiouser"
+	bobName := "Bob"
+	bobDesc := "friend of alice"
+	assert.NoError(srv.Indexer.indexProfiles(ctx, []*ProfileIndexJob{
+		{ident: &alice, record: &appbsky.ActorProfile{DisplayName: &aliceName, Description: &aliceDesc}, rcid: cid.Undef},
+		{ident: &bob, record: &appbsky.ActorProfile{DisplayName: &bobName, Description: &bobDesc}, rcid: cid.Undef},
+	}))
+
+	search := func(q string, typeahead bool) []string {
+		out, err := srv.SearchProfiles(ctx, &ActorSearchParams{Query: q, Typeahead: typeahead, Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dids []string
+		for _, a := range out.Actors {
+			dids = append(dids, a.Did)
+		}
+		return dids
+	}
+
+	// name matches rank above description matches
+	assert.Equal([]string{"did:plc:abc111", "did:plc:abc222"}, search("alice", false))
+	assert.Equal([]string{"did:plc:abc111"}, search("curiouser", false))
+	assert.Equal([]string{"did:plc:abc111"}, search("alice.example.com", false))
+	assert.Empty(search("curious", false))
+	assert.Equal([]string{"did:plc:abc111"}, search("ali", true))
+	assert.Equal([]string{"did:plc:abc111"}, search("alice lid", true))
+	assert.Empty(search("curious", true))
+
+	assert.NoError(srv.Indexer.backend.UpdateProfileHandle(ctx, bob.DID, "robert.example.com"))
+	assert.Equal([]string{"did:plc:abc222"}, search("rob", true))
+	assert.Equal([]string{"did:plc:abc222"}, search("robert.example.com", false))
+}
+
+func TestEmbeddedServerAPI(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	ident := identity.Identity{DID: syntax.DID("did:plc:abc111"), Handle: syntax.Handle("handle.example.com")}
+	dir.Insert(ident)
+
+	// a server needs some backend to query
+	_, err := NewServer(nil, &dir, ServerConfig{Logger: slog.Default()})
+	assert.Error(err)
+
+	srv := testEmbeddedServer(t, &dir)
+	assert.NoError(srv.Indexer.indexPosts(ctx, []*PostIndexJob{{
+		did:    ident.DID,
+		record: &appbsky.FeedPost{Text: "basic english post", CreatedAt: "2024-01-02T03:04:05.006Z"},
+		rkey:   "3kpnillluaaaa",
+		rcid:   cid.Undef,
+	}}))
+
+	ts := httptest.NewServer(srv.newAPI())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/_health")
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/xrpc/app.bsky.unspecced.searchPostsSkeleton?q=english")
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var out appbsky.UnspeccedSearchPostsSkeleton_Output
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&out))
+	assert.Len(out.Posts, 1)
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/3kpnillluaaaa", out.Posts[0].Uri)
+}
+
+func TestIndexerNoRecordMappings(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := identity.NewMockDirectory()
+	srv := testEmbeddedServer(t, &dir)
+	idx := srv.Indexer
+	assert.Nil(idx.recordMappings)
+	assert.False(idx.recordMappings.Enabled())
+
+	// starts the indexer workers without panicking; fails on the (invalid) relay URL
+	idx.relayhost = "http://relay.invalid"
+	err := idx.RunIndexer(ctx)
+	assert.ErrorContains(err, "events dial failed")
+	assert.NoError(idx.bf.Stop(ctx))
+}
@@ -0,0 +1,188 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Embedder turns text in to fixed-length vectors, for semantic search
+type Embedder interface {
+	// Dimensions is the length of every vector returned by Embed
+	Dimensions() int
+	// Embed returns one vector for each of the texts, in the same order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// HashEmbedder is a local stand-in for a real embedding model. It hashes words (and pairs of adjacent words) in to buckets of a fixed-length vector, so texts which share words are "similar". Deterministic and needs no network access, which makes it useful for tests and development, but it has no idea what words mean.
+type HashEmbedder struct {
+	Dims int
+}
+
+func (e *HashEmbedder) Dimensions() int {
+	return e.Dims
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, e.Dims)
+		tokens := embeddedTokens(text)
+		for j, tok := range tokens {
+			e.add(vec, tok, 1)
+			if j > 0 {
+				e.add(vec, tokens[j-1]+" "+tok, 0.5)
+			}
+		}
+		normalizeVector(vec)
+		out[i] = vec
+	}
+	return out, nil
+}
+
+func (e *HashEmbedder) add(vec []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	// the sign bit reduces the bias from hash collisions
+	if sum&(1<<63) != 0 {
+		weight = -weight
+	}
+	vec[sum%uint64(len(vec))] += weight
+}
+
+// HTTPEmbedder calls an OpenAI-compatible "/embeddings" endpoint
+type HTTPEmbedder struct {
+	// full URL of the endpoint, eg "http://localhost:8080/v1/embeddings"
+	URL    string
+	Model  string
+	APIKey string
+	Dims   int
+	Client *http.Client
+}
+
+func NewHTTPEmbedder(url, model, apiKey string, dims int) *HTTPEmbedder {
+	return &HTTPEmbedder{
+		URL:    url,
+		Model:  model,
+		APIKey: apiKey,
+		Dims:   dims,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (e *HTTPEmbedder) Dimensions() int {
+	return e.Dims
+}
+
+type embeddingsRequest struct {
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingsRequest{Model: e.Model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embeddings request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("embeddings request failed (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out embeddingsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding embeddings response: %w", err)
+	}
+	vecs := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings response has out-of-range index: %d", d.Index)
+		}
+		if len(d.Embedding) != e.Dims {
+			return nil, fmt.Errorf("embeddings response has wrong dimensions: %d (expected %d)", len(d.Embedding), e.Dims)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	for i, v := range vecs {
+		if v == nil {
+			return nil, fmt.Errorf("embeddings response missing index: %d", i)
+		}
+	}
+	return vecs, nil
+}
+
+func normalizeVector(vec []float32) {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vec {
+		vec[i] /= norm
+	}
+}
+
+// cosineSimilarity returns zero if either vector is empty, or they have different lengths
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// postEmbeddingText is the text of a post which is embedded: the post text, and image alt text
+func postEmbeddingText(doc *PostDoc) string {
+	return strings.Join(append([]string{doc.Text}, doc.EmbedImgAltText...), "\n")
+}
+
+// profileEmbeddingText is the text of a profile which is embedded: display name and description
+func profileEmbeddingText(doc *ProfileDoc) string {
+	var parts []string
+	if doc.DisplayName != nil {
+		parts = append(parts, *doc.DisplayName)
+	}
+	if doc.Description != nil {
+		parts = append(parts, *doc.Description)
+	}
+	return strings.Join(parts, "\n")
+}
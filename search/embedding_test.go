@@ -0,0 +1,258 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	es "github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashEmbedder(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	e := &HashEmbedder{Dims: 256}
+
+	vecs, err := e.Embed(ctx, []string{"the cat sat on the mat", "The cat sat on the mat!", "a cat on a mat", "stock market news", ""})
+	assert.NoError(err)
+	assert.Len(vecs, 5)
+	assert.Len(vecs[0], 256)
+	assert.Equal(vecs[0], vecs[1])
+	assert.InDelta(1.0, cosineSimilarity(vecs[0], vecs[0]), 0.0001)
+	assert.Greater(cosineSimilarity(vecs[0], vecs[2]), cosineSimilarity(vecs[0], vecs[3]))
+	assert.Equal(0.0, cosineSimilarity(vecs[0], vecs[4]))
+	assert.Equal(0.0, cosineSimilarity(vecs[0], nil))
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req embeddingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(400)
+			return
+		}
+		// out of order, to check that the index is used
+		var out embeddingsResponse
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			out.Data = append(out.Data, struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			}{Index: i, Embedding: []float32{float32(i), 1}})
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	e := NewHTTPEmbedder(srv.URL, "test-model", "secret", 2)
+	vecs, err := e.Embed(ctx, []string{"a", "b", "c"})
+	assert.NoError(err)
+	assert.Equal([][]float32{{0, 1}, {1, 1}, {2, 1}}, vecs)
+
+	e = NewHTTPEmbedder(srv.URL, "test-model", "secret", 3)
+	_, err = e.Embed(ctx, []string{"a"})
+	assert.Error(err)
+	e = NewHTTPEmbedder(srv.URL, "test-model", "wrong", 2)
+	_, err = e.Embed(ctx, []string{"a"})
+	assert.Error(err)
+}
+
+func TestEmbeddingMapping(t *testing.T) {
+	assert := assert.New(t)
+
+	out, err := withEmbeddingMapping(palomarPostSchemaJSON, 384)
+	assert.NoError(err)
+	var schema map[string]any
+	assert.NoError(json.Unmarshal([]byte(out), &schema))
+	assert.Equal(true, schema["settings"].(map[string]any)["index"].(map[string]any)["knn"])
+	embedding := schema["mappings"].(map[string]any)["properties"].(map[string]any)["embedding"].(map[string]any)
+	assert.Equal("knn_vector", embedding["type"])
+	assert.Equal(384.0, embedding["dimension"])
+	_, err = withEmbeddingMapping(palomarProfileSchemaJSON, 384)
+	assert.NoError(err)
+
+	params := PostSearchParams{Query: "cats", Sort: "hybrid", Size: 10, QueryVector: []float32{1, 0}}
+	assert.True(params.relevance())
+	assert.Equal(100, params.knnK())
+	params.Sort = "latest"
+	assert.False(params.relevance())
+}
+
+// records index creation, mapping updates, and search requests, like a minimal OpenSearch cluster
+type fakeOpenSearch struct {
+	lk       sync.Mutex
+	existing map[string]bool
+	created  map[string]map[string]any
+	mappings map[string]map[string]any
+	queries  []map[string]any
+	params   []url.Values
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+	switch {
+	case r.Method == http.MethodHead:
+		if !f.existing[strings.TrimPrefix(r.URL.Path, "/")] {
+			w.WriteHeader(404)
+		}
+	case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/_mapping"):
+		f.mappings[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/_mapping")] = body
+		w.Write([]byte(`{"acknowledged": true}`))
+	case r.Method == http.MethodPut:
+		f.created[strings.TrimPrefix(r.URL.Path, "/")] = body
+		w.Write([]byte(`{"acknowledged": true}`))
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		f.queries = append(f.queries, body)
+		f.params = append(f.params, r.URL.Query())
+		w.Write([]byte(`{"took": 1, "hits": {"hits": []}}`))
+	default:
+		w.WriteHeader(400)
+	}
+}
+
+func TestEsBackendEmbeddings(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fake := &fakeOpenSearch{created: make(map[string]map[string]any), mappings: make(map[string]map[string]any)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	escli, err := es.NewClient(es.Config{Addresses: []string{srv.URL}})
+	assert.NoError(err)
+
+	b := newDefaultEsBackend(escli, "post", "profile", "record", &HashEmbedder{Dims: 8}, &RecordMappings{Collections: []CollectionMapping{{NSID: "com.example.record"}}}, nil)
+	assert.NoError(b.EnsureIndices(ctx))
+	knnField := func(index string) any {
+		props := fake.created[index]["mappings"].(map[string]any)["properties"].(map[string]any)
+		return props["embedding"]
+	}
+	assert.Len(fake.created, 3)
+	assert.Equal(8.0, knnField("post").(map[string]any)["dimension"])
+	assert.NotNil(knnField("profile"))
+	assert.Nil(knnField("record"))
+
+	// without an embedder, indices don't get the vector field
+	fake.created = make(map[string]map[string]any)
+	assert.NoError(newDefaultEsBackend(escli, "post", "profile", "record", nil, nil, nil).EnsureIndices(ctx))
+	assert.Len(fake.created, 2)
+	assert.Nil(knnField("post"))
+
+	// vectors are not returned in search results, and lexical scores are normalized for hybrid ranking
+	_, err = b.SearchPosts(ctx, &PostSearchParams{Query: "cats", Sort: "hybrid", Size: 10, QueryVector: make([]float32, 8)})
+	assert.NoError(err)
+	assert.Len(fake.queries, 1)
+	assert.Equal("embedding", fake.params[0].Get("_source_excludes"))
+	should := fake.queries[0]["query"].(map[string]any)["bool"].(map[string]any)["should"].([]any)
+	assert.Len(should, 2)
+	assert.Contains(should[0].(map[string]any), "script_score")
+	assert.Contains(should[1].(map[string]any), "knn")
+}
+
+func TestEsBackendExistingIndices(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fake := &fakeOpenSearch{
+		existing: map[string]bool{"post": true, "profile": true},
+		created:  make(map[string]map[string]any),
+		mappings: make(map[string]map[string]any),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	escli, err := es.NewClient(es.Config{Addresses: []string{srv.URL}})
+	assert.NoError(err)
+
+	// existing post indices get the sort sub-fields used for search_after tie-breakers
+	assert.NoError(NewEsBackend(escli, "post", "profile", nil).EnsureIndices(ctx))
+	assert.Empty(fake.created)
+	assert.Len(fake.mappings, 1)
+	props := fake.mappings["post"]["properties"].(map[string]any)
+	assert.Len(props, 2)
+	for _, f := range []string{"did", "record_rkey"} {
+		field := props[f].(map[string]any)
+		assert.Equal(false, field["doc_values"])
+		assert.Contains(field["fields"].(map[string]any), "sort")
+	}
+
+	// and post search sorts on the sub-fields
+	_, err = doSearchPosts(ctx, escli, "post", &PostSearchParams{Query: "cats", Size: 10})
+	assert.NoError(err)
+	sort := fake.queries[0]["sort"].([]any)
+	assert.Len(sort, 3)
+	assert.Contains(sort[1].(map[string]any), "did.sort")
+	assert.Contains(sort[2].(map[string]any), "record_rkey.sort")
+}
+
+func TestEmbeddedSemanticSearch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	srv := testEmbeddedServer(t, &dir)
+	embedder := &HashEmbedder{Dims: 256}
+	srv.embedder = embedder
+	srv.Indexer.embedder = embedder
+	did := syntax.DID("did:plc:abc111")
+
+	posts := map[string]string{
+		"3kpnilllu2222": "my cat sleeps on the sofa all day",
+		"3kpnilllu3333": "cat food prices are up again",
+		"3kpnilllu4444": "the stock market fell today",
+		"3kpnilllu5555": "sofa shopping",
+		"3kpnilllu6666": "kittens!",
+	}
+	var jobs []*PostIndexJob
+	for rkey, text := range posts {
+		jobs = append(jobs, &PostIndexJob{did: did, rkey: rkey, rcid: cid.Undef, record: &appbsky.FeedPost{Text: text, CreatedAt: "2024-01-02T03:04:05.006Z"}})
+	}
+	assert.NoError(srv.Indexer.indexPosts(ctx, jobs))
+
+	search := func(q, sort string, size int) []string {
+		out, err := srv.SearchPosts(ctx, &PostSearchParams{Query: q, Sort: sort, Size: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rkeys []string
+		for _, p := range out.Posts {
+			uri, err := syntax.ParseATURI(p.Uri)
+			assert.NoError(err)
+			rkeys = append(rkeys, uri.RecordKey().String())
+		}
+		return rkeys
+	}
+
+	// nearest neighbors first, and unrelated posts (zero similarity) last
+	semantic := search("cat on a sofa", "semantic", 10)
+	assert.Len(semantic, 5)
+	assert.Equal("3kpnilllu2222", semantic[0])
+	// lexical matches get a bonus in hybrid ranking
+	hybrid := search("cat food", "hybrid", 10)
+	assert.Equal("3kpnilllu3333", hybrid[0])
+	// filters still apply, but query text is only used for the embedding
+	assert.Empty(search("cat on a sofa did:plc:abc222", "semantic", 10))
+	assert.Equal([]string{"3kpnilllu5555", "3kpnilllu4444"}, search("sofa (shopping OR market)", "semantic", 10))
+	// relevance sorts use offset pagination
+	out, err := srv.SearchPosts(ctx, &PostSearchParams{Query: "cat", Sort: "semantic", Size: 2})
+	assert.NoError(err)
+	assert.Equal("2", *out.Cursor)
+	// without a query (or an embedder), fall back to lexical search
+	assert.Len(search("*", "semantic", 10), 5)
+	srv.embedder = nil
+	assert.Len(search("cat", "semantic", 10), 2)
+}
@@ -11,6 +11,7 @@ import (
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/data"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/backfill"
 	"github.com/bluesky-social/indigo/events"
@@ -50,6 +51,9 @@ func (idx *Indexer) RunIndexer(ctx context.Context) error {
 	// Start the indexer batch workers
 	go idx.runPostIndexer(ctx)
 	go idx.runProfileIndexer(ctx)
+	if idx.recordMappings.Enabled() {
+		go idx.runRecordIndexer(ctx)
+	}
 
 	err = idx.bfs.LoadJobs(ctx)
 	if err != nil {
@@ -191,6 +195,10 @@ func (idx *Indexer) discoverRepos() {
 
 func (idx *Indexer) handleCreateOrUpdate(ctx context.Context, rawDID string, rev string, path string, recB *[]byte, rcid *cid.Cid) error {
 	logger := idx.logger.With("func", "handleCreateOrUpdate", "did", rawDID, "rev", rev, "path", path)
+	if mapping := idx.recordMappings.Collection(collectionFromPath(path)); mapping != nil {
+		return idx.queueRecord(mapping, rawDID, path, *recB, *rcid)
+	}
+
 	// Since this gets called in a backfill job, we need to check if the path is a post or profile
 	if !strings.Contains(path, "app.bsky.feed.post") && !strings.Contains(path, "app.bsky.actor.profile") {
 		return nil
@@ -264,6 +272,18 @@ func (idx *Indexer) handleCreateOrUpdate(ctx context.Context, rawDID string, rev
 }
 
 func (idx *Indexer) handleDelete(ctx context.Context, rawDID, rev, path string) error {
+	if mapping := idx.recordMappings.Collection(collectionFromPath(path)); mapping != nil {
+		did, err := syntax.ParseDID(rawDID)
+		if err != nil {
+			return fmt.Errorf("invalid DID in event: %w", err)
+		}
+		if err := idx.deleteRecord(ctx, did, path); err != nil {
+			return err
+		}
+		recordsDeleted.Inc()
+		return nil
+	}
+
 	// Since this gets called in a backfill job, we need to check if the path is a post or profile
 	if !strings.Contains(path, "app.bsky.feed.post") && !strings.Contains(path, "app.bsky.actor.profile") {
 		return nil
@@ -315,6 +335,18 @@ func (idx *Indexer) processTooBigCommit(ctx context.Context, evt *comatproto.Syn
 	}
 
 	return r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		if mapping := idx.recordMappings.Collection(collectionFromPath(k)); mapping != nil {
+			rcid, recB, err := r.GetRecordBytes(ctx, k)
+			if err != nil {
+				// TODO: handle this case (instead of return nil)
+				idx.logger.Error("failed to get record from repo checkout", "path", k, "err", err)
+				return nil
+			}
+			if err := idx.queueRecord(mapping, evt.Repo, k, *recB, rcid); err != nil {
+				logger.Warn("skipping record", "path", k, "err", err)
+			}
+			return nil
+		}
 		if strings.HasPrefix(k, "app.bsky.feed.post") || strings.HasPrefix(k, "app.bsky.actor.profile") {
 			rcid, rec, err := r.GetRecord(ctx, k)
 			if err != nil {
@@ -366,3 +398,37 @@ func (idx *Indexer) processTooBigCommit(ctx context.Context, evt *comatproto.Syn
 		return nil
 	})
 }
+
+// collectionFromPath returns the collection part of a repo path ("<collection>/<rkey>")
+func collectionFromPath(path string) string {
+	collection, _, _ := strings.Cut(path, "/")
+	return collection
+}
+
+// queueRecord decodes a record of a mapped collection, and sends it to the record indexer
+func (idx *Indexer) queueRecord(mapping *CollectionMapping, rawDID, path string, recB []byte, rcid cid.Cid) error {
+	did, err := syntax.ParseDID(rawDID)
+	if err != nil {
+		return fmt.Errorf("bad DID syntax in event: %w", err)
+	}
+	_, rawRkey, _ := strings.Cut(path, "/")
+	rkey, err := syntax.ParseRecordKey(rawRkey)
+	if err != nil {
+		idx.logger.Warn("skipping record with malformed path", "did", rawDID, "path", path)
+		return nil
+	}
+	rec, err := data.UnmarshalCBOR(recB)
+	if err != nil {
+		return fmt.Errorf("cbor decode: %w", err)
+	}
+
+	idx.recordQueue <- &RecordIndexJob{
+		did:     did,
+		mapping: mapping,
+		record:  rec,
+		rcid:    rcid,
+		rkey:    rkey.String(),
+	}
+	recordsIndexed.Inc()
+	return nil
+}
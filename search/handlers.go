@@ -43,11 +43,19 @@ func parseCursorLimit(e echo.Context) (int, int, error) {
 		}
 	}
 
+	limit, err := parseLimit(e)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+func parseLimit(e echo.Context) (int, error) {
 	limit := 25
 	if l := strings.TrimSpace(e.QueryParam("limit")); l != "" {
 		v, err := strconv.Atoi(l)
 		if err != nil {
-			return 0, 0, &echo.HTTPError{
+			return 0, &echo.HTTPError{
 				Code:    400,
 				Message: fmt.Sprintf("invalid value for 'count': %s", err),
 			}
@@ -62,7 +70,12 @@ func parseCursorLimit(e echo.Context) (int, int, error) {
 	if limit < 0 {
 		limit = 0
 	}
-	return offset, limit, nil
+	return limit, nil
+}
+
+func isOffsetCursor(c string) bool {
+	_, err := strconv.Atoi(c)
+	return err == nil
 }
 
 func (s *Server) handleSearchPostsSkeleton(e echo.Context) error {
@@ -192,7 +205,19 @@ func (s *Server) handleSearchPostsSkeleton(e echo.Context) error {
 		params.Tags = tags
 	}
 
-	offset, limit, err := parseCursorLimit(e)
+	// numeric cursors are from older offset-based pagination
+	var offset, limit int
+	var err error
+	if c := strings.TrimSpace(e.QueryParam("cursor")); c != "" && !isOffsetCursor(c) {
+		params.SearchAfter, err = DecodePostCursor(c)
+		if err != nil {
+			err = &echo.HTTPError{Code: 400, Message: err.Error()}
+		} else {
+			limit, err = parseLimit(e)
+		}
+	} else {
+		offset, limit, err = parseCursorLimit(e)
+	}
 	if err != nil {
 		span.SetAttributes(attribute.String("error", fmt.Sprintf("invalid cursor/limit: %s", err)))
 		span.SetStatus(codes.Error, err.Error())
@@ -282,13 +307,28 @@ func (s *Server) SearchPosts(ctx context.Context, params *PostSearchParams) (*ap
 	ctx, span := tracer.Start(ctx, "SearchPosts")
 	defer span.End()
 
-	resp, err := DoSearchPosts(ctx, s.dir, s.escli, s.postIndex, params)
+	if err := preparePostSearch(ctx, s.dir, s.lists, params); err != nil {
+		return nil, err
+	}
+	if (params.Sort == "semantic" || params.Sort == "hybrid") && s.embedder != nil && params.Query != "*" {
+		if params.SearchAfter != nil {
+			return nil, &echo.HTTPError{Code: 400, Message: fmt.Sprintf("cursor can't be used with sort %q", params.Sort)}
+		}
+		vecs, err := s.embedder.Embed(ctx, []string{params.Query})
+		if err != nil {
+			return nil, fmt.Errorf("embedding query: %w", err)
+		}
+		params.QueryVector = vecs[0]
+	}
+	resp, err := s.backend.SearchPosts(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 
 	posts := []*appbsky.UnspeccedDefs_SkeletonSearchPost{}
+	var lastSort []any
 	for _, r := range resp.Hits.Hits {
+		lastSort = r.Sort
 		var doc PostDoc
 		if err := json.Unmarshal(r.Source, &doc); err != nil {
 			return nil, fmt.Errorf("decoding post doc from search response: %w", err)
@@ -305,7 +345,14 @@ func (s *Server) SearchPosts(ctx context.Context, params *PostSearchParams) (*ap
 	}
 
 	out := appbsky.UnspeccedSearchPostsSkeleton_Output{Posts: posts}
-	if len(posts) == params.Size && (params.Offset+params.Size) < 10000 {
+	if len(posts) == params.Size && lastSort != nil && !params.relevance() {
+		// search_after cursors don't have a depth limit
+		c, err := EncodePostCursor(lastSort)
+		if err != nil {
+			return nil, err
+		}
+		out.Cursor = &c
+	} else if len(posts) == params.Size && (params.Offset+params.Size) < 10000 {
 		s := fmt.Sprintf("%d", params.Offset+params.Size)
 		out.Cursor = &s
 	}
@@ -341,9 +388,9 @@ func (s *Server) SearchProfiles(ctx context.Context, params *ActorSearchParams)
 		myQ.Follows = nil
 
 		if myQ.Typeahead {
-			globalResp, globalErr = DoSearchProfilesTypeahead(ctx, s.escli, s.profileIndex, &myQ)
+			globalResp, globalErr = s.backend.SearchProfilesTypeahead(ctx, &myQ)
 		} else {
-			globalResp, globalErr = DoSearchProfiles(ctx, s.dir, s.escli, s.profileIndex, &myQ)
+			globalResp, globalErr = s.backend.SearchProfiles(ctx, &myQ)
 		}
 	}(*params)
 
@@ -353,9 +400,9 @@ func (s *Server) SearchProfiles(ctx context.Context, params *ActorSearchParams)
 		go func(myQ ActorSearchParams) {
 			defer wg.Done()
 			if myQ.Typeahead {
-				personalizedResp, personalizedErr = DoSearchProfilesTypeahead(ctx, s.escli, s.profileIndex, &myQ)
+				personalizedResp, personalizedErr = s.backend.SearchProfilesTypeahead(ctx, &myQ)
 			} else {
-				personalizedResp, personalizedErr = DoSearchProfiles(ctx, s.dir, s.escli, s.profileIndex, &myQ)
+				personalizedResp, personalizedErr = s.backend.SearchProfiles(ctx, &myQ)
 			}
 		}(*params)
 	}
@@ -471,3 +518,97 @@ func (s *Server) SearchProfiles(ctx context.Context, params *ActorSearchParams)
 	}
 	return &out, nil
 }
+
+type SkeletonSearchRecord struct {
+	Uri string `json:"uri"`
+	Cid string `json:"cid"`
+}
+
+type SearchRecordsOutput struct {
+	Records   []*SkeletonSearchRecord `json:"records"`
+	Cursor    *string                 `json:"cursor,omitempty"`
+	HitsTotal *int64                  `json:"hitsTotal,omitempty"`
+}
+
+func (s *Server) handleSearchRecords(e echo.Context) error {
+	ctx, span := tracer.Start(e.Request().Context(), "handleSearchRecords")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("query", e.QueryParam("q")), attribute.String("collection", e.QueryParam("collection")))
+
+	q := strings.TrimSpace(e.QueryParam("q"))
+	if q == "" {
+		return e.JSON(400, map[string]any{
+			"error":   "BadRequest",
+			"message": "must pass non-empty search query",
+		})
+	}
+	collection := e.QueryParam("collection")
+	if s.records.Collection(collection) == nil {
+		return e.JSON(400, map[string]any{
+			"error":   "BadRequest",
+			"message": fmt.Sprintf("collection is not indexed: %q", collection),
+		})
+	}
+
+	offset, limit, err := parseCursorLimit(e)
+	if err != nil {
+		span.SetAttributes(attribute.String("error", fmt.Sprintf("invalid cursor/limit: %s", err)))
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetAttributes(attribute.Int("offset", offset), attribute.Int("limit", limit))
+
+	out, err := s.SearchRecords(ctx, collection, q, offset, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("error", fmt.Sprintf("failed to SearchRecords: %s", err)))
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("records.length", len(out.Records)))
+
+	return e.JSON(200, out)
+}
+
+// SearchRecords searches records of one of the mapped collections. The query string can include "from:", "since:" and "until:" filters, and "name:value" filters for the keyword fields of the collection.
+func (s *Server) SearchRecords(ctx context.Context, collection, q string, offset, size int) (*SearchRecordsOutput, error) {
+	ctx, span := tracer.Start(ctx, "SearchRecords")
+	defer span.End()
+
+	mapping := s.records.Collection(collection)
+	if mapping == nil {
+		return nil, fmt.Errorf("collection is not indexed: %s", collection)
+	}
+	if err := checkParams(offset, size); err != nil {
+		return nil, err
+	}
+	params := parseRecordQuery(ctx, s.dir, mapping, q)
+	params.Offset = offset
+	params.Size = size
+
+	resp, err := s.backend.SearchRecords(ctx, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*SkeletonSearchRecord{}
+	for _, r := range resp.Hits.Hits {
+		var doc RecordDoc
+		if err := json.Unmarshal(r.Source, &doc); err != nil {
+			return nil, fmt.Errorf("decoding record doc from search response: %w", err)
+		}
+		records = append(records, &SkeletonSearchRecord{Uri: doc.ATURI(), Cid: doc.RecordCID})
+	}
+
+	out := SearchRecordsOutput{Records: records}
+	if len(records) == size && (offset+size) < 10000 {
+		s := fmt.Sprintf("%d", offset+size)
+		out.Cursor = &s
+	}
+	if resp.Hits.Total.Relation == "eq" {
+		i := int64(resp.Hits.Total.Value)
+		out.HitsTotal = &i
+	}
+	return &out, nil
+}
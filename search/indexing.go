@@ -1,12 +1,9 @@
 package search
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"strings"
@@ -24,28 +21,30 @@ import (
 	gorm "gorm.io/gorm"
 
 	es "github.com/opensearch-project/opensearch-go/v2"
-	esapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
 )
 
 type Indexer struct {
-	escli        *es.Client
-	postIndex    string
-	profileIndex string
-	db           *gorm.DB
-	relayhost    string
-	relayXRPC    *xrpc.Client
-	dir          identity.Directory
-	echo         *echo.Echo
-	logger       *slog.Logger
+	backend   Backend
+	db        *gorm.DB
+	relayhost string
+	relayXRPC *xrpc.Client
+	dir       identity.Directory
+	echo      *echo.Echo
+	logger    *slog.Logger
 
 	bfs *backfill.Gormstore
 	bf  *backfill.Backfiller
 
+	streams        *postStreams
+	embedder       Embedder
+	recordMappings *RecordMappings
+
 	enableRepoDiscovery bool
 
 	indexLimiter  *rate.Limiter
 	profileQueue  chan *ProfileIndexJob
 	postQueue     chan *PostIndexJob
+	recordQueue   chan *RecordIndexJob
 	pagerankQueue chan *PagerankIndexJob
 }
 
@@ -53,11 +52,18 @@ type IndexerConfig struct {
 	RelayHost           string
 	ProfileIndex        string
 	PostIndex           string
+	RecordIndex         string
 	Logger              *slog.Logger
 	RelaySyncRateLimit  int
 	IndexMaxConcurrency int
 	DiscoverRepos       bool
 	IndexingRateLimit   int
+	// if nil, an EsBackend is used, with the given index names
+	Backend Backend
+	// if set, posts and profiles are indexed with embeddings, for semantic search
+	Embedder Embedder
+	// other record types to index, for searchRecords
+	RecordMappings *RecordMappings
 }
 
 type ProfileIndexJob struct {
@@ -73,6 +79,14 @@ type PostIndexJob struct {
 	rkey   string
 }
 
+type RecordIndexJob struct {
+	did     syntax.DID
+	mapping *CollectionMapping
+	record  map[string]any
+	rcid    cid.Cid
+	rkey    string
+}
+
 type PagerankIndexJob struct {
 	did  syntax.DID
 	rank float64
@@ -103,20 +117,30 @@ func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config In
 
 	limiter := rate.NewLimiter(rate.Limit(config.IndexingRateLimit), 10_000)
 
+	backend := config.Backend
+	if backend == nil {
+		if escli == nil {
+			return nil, fmt.Errorf("indexer requires either an OpenSearch client or a Backend")
+		}
+		backend = newDefaultEsBackend(escli, config.PostIndex, config.ProfileIndex, config.RecordIndex, config.Embedder, config.RecordMappings, logger)
+	}
+
 	idx := &Indexer{
-		escli:               escli,
-		profileIndex:        config.ProfileIndex,
-		postIndex:           config.PostIndex,
+		backend:             backend,
 		db:                  db,
 		relayhost:           config.RelayHost,
 		relayXRPC:           relayXRPC,
 		dir:                 dir,
 		logger:              logger,
 		enableRepoDiscovery: config.DiscoverRepos,
+		streams:             newPostStreams(),
+		embedder:            config.Embedder,
+		recordMappings:      config.RecordMappings,
 
 		indexLimiter:  limiter,
 		profileQueue:  make(chan *ProfileIndexJob, 1000),
 		postQueue:     make(chan *PostIndexJob, 1000),
+		recordQueue:   make(chan *RecordIndexJob, 1000),
 		pagerankQueue: make(chan *PagerankIndexJob, 1000),
 	}
 
@@ -137,6 +161,10 @@ func NewIndexer(db *gorm.DB, escli *es.Client, dir identity.Directory, config In
 		opts.ParallelRecordCreates = 20
 	}
 	opts.NSIDFilter = "app.bsky."
+	if config.RecordMappings.Enabled() {
+		// mapped collections may be in any namespace; handlers skip unknown records
+		opts.NSIDFilter = ""
+	}
 	bf := backfill.NewBackfiller(
 		"search",
 		bfstore,
@@ -161,43 +189,7 @@ var palomarPostSchemaJSON string
 var palomarProfileSchemaJSON string
 
 func (idx *Indexer) EnsureIndices(ctx context.Context) error {
-	indices := []struct {
-		Name       string
-		SchemaJSON string
-	}{
-		{Name: idx.postIndex, SchemaJSON: palomarPostSchemaJSON},
-		{Name: idx.profileIndex, SchemaJSON: palomarProfileSchemaJSON},
-	}
-	for _, index := range indices {
-		resp, err := idx.escli.Indices.Exists([]string{index.Name})
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		io.ReadAll(resp.Body)
-		if resp.IsError() && resp.StatusCode != 404 {
-			return fmt.Errorf("failed to check index existence")
-		}
-		if resp.StatusCode == 404 {
-			idx.logger.Warn("creating opensearch index", "index", index.Name)
-			if len(index.SchemaJSON) < 2 {
-				return fmt.Errorf("empty schema file (go:embed failed)")
-			}
-			buf := strings.NewReader(index.SchemaJSON)
-			resp, err := idx.escli.Indices.Create(
-				index.Name,
-				idx.escli.Indices.Create.WithBody(buf))
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			io.ReadAll(resp.Body)
-			if resp.IsError() {
-				return fmt.Errorf("failed to create index")
-			}
-		}
-	}
-	return nil
+	return idx.backend.EnsureIndices(ctx)
 }
 
 func (idx *Indexer) runPostIndexer(ctx context.Context) {
@@ -288,6 +280,50 @@ func (idx *Indexer) runProfileIndexer(ctx context.Context) {
 	}
 }
 
+func (idx *Indexer) runRecordIndexer(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "runRecordIndexer")
+	defer span.End()
+
+	// Batch up to 1000 records at a time, or every 5 seconds
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+
+	var records []*RecordIndexJob
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if len(records) > 0 {
+				err := idx.indexLimiter.WaitN(ctx, len(records))
+				if err != nil {
+					idx.logger.Error("failed to wait for rate limiter", "err", err)
+					continue
+				}
+				err = idx.indexRecords(ctx, records)
+				if err != nil {
+					idx.logger.Error("failed to index records", "err", err)
+				}
+				records = records[:0]
+			}
+		case job := <-idx.recordQueue:
+			records = append(records, job)
+			if len(records) >= 1000 {
+				err := idx.indexLimiter.WaitN(ctx, len(records))
+				if err != nil {
+					idx.logger.Error("failed to wait for rate limiter", "err", err)
+					continue
+				}
+				err = idx.indexRecords(ctx, records)
+				if err != nil {
+					idx.logger.Error("failed to index records", "err", err)
+				}
+				records = records[:0]
+			}
+		}
+	}
+}
+
 func (idx *Indexer) runPagerankIndexer(ctx context.Context) {
 	ctx, span := tracer.Start(ctx, "runPagerankIndexer")
 	defer span.End()
@@ -352,30 +388,61 @@ func (idx *Indexer) deletePost(ctx context.Context, did syntax.DID, recordPath s
 
 	docID := fmt.Sprintf("%s_%s", did.String(), rkey)
 	logger.Info("deleting post from index", "docID", docID)
-	req := esapi.DeleteRequest{
-		Index:      idx.postIndex,
-		DocumentID: docID,
-		Refresh:    "true",
-	}
 
 	err = idx.indexLimiter.Wait(ctx)
 	if err != nil {
 		logger.Warn("failed to wait for rate limiter", "err", err)
 		return err
 	}
-	res, err := req.Do(ctx, idx.escli)
+	return idx.backend.DeletePost(ctx, docID)
+}
+
+func (idx *Indexer) deleteRecord(ctx context.Context, did syntax.DID, recordPath string) error {
+	ctx, span := tracer.Start(ctx, "deleteRecord")
+	defer span.End()
+	span.SetAttributes(attribute.String("repo", did.String()), attribute.String("path", recordPath))
+
+	logger := idx.logger.With("repo", did, "path", recordPath, "op", "deleteRecord")
+
+	collection, rawRkey, _ := strings.Cut(recordPath, "/")
+	rkey, err := syntax.ParseRecordKey(rawRkey)
 	if err != nil {
-		return fmt.Errorf("failed to delete post: %w", err)
+		logger.Warn("skipping record with malformed path")
+		return nil
 	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+
+	doc := RecordDoc{DID: did.String(), Collection: collection, RecordRkey: rkey.String()}
+	logger.Info("deleting record from index", "docID", doc.DocId())
+
+	err = idx.indexLimiter.Wait(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read indexing response: %w", err)
+		logger.Warn("failed to wait for rate limiter", "err", err)
+		return err
 	}
-	if res.IsError() {
-		logger.Warn("opensearch indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("indexing error, code=%d", res.StatusCode)
+	return idx.backend.DeleteRecord(ctx, doc.DocId())
+}
+
+func (idx *Indexer) indexRecords(ctx context.Context, jobs []*RecordIndexJob) error {
+	ctx, span := tracer.Start(ctx, "indexRecords")
+	defer span.End()
+	span.SetAttributes(attribute.Int("num_records", len(jobs)))
+
+	log := idx.logger.With("op", "indexRecords")
+	start := time.Now()
+
+	docs := make([]RecordDoc, len(jobs))
+	for i, job := range jobs {
+		docs[i] = TransformRecord(job.mapping, job.record, job.did, job.rkey, job.rcid.String())
+	}
+
+	log.Info("indexing records", "num_records", len(jobs))
+
+	if err := idx.backend.IndexRecords(ctx, docs); err != nil {
+		return err
 	}
+
+	log.Info("indexed records", "num_records", len(jobs), "duration", time.Since(start))
+
 	return nil
 }
 
@@ -387,42 +454,33 @@ func (idx *Indexer) indexPosts(ctx context.Context, jobs []*PostIndexJob) error
 	log := idx.logger.With("op", "indexPosts")
 	start := time.Now()
 
-	var buf bytes.Buffer
-	for i := range jobs {
-		job := jobs[i]
-		doc := TransformPost(job.record, job.did, job.rkey, job.rcid.String())
-		docBytes, err := json.Marshal(doc)
+	docs := make([]PostDoc, len(jobs))
+	for i, job := range jobs {
+		docs[i] = TransformPost(job.record, job.did, job.rkey, job.rcid.String())
+	}
+
+	if idx.embedder != nil {
+		texts := make([]string, len(docs))
+		for i := range docs {
+			texts[i] = postEmbeddingText(&docs[i])
+		}
+		// posts are still indexed (for lexical search) if this fails
+		vecs, err := idx.embedder.Embed(ctx, texts)
 		if err != nil {
-			log.Warn("failed to marshal post", "err", err)
-			return err
+			log.Warn("failed to embed posts", "err", err)
+		} else {
+			for i := range docs {
+				docs[i].Embedding = vecs[i]
+			}
 		}
-
-		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, doc.DocId(), "\n"))
-		docBytes = append(docBytes, "\n"...)
-
-		buf.Grow(len(indexScript) + len(docBytes))
-		buf.Write(indexScript)
-		buf.Write(docBytes)
 	}
 
 	log.Info("indexing posts", "num_posts", len(jobs))
 
-	res, err := idx.escli.Bulk(bytes.NewReader(buf.Bytes()), idx.escli.Bulk.WithIndex(idx.postIndex))
-	if err != nil {
-		log.Warn("failed to send bulk indexing request", "err", err)
-		return fmt.Errorf("failed to send bulk indexing request: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			log.Warn("failed to read bulk indexing response", "err", err)
-			return fmt.Errorf("failed to read bulk indexing response: %w", err)
-		}
-		log.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
+	if err := idx.backend.IndexPosts(ctx, docs); err != nil {
+		return err
 	}
+	idx.streams.publish(docs)
 
 	log.Info("indexed posts", "num_posts", len(jobs), "duration", time.Since(start))
 
@@ -437,42 +495,30 @@ func (idx *Indexer) indexProfiles(ctx context.Context, jobs []*ProfileIndexJob)
 	log := idx.logger.With("op", "indexProfiles")
 	start := time.Now()
 
-	var buf bytes.Buffer
-	for i := range jobs {
-		job := jobs[i]
+	docs := make([]ProfileDoc, len(jobs))
+	for i, job := range jobs {
+		docs[i] = TransformProfile(job.record, job.ident, job.rcid.String())
+	}
 
-		doc := TransformProfile(job.record, job.ident, job.rcid.String())
-		docBytes, err := json.Marshal(doc)
+	if idx.embedder != nil {
+		texts := make([]string, len(docs))
+		for i := range docs {
+			texts[i] = profileEmbeddingText(&docs[i])
+		}
+		vecs, err := idx.embedder.Embed(ctx, texts)
 		if err != nil {
-			log.Warn("failed to marshal profile", "err", err)
-			return err
+			log.Warn("failed to embed profiles", "err", err)
+		} else {
+			for i := range docs {
+				docs[i].Embedding = vecs[i]
+			}
 		}
-
-		indexScript := []byte(fmt.Sprintf(`{"index":{"_id":"%s"}}%s`, job.ident.DID.String(), "\n"))
-		docBytes = append(docBytes, "\n"...)
-
-		buf.Grow(len(indexScript) + len(docBytes))
-		buf.Write(indexScript)
-		buf.Write(docBytes)
 	}
 
 	log.Info("indexing profiles", "num_profiles", len(jobs))
 
-	res, err := idx.escli.Bulk(bytes.NewReader(buf.Bytes()), idx.escli.Bulk.WithIndex(idx.profileIndex))
-	if err != nil {
-		log.Warn("failed to send bulk indexing request", "err", err)
-		return fmt.Errorf("failed to send bulk indexing request: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			log.Warn("failed to read bulk indexing response", "err", err)
-			return fmt.Errorf("failed to read bulk indexing response: %w", err)
-		}
-		log.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
+	if err := idx.backend.IndexProfiles(ctx, docs); err != nil {
+		return err
 	}
 
 	log.Info("indexed profiles", "num_profiles", len(jobs), "duration", time.Since(start))
@@ -490,49 +536,7 @@ func (idx *Indexer) indexPageranks(ctx context.Context, pageranks []*PagerankInd
 
 	log.Info("updating profile pageranks")
 
-	var buf bytes.Buffer
-	for _, pr := range pageranks {
-		updateScript := map[string]any{
-			"script": map[string]any{
-				"source": "ctx._source.pagerank = params.pagerank",
-				"lang":   "painless",
-				"params": map[string]any{
-					"pagerank": pr.rank,
-				},
-			},
-		}
-		updateScriptJSON, err := json.Marshal(updateScript)
-		if err != nil {
-			log.Warn("failed to marshal update script", "err", err)
-			return err
-		}
-
-		updateMetaJSON := []byte(fmt.Sprintf(`{"update":{"_id":"%s"}}%s`, pr.did.String(), "\n"))
-		updateScriptJSON = append(updateScriptJSON, "\n"...)
-
-		buf.Grow(len(updateMetaJSON) + len(updateScriptJSON))
-		buf.Write(updateMetaJSON)
-		buf.Write(updateScriptJSON)
-	}
-
-	res, err := idx.escli.Bulk(bytes.NewReader(buf.Bytes()), idx.escli.Bulk.WithIndex(idx.profileIndex))
-	if err != nil {
-		log.Warn("failed to send bulk indexing request", "err", err)
-		return fmt.Errorf("failed to send bulk indexing request: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			log.Warn("failed to read bulk indexing response", "err", err)
-			return fmt.Errorf("failed to read bulk indexing response: %w", err)
-		}
-		log.Warn("opensearch bulk indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("bulk indexing error, code=%d", res.StatusCode)
-	}
-
-	return nil
+	return idx.backend.UpdatePageranks(ctx, pageranks)
 }
 
 func (idx *Indexer) updateUserHandle(ctx context.Context, did syntax.DID, handle string) error {
@@ -562,45 +566,14 @@ func (idx *Indexer) updateUserHandle(ctx context.Context, did syntax.DID, handle
 	log.Info("updating user handle", "handle_from_dir", ident.Handle)
 	span.SetAttributes(attribute.String("dir.handle", ident.Handle.String()))
 
-	b, err := json.Marshal(map[string]any{
-		"script": map[string]any{
-			"source": "ctx._source.handle = params.handle",
-			"lang":   "painless",
-			"params": map[string]any{
-				"handle": ident.Handle,
-			},
-		},
-	})
-	if err != nil {
-		log.Warn("failed to marshal update script", "err", err)
-		return err
-	}
-
-	req := esapi.UpdateRequest{
-		Index:      idx.profileIndex,
-		DocumentID: did.String(),
-		Body:       bytes.NewReader(b),
-	}
-
 	err = idx.indexLimiter.Wait(ctx)
 	if err != nil {
 		log.Warn("failed to wait for rate limiter", "err", err)
 		return err
 	}
-	res, err := req.Do(ctx, idx.escli)
-	if err != nil {
-		log.Warn("failed to send indexing request", "err", err)
-		return fmt.Errorf("failed to send indexing request: %w", err)
-	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Warn("failed to read indexing response", "err", err)
-		return fmt.Errorf("failed to read indexing response: %w", err)
-	}
-	if res.IsError() {
-		log.Warn("opensearch indexing error", "status_code", res.StatusCode, "response", res, "body", string(body))
-		return fmt.Errorf("indexing error, code=%d", res.StatusCode)
+	if err := idx.backend.UpdateProfileHandle(ctx, did, ident.Handle.String()); err != nil {
+		log.Warn("failed to update handle", "err", err)
+		return err
 	}
 	return nil
 }
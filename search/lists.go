@@ -0,0 +1,70 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// ListResolver fetches the members of an app.bsky.graph.list, for the "list:" query filter
+type ListResolver interface {
+	ListMembers(ctx context.Context, list syntax.ATURI) ([]syntax.DID, error)
+}
+
+// XRPCListResolver fetches list members from an AppView, and caches them for a few minutes
+type XRPCListResolver struct {
+	Client *xrpc.Client
+	// lists longer than this are truncated
+	MaxMembers int
+
+	cache *expirable.LRU[syntax.ATURI, []syntax.DID]
+}
+
+func NewXRPCListResolver(host string) *XRPCListResolver {
+	return &XRPCListResolver{
+		Client:     &xrpc.Client{Host: host},
+		MaxMembers: 1000,
+		cache:      expirable.NewLRU[syntax.ATURI, []syntax.DID](1000, nil, 5*time.Minute),
+	}
+}
+
+func (r *XRPCListResolver) ListMembers(ctx context.Context, list syntax.ATURI) ([]syntax.DID, error) {
+	if members, ok := r.cache.Get(list); ok {
+		return members, nil
+	}
+
+	var members []syntax.DID
+	cursor := ""
+	for len(members) < r.MaxMembers {
+		resp, err := appbsky.GraphGetList(ctx, r.Client, cursor, 100, list.String())
+		if err != nil {
+			return nil, fmt.Errorf("fetching list %s: %w", list, err)
+		}
+		for _, item := range resp.Items {
+			if item.Subject == nil {
+				continue
+			}
+			did, err := syntax.ParseDID(item.Subject.Did)
+			if err != nil {
+				continue
+			}
+			members = append(members, did)
+		}
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Items) == 0 {
+			break
+		}
+		cursor = *resp.Cursor
+	}
+	if len(members) > r.MaxMembers {
+		members = members[:r.MaxMembers]
+	}
+
+	r.cache.Add(list, members)
+	return members, nil
+}
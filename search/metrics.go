@@ -32,6 +32,16 @@ var postsDeleted = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "Number of posts deleted",
 })
 
+var recordsIndexed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_records_indexed",
+	Help: "Number of generic (mapped) records indexed",
+})
+
+var recordsDeleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_records_deleted",
+	Help: "Number of generic (mapped) records deleted",
+})
+
 var profilesReceived = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "search_profiles_received",
 	Help: "Number of profiles received",
@@ -57,6 +67,16 @@ var currentSeq = promauto.NewGauge(prometheus.GaugeOpts{
 	Help: "Current sequence number",
 })
 
+var savedSearchSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "search_saved_search_subscribers",
+	Help: "Number of connected saved-search subscribers",
+})
+
+var savedSearchPostsSent = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "search_saved_search_posts_sent",
+	Help: "Number of posts sent to saved-search subscribers",
+})
+
 var reqSz = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Name:    "http_request_size_bytes",
 	Help:    "A histogram of request sizes for requests.",
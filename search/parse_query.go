@@ -5,147 +5,437 @@ import (
 	"log/slog"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 )
 
-// ParsePostQuery takes a query string and pulls out some facet patterns ("from:handle.net") as filters
-func ParsePostQuery(ctx context.Context, dir identity.Directory, raw string, viewer *syntax.DID) PostSearchParams {
+type QueryNodeKind int
+
+const (
+	QueryAnd QueryNodeKind = iota
+	QueryOr
+	QueryNot
+	// a keyword or "quoted phrase", matched against post text
+	QueryText
+	// a key:value filter, which matches if any of the values match
+	QueryFilter
+)
+
+// QueryNode is a node of a parsed post query.
+//
+// Filter keys are: "author" and "mentions" (DIDs), "lang", "since" and "until" (datetimes), "url", "domain", "tag", "has" (eg, "image"), "is" ("reply" or "quote"), and "embed" (AT-URI of a quoted record).
+type QueryNode struct {
+	Kind     QueryNodeKind
+	Children []*QueryNode
+	// for QueryText, the term as written, including any quotes
+	Text   string
+	Key    string
+	Values []string
+}
+
+func combineQueryNodes(kind QueryNodeKind, children []*QueryNode) *QueryNode {
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	default:
+		return &QueryNode{Kind: kind, Children: children}
+	}
+}
+
+// splits a query string in to terms, quoted phrases, and parentheses
+func tokenizeQuery(raw string) []string {
+	var out []string
+	var cur strings.Builder
 	quoted := false
-	parts := strings.FieldsFunc(raw, func(r rune) bool {
-		if r == '"' {
+	flush := func() {
+		if cur.Len() > 0 {
+			out = append(out, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range raw {
+		switch {
+		case r == '"':
 			quoted = !quoted
+			cur.WriteRune(r)
+		case quoted:
+			cur.WriteRune(r)
+		case unicode.IsSpace(r):
+			flush()
+		case (r == '(' || r == ')') && !strings.HasPrefix(cur.String(), "http"):
+			// URLs may contain parentheses
+			flush()
+			out = append(out, string(r))
+		default:
+			cur.WriteRune(r)
 		}
-		return r == ' ' && !quoted
-	})
+	}
+	flush()
+	return out
+}
 
-	params := PostSearchParams{}
+// queryParser is a recursive descent parser for the grammar:
+//
+//	query   = or { ")" or }
+//	or      = and { ("OR" | "|") and }
+//	and     = unary { ["AND"] unary }
+//	unary   = ("-" | "NOT") unary | "(" or [")"] | term
+//
+// It is lenient: unbalanced parentheses and dangling operators are ignored, and filters which can't be resolved (eg, unknown handles) are dropped.
+type queryParser struct {
+	ctx    context.Context
+	dir    identity.Directory
+	lists  ListResolver
+	viewer *syntax.DID
 
-	keep := make([]string, 0, len(parts))
-	for _, p := range parts {
-		// pass-through quoted, either phrase or single token
-		if strings.HasPrefix(p, "\"") {
-			keep = append(keep, p)
-			continue
+	toks []string
+	pos  int
+}
+
+func (qp *queryParser) peek() string {
+	if qp.pos >= len(qp.toks) {
+		return ""
+	}
+	return qp.toks[qp.pos]
+}
+
+func (qp *queryParser) parseQuery() *QueryNode {
+	var children []*QueryNode
+	for qp.pos < len(qp.toks) {
+		if n := qp.parseOr(); n != nil {
+			children = append(children, n)
 		}
+		if qp.peek() == ")" {
+			qp.pos++
+		}
+	}
+	return combineQueryNodes(QueryAnd, children)
+}
 
-		// tags (array)
-		if strings.HasPrefix(p, "#") && len(p) > 1 {
-			params.Tags = append(params.Tags, p[1:])
+func (qp *queryParser) parseOr() *QueryNode {
+	var children []*QueryNode
+	for {
+		if n := qp.parseAnd(); n != nil {
+			children = append(children, n)
+		}
+		if tok := qp.peek(); tok == "OR" || tok == "|" {
+			qp.pos++
 			continue
 		}
+		break
+	}
+	return combineQueryNodes(QueryOr, children)
+}
 
-		// handle (mention)
-		if strings.HasPrefix(p, "@") && len(p) > 1 {
-			handle, err := syntax.ParseHandle(p[1:])
-			if err != nil {
-				keep = append(keep, p)
-				continue
-			}
-			id, err := dir.LookupHandle(ctx, handle)
-			if err != nil {
-				if err != identity.ErrHandleNotFound {
-					slog.Error("failed to resolve handle", "err", err)
-				}
-				continue
-			}
-			params.Mentions = &id.DID
+func (qp *queryParser) parseAnd() *QueryNode {
+	var children []*QueryNode
+	for qp.pos < len(qp.toks) {
+		tok := qp.peek()
+		if tok == "OR" || tok == "|" || tok == ")" {
+			break
+		}
+		if tok == "AND" {
+			qp.pos++
 			continue
 		}
+		if n := qp.parseUnary(); n != nil {
+			children = append(children, n)
+		}
+	}
+	return combineQueryNodes(QueryAnd, children)
+}
 
-		tokParts := strings.SplitN(p, ":", 2)
-		if len(tokParts) == 1 {
-			keep = append(keep, p)
-			continue
+func (qp *queryParser) parseUnary() *QueryNode {
+	tok := qp.peek()
+	qp.pos++
+	switch {
+	case tok == "-" || tok == "NOT":
+		if n := qp.parseUnary(); n != nil {
+			return &QueryNode{Kind: QueryNot, Children: []*QueryNode{n}}
+		}
+		return nil
+	case tok == "(":
+		n := qp.parseOr()
+		if qp.peek() == ")" {
+			qp.pos++
+		}
+		return n
+	case strings.HasPrefix(tok, "-") && len(tok) > 1:
+		if n := qp.parseTerm(tok[1:]); n != nil {
+			return &QueryNode{Kind: QueryNot, Children: []*QueryNode{n}}
+		}
+		return nil
+	default:
+		return qp.parseTerm(tok)
+	}
+}
+
+func (qp *queryParser) resolveHandle(raw string) *syntax.DID {
+	raw = strings.TrimPrefix(raw, "@")
+	if did, err := syntax.ParseDID(raw); err == nil {
+		return &did
+	}
+	handle, err := syntax.ParseHandle(raw)
+	if err != nil {
+		return nil
+	}
+	id, err := qp.dir.LookupHandle(qp.ctx, handle)
+	if err != nil {
+		if err != identity.ErrHandleNotFound {
+			slog.Error("failed to resolve handle", "err", err)
 		}
+		return nil
+	}
+	return &id.DID
+}
 
-		switch tokParts[0] {
-		case "did":
-			// Used as a hack for `from:me` when supplied by the client
-			did, err := syntax.ParseDID(p)
-			if err != nil {
-				continue
-			}
-			params.Author = &did
-			continue
-		case "from", "to", "mentions":
-			raw := tokParts[1]
+func parseQueryDatetime(raw string) (syntax.Datetime, error) {
+	// first try just date
+	date, err := time.Parse(time.DateOnly, raw)
+	if nil == err {
+		return syntax.Datetime(date.Format(syntax.AtprotoDatetimeLayout)), nil
+	}
+	// fallback to formal atproto datetime format
+	return syntax.ParseDatetimeLenient(raw)
+}
+
+func filterNode(key string, values []string) *QueryNode {
+	if len(values) == 0 {
+		return nil
+	}
+	return &QueryNode{Kind: QueryFilter, Key: key, Values: values}
+}
+
+// parseTerm returns a filter node for filter syntax ("from:handle.net", "#tag", etc), or a text node
+func (qp *queryParser) parseTerm(tok string) *QueryNode {
+	text := &QueryNode{Kind: QueryText, Text: tok}
+
+	// pass-through quoted, either phrase or single token
+	if strings.HasPrefix(tok, "\"") {
+		return text
+	}
+
+	// tags
+	if strings.HasPrefix(tok, "#") && len(tok) > 1 {
+		return filterNode("tag", []string{tok[1:]})
+	}
+
+	// handle (mention)
+	if strings.HasPrefix(tok, "@") && len(tok) > 1 {
+		if _, err := syntax.ParseHandle(tok[1:]); err != nil {
+			return text
+		}
+		if did := qp.resolveHandle(tok); did != nil {
+			return filterNode("mentions", []string{did.String()})
+		}
+		return nil
+	}
+
+	key, val, ok := strings.Cut(tok, ":")
+	if !ok {
+		return text
+	}
+	// multiple values are comma-separated, except for URLs
+	vals := strings.Split(val, ",")
+
+	switch key {
+	case "did":
+		// Used as a hack for `from:me` when supplied by the client
+		did, err := syntax.ParseDID(tok)
+		if err != nil {
+			return nil
+		}
+		return filterNode("author", []string{did.String()})
+	case "from", "to", "mentions":
+		var dids []string
+		for _, raw := range vals {
 			if raw == "me" {
-				if viewer != nil && tokParts[0] == "from" {
-					params.Author = viewer
-				} else if viewer != nil {
-					params.Mentions = viewer
+				if qp.viewer != nil {
+					dids = append(dids, qp.viewer.String())
 				}
 				continue
 			}
-			if strings.HasPrefix(raw, "@") && len(raw) > 1 {
-				raw = raw[1:]
+			if did := qp.resolveHandle(raw); did != nil {
+				dids = append(dids, did.String())
 			}
-			handle, err := syntax.ParseHandle(raw)
-			if err != nil {
+		}
+		if key == "from" {
+			return filterNode("author", dids)
+		}
+		return filterNode("mentions", dids)
+	case "list":
+		var dids []string
+		resolved := false
+		for _, raw := range vals {
+			uri, err := syntax.ParseATURI(raw)
+			if err != nil || qp.lists == nil {
 				continue
 			}
-			id, err := dir.LookupHandle(ctx, handle)
+			members, err := qp.lists.ListMembers(qp.ctx, uri)
 			if err != nil {
-				if err != identity.ErrHandleNotFound {
-					slog.Error("failed to resolve handle", "err", err)
-				}
+				slog.Warn("failed to fetch list members", "list", uri, "err", err)
 				continue
 			}
-			if tokParts[0] == "from" {
-				params.Author = &id.DID
-			} else {
-				params.Mentions = &id.DID
+			resolved = true
+			for _, did := range members {
+				dids = append(dids, did.String())
 			}
-			continue
-		case "http", "https":
-			params.URL = p
-			continue
-		case "domain":
-			params.Domain = tokParts[1]
-			continue
-		case "lang":
-			lang, err := syntax.ParseLanguage(tokParts[1])
-			if nil == err {
-				params.Lang = &lang
+		}
+		// lists with no members match nothing
+		if resolved && len(dids) == 0 {
+			return &QueryNode{Kind: QueryFilter, Key: "author"}
+		}
+		return filterNode("author", dids)
+	case "http", "https":
+		return filterNode("url", []string{tok})
+	case "domain", "tag":
+		return filterNode(key, vals)
+	case "has":
+		for i, v := range vals {
+			vals[i] = strings.ToLower(v)
+		}
+		return filterNode(key, vals)
+	case "is":
+		var kinds []string
+		for _, v := range vals {
+			if v == "reply" || v == "quote" {
+				kinds = append(kinds, v)
 			}
-			continue
-		case "since", "until":
-			var dt syntax.Datetime
-			// first try just date
-			date, err := time.Parse(time.DateOnly, tokParts[1])
-			if nil == err {
-				dt = syntax.Datetime(date.Format(syntax.AtprotoDatetimeLayout))
-			} else {
-				// fallback to formal atproto datetime format
-				dt, err = syntax.ParseDatetimeLenient(tokParts[1])
-				if err != nil {
-					continue
-				}
+		}
+		return filterNode(key, kinds)
+	case "embed":
+		var uris []string
+		for _, raw := range vals {
+			if uri, err := syntax.ParseATURI(raw); err == nil {
+				uris = append(uris, uri.String())
 			}
-			if tokParts[0] == "since" {
-				params.Since = &dt
-			} else {
-				params.Until = &dt
+		}
+		return filterNode(key, uris)
+	case "lang":
+		var langs []string
+		for _, raw := range vals {
+			if lang, err := syntax.ParseLanguage(raw); err == nil {
+				langs = append(langs, lang.String())
 			}
-			continue
 		}
+		return filterNode(key, langs)
+	case "since", "until":
+		dt, err := parseQueryDatetime(val)
+		if err != nil {
+			return nil
+		}
+		return filterNode(key, []string{dt.String()})
+	}
 
-		keep = append(keep, p)
+	return text
+}
+
+// hoist moves a single-valued filter in to the equivalent field of params, if that is unset. Returns false if there is no equivalent field.
+func (p *PostSearchParams) hoist(n *QueryNode) bool {
+	if n.Kind != QueryFilter || len(n.Values) != 1 {
+		return false
 	}
+	val := n.Values[0]
+	switch n.Key {
+	case "author":
+		if p.Author == nil {
+			did := syntax.DID(val)
+			p.Author = &did
+			return true
+		}
+	case "mentions":
+		if p.Mentions == nil {
+			did := syntax.DID(val)
+			p.Mentions = &did
+			return true
+		}
+	case "lang":
+		if p.Lang == nil {
+			lang := syntax.Language(val)
+			p.Lang = &lang
+			return true
+		}
+	case "since":
+		if p.Since == nil {
+			dt := syntax.Datetime(val)
+			p.Since = &dt
+			return true
+		}
+	case "until":
+		if p.Until == nil {
+			dt := syntax.Datetime(val)
+			p.Until = &dt
+			return true
+		}
+	case "url":
+		if p.URL == "" {
+			p.URL = val
+			return true
+		}
+	case "domain":
+		if p.Domain == "" {
+			p.Domain = val
+			return true
+		}
+	case "has":
+		if p.Has == "" {
+			p.Has = val
+			return true
+		}
+	case "tag":
+		p.Tags = append(p.Tags, val)
+		return true
+	}
+	return false
+}
 
-	out := ""
-	for _, p := range keep {
-		if out == "" {
-			out = p
-		} else {
-			out += " " + p
+// ParsePostQuery takes a query string and pulls out some facet patterns ("from:handle.net") as filters
+func ParsePostQuery(ctx context.Context, dir identity.Directory, raw string, viewer *syntax.DID) PostSearchParams {
+	return parsePostQuery(ctx, dir, nil, raw, viewer)
+}
+
+// parsePostQuery parses a query string with the full grammar (see queryParser). Top-level text terms are kept in Query, and simple top-level filters are moved to the matching params fields; anything else (OR, grouping, negated filters, etc) ends up in Tree.
+func parsePostQuery(ctx context.Context, dir identity.Directory, lists ListResolver, raw string, viewer *syntax.DID) PostSearchParams {
+	qp := queryParser{
+		ctx:    ctx,
+		dir:    dir,
+		lists:  lists,
+		viewer: viewer,
+		toks:   tokenizeQuery(raw),
+	}
+	tree := qp.parseQuery()
+
+	params := PostSearchParams{}
+
+	var top []*QueryNode
+	if tree != nil && tree.Kind == QueryAnd {
+		top = tree.Children
+	} else if tree != nil {
+		top = []*QueryNode{tree}
+	}
+
+	var keep []string
+	var rest []*QueryNode
+	for _, n := range top {
+		switch {
+		case n.Kind == QueryText:
+			keep = append(keep, n.Text)
+		case n.Kind == QueryNot && n.Children[0].Kind == QueryText:
+			keep = append(keep, "-"+n.Children[0].Text)
+		case params.hoist(n):
+		default:
+			rest = append(rest, n)
 		}
 	}
-	if out == "" {
-		out = "*"
+
+	params.Query = strings.Join(keep, " ")
+	if params.Query == "" {
+		params.Query = "*"
 	}
-	params.Query = out
+	params.Tree = combineQueryNodes(QueryAnd, rest)
 	return params
 }
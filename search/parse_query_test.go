@@ -2,6 +2,7 @@ package search
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -96,3 +97,120 @@ func TestParseQuery(t *testing.T) {
 
 	// TODO: more parsing tests: bare handles, to:, since:, until:, URL, domain:, lang
 }
+
+type testListResolver map[syntax.ATURI][]syntax.DID
+
+func (r testListResolver) ListMembers(ctx context.Context, list syntax.ATURI) ([]syntax.DID, error) {
+	return r[list], nil
+}
+
+func TestParseQueryBoolean(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{Handle: syntax.Handle("known.example.com"), DID: syntax.DID("did:plc:abc222")})
+	dir.Insert(identity.Identity{Handle: syntax.Handle("other.example.com"), DID: syntax.DID("did:plc:abc333")})
+	lists := testListResolver{
+		syntax.ATURI("at://did:plc:abc222/app.bsky.graph.list/3kabc"): {syntax.DID("did:plc:abc222"), syntax.DID("did:plc:abc333")},
+	}
+
+	var p PostSearchParams
+
+	assert.Equal([]string{"(", "a", "OR", "\"b ( c\"", ")", "https://example.com/a_(b)"}, tokenizeQuery(`(a OR "b ( c") https://example.com/a_(b)`))
+
+	// OR of text terms
+	p = parsePostQuery(ctx, &dir, nil, "cats OR dogs", nil)
+	assert.Equal("*", p.Query)
+	assert.NotNil(p.Tree)
+	assert.Equal(QueryOr, p.Tree.Kind)
+	assert.Len(p.Tree.Children, 2)
+	assert.Equal(1, len(p.Filters()))
+
+	// grouping, with the rest of the query still hoisted
+	p = parsePostQuery(ctx, &dir, nil, "pets (cats OR dogs) lang:en -ok", nil)
+	assert.Equal("pets -ok", p.Query)
+	assert.NotNil(p.Lang)
+	assert.Equal(QueryOr, p.Tree.Kind)
+	assert.Equal(2, len(p.Filters()))
+
+	// nested groups, and unbalanced parens
+	p = parsePostQuery(ctx, &dir, nil, "((a OR (b c)) OR d", nil)
+	assert.Equal(QueryOr, p.Tree.Kind)
+	assert.Len(p.Tree.Children, 2)
+	assert.Equal(QueryAnd, p.Tree.Children[0].Children[1].Kind)
+	p = parsePostQuery(ctx, &dir, nil, "a ) b OR", nil)
+	assert.Equal("a b", p.Query)
+	assert.Nil(p.Tree)
+
+	// negated filters
+	p = parsePostQuery(ctx, &dir, nil, "hello -from:known.example.com NOT lang:de", nil)
+	assert.Equal("hello", p.Query)
+	assert.Nil(p.Author)
+	assert.Nil(p.Lang)
+	assert.Equal(QueryAnd, p.Tree.Kind)
+	assert.Equal(QueryNot, p.Tree.Children[0].Kind)
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "author", Values: []string{"did:plc:abc222"}}, p.Tree.Children[0].Children[0])
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "lang", Values: []string{"de"}}, p.Tree.Children[1].Children[0])
+
+	// multiple values, some of which don't resolve
+	p = parsePostQuery(ctx, &dir, nil, "from:known.example.com,missing.example.com,other.example.com lang:en,de,!!", nil)
+	assert.Nil(p.Author)
+	assert.Equal([]*QueryNode{
+		{Kind: QueryFilter, Key: "author", Values: []string{"did:plc:abc222", "did:plc:abc333"}},
+		{Kind: QueryFilter, Key: "lang", Values: []string{"en", "de"}},
+	}, p.Tree.Children)
+
+	// new filters
+	p = parsePostQuery(ctx, &dir, nil, "has:Image is:reply", nil)
+	assert.Equal("image", p.Has)
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "is", Values: []string{"reply"}}, p.Tree)
+	p = parsePostQuery(ctx, &dir, nil, "is:bogus embed:at://did:plc:abc222/app.bsky.feed.post/3kabc", nil)
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "embed", Values: []string{"at://did:plc:abc222/app.bsky.feed.post/3kabc"}}, p.Tree)
+	assert.Equal(map[string]interface{}{
+		"term": map[string]interface{}{"embed_aturi": map[string]interface{}{
+			"value":            "at://did:plc:abc222/app.bsky.feed.post/3kabc",
+			"case_insensitive": true,
+		}},
+	}, p.Filters()[0])
+
+	// lists are expanded to their members, or ignored without a resolver
+	p = parsePostQuery(ctx, &dir, lists, "list:at://did:plc:abc222/app.bsky.graph.list/3kabc", nil)
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "author", Values: []string{"did:plc:abc222", "did:plc:abc333"}}, p.Tree)
+	p = parsePostQuery(ctx, &dir, lists, "list:at://did:plc:abc222/app.bsky.graph.list/3kempty", nil)
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "author"}, p.Tree)
+	// members of every list are included, even if an earlier one is empty
+	p = parsePostQuery(ctx, &dir, lists, "list:at://did:plc:abc222/app.bsky.graph.list/3kempty,at://did:plc:abc222/app.bsky.graph.list/3kabc", nil)
+	assert.Equal(&QueryNode{Kind: QueryFilter, Key: "author", Values: []string{"did:plc:abc222", "did:plc:abc333"}}, p.Tree)
+	p = parsePostQuery(ctx, &dir, nil, "list:at://did:plc:abc222/app.bsky.graph.list/3kabc", nil)
+	assert.Nil(p.Tree)
+	assert.Empty(p.Filters())
+
+	// compiled filter DSL
+	p = parsePostQuery(ctx, &dir, nil, "(#cat OR -has:video) lang:en,de", nil)
+	assert.Equal(map[string]interface{}{
+		"bool": map[string]interface{}{"filter": []map[string]interface{}{
+			{"bool": map[string]interface{}{
+				"should": []map[string]interface{}{
+					filterDSL("tag", "cat"),
+					{"bool": map[string]interface{}{"must_not": []map[string]interface{}{filterDSL("has", "video")}}},
+				},
+				"minimum_should_match": 1,
+			}},
+			{"terms": map[string]interface{}{"lang_code_iso2": []string{"en", "de"}}},
+		}},
+	}, p.Tree.DSL())
+
+	// large lists are a single clause, to stay under the OpenSearch limit on query clauses
+	var members []syntax.DID
+	for i := range 1500 {
+		members = append(members, syntax.DID(fmt.Sprintf("did:plc:member%d", i)))
+	}
+	lists[syntax.ATURI("at://did:plc:abc222/app.bsky.graph.list/3kbig")] = members
+	p = parsePostQuery(ctx, &dir, lists, "list:at://did:plc:abc222/app.bsky.graph.list/3kbig is:reply,quote", nil)
+	dsl := p.Tree.DSL()
+	authors := dsl["bool"].(map[string]interface{})["filter"].([]map[string]interface{})[0]
+	assert.Len(authors["terms"].(map[string]interface{})["did"], 1500)
+	// values which need a clause each are unchanged
+	is := dsl["bool"].(map[string]interface{})["filter"].([]map[string]interface{})[1]
+	assert.Len(is["bool"].(map[string]interface{})["should"], 2)
+}
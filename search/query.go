@@ -2,7 +2,9 @@ package search
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -21,6 +23,8 @@ type EsSearchHit struct {
 	ID     string          `json:"_id"`
 	Score  float64         `json:"_score"`
 	Source json.RawMessage `json:"_source"`
+	// sort values of the hit, used for search_after pagination
+	Sort []any `json:"sort,omitempty"`
 }
 
 type EsSearchHits struct {
@@ -65,6 +69,26 @@ type PostSearchParams struct {
 	Viewer   *syntax.DID      `json:"viewer"`
 	Offset   int              `json:"offset"`
 	Size     int              `json:"size"`
+
+	// filters and grouping from the query string which don't fit in the fields above
+	Tree *QueryNode `json:"-"`
+	// sort values of the last hit of the previous page (see EncodePostCursor). If set, Offset should be zero.
+	SearchAfter []any `json:"-"`
+	// embedding of Query, for the "semantic" and "hybrid" sorts
+	QueryVector []float32 `json:"-"`
+}
+
+// relevance is true for sorts which rank by similarity to QueryVector, rather than by time. These sorts only support offset pagination.
+func (p *PostSearchParams) relevance() bool {
+	return p.QueryVector != nil && (p.Sort == "semantic" || p.Sort == "hybrid")
+}
+
+// BM25 score which is scaled to 0.5 for hybrid ranking; higher scores approach 1
+const hybridLexicalPivot = 5.0
+
+// knnK is the number of nearest neighbors fetched for a relevance sort. Filters are applied after the neighbors are found, so this is more than one page.
+func (p *PostSearchParams) knnK() int {
+	return max(p.Offset+p.Size, 100)
 }
 
 type ActorSearchParams struct {
@@ -103,6 +127,10 @@ func (p *PostSearchParams) Update(other *PostSearchParams) {
 	if len(p.Tags) == 0 {
 		p.Tags = other.Tags
 	}
+	if p.Has == "" {
+		p.Has = other.Has
+	}
+	p.Tree = other.Tree
 }
 
 // Filters turns search params in to actual elasticsearch/opensearch filter DSL
@@ -110,100 +138,216 @@ func (p *PostSearchParams) Filters() []map[string]interface{} {
 	var filters []map[string]interface{}
 
 	if p.Author != nil {
-		filters = append(filters, map[string]interface{}{
-			"term": map[string]interface{}{"did": map[string]interface{}{
-				"value":            p.Author.String(),
-				"case_insensitive": true,
-			}},
-		})
+		filters = append(filters, filterDSL("author", p.Author.String()))
 	}
 
 	if p.Mentions != nil {
-		filters = append(filters, map[string]interface{}{
-			"bool": map[string]interface{}{
-				"should": []map[string]interface{}{
-					{"term": map[string]interface{}{"mention_did": map[string]interface{}{
-						"value":            p.Mentions.String(),
-						"case_insensitive": true,
-					}}},
-					{"term": map[string]interface{}{"parent_did": map[string]interface{}{
-						"value":            p.Mentions.String(),
-						"case_insensitive": true,
-					}}},
-				},
-			},
-		})
+		filters = append(filters, filterDSL("mentions", p.Mentions.String()))
 	}
 
 	if p.Lang != nil {
 		// TODO: extracting just the 2-char code would be good
-		filters = append(filters, map[string]interface{}{
-			"term": map[string]interface{}{"lang_code_iso2": map[string]interface{}{
-				"value":            p.Lang.String(),
-				"case_insensitive": true,
-			}},
-		})
+		filters = append(filters, filterDSL("lang", p.Lang.String()))
 	}
 
 	if p.Since != nil {
-		filters = append(filters, map[string]interface{}{
-			"range": map[string]interface{}{
-				"created_at": map[string]interface{}{
-					"gte": p.Since.String(),
-				},
-			},
-		})
+		filters = append(filters, filterDSL("since", p.Since.String()))
 	}
 
 	if p.Until != nil {
-		filters = append(filters, map[string]interface{}{
-			"range": map[string]interface{}{
-				"created_at": map[string]interface{}{
-					"lt": p.Until.String(),
-				},
-			},
-		})
+		filters = append(filters, filterDSL("until", p.Until.String()))
 	}
 
 	if p.URL != "" {
-		filters = append(filters, map[string]interface{}{
-			"term": map[string]interface{}{"url": map[string]interface{}{
-				"value":            NormalizeLossyURL(p.URL),
-				"case_insensitive": true,
-			}},
-		})
+		filters = append(filters, filterDSL("url", p.URL))
 	}
 
 	if p.Domain != "" {
-		filters = append(filters, map[string]interface{}{
-			"term": map[string]interface{}{"domain": map[string]interface{}{
-				"value":            p.Domain,
-				"case_insensitive": true,
-			}},
-		})
+		filters = append(filters, filterDSL("domain", p.Domain))
 	}
 
 	if p.Has != "" {
-		filters = append(filters, map[string]interface{}{
-			"term": map[string]interface{}{"has": map[string]interface{}{
-				"value":            p.Has,
-				"case_insensitive": true,
-			}},
-		})
+		filters = append(filters, filterDSL("has", p.Has))
 	}
 
 	for _, tag := range p.Tags {
-		filters = append(filters, map[string]interface{}{
-			"term": map[string]interface{}{
-				"tag": map[string]interface{}{
-					"value":            tag,
-					"case_insensitive": true,
+		filters = append(filters, filterDSL("tag", tag))
+	}
+
+	if p.Tree != nil {
+		filters = append(filters, p.Tree.DSL())
+	}
+
+	return filters
+}
+
+func termDSL(field, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]interface{}{field: map[string]interface{}{
+			"value":            value,
+			"case_insensitive": true,
+		}},
+	}
+}
+
+// termsDSL matches any of several values of a keyword field, as a single clause. Values are lowercased, because "terms" queries are not case-insensitive (the fields have a lowercase normalizer)
+func termsDSL(field string, values []string) map[string]interface{} {
+	lower := make([]string, len(values))
+	for i, v := range values {
+		lower[i] = strings.ToLower(v)
+	}
+	return map[string]interface{}{
+		"terms": map[string]interface{}{field: lower},
+	}
+}
+
+// multiFilterDSL returns a single clause for several values of a keyword filter, or nil for filters (eg, date ranges) which need a clause per value. A "list:" filter can expand to many accounts, and one clause per value would exceed the OpenSearch limit on query clauses (max_clause_count).
+func multiFilterDSL(key string, values []string) map[string]interface{} {
+	switch key {
+	case "author":
+		return termsDSL("did", values)
+	case "mentions":
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []map[string]interface{}{
+					termsDSL("mention_did", values),
+					termsDSL("parent_did", values),
 				},
 			},
-		})
+		}
+	case "lang":
+		return termsDSL("lang_code_iso2", values)
+	case "url":
+		urls := make([]string, len(values))
+		for i, v := range values {
+			urls[i] = NormalizeLossyURL(v)
+		}
+		return termsDSL("url", urls)
+	case "domain", "has", "tag":
+		return termsDSL(key, values)
+	case "embed":
+		return termsDSL("embed_aturi", values)
 	}
+	return nil
+}
 
-	return filters
+// filterDSL returns the filter DSL for a single value of a query filter (see QueryNode for keys)
+func filterDSL(key, value string) map[string]interface{} {
+	switch key {
+	case "author":
+		return termDSL("did", value)
+	case "mentions":
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []map[string]interface{}{
+					termDSL("mention_did", value),
+					termDSL("parent_did", value),
+				},
+			},
+		}
+	case "lang":
+		return termDSL("lang_code_iso2", value)
+	case "since":
+		return map[string]interface{}{
+			"range": map[string]interface{}{
+				"created_at": map[string]interface{}{
+					"gte": value,
+				},
+			},
+		}
+	case "until":
+		return map[string]interface{}{
+			"range": map[string]interface{}{
+				"created_at": map[string]interface{}{
+					"lt": value,
+				},
+			},
+		}
+	case "url":
+		return termDSL("url", NormalizeLossyURL(value))
+	case "domain", "has", "tag":
+		return termDSL(key, value)
+	case "is":
+		field := "reply_root_aturi"
+		if value == "quote" {
+			field = "embed_aturi"
+		}
+		return map[string]interface{}{
+			"exists": map[string]interface{}{"field": field},
+		}
+	case "embed":
+		return termDSL("embed_aturi", value)
+	}
+	return map[string]interface{}{"match_none": map[string]interface{}{}}
+}
+
+// textQueryDSL returns a fulltext query for a query string (or a single QueryText term)
+func textQueryDSL(text string) map[string]interface{} {
+	idx := "everything"
+	if containsJapanese(text) {
+		idx = "everything_ja"
+	}
+	return map[string]interface{}{
+		"simple_query_string": map[string]interface{}{
+			"query":            text,
+			"fields":           []string{idx},
+			"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
+			"default_operator": "and",
+			"lenient":          true,
+			"analyze_wildcard": false,
+		},
+	}
+}
+
+// DSL compiles a query tree to elasticsearch/opensearch query DSL, for use in a filter context
+func (n *QueryNode) DSL() map[string]interface{} {
+	switch n.Kind {
+	case QueryAnd, QueryOr:
+		clauses := make([]map[string]interface{}, len(n.Children))
+		for i, c := range n.Children {
+			clauses[i] = c.DSL()
+		}
+		if n.Kind == QueryAnd {
+			return map[string]interface{}{
+				"bool": map[string]interface{}{"filter": clauses},
+			}
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               clauses,
+				"minimum_should_match": 1,
+			},
+		}
+	case QueryNot:
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": []map[string]interface{}{n.Children[0].DSL()},
+			},
+		}
+	case QueryText:
+		return textQueryDSL(n.Text)
+	case QueryFilter:
+		if len(n.Values) == 1 {
+			return filterDSL(n.Key, n.Values[0])
+		}
+		if len(n.Values) == 0 {
+			return map[string]interface{}{"match_none": map[string]interface{}{}}
+		}
+		if dsl := multiFilterDSL(n.Key, n.Values); dsl != nil {
+			return dsl
+		}
+		clauses := make([]map[string]interface{}, len(n.Values))
+		for i, v := range n.Values {
+			clauses[i] = filterDSL(n.Key, v)
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               clauses,
+				"minimum_should_match": 1,
+			},
+		}
+	}
+	return map[string]interface{}{"match_none": map[string]interface{}{}}
 }
 
 // Filters turns search params in to actual elasticsearch/opensearch filter DSL
@@ -225,6 +369,223 @@ func (p *ActorSearchParams) Filters() []map[string]interface{} {
 	return filters
 }
 
+// matchDoc checks a post document against the same filters as Filters, for backends which don't use the filter DSL. matchText is used for any text terms in Tree.
+func (p *PostSearchParams) matchDoc(doc *PostDoc, matchText func(text string) bool) bool {
+	if p.Author != nil && !matchFilter(doc, "author", p.Author.String()) {
+		return false
+	}
+	if p.Mentions != nil && !matchFilter(doc, "mentions", p.Mentions.String()) {
+		return false
+	}
+	if p.Lang != nil && !matchFilter(doc, "lang", p.Lang.String()) {
+		return false
+	}
+	if p.Since != nil && !matchFilter(doc, "since", p.Since.String()) {
+		return false
+	}
+	if p.Until != nil && !matchFilter(doc, "until", p.Until.String()) {
+		return false
+	}
+	if p.URL != "" && !matchFilter(doc, "url", p.URL) {
+		return false
+	}
+	if p.Domain != "" && !matchFilter(doc, "domain", p.Domain) {
+		return false
+	}
+	if p.Has != "" && !matchFilter(doc, "has", p.Has) {
+		return false
+	}
+	for _, tag := range p.Tags {
+		if !matchFilter(doc, "tag", tag) {
+			return false
+		}
+	}
+	if p.Tree != nil && !p.Tree.matchDoc(doc, matchText) {
+		return false
+	}
+	return true
+}
+
+// matchFilter is the non-DSL equivalent of filterDSL
+func matchFilter(doc *PostDoc, key, value string) bool {
+	switch key {
+	case "author":
+		return strings.EqualFold(doc.DID, value)
+	case "mentions":
+		return containsFold(doc.MentionDID, value) || (doc.ParentDid != nil && strings.EqualFold(*doc.ParentDid, value))
+	case "lang":
+		return containsFold(doc.LangCodeIso2, value)
+	case "since", "until":
+		if doc.CreatedAt == nil {
+			return false
+		}
+		createdAt, err := syntax.ParseDatetimeLenient(*doc.CreatedAt)
+		if err != nil {
+			return false
+		}
+		bound, err := syntax.ParseDatetimeLenient(value)
+		if err != nil {
+			return false
+		}
+		before := createdAt.Time().Before(bound.Time())
+		return before == (key == "until")
+	case "url":
+		return containsFold(doc.URL, NormalizeLossyURL(value))
+	case "domain":
+		return containsFold(doc.Domain, value)
+	case "has":
+		return containsFold(doc.Has, value)
+	case "tag":
+		return containsFold(doc.Tag, value)
+	case "is":
+		if value == "quote" {
+			return doc.EmbedATURI != nil
+		}
+		return doc.ReplyRootATURI != nil
+	case "embed":
+		return doc.EmbedATURI != nil && strings.EqualFold(*doc.EmbedATURI, value)
+	}
+	return false
+}
+
+func (n *QueryNode) matchDoc(doc *PostDoc, matchText func(text string) bool) bool {
+	switch n.Kind {
+	case QueryAnd:
+		for _, c := range n.Children {
+			if !c.matchDoc(doc, matchText) {
+				return false
+			}
+		}
+		return true
+	case QueryOr:
+		for _, c := range n.Children {
+			if c.matchDoc(doc, matchText) {
+				return true
+			}
+		}
+		return false
+	case QueryNot:
+		return !n.Children[0].matchDoc(doc, matchText)
+	case QueryText:
+		return matchText(n.Text)
+	case QueryFilter:
+		for _, v := range n.Values {
+			if matchFilter(doc, n.Key, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchDoc checks a profile document against the same filters as Filters, for backends which don't use the filter DSL
+func (p *ActorSearchParams) matchDoc(doc *ProfileDoc) bool {
+	if len(p.Follows) == 0 {
+		return true
+	}
+	for _, did := range p.Follows {
+		if did.String() == doc.DID {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(vals []string, want string) bool {
+	for _, v := range vals {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodePostCursor turns the sort values of a post search hit in to an opaque pagination cursor
+func EncodePostCursor(sort []any) (string, error) {
+	b, err := json.Marshal(sort)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodePostCursor is the inverse of EncodePostCursor
+func DecodePostCursor(cursor string) ([]any, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// keep timestamps as exact integers
+	dec.UseNumber()
+	var sort []any
+	if err := dec.Decode(&sort); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if _, err := parseSearchAfter(sort); err != nil {
+		return nil, err
+	}
+	return sort, nil
+}
+
+// sort values of a post search hit (see doSearchPosts)
+type postSortKey struct {
+	createdAt int64
+	did       string
+	rkey      string
+}
+
+func newPostSortKey(createdAt int64, did, rkey string) postSortKey {
+	// keyword fields are lowercased by the index normalizer, and sorted on that value
+	return postSortKey{createdAt: createdAt, did: strings.ToLower(did), rkey: strings.ToLower(rkey)}
+}
+
+func (k postSortKey) values() []any {
+	return []any{k.createdAt, k.did, k.rkey}
+}
+
+// compare orders keys newest first, like the OpenSearch sort
+func (k postSortKey) compare(other postSortKey) int {
+	if c := cmp.Compare(other.createdAt, k.createdAt); c != 0 {
+		return c
+	}
+	if c := strings.Compare(other.did, k.did); c != 0 {
+		return c
+	}
+	return strings.Compare(other.rkey, k.rkey)
+}
+
+// parseSearchAfter checks post sort values, which are the creation time (as unix milliseconds), account DID, and record key
+func parseSearchAfter(sort []any) (postSortKey, error) {
+	if len(sort) != 3 {
+		return postSortKey{}, fmt.Errorf("invalid cursor: wrong number of sort values")
+	}
+	var ms int64
+	switch v := sort[0].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return postSortKey{}, fmt.Errorf("invalid cursor: %w", err)
+		}
+		ms = n
+	case float64:
+		ms = int64(v)
+	case int64:
+		ms = v
+	default:
+		return postSortKey{}, fmt.Errorf("invalid cursor: bad timestamp")
+	}
+	did, ok := sort[1].(string)
+	if !ok {
+		return postSortKey{}, fmt.Errorf("invalid cursor: bad DID")
+	}
+	rkey, ok := sort[2].(string)
+	if !ok {
+		return postSortKey{}, fmt.Errorf("invalid cursor: bad record key")
+	}
+	return newPostSortKey(ms, did, rkey), nil
+}
+
 func checkParams(offset, size int) error {
 	if offset+size > 10000 || size > 250 || offset > 10000 || offset < 0 || size < 0 {
 		return fmt.Errorf("disallowed size/offset parameters")
@@ -236,25 +597,24 @@ func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client
 	ctx, span := tracer.Start(ctx, "DoSearchPosts")
 	defer span.End()
 
-	if err := checkParams(params.Offset, params.Size); err != nil {
+	if err := preparePostSearch(ctx, dir, nil, params); err != nil {
 		return nil, err
 	}
-	queryStringParams := ParsePostQuery(ctx, dir, params.Query, params.Viewer)
-	params.Update(&queryStringParams)
-	idx := "everything"
-	if containsJapanese(params.Query) {
-		idx = "everything_ja"
-	}
-	basic := map[string]interface{}{
-		"simple_query_string": map[string]interface{}{
-			"query":            params.Query,
-			"fields":           []string{idx},
-			"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
-			"default_operator": "and",
-			"lenient":          true,
-			"analyze_wildcard": false,
-		},
+	return doSearchPosts(ctx, escli, index, params)
+}
+
+// checks paging parameters, and pulls filters out of the query string in to params
+func preparePostSearch(ctx context.Context, dir identity.Directory, lists ListResolver, params *PostSearchParams) error {
+	if err := checkParams(params.Offset, params.Size); err != nil {
+		return err
 	}
+	queryStringParams := parsePostQuery(ctx, dir, lists, params.Query, params.Viewer)
+	params.Update(&queryStringParams)
+	return nil
+}
+
+func doSearchPosts(ctx context.Context, escli *es.Client, index string, params *PostSearchParams) (*EsSearchResponse, error) {
+	basic := textQueryDSL(params.Query)
 	filters := params.Filters()
 	// filter out future posts (TODO: temporary hack)
 	now := syntax.DatetimeNow()
@@ -272,10 +632,83 @@ func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client
 				"filter": filters,
 			},
 		},
-		"sort": map[string]any{
-			"created_at": map[string]any{
-				"order": "desc",
+		// account and record key are a tie-breaker, so that search_after pagination is stable. these are "sort" sub-fields with doc values (sorting on _id needs fielddata, which is disabled by default). the sub-fields were added to existing indices by EnsureIndices, so older documents may not have values until they are re-indexed; they sort last among posts with the same creation time
+		"sort": []any{
+			map[string]any{"created_at": map[string]any{"order": "desc"}},
+			map[string]any{"did.sort": map[string]any{"order": "desc", "missing": ""}},
+			map[string]any{"record_rkey.sort": map[string]any{"order": "desc", "missing": ""}},
+		},
+		"size": params.Size,
+		"from": params.Offset,
+	}
+	if params.relevance() {
+		knn := map[string]interface{}{
+			"knn": map[string]interface{}{
+				"embedding": map[string]interface{}{
+					"vector": params.QueryVector,
+					"k":      params.knnK(),
+				},
+			},
+		}
+		boolQuery := map[string]interface{}{"filter": filters}
+		if params.Sort == "semantic" {
+			boolQuery["must"] = knn
+		} else {
+			// hybrid: lexical matches and nearest neighbors, with scores summed. BM25 scores are unbounded, so they are scaled to the same 0-1 range as kNN (cosine) scores first; otherwise lexical matches would swamp similarity
+			lexical := map[string]interface{}{
+				"script_score": map[string]interface{}{
+					"query": basic,
+					"script": map[string]interface{}{
+						"source": "_score / (_score + params.pivot)",
+						"params": map[string]interface{}{"pivot": hybridLexicalPivot},
+					},
+				},
+			}
+			boolQuery["should"] = []interface{}{lexical, knn}
+			boolQuery["minimum_should_match"] = 1
+		}
+		query["query"] = map[string]interface{}{"bool": boolQuery}
+		query["sort"] = []any{"_score"}
+	} else if params.SearchAfter != nil {
+		query["search_after"] = params.SearchAfter
+		delete(query, "from")
+	}
+
+	return doSearch(ctx, escli, index, query)
+}
+
+func doSearchRecords(ctx context.Context, escli *es.Client, index string, params *RecordSearchParams) (*EsSearchResponse, error) {
+	ctx, span := tracer.Start(ctx, "doSearchRecords")
+	defer span.End()
+
+	var basic map[string]interface{}
+	if params.Query == "*" {
+		basic = map[string]interface{}{"match_all": map[string]interface{}{}}
+	} else {
+		basic = map[string]interface{}{
+			"simple_query_string": map[string]interface{}{
+				"query":            params.Query,
+				"fields":           []string{"text"},
+				"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
+				"default_operator": "and",
+				"lenient":          true,
+				"analyze_wildcard": false,
 			},
+		}
+	}
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   basic,
+				"filter": params.Filters(),
+			},
+		},
+		// records without a date field sort last
+		"sort": []any{
+			map[string]any{"created_at": map[string]any{"order": "desc", "missing": "_last"}},
+			map[string]any{"did": map[string]any{"order": "desc"}},
+			map[string]any{"collection": map[string]any{"order": "desc"}},
+			map[string]any{"record_rkey": map[string]any{"order": "desc"}},
 		},
 		"size": params.Size,
 		"from": params.Offset,
@@ -431,6 +864,8 @@ func doSearch(ctx context.Context, escli *es.Client, index string, query interfa
 		escli.Search.WithContext(ctx),
 		escli.Search.WithIndex(index),
 		escli.Search.WithBody(bytes.NewBuffer(b)),
+		// embedding vectors are large, and aren't used in responses
+		escli.Search.WithSourceExcludes("embedding"),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("search query error: %w", err)
@@ -0,0 +1,343 @@
+package search
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+//go:embed record_schema.json
+var palomarRecordSchemaJSON string
+
+// RecordMappings declares which other record types (beyond posts and profiles) are indexed, and which fields of those records. It is usually loaded from a JSON file:
+//
+//	{"collections": [{"nsid": "com.example.blog.entry", "fields": [
+//		{"path": "title", "type": "text"},
+//		{"path": "content", "type": "text"},
+//		{"path": "tags", "type": "keyword", "name": "tag"},
+//		{"path": "createdAt", "type": "date"}
+//	]}]}
+type RecordMappings struct {
+	Collections []CollectionMapping `json:"collections"`
+}
+
+type CollectionMapping struct {
+	NSID   string         `json:"nsid"`
+	Fields []FieldMapping `json:"fields"`
+}
+
+const (
+	FieldText    = "text"
+	FieldKeyword = "keyword"
+	FieldDate    = "date"
+)
+
+type FieldMapping struct {
+	// dot-separated path of the field in record data, as in the lexicon (eg, "subject.uri"). Arrays along the path are flattened.
+	Path string `json:"path"`
+	// one of FieldText (full-text search), FieldKeyword (exact match filters), or FieldDate (the record's creation time, for sorting and since/until filters)
+	Type string `json:"type"`
+	// name of a keyword field in queries ("name:value"). Defaults to the last element of Path.
+	Name string `json:"name,omitempty"`
+}
+
+var keywordNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// LoadRecordMappings reads and validates a mappings JSON file
+func LoadRecordMappings(path string) (*RecordMappings, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m RecordMappings
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parsing record mappings: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks mappings, and fills in default keyword field names
+func (m *RecordMappings) Validate() error {
+	seen := make(map[string]bool)
+	for i := range m.Collections {
+		c := &m.Collections[i]
+		if _, err := syntax.ParseNSID(c.NSID); err != nil {
+			return fmt.Errorf("record mappings: %w", err)
+		}
+		// these have their own indices
+		if c.NSID == "app.bsky.feed.post" || c.NSID == "app.bsky.actor.profile" {
+			return fmt.Errorf("record mappings: collection is indexed separately: %s", c.NSID)
+		}
+		if seen[c.NSID] {
+			return fmt.Errorf("record mappings: duplicate collection: %s", c.NSID)
+		}
+		seen[c.NSID] = true
+
+		names := make(map[string]bool)
+		dates := 0
+		for j := range c.Fields {
+			f := &c.Fields[j]
+			if f.Path == "" || strings.Contains(f.Path, "..") || strings.HasPrefix(f.Path, ".") || strings.HasSuffix(f.Path, ".") {
+				return fmt.Errorf("record mappings: %s: invalid field path: %q", c.NSID, f.Path)
+			}
+			switch f.Type {
+			case FieldText:
+			case FieldDate:
+				dates++
+			case FieldKeyword:
+				if f.Name == "" {
+					parts := strings.Split(f.Path, ".")
+					f.Name = parts[len(parts)-1]
+				}
+				if !keywordNameRegex.MatchString(f.Name) {
+					return fmt.Errorf("record mappings: %s: invalid keyword field name: %q", c.NSID, f.Name)
+				}
+				// these would be confused with the built-in filters
+				if f.Name == "from" || f.Name == "did" || f.Name == "since" || f.Name == "until" || names[f.Name] {
+					return fmt.Errorf("record mappings: %s: duplicate or reserved keyword field name: %q", c.NSID, f.Name)
+				}
+				names[f.Name] = true
+			default:
+				return fmt.Errorf("record mappings: %s: unknown field type: %q", c.NSID, f.Type)
+			}
+		}
+		if dates > 1 {
+			return fmt.Errorf("record mappings: %s: at most one date field is allowed", c.NSID)
+		}
+	}
+	return nil
+}
+
+// Enabled is true if any collections are indexed. Safe to call on a nil receiver.
+func (m *RecordMappings) Enabled() bool {
+	return m != nil && len(m.Collections) > 0
+}
+
+// Collection returns the mapping for an NSID, or nil if that collection isn't indexed. Safe to call on a nil receiver.
+func (m *RecordMappings) Collection(nsid string) *CollectionMapping {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Collections {
+		if m.Collections[i].NSID == nsid {
+			return &m.Collections[i]
+		}
+	}
+	return nil
+}
+
+func (c *CollectionMapping) hasKeyword(name string) bool {
+	for _, f := range c.Fields {
+		if f.Type == FieldKeyword && f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type RecordDoc struct {
+	DocIndexTs string  `json:"doc_index_ts"`
+	DID        string  `json:"did"`
+	Collection string  `json:"collection"`
+	RecordRkey string  `json:"record_rkey"`
+	RecordCID  string  `json:"record_cid"`
+	CreatedAt  *string `json:"created_at,omitempty"`
+	// values of all the text fields
+	Text []string `json:"text,omitempty"`
+	// values of keyword fields, as "name=value"
+	Keyword []string `json:"keyword,omitempty"`
+}
+
+// Returns the search index document ID (`_id`) for this document.
+//
+// This identifier should be URL safe and not contain a slash ("/").
+func (d *RecordDoc) DocId() string {
+	return d.DID + "_" + d.Collection + "_" + d.RecordRkey
+}
+
+func (d *RecordDoc) ATURI() string {
+	return fmt.Sprintf("at://%s/%s/%s", d.DID, d.Collection, d.RecordRkey)
+}
+
+// recordValues returns the values at a dot-separated path in record data, flattening arrays
+func recordValues(val any, path []string) []any {
+	if arr, ok := val.([]any); ok {
+		var out []any
+		for _, v := range arr {
+			out = append(out, recordValues(v, path)...)
+		}
+		return out
+	}
+	if len(path) == 0 {
+		if val == nil {
+			return nil
+		}
+		return []any{val}
+	}
+	obj, ok := val.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return recordValues(obj[path[0]], path[1:])
+}
+
+// TransformRecord extracts the mapped fields of a record (as decoded by the atproto/data package). Values of the wrong type for a field are skipped.
+func TransformRecord(mapping *CollectionMapping, rec map[string]any, did syntax.DID, rkey, cid string) RecordDoc {
+	doc := RecordDoc{
+		DocIndexTs: syntax.DatetimeNow().String(),
+		DID:        did.String(),
+		Collection: mapping.NSID,
+		RecordRkey: rkey,
+		RecordCID:  cid,
+	}
+	for _, f := range mapping.Fields {
+		for _, v := range recordValues(rec, strings.Split(f.Path, ".")) {
+			switch f.Type {
+			case FieldText:
+				if s, ok := v.(string); ok && s != "" {
+					doc.Text = append(doc.Text, s)
+				}
+			case FieldKeyword:
+				var s string
+				switch v := v.(type) {
+				case string:
+					s = v
+				case int64:
+					s = strconv.FormatInt(v, 10)
+				case bool:
+					s = strconv.FormatBool(v)
+				default:
+					continue
+				}
+				if s != "" {
+					doc.Keyword = append(doc.Keyword, f.Name+"="+s)
+				}
+			case FieldDate:
+				s, ok := v.(string)
+				if !ok || doc.CreatedAt != nil {
+					continue
+				}
+				if dt, err := syntax.ParseDatetimeLenient(s); err == nil {
+					created := dt.String()
+					doc.CreatedAt = &created
+				}
+			}
+		}
+	}
+	return doc
+}
+
+type RecordSearchParams struct {
+	Query      string           `json:"q"`
+	Collection string           `json:"collection"`
+	Author     *syntax.DID      `json:"author"`
+	Since      *syntax.Datetime `json:"since"`
+	Until      *syntax.Datetime `json:"until"`
+	// keyword filters, as "name=value"
+	Keywords []string `json:"keywords"`
+	Offset   int      `json:"offset"`
+	Size     int      `json:"size"`
+}
+
+// Filters turns search params in to actual elasticsearch/opensearch filter DSL
+func (p *RecordSearchParams) Filters() []map[string]interface{} {
+	filters := []map[string]interface{}{termDSL("collection", p.Collection)}
+	if p.Author != nil {
+		filters = append(filters, termDSL("did", p.Author.String()))
+	}
+	if p.Since != nil {
+		filters = append(filters, filterDSL("since", p.Since.String()))
+	}
+	if p.Until != nil {
+		filters = append(filters, filterDSL("until", p.Until.String()))
+	}
+	for _, kw := range p.Keywords {
+		filters = append(filters, termDSL("keyword", kw))
+	}
+	return filters
+}
+
+// matchDoc checks a record document against the same filters as Filters, for backends which don't use the filter DSL
+func (p *RecordSearchParams) matchDoc(doc *RecordDoc) bool {
+	if doc.Collection != p.Collection {
+		return false
+	}
+	if p.Author != nil && !strings.EqualFold(doc.DID, p.Author.String()) {
+		return false
+	}
+	if p.Since != nil || p.Until != nil {
+		if doc.CreatedAt == nil {
+			return false
+		}
+		createdAt, err := syntax.ParseDatetimeLenient(*doc.CreatedAt)
+		if err != nil {
+			return false
+		}
+		if p.Since != nil && createdAt.Time().Before(p.Since.Time()) {
+			return false
+		}
+		if p.Until != nil && !createdAt.Time().Before(p.Until.Time()) {
+			return false
+		}
+	}
+	for _, kw := range p.Keywords {
+		if !containsFold(doc.Keyword, kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRecordQuery pulls filters out of a record query string: "from:<handle or DID>", bare DIDs, "since:" and "until:", and "name:value" for keyword fields of the collection. Unlike post queries, there is no boolean grammar.
+func parseRecordQuery(ctx context.Context, dir identity.Directory, mapping *CollectionMapping, raw string) RecordSearchParams {
+	params := RecordSearchParams{Collection: mapping.NSID}
+	qp := queryParser{ctx: ctx, dir: dir}
+
+	var keep []string
+	for _, tok := range tokenizeQuery(raw) {
+		key, val, ok := strings.Cut(tok, ":")
+		switch {
+		case strings.HasPrefix(tok, "\"") || !ok:
+			keep = append(keep, tok)
+		case key == "did":
+			if did, err := syntax.ParseDID(tok); err == nil {
+				params.Author = &did
+			}
+		case key == "from":
+			if did := qp.resolveHandle(val); did != nil {
+				params.Author = did
+			}
+		case key == "since" || key == "until":
+			dt, err := parseQueryDatetime(val)
+			if err != nil {
+				continue
+			}
+			if key == "since" {
+				params.Since = &dt
+			} else {
+				params.Until = &dt
+			}
+		case mapping.hasKeyword(key):
+			params.Keywords = append(params.Keywords, key+"="+val)
+		default:
+			keep = append(keep, tok)
+		}
+	}
+
+	params.Query = strings.Join(keep, " ")
+	if params.Query == "" {
+		params.Query = "*"
+	}
+	return params
+}
@@ -0,0 +1,159 @@
+package search
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecordMappings() *RecordMappings {
+	return &RecordMappings{Collections: []CollectionMapping{{
+		NSID: "com.example.blog.entry",
+		Fields: []FieldMapping{
+			{Path: "title", Type: FieldText},
+			{Path: "content", Type: FieldText},
+			{Path: "tags", Type: FieldKeyword, Name: "tag"},
+			{Path: "meta.visibility", Type: FieldKeyword},
+			{Path: "sections.heading", Type: FieldText},
+			{Path: "createdAt", Type: FieldDate},
+		},
+	}}}
+}
+
+func TestRecordMappingsValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	m := testRecordMappings()
+	assert.NoError(m.Validate())
+	// default keyword name is the last path element
+	assert.Equal("visibility", m.Collections[0].Fields[3].Name)
+	assert.NotNil(m.Collection("com.example.blog.entry"))
+	assert.Nil(m.Collection("com.example.other"))
+	var none *RecordMappings
+	assert.Nil(none.Collection("com.example.blog.entry"))
+
+	bad := []RecordMappings{
+		{Collections: []CollectionMapping{{NSID: "not-an-nsid"}}},
+		{Collections: []CollectionMapping{{NSID: "app.bsky.feed.post"}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a"}, {NSID: "com.example.a"}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a", Fields: []FieldMapping{{Path: "a..b", Type: FieldText}}}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a", Fields: []FieldMapping{{Path: "a", Type: "number"}}}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a", Fields: []FieldMapping{{Path: "from", Type: FieldKeyword}}}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a", Fields: []FieldMapping{{Path: "a", Type: FieldKeyword, Name: "b-c"}}}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a", Fields: []FieldMapping{{Path: "a", Type: FieldKeyword}, {Path: "b.a", Type: FieldKeyword}}}}},
+		{Collections: []CollectionMapping{{NSID: "com.example.a", Fields: []FieldMapping{{Path: "a", Type: FieldDate}, {Path: "b", Type: FieldDate}}}}},
+	}
+	for _, m := range bad {
+		assert.Error(m.Validate(), m)
+	}
+}
+
+func TestTransformRecord(t *testing.T) {
+	assert := assert.New(t)
+
+	m := testRecordMappings()
+	assert.NoError(m.Validate())
+	rec := map[string]any{
+		"$type":   "com.example.blog.entry",
+		"title":   "Hello World",
+		"content": "first post on the blog",
+		"tags":    []any{"intro", "Meta", int64(3)},
+		"meta":    map[string]any{"visibility": "public"},
+		"sections": []any{
+			map[string]any{"heading": "one"},
+			map[string]any{"heading": "two"},
+			map[string]any{"heading": false},
+		},
+		"createdAt": "2024-01-02T03:04:05Z",
+	}
+	doc := TransformRecord(m.Collection("com.example.blog.entry"), rec, syntax.DID("did:plc:abc111"), "3kpnillluaaaa", "bafyreic")
+	assert.Equal([]string{"Hello World", "first post on the blog", "one", "two"}, doc.Text)
+	assert.Equal([]string{"tag=intro", "tag=Meta", "tag=3", "visibility=public"}, doc.Keyword)
+	assert.NotNil(doc.CreatedAt)
+	assert.Equal("did:plc:abc111_com.example.blog.entry_3kpnillluaaaa", doc.DocId())
+	assert.Equal("at://did:plc:abc111/com.example.blog.entry/3kpnillluaaaa", doc.ATURI())
+}
+
+func TestParseRecordQuery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{DID: syntax.DID("did:plc:abc111"), Handle: syntax.Handle("alice.example.com")})
+
+	m := testRecordMappings()
+	assert.NoError(m.Validate())
+	mapping := m.Collection("com.example.blog.entry")
+
+	p := parseRecordQuery(ctx, &dir, mapping, "hello tag:intro from:alice.example.com since:2024-01-01 other:thing")
+	assert.Equal("hello other:thing", p.Query)
+	assert.Equal("com.example.blog.entry", p.Collection)
+	assert.Equal([]string{"tag=intro"}, p.Keywords)
+	assert.Equal("did:plc:abc111", p.Author.String())
+	assert.NotNil(p.Since)
+
+	p = parseRecordQuery(ctx, &dir, mapping, "did:plc:abc222 visibility:public")
+	assert.Equal("*", p.Query)
+	assert.Equal("did:plc:abc222", p.Author.String())
+	assert.Equal([]string{"visibility=public"}, p.Keywords)
+}
+
+func TestEmbeddedRecordSearch(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{DID: syntax.DID("did:plc:abc111"), Handle: syntax.Handle("alice.example.com")})
+	srv := testEmbeddedServer(t, &dir)
+
+	m := testRecordMappings()
+	assert.NoError(m.Validate())
+	srv.records = m
+	mapping := m.Collection("com.example.blog.entry")
+
+	entries := map[string]map[string]any{
+		"3kpnillluaaaa": {"title": "Hello World", "tags": []any{"intro"}, "createdAt": "2024-01-02T03:04:05Z"},
+		"3kpnilllu2222": {"title": "Second entry", "content": "more words", "createdAt": "2024-02-02T03:04:05Z"},
+		"3kpnilllu3333": {"title": "Undated entry", "tags": []any{"intro", "misc"}},
+	}
+	var jobs []*RecordIndexJob
+	for rkey, rec := range entries {
+		jobs = append(jobs, &RecordIndexJob{did: syntax.DID("did:plc:abc111"), mapping: mapping, record: rec, rkey: rkey, rcid: cid.Undef})
+	}
+	assert.NoError(srv.Indexer.indexRecords(ctx, jobs))
+
+	search := func(q string) []string {
+		out, err := srv.SearchRecords(ctx, "com.example.blog.entry", q, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var uris []string
+		for _, r := range out.Records {
+			uris = append(uris, r.Uri)
+		}
+		return uris
+	}
+
+	// newest first, undated last
+	assert.Equal([]string{
+		"at://did:plc:abc111/com.example.blog.entry/3kpnilllu2222",
+		"at://did:plc:abc111/com.example.blog.entry/3kpnillluaaaa",
+		"at://did:plc:abc111/com.example.blog.entry/3kpnilllu3333",
+	}, search("*"))
+	assert.Len(search("entry"), 2)
+	assert.Len(search("words"), 1)
+	assert.Len(search("tag:intro"), 2)
+	assert.Len(search("entry tag:intro"), 1)
+	assert.Len(search("from:alice.example.com"), 3)
+	assert.Len(search("since:2024-01-15"), 1)
+	assert.Empty(search("did:plc:abc222"))
+
+	_, err := srv.SearchRecords(ctx, "com.example.other", "*", 0, 10)
+	assert.Error(err)
+
+	assert.NoError(srv.Indexer.deleteRecord(ctx, syntax.DID("did:plc:abc111"), "com.example.blog.entry/3kpnilllu2222"))
+	assert.Len(search("entry"), 1)
+}
@@ -3,11 +3,9 @@ package search
 import (
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 
@@ -31,16 +29,27 @@ type ServerConfig struct {
 	Logger            *slog.Logger
 	ProfileIndex      string
 	PostIndex         string
+	RecordIndex       string
 	AtlantisAddresses []string
+	// if nil, an EsBackend is used, with the given index names
+	Backend Backend
+	// used for the "list:" query filter; if nil, that filter is ignored
+	Lists ListResolver
+	// used for "semantic" and "hybrid" post search sorts; if nil, those fall back to lexical search
+	Embedder Embedder
+	// collections which can be searched with searchRecords; should match the IndexerConfig
+	RecordMappings *RecordMappings
 }
 
 type Server struct {
-	escli        *es.Client
-	postIndex    string
-	profileIndex string
-	dir          identity.Directory
-	echo         *echo.Echo
-	logger       *slog.Logger
+	escli    *es.Client
+	backend  Backend
+	dir      identity.Directory
+	lists    ListResolver
+	embedder Embedder
+	records  *RecordMappings
+	echo     *echo.Echo
+	logger   *slog.Logger
 
 	Indexer *Indexer
 }
@@ -53,60 +62,29 @@ func NewServer(escli *es.Client, dir identity.Directory, config ServerConfig) (*
 		}))
 	}
 
+	backend := config.Backend
+	if backend == nil {
+		if escli == nil {
+			return nil, fmt.Errorf("search server requires either an OpenSearch client or a Backend")
+		}
+		backend = newDefaultEsBackend(escli, config.PostIndex, config.ProfileIndex, config.RecordIndex, config.Embedder, config.RecordMappings, logger)
+	}
+
 	serv := Server{
-		escli:        escli,
-		postIndex:    config.PostIndex,
-		profileIndex: config.ProfileIndex,
-		dir:          dir,
-		logger:       logger,
+		escli:    escli,
+		backend:  backend,
+		dir:      dir,
+		lists:    config.Lists,
+		embedder: config.Embedder,
+		records:  config.RecordMappings,
+		logger:   logger,
 	}
 
 	return &serv, nil
 }
 
 func (s *Server) EnsureIndices(ctx context.Context) error {
-
-	indices := []struct {
-		Name       string
-		SchemaJSON string
-	}{
-		{Name: s.postIndex, SchemaJSON: palomarPostSchemaJSON},
-		{Name: s.profileIndex, SchemaJSON: palomarProfileSchemaJSON},
-	}
-	for _, idx := range indices {
-		resp, err := s.escli.Indices.Exists([]string{idx.Name})
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		io.ReadAll(resp.Body)
-		if resp.IsError() && resp.StatusCode != 404 {
-			return fmt.Errorf("failed to check index existence")
-		}
-		if resp.StatusCode == 404 {
-			s.logger.Warn("creating opensearch index", "index", idx.Name)
-			if len(idx.SchemaJSON) < 2 {
-				return fmt.Errorf("empty schema file (go:embed failed)")
-			}
-			buf := strings.NewReader(idx.SchemaJSON)
-			resp, err := s.escli.Indices.Create(
-				idx.Name,
-				s.escli.Indices.Create.WithBody(buf))
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			errBytes, err := io.ReadAll(resp.Body)
-			if resp.IsError() {
-				s.logger.Error("failed to create index", "index", idx.Name, "response", string(errBytes))
-				return fmt.Errorf("failed to create index")
-			}
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	return s.backend.EnsureIndices(ctx)
 }
 
 type HealthStatus struct {
@@ -127,7 +105,13 @@ func (a *Server) handleHealthCheck(c echo.Context) error {
 }
 
 func (s *Server) RunAPI(listen string) error {
+	s.echo = s.newAPI()
+	s.logger.Info("starting search API daemon", "bind", listen)
+	return s.echo.Start(listen)
+}
 
+// newAPI configures the HTTP server and routes
+func (s *Server) newAPI() *echo.Echo {
 	s.logger.Info("Configuring HTTP server")
 	e := echo.New()
 	e.HideBanner = true
@@ -152,10 +136,9 @@ func (s *Server) RunAPI(listen string) error {
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 	e.GET("/xrpc/app.bsky.unspecced.searchPostsSkeleton", s.handleSearchPostsSkeleton)
 	e.GET("/xrpc/app.bsky.unspecced.searchActorsSkeleton", s.handleSearchActorsSkeleton)
-	s.echo = e
-
-	s.logger.Info("starting search API daemon", "bind", listen)
-	return s.echo.Start(listen)
+	e.GET("/search/records", s.handleSearchRecords)
+	e.GET("/stream/posts", s.handleSubscribePosts)
+	return e
 }
 
 func (s *Server) RunMetrics(listen string) error {
@@ -0,0 +1,182 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// posts created longer ago than this (eg, during backfill) are not sent to saved-search subscribers
+var savedSearchMaxAge = time.Hour
+
+// SavedSearchPost is a newly indexed post which matched a saved search
+type SavedSearchPost struct {
+	Uri       string `json:"uri"`
+	Cid       string `json:"cid"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type postSubscription struct {
+	params PostSearchParams
+	q      embeddedQuery
+	out    chan *SavedSearchPost
+}
+
+// postStreams fans out newly indexed posts to saved-search subscribers. Matching uses the same simple text matching as the embedded index, regardless of backend.
+type postStreams struct {
+	lk   sync.Mutex
+	subs map[*postSubscription]struct{}
+}
+
+func newPostStreams() *postStreams {
+	return &postStreams{subs: make(map[*postSubscription]struct{})}
+}
+
+// subscribe registers a saved search (params must already have been parsed; see preparePostSearch). The returned channel is closed if the subscriber falls behind, or when cleanup is called.
+func (ps *postStreams) subscribe(params *PostSearchParams) (<-chan *SavedSearchPost, func()) {
+	sub := &postSubscription{
+		params: *params,
+		q:      parseEmbeddedQuery(params.Query),
+		out:    make(chan *SavedSearchPost, 1000),
+	}
+	ps.lk.Lock()
+	ps.subs[sub] = struct{}{}
+	savedSearchSubscribers.Set(float64(len(ps.subs)))
+	ps.lk.Unlock()
+
+	cleanup := func() {
+		ps.lk.Lock()
+		defer ps.lk.Unlock()
+		if _, ok := ps.subs[sub]; ok {
+			delete(ps.subs, sub)
+			close(sub.out)
+			savedSearchSubscribers.Set(float64(len(ps.subs)))
+		}
+	}
+	return sub.out, cleanup
+}
+
+func (ps *postStreams) publish(docs []PostDoc) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	if len(ps.subs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for i := range docs {
+		doc := &docs[i]
+		if doc.CreatedAt == nil {
+			continue
+		}
+		createdAt, err := syntax.ParseDatetimeLenient(*doc.CreatedAt)
+		if err != nil || createdAt.Time().After(now) || now.Sub(createdAt.Time()) > savedSearchMaxAge {
+			continue
+		}
+		post := &SavedSearchPost{
+			Uri:       fmt.Sprintf("at://%s/app.bsky.feed.post/%s", doc.DID, doc.RecordRkey),
+			Cid:       doc.RecordCID,
+			CreatedAt: *doc.CreatedAt,
+		}
+		for sub := range ps.subs {
+			if !matchPost(&sub.params, &sub.q, doc) {
+				continue
+			}
+			select {
+			case sub.out <- post:
+				savedSearchPostsSent.Inc()
+			default:
+				// slow consumer; the handler will close the connection
+				delete(ps.subs, sub)
+				close(sub.out)
+				savedSearchSubscribers.Set(float64(len(ps.subs)))
+			}
+		}
+	}
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 10_000,
+}
+
+// handleSubscribePosts streams newly indexed posts matching a query (the 'q' param, with the same syntax as searchPostsSkeleton) as JSON messages over a websocket
+func (s *Server) handleSubscribePosts(e echo.Context) error {
+	if s.Indexer == nil {
+		return &echo.HTTPError{Code: 501, Message: "saved searches are only available on indexing instances"}
+	}
+
+	q := strings.TrimSpace(e.QueryParam("q"))
+	if q == "" {
+		return e.JSON(400, map[string]any{
+			"error":   "BadRequest",
+			"message": "must pass non-empty search query",
+		})
+	}
+	params := PostSearchParams{Query: q}
+	if viewerStr := e.QueryParam("viewer"); viewerStr != "" {
+		d, err := syntax.ParseDID(viewerStr)
+		if err != nil {
+			return e.JSON(400, map[string]any{
+				"error":   "BadRequest",
+				"message": fmt.Sprintf("invalid DID for 'viewer': %s", err),
+			})
+		}
+		params.Viewer = &d
+	}
+
+	ctx, cancel := context.WithCancel(e.Request().Context())
+	defer cancel()
+	if err := preparePostSearch(ctx, s.dir, s.lists, &params); err != nil {
+		return err
+	}
+
+	conn, err := wsUpgrader.Upgrade(e.Response(), e.Request(), e.Response().Header())
+	if err != nil {
+		return fmt.Errorf("upgrading websocket: %w", err)
+	}
+	defer conn.Close()
+
+	// read and discard client messages, to handle pings and notice disconnects
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	posts, cleanup := s.Indexer.streams.subscribe(&params)
+	defer cleanup()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
+				return nil
+			}
+		case post, ok := <-posts:
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "consumer too slow")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(5*time.Second))
+				return nil
+			}
+			if err := conn.WriteJSON(post); err != nil {
+				s.logger.Warn("failed to write saved search post", "err", err)
+				return nil
+			}
+		}
+	}
+}
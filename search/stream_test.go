@@ -0,0 +1,50 @@
+package search
+
+import (
+	"context"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavedSearchStream(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := identity.NewMockDirectory()
+	srv := testEmbeddedServer(t, &dir)
+	did := syntax.DID("did:plc:abc111")
+
+	params := PostSearchParams{Query: "cats OR dogs -lang:de"}
+	assert.NoError(preparePostSearch(ctx, &dir, nil, &params))
+	posts, cleanup := srv.Indexer.streams.subscribe(&params)
+
+	now := syntax.DatetimeNow().String()
+	assert.NoError(srv.Indexer.indexPosts(ctx, []*PostIndexJob{
+		{did: did, rkey: "3kpnilllu2222", rcid: cid.Undef, record: &appbsky.FeedPost{Text: "i like cats", CreatedAt: now}},
+		{did: did, rkey: "3kpnilllu3333", rcid: cid.Undef, record: &appbsky.FeedPost{Text: "i like birds", CreatedAt: now}},
+		{did: did, rkey: "3kpnilllu4444", rcid: cid.Undef, record: &appbsky.FeedPost{Text: "ich mag dogs", CreatedAt: now, Langs: []string{"de"}}},
+		// old posts (eg, from backfill) aren't streamed
+		{did: did, rkey: "3kpnilllu5555", rcid: cid.Undef, record: &appbsky.FeedPost{Text: "old dogs", CreatedAt: "2020-01-02T03:04:05.006Z"}},
+		{did: did, rkey: "3kpnilllu6666", rcid: cid.Undef, record: &appbsky.FeedPost{Text: "dogs!", CreatedAt: now}},
+	}))
+
+	assert.Len(posts, 2)
+	post := <-posts
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/3kpnilllu2222", post.Uri)
+	post = <-posts
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/3kpnilllu6666", post.Uri)
+
+	cleanup()
+	_, ok := <-posts
+	assert.False(ok)
+	// cleanup is idempotent, and publishing without subscribers is fine
+	cleanup()
+	assert.NoError(srv.Indexer.indexPosts(ctx, []*PostIndexJob{
+		{did: did, rkey: "3kpnilllu7777", rcid: cid.Undef, record: &appbsky.FeedPost{Text: "more cats", CreatedAt: now}},
+	}))
+}
@@ -28,6 +28,8 @@ type ProfileDoc struct {
 	Emoji       []string `json:"emoji,omitempty"`
 	HasAvatar   bool     `json:"has_avatar"`
 	HasBanner   bool     `json:"has_banner"`
+	// optional, see Embedder
+	Embedding []float32 `json:"embedding,omitempty"`
 }
 
 type PostDoc struct {
@@ -53,6 +55,8 @@ type PostDoc struct {
 	Tag               []string `json:"tag,omitempty"`
 	Emoji             []string `json:"emoji,omitempty"`
 	Has               []string `json:"has,omitempty"`
+	// optional, see Embedder
+	Embedding []float32 `json:"embedding,omitempty"`
 }
 
 // Returns the search index document ID (`_id`) for this document.
@@ -0,0 +1,381 @@
+package splitter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/pebblepersist"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// how long the last identity and account event for each account is remembered for deduplication
+const fanInContentWindow = 15 * time.Minute
+
+// fanIn merges the firehoses of several upstream hosts (relays or PDSes) into the splitter's event stream, so that the splitter keeps serving when any single upstream fails.
+//
+// Commits and syncs are deduplicated by (DID, rev): an event is only passed on if its rev is newer than the last one seen for the account, from any upstream. Identity events are only dropped if they are a replay of the last one passed on for the account (same handle and event time), within a window: a key rotation or PDS migration keeps the same handle, but consumers still need to refresh the DID document. Account events are dropped if they match the last account state passed on for the account, within a window; a change in state (eg, deactivation and then reactivation) is always passed on. Passed on events get new sequence numbers, continuing from the last persisted event.
+//
+// Schema:
+// c{upstream host} : {uint64 cursor}
+// r{did} : {rev}
+type fanIn struct {
+	s      *Splitter
+	hosts  []string
+	db     *pebble.DB
+	logger *slog.Logger
+
+	// serializes sequence number assignment and deduplication
+	lk      sync.Mutex
+	nextSeq int64
+	revs    *lru.Cache[string, string]
+	// "identity|{did}" or "account|{did}" -> last event (or account state) passed on
+	states *expirable.LRU[string, string]
+
+	cursorsLk sync.Mutex
+	cursors   map[string]int64
+	connected map[string]bool
+
+	// stops upstream subscriptions; Shutdown waits for them before closing the database
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newFanIn(s *Splitter, hosts []string, dbPath string) (*fanIn, error) {
+	db, err := pebble.Open(dbPath, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: pebble could not open, %w", dbPath, err)
+	}
+	revs, err := lru.New[string, string](1_000_000)
+	if err != nil {
+		return nil, err
+	}
+	return &fanIn{
+		s:         s,
+		hosts:     hosts,
+		db:        db,
+		logger:    s.logger.With("component", "fanin"),
+		revs:      revs,
+		states:    expirable.NewLRU[string, string](1_000_000, nil, fanInContentWindow),
+		cursors:   make(map[string]int64),
+		connected: make(map[string]bool),
+	}, nil
+}
+
+func fanInCursorKey(host string) []byte {
+	return append([]byte{'c'}, host...)
+}
+
+func fanInRevKey(did string) []byte {
+	return append([]byte{'r'}, did...)
+}
+
+// loadCursor returns -1 if there is no saved cursor for the upstream
+func (fi *fanIn) loadCursor(host string) (int64, error) {
+	val, closer, err := fi.db.Get(fanInCursorKey(host))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	defer closer.Close()
+	if len(val) != 8 {
+		return -1, fmt.Errorf("bad cursor value for %s", host)
+	}
+	return int64(binary.BigEndian.Uint64(val)), nil
+}
+
+func (fi *fanIn) saveCursors() error {
+	fi.cursorsLk.Lock()
+	defer fi.cursorsLk.Unlock()
+	batch := fi.db.NewBatch()
+	defer batch.Close()
+	for host, curs := range fi.cursors {
+		var val [8]byte
+		binary.BigEndian.PutUint64(val[:], uint64(curs))
+		if err := batch.Set(fanInCursorKey(host), val[:], nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// lastRev returns the last rev passed on for an account, or "" if none
+func (fi *fanIn) lastRev(did string) (string, error) {
+	if rev, ok := fi.revs.Get(did); ok {
+		return rev, nil
+	}
+	val, closer, err := fi.db.Get(fanInRevKey(did))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	rev := string(val)
+	closer.Close()
+	fi.revs.Add(did, rev)
+	return rev, nil
+}
+
+// Start subscribes to every upstream; it does not block. Subscriptions run until Shutdown is called (or ctx is done).
+func (fi *fanIn) Start(ctx context.Context) error {
+	ctx, fi.cancel = context.WithCancel(ctx)
+
+	// the cursor file holds an upstream's sequence number, so only the persisted events are used here
+	seq, _, _, err := fi.s.pp.GetLast(ctx)
+	if errors.Is(err, pebblepersist.ErrNoLast) {
+		seq = 0
+	} else if err != nil {
+		return fmt.Errorf("loading last sequence number: %w", err)
+	}
+	fi.nextSeq = seq + 1
+	fi.logger.Info("starting fan-in", "upstreams", fi.hosts, "next_seq", fi.nextSeq)
+
+	for _, host := range fi.hosts {
+		curs, err := fi.loadCursor(host)
+		if err != nil {
+			return err
+		}
+		fi.wg.Add(1)
+		go func(host string, curs int64) {
+			defer fi.wg.Done()
+			fi.subscribeWithRedialer(ctx, host, curs)
+		}(host, curs)
+	}
+
+	fi.wg.Add(1)
+	go func() {
+		defer fi.wg.Done()
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := fi.saveCursors(); err != nil {
+					fi.logger.Error("saving upstream cursors failed", "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (fi *fanIn) Shutdown() error {
+	if fi.cancel != nil {
+		fi.cancel()
+	}
+	fi.wg.Wait()
+	if err := fi.saveCursors(); err != nil {
+		return err
+	}
+	return fi.db.Close()
+}
+
+// ConnectedUpstreams returns the number of upstreams with an open connection
+func (fi *fanIn) ConnectedUpstreams() int {
+	fi.cursorsLk.Lock()
+	defer fi.cursorsLk.Unlock()
+	n := 0
+	for _, ok := range fi.connected {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (fi *fanIn) setConnected(host string, connected bool) {
+	fi.cursorsLk.Lock()
+	fi.connected[host] = connected
+	fi.cursorsLk.Unlock()
+	v := 0.0
+	if connected {
+		v = 1
+	}
+	faninUpstreamConnected.WithLabelValues(host).Set(v)
+}
+
+func (fi *fanIn) subscribeWithRedialer(ctx context.Context, host string, cursor int64) {
+	d := websocket.Dialer{}
+
+	upstreamUrl, err := url.Parse(hostWebsocketURL(host))
+	if err != nil {
+		fi.logger.Error("bad upstream host", "host", host, "err", err)
+		return
+	}
+	upstreamUrl = upstreamUrl.JoinPath("/xrpc/com.atproto.sync.subscribeRepos")
+
+	header := http.Header{
+		"User-Agent": []string{fi.s.conf.UserAgent},
+	}
+
+	var backoff int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if cursor < 0 {
+			upstreamUrl.RawQuery = ""
+		} else {
+			upstreamUrl.RawQuery = fmt.Sprintf("cursor=%d", cursor)
+		}
+		uurl := upstreamUrl.String()
+		con, res, err := d.DialContext(ctx, uurl, header)
+		if err != nil {
+			fi.logger.Warn("dialing failed", "url", uurl, "err", err, "backoff", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(sleepForBackoff(backoff)):
+			}
+			backoff++
+			continue
+		}
+		backoff = 0
+
+		fi.logger.Info("event subscription response", "host", host, "code", res.StatusCode)
+		fi.setConnected(host, true)
+		if err := fi.handleUpstreamConnection(ctx, host, con, &cursor); err != nil {
+			fi.logger.Warn("upstream connection failed", "url", uurl, "err", err)
+		}
+		fi.setConnected(host, false)
+	}
+}
+
+func (fi *fanIn) handleUpstreamConnection(ctx context.Context, host string, con *websocket.Conn, lastCursor *int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sched := sequential.NewScheduler("splitter-fanin-"+host, func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		seq := events.SequenceForEvent(evt)
+		if seq < 0 {
+			// ignore info events and other unsupported types
+			return nil
+		}
+
+		if err := fi.accept(ctx, host, evt); err != nil {
+			return err
+		}
+
+		*lastCursor = seq
+		fi.cursorsLk.Lock()
+		fi.cursors[host] = seq
+		fi.cursorsLk.Unlock()
+		return nil
+	})
+
+	return events.HandleRepoStream(ctx, con, sched, nil)
+}
+
+// accept passes an event from an upstream on to the splitter's stream, with a new sequence number, unless it duplicates an earlier event
+func (fi *fanIn) accept(ctx context.Context, host string, evt *events.XRPCStreamEvent) error {
+	fi.lk.Lock()
+	defer fi.lk.Unlock()
+
+	result, err := fi.check(evt)
+	if err != nil {
+		return err
+	}
+	faninEventsCounter.WithLabelValues(host, result).Inc()
+	if result != "accepted" {
+		return nil
+	}
+
+	seq := fi.nextSeq
+	switch {
+	case evt.RepoCommit != nil:
+		evt.RepoCommit.Seq = seq
+	case evt.RepoSync != nil:
+		evt.RepoSync.Seq = seq
+	case evt.RepoIdentity != nil:
+		evt.RepoIdentity.Seq = seq
+	case evt.RepoAccount != nil:
+		evt.RepoAccount.Seq = seq
+	}
+	evt.Preserialized = nil
+	if err := fi.s.events.AddEvent(ctx, evt); err != nil {
+		return err
+	}
+	fi.nextSeq++
+
+	switch {
+	case evt.RepoCommit != nil:
+		return fi.recordRev(evt.RepoCommit.Repo, evt.RepoCommit.Rev)
+	case evt.RepoSync != nil:
+		return fi.recordRev(evt.RepoSync.Did, evt.RepoSync.Rev)
+	}
+	return nil
+}
+
+func (fi *fanIn) recordRev(did, rev string) error {
+	fi.revs.Add(did, rev)
+	// not synced; on a crash, some events may be passed on twice after restart
+	return fi.db.Set(fanInRevKey(did), []byte(rev), pebble.NoSync)
+}
+
+// check returns "accepted", "duplicate" (already passed on), "stale" (older than an event already passed on), or "unsupported"
+func (fi *fanIn) check(evt *events.XRPCStreamEvent) (string, error) {
+	var did, rev, stateKey, state string
+	switch {
+	case evt.RepoCommit != nil:
+		did, rev = evt.RepoCommit.Repo, evt.RepoCommit.Rev
+	case evt.RepoSync != nil:
+		did, rev = evt.RepoSync.Did, evt.RepoSync.Rev
+	case evt.RepoIdentity != nil:
+		handle := ""
+		if evt.RepoIdentity.Handle != nil {
+			handle = *evt.RepoIdentity.Handle
+		}
+		stateKey = "identity|" + evt.RepoIdentity.Did
+		state = evt.RepoIdentity.Time + "|" + handle
+	case evt.RepoAccount != nil:
+		status := ""
+		if evt.RepoAccount.Status != nil {
+			status = *evt.RepoAccount.Status
+		}
+		stateKey = "account|" + evt.RepoAccount.Did
+		state = fmt.Sprintf("%t|%s", evt.RepoAccount.Active, status)
+	default:
+		return "unsupported", nil
+	}
+
+	if stateKey != "" {
+		if last, ok := fi.states.Get(stateKey); ok && last == state {
+			return "duplicate", nil
+		}
+		fi.states.Add(stateKey, state)
+		return "accepted", nil
+	}
+
+	last, err := fi.lastRev(did)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case last == "" || rev > last:
+		return "accepted", nil
+	case rev == last:
+		return "duplicate", nil
+	default:
+		return "stale", nil
+	}
+}
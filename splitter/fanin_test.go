@@ -0,0 +1,136 @@
+package splitter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/pebblepersist"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanInDedup(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	conf := SplitterConfig{
+		UpstreamHost: "relay1.example.com",
+		FanInHosts:   []string{"relay1.example.com", "relay2.example.com"},
+		FanInDBPath:  filepath.Join(dir, "fanin.db"),
+		PebbleOptions: &pebblepersist.PebblePersistOptions{
+			DbPath:          filepath.Join(dir, "events.db"),
+			PersistDuration: time.Hour,
+			GCPeriod:        time.Hour,
+		},
+	}
+	s, err := NewSplitter(conf, nil)
+	assert.NoError(err)
+	fi := s.fanin
+	assert.NotNil(fi)
+	fi.nextSeq = 1
+
+	commit := func(did, rev string, seq int64) *events.XRPCStreamEvent {
+		evt := commitEvent(did, "app.bsky.feed.post/3kabc")
+		evt.RepoCommit.Rev = rev
+		evt.RepoCommit.Seq = seq
+		return evt
+	}
+	handle := "alice.example.com"
+	identity := func(ts string, seq int64) *events.XRPCStreamEvent {
+		return &events.XRPCStreamEvent{
+			RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:abc111", Handle: &handle, Seq: seq, Time: ts},
+		}
+	}
+	deactivated := "deactivated"
+	account := func(active bool, seq int64) *events.XRPCStreamEvent {
+		evt := &events.XRPCStreamEvent{
+			RepoAccount: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:abc111", Active: active, Seq: seq},
+		}
+		if !active {
+			evt.RepoAccount.Status = &deactivated
+		}
+		return evt
+	}
+
+	evts := []struct {
+		host     string
+		evt      *events.XRPCStreamEvent
+		accepted bool
+	}{
+		{"relay1.example.com", commit("did:plc:abc111", "3kaaaaaaaaa22", 500), true},
+		{"relay2.example.com", commit("did:plc:abc111", "3kaaaaaaaaa22", 9000), false},
+		{"relay2.example.com", commit("did:plc:abc111", "3kaaaaaaaaa33", 9001), true},
+		{"relay1.example.com", commit("did:plc:abc111", "3kaaaaaaaaa33", 501), false},
+		{"relay1.example.com", commit("did:plc:abc111", "3kaaaaaaaaa23", 502), false},
+		{"relay1.example.com", commit("did:plc:def222", "3kaaaaaaaaa22", 503), true},
+		{"relay1.example.com", identity("2024-01-02T03:04:05.000Z", 504), true},
+		{"relay2.example.com", identity("2024-01-02T03:04:05.000Z", 9002), false},
+		// a later identity event with the same handle (eg, key rotation) is passed on
+		{"relay2.example.com", identity("2024-01-02T04:00:00.000Z", 9003), true},
+		// changes of state are passed on, even if an identical state was seen recently
+		{"relay1.example.com", account(false, 505), true},
+		{"relay2.example.com", account(false, 9005), false},
+		{"relay1.example.com", account(true, 506), true},
+		{"relay1.example.com", account(false, 507), true},
+		{"relay2.example.com", account(false, 9006), false},
+	}
+	for i, tc := range evts {
+		before := fi.nextSeq
+		assert.NoError(fi.accept(ctx, tc.host, tc.evt))
+		assert.Equal(tc.accepted, fi.nextSeq == before+1, "event %d", i)
+	}
+
+	// accepted events are renumbered in order
+	seq, _, last, err := s.pp.GetLast(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(8), seq)
+	assert.NotNil(last.RepoAccount)
+	assert.Equal(int64(8), last.RepoAccount.Seq)
+	assert.Equal(int64(9), fi.nextSeq)
+
+	// dedup state survives a restart
+	assert.NoError(s.Shutdown())
+	fi, err = newFanIn(s, conf.FanInHosts, conf.FanInDBPath)
+	assert.NoError(err)
+	defer fi.Shutdown()
+	result, err := fi.check(commit("did:plc:abc111", "3kaaaaaaaaa33", 9001))
+	assert.NoError(err)
+	assert.Equal("duplicate", result)
+}
+
+func TestFanInShutdown(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	// upstreams which never accept connections; subscriptions keep redialing until shut down
+	conf := SplitterConfig{
+		UpstreamHost: "localhost:1",
+		FanInHosts:   []string{"localhost:1", "localhost:2"},
+		FanInDBPath:  filepath.Join(dir, "fanin.db"),
+		PebbleOptions: &pebblepersist.PebblePersistOptions{
+			DbPath:          filepath.Join(dir, "events.db"),
+			PersistDuration: time.Hour,
+			GCPeriod:        time.Hour,
+		},
+	}
+	s, err := NewSplitter(conf, nil)
+	assert.NoError(err)
+	assert.NoError(s.fanin.Start(context.Background()))
+
+	// health check fails while no upstreams are connected
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	assert.NoError(s.HandleHealthCheck(e.NewContext(httptest.NewRequest(http.MethodGet, "/xrpc/_health", nil), rec)))
+	assert.Equal(http.StatusServiceUnavailable, rec.Code)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(s.Shutdown())
+}
@@ -0,0 +1,165 @@
+package splitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+)
+
+const (
+	maxFilterCollections = 100
+	maxFilterDIDs        = 10_000
+)
+
+// EventFilter selects the subset of firehose events a subscriber is interested in.
+//
+// Commit events are passed through unmodified (including all ops and blocks) if the repo matches the DID filter and at least one op matches the collection filter; partial commits would not be verifiable by consumers. Other repo events (#identity, #account, #sync) are filtered by DID only. #info and error frames always pass.
+type EventFilter struct {
+	// exact NSIDs
+	Collections map[string]bool
+	// NSID prefixes, from patterns like "app.bsky.graph.*" (stored with the trailing ".")
+	CollectionPrefixes []string
+	DIDs               map[string]bool
+}
+
+// Parses filter query parameters (`wantedCollections` and `wantedDids`, each repeatable). Returns nil (and no error) if no filter was requested.
+func ParseEventFilter(params url.Values) (*EventFilter, error) {
+	collections := params["wantedCollections"]
+	dids := params["wantedDids"]
+	if len(collections) == 0 && len(dids) == 0 {
+		return nil, nil
+	}
+	if len(collections) > maxFilterCollections {
+		return nil, fmt.Errorf("too many wantedCollections (max %d)", maxFilterCollections)
+	}
+	if len(dids) > maxFilterDIDs {
+		return nil, fmt.Errorf("too many wantedDids (max %d)", maxFilterDIDs)
+	}
+
+	f := EventFilter{}
+	if len(collections) > 0 {
+		f.Collections = make(map[string]bool)
+	}
+	for _, c := range collections {
+		if prefix, ok := strings.CutSuffix(c, ".*"); ok {
+			if strings.Count(prefix, ".") < 1 {
+				return nil, fmt.Errorf("collection prefix pattern too broad: %s", c)
+			}
+			f.CollectionPrefixes = append(f.CollectionPrefixes, prefix+".")
+			continue
+		}
+		nsid, err := syntax.ParseNSID(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wantedCollections: %w", err)
+		}
+		f.Collections[nsid.String()] = true
+	}
+	if len(dids) > 0 {
+		f.DIDs = make(map[string]bool, len(dids))
+	}
+	for _, d := range dids {
+		did, err := syntax.ParseDID(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wantedDids: %w", err)
+		}
+		f.DIDs[did.String()] = true
+	}
+	return &f, nil
+}
+
+func (f *EventFilter) matchDID(did string) bool {
+	return f.DIDs == nil || f.DIDs[did]
+}
+
+func (f *EventFilter) matchCollection(collection string) bool {
+	if f.Collections == nil && len(f.CollectionPrefixes) == 0 {
+		return true
+	}
+	if f.Collections[collection] {
+		return true
+	}
+	for _, prefix := range f.CollectionPrefixes {
+		if strings.HasPrefix(collection, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the event should be sent to the subscriber.
+func (f *EventFilter) Match(evt *events.XRPCStreamEvent) bool {
+	if f == nil {
+		return true
+	}
+	switch {
+	case evt.RepoCommit != nil:
+		if !f.matchDID(evt.RepoCommit.Repo) {
+			return false
+		}
+		for _, op := range evt.RepoCommit.Ops {
+			// op paths are "{collection}/{rkey}"; only the collection part is needed
+			collection, _, _ := strings.Cut(op.Path, "/")
+			if f.matchCollection(collection) {
+				return true
+			}
+		}
+		return false
+	case evt.RepoSync != nil:
+		return f.matchDID(evt.RepoSync.Did)
+	case evt.RepoIdentity != nil:
+		return f.matchDID(evt.RepoIdentity.Did)
+	case evt.RepoAccount != nil:
+		return f.matchDID(evt.RepoAccount.Did)
+	default:
+		return true
+	}
+}
+
+// Encodes an event as a JSON object, with the message type in a "$type" field (eg, "com.atproto.sync.subscribeRepos#commit"). Bytes fields (such as commit blocks) are encoded as {"$bytes": base64}.
+func eventToJSON(evt *events.XRPCStreamEvent) ([]byte, error) {
+	var typ string
+	var obj any
+	switch {
+	case evt.Error != nil:
+		typ = "#error"
+		obj = evt.Error
+	case evt.RepoCommit != nil:
+		typ = "#commit"
+		obj = evt.RepoCommit
+	case evt.RepoSync != nil:
+		typ = "#sync"
+		obj = evt.RepoSync
+	case evt.RepoIdentity != nil:
+		typ = "#identity"
+		obj = evt.RepoIdentity
+	case evt.RepoAccount != nil:
+		typ = "#account"
+		obj = evt.RepoAccount
+	case evt.RepoInfo != nil:
+		typ = "#info"
+		obj = evt.RepoInfo
+	default:
+		return nil, fmt.Errorf("unsupported event type for JSON encoding")
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 || b[0] != '{' {
+		return nil, fmt.Errorf("unexpected JSON encoding for event")
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`{"$type":"com.atproto.sync.subscribeRepos`)
+	buf.WriteString(typ)
+	buf.WriteByte('"')
+	if len(b) > 2 {
+		buf.WriteByte(',')
+	}
+	buf.Write(b[1:])
+	return buf.Bytes(), nil
+}
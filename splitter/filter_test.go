@@ -0,0 +1,75 @@
+package splitter
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func commitEvent(did string, paths ...string) *events.XRPCStreamEvent {
+	ops := make([]*comatproto.SyncSubscribeRepos_RepoOp, len(paths))
+	for i, p := range paths {
+		ops[i] = &comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: p}
+	}
+	return &events.XRPCStreamEvent{
+		RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+			Repo:   did,
+			Seq:    123,
+			Ops:    ops,
+			Commit: lexutil.LexLink(cid.MustParse("bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq")),
+		},
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ParseEventFilter(url.Values{})
+	assert.NoError(err)
+	assert.Nil(f)
+	assert.True(f.Match(commitEvent("did:plc:abc111", "app.bsky.feed.post/3kabc")))
+
+	_, err = ParseEventFilter(url.Values{"wantedCollections": {"not an nsid"}})
+	assert.Error(err)
+	_, err = ParseEventFilter(url.Values{"wantedCollections": {"app.*"}})
+	assert.Error(err)
+	_, err = ParseEventFilter(url.Values{"wantedDids": {"bob"}})
+	assert.Error(err)
+
+	f, err = ParseEventFilter(url.Values{"wantedCollections": {"app.bsky.feed.post", "app.bsky.graph.*"}})
+	assert.NoError(err)
+	assert.True(f.Match(commitEvent("did:plc:abc111", "app.bsky.feed.like/3kabc", "app.bsky.feed.post/3kabc")))
+	assert.True(f.Match(commitEvent("did:plc:abc111", "app.bsky.graph.follow/3kabc")))
+	assert.False(f.Match(commitEvent("did:plc:abc111", "app.bsky.feed.like/3kabc")))
+	assert.False(f.Match(commitEvent("did:plc:abc111", "app.bsky.graphx.follow/3kabc")))
+	assert.False(f.Match(commitEvent("did:plc:abc111")))
+	// non-commit events are not filtered by collection
+	assert.True(f.Match(&events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:abc111"}}))
+
+	f, err = ParseEventFilter(url.Values{"wantedCollections": {"app.bsky.feed.post"}, "wantedDids": {"did:plc:abc111"}})
+	assert.NoError(err)
+	assert.True(f.Match(commitEvent("did:plc:abc111", "app.bsky.feed.post/3kabc")))
+	assert.False(f.Match(commitEvent("did:plc:abc222", "app.bsky.feed.post/3kabc")))
+	assert.True(f.Match(&events.XRPCStreamEvent{RepoAccount: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:abc111"}}))
+	assert.False(f.Match(&events.XRPCStreamEvent{RepoAccount: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:abc222"}}))
+	assert.True(f.Match(&events.XRPCStreamEvent{RepoInfo: &comatproto.SyncSubscribeRepos_Info{Name: "OutdatedCursor"}}))
+}
+
+func TestEventToJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := eventToJSON(commitEvent("did:plc:abc111", "app.bsky.feed.post/3kabc"))
+	assert.NoError(err)
+	var out map[string]any
+	assert.NoError(json.Unmarshal(b, &out))
+	assert.Equal("com.atproto.sync.subscribeRepos#commit", out["$type"])
+	assert.Equal("did:plc:abc111", out["repo"])
+	assert.Equal(float64(123), out["seq"])
+}
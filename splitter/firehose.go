@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/xrpc"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -26,6 +27,19 @@ func (s *Splitter) HandleSubscribeRepos(c echo.Context) error {
 		since = &sval
 	}
 
+	filter, err := ParseEventFilter(c.QueryParams())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: err.Error()})
+	}
+	jsonEncoding := false
+	switch c.QueryParam("encoding") {
+	case "", "cbor":
+	case "json":
+		jsonEncoding = true
+	default:
+		return c.JSON(http.StatusBadRequest, xrpc.XRPCError{ErrStr: "BadRequest", Message: "encoding must be 'cbor' or 'json'"})
+	}
+
 	// NOTE: the request context outlives the HTTP 101 response; it lives as long as the WebSocket is open, and then get cancelled. That is the behavior we want for this ctx, but should be careful if spawning goroutines which should outlive the WebSocket connection.
 	// https://github.com/bluesky-social/indigo/pull/1023#pullrequestreview-2768335762
 	ctx, cancel := context.WithCancel(c.Request().Context())
@@ -95,7 +109,8 @@ func (s *Splitter) HandleSubscribeRepos(c echo.Context) error {
 
 	ident := c.RealIP() + "-" + c.Request().UserAgent()
 
-	evts, cleanup, err := s.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool { return true }, since)
+	// NOTE: the subscription filter only applies to live events, not playback, so events are also checked in the loop below
+	evts, cleanup, err := s.events.Subscribe(ctx, ident, filter.Match, since)
 	if err != nil {
 		return err
 	}
@@ -118,6 +133,8 @@ func (s *Splitter) HandleSubscribeRepos(c echo.Context) error {
 		"user_agent", consumer.UserAgent,
 		"cursor", since,
 		"consumer_id", consumerID,
+		"filtered", filter != nil,
+		"json", jsonEncoding,
 	)
 	activeClientGauge.Inc()
 	defer activeClientGauge.Dec()
@@ -130,13 +147,28 @@ func (s *Splitter) HandleSubscribeRepos(c echo.Context) error {
 				return nil
 			}
 
-			wc, err := conn.NextWriter(websocket.BinaryMessage)
+			if !filter.Match(evt) {
+				eventsFilteredCounter.Inc()
+				continue
+			}
+
+			msgType := websocket.BinaryMessage
+			if jsonEncoding {
+				msgType = websocket.TextMessage
+			}
+			wc, err := conn.NextWriter(msgType)
 			if err != nil {
 				s.logger.Error("failed to get next writer", "err", err)
 				return err
 			}
 
-			if evt.Preserialized != nil {
+			if jsonEncoding {
+				var b []byte
+				b, err = eventToJSON(evt)
+				if err == nil {
+					_, err = wc.Write(b)
+				}
+			} else if evt.Preserialized != nil {
 				_, err = wc.Write(evt.Preserialized)
 			} else {
 				err = evt.Serialize(wc)
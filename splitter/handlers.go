@@ -20,6 +20,9 @@ type HealthStatus struct {
 }
 
 func (s *Splitter) HandleHealthCheck(c echo.Context) error {
+	if s.fanin != nil && s.fanin.ConnectedUpstreams() == 0 {
+		return c.JSON(http.StatusServiceUnavailable, HealthStatus{Status: "error", Message: "no upstreams connected"})
+	}
 	return c.JSON(http.StatusOK, HealthStatus{Status: "ok"})
 }
 
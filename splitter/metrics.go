@@ -14,3 +14,18 @@ var activeClientGauge = promauto.NewGauge(prometheus.GaugeOpts{
 	Name: "spl_active_clients",
 	Help: "Current number of active clients",
 })
+
+var eventsFilteredCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_events_filtered_counter",
+	Help: "The total number of events skipped for consumers with collection or DID filters (during playback)",
+})
+
+var faninEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spl_fanin_events",
+	Help: "Events received from fan-in upstreams, by whether they were passed on or deduplicated",
+}, []string{"upstream", "result"})
+
+var faninUpstreamConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "spl_fanin_upstream_connected",
+	Help: "Whether each fan-in upstream currently has an open connection",
+}, []string{"upstream"})
@@ -45,6 +45,9 @@ type Splitter struct {
 	upstreamClient *http.Client
 	peerClient     *http.Client
 	nextCrawlers   []url.URL
+
+	// set when subscribing to multiple upstreams
+	fanin *fanIn
 }
 
 type SplitterConfig struct {
@@ -54,14 +57,23 @@ type SplitterConfig struct {
 	UserAgent         string
 	PebbleOptions     *pebblepersist.PebblePersistOptions
 	Logger            *slog.Logger
+
+	// if set, the firehose is merged from these hosts instead of UpstreamHost, which is still used for proxied requests. Requires PebbleOptions.
+	FanInHosts []string
+	// path of the pebble database holding fan-in cursors and deduplication state
+	FanInDBPath string
 }
 
 func (sc *SplitterConfig) UpstreamHostWebsocket() string {
+	return hostWebsocketURL(sc.UpstreamHost)
+}
 
-	if !strings.Contains(sc.UpstreamHost, "://") {
-		return "wss://" + sc.UpstreamHost
+func hostWebsocketURL(host string) string {
+
+	if !strings.Contains(host, "://") {
+		return "wss://" + host
 	}
-	u, err := url.Parse(sc.UpstreamHost)
+	u, err := url.Parse(host)
 	if err != nil {
 		// this will cause an error downstream
 		return ""
@@ -160,6 +172,20 @@ func NewSplitter(conf SplitterConfig, nextCrawlers []string) (*Splitter, error)
 		s.events = events.NewEventManager(pp)
 	}
 
+	if len(conf.FanInHosts) > 0 {
+		if s.pp == nil {
+			return nil, fmt.Errorf("fan-in from multiple upstreams requires persistent storage")
+		}
+		if conf.FanInDBPath == "" {
+			return nil, fmt.Errorf("fan-in from multiple upstreams requires a database path")
+		}
+		fi, err := newFanIn(s, conf.FanInHosts, conf.FanInDBPath)
+		if err != nil {
+			return nil, err
+		}
+		s.fanin = fi
+	}
+
 	return s, nil
 }
 
@@ -168,12 +194,18 @@ func (s *Splitter) StartAPI(addr string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	curs, err := s.getLastCursor()
-	if err != nil {
-		return fmt.Errorf("loading cursor failed: %w", err)
-	}
+	if s.fanin != nil {
+		if err := s.fanin.Start(context.Background()); err != nil {
+			return fmt.Errorf("starting fan-in failed: %w", err)
+		}
+	} else {
+		curs, err := s.getLastCursor()
+		if err != nil {
+			return fmt.Errorf("loading cursor failed: %w", err)
+		}
 
-	go s.subscribeWithRedialer(context.Background(), curs)
+		go s.subscribeWithRedialer(context.Background(), curs)
+	}
 
 	li, err := lc.Listen(ctx, "tcp", addr)
 	if err != nil {
@@ -188,6 +220,9 @@ func (s *Splitter) StartMetrics(listen string) error {
 }
 
 func (s *Splitter) Shutdown() error {
+	if s.fanin != nil {
+		return s.fanin.Shutdown()
+	}
 	return nil
 }
 
@@ -407,6 +407,43 @@ func TestAccountEvent(t *testing.T) {
 	assert.Equal(*acevt.RepoAccount.Status, events.AccountStatusActive)
 }
 
+func TestAccountDeleteEvent(t *testing.T) {
+	assert := assert.New(t)
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".pdsuno", didr)
+	p1.Run(t)
+
+	b1 := MustSetupRelay(t, didr, true)
+	b1.Run(t)
+
+	b1.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, b1)
+	p1.BumpLimits(t, b1)
+	time.Sleep(time.Millisecond * 50)
+
+	evts := b1.Events(t, -1)
+
+	u := p1.MustNewUser(t, usernames[0]+".pdsuno")
+	time.Sleep(time.Millisecond * 50)
+
+	u.DeleteAccount(t)
+
+	time.Sleep(time.Millisecond * 100)
+
+	initevt := evts.Next()
+	t.Log(initevt.RepoCommit)
+
+	acevt := evts.Next()
+	t.Log(acevt.RepoAccount)
+	assert.Equal(acevt.RepoAccount.Did, u.DID())
+	assert.Equal(acevt.RepoAccount.Active, false)
+	assert.Equal(*acevt.RepoAccount.Status, events.AccountStatusDeleted)
+
+	// the handle can be registered again
+	p1.MustNewUser(t, usernames[0]+".pdsuno")
+}
+
 func TestRelayTakedown(t *testing.T) {
 	testRelayTakedown(t, true)
 }
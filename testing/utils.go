@@ -55,6 +55,10 @@ type TestPDS struct {
 	listener net.Listener
 
 	shutdown func()
+
+	// account tokens "emailed" by the PDS, by email and purpose
+	lk     sync.Mutex
+	tokens map[string]string
 }
 
 // RawHost returns a host:port string that the PDS server is running at
@@ -143,11 +147,28 @@ func SetupPDS(ctx context.Context, suffix string, plc plc.PLCClient) (*TestPDS,
 		return nil, err
 	}
 
-	return &TestPDS{
+	tp := &TestPDS{
 		dir:      dir,
 		server:   srv,
 		listener: li,
-	}, nil
+		tokens:   make(map[string]string),
+	}
+	srv.SetEmailSender(func(ctx context.Context, email, purpose, token string) error {
+		tp.lk.Lock()
+		defer tp.lk.Unlock()
+		tp.tokens[email+"/"+purpose] = token
+		return nil
+	})
+
+	return tp, nil
+}
+
+// AccountToken returns the most recent account token sent to the given email
+// address for the given purpose
+func (tp *TestPDS) AccountToken(email, purpose string) string {
+	tp.lk.Lock()
+	defer tp.lk.Unlock()
+	return tp.tokens[email+"/"+purpose]
 }
 
 func (tp *TestPDS) Run(t *testing.T) {
@@ -480,6 +501,30 @@ func (u *TestUser) ChangeHandle(t *testing.T, nhandle string) {
 	}
 }
 
+// DeleteAccount deletes the user's account through the same
+// requestAccountDelete/deleteAccount flow a real client would use
+func (u *TestUser) DeleteAccount(t *testing.T) {
+	t.Helper()
+
+	ctx := context.TODO()
+	if err := atproto.ServerRequestAccountDelete(ctx, u.client); err != nil {
+		t.Fatal(err)
+	}
+
+	token := u.pds.AccountToken(u.handle+"@fake.com", "delete_account")
+	if token == "" {
+		t.Fatal("no account deletion token was sent")
+	}
+
+	if err := atproto.ServerDeleteAccount(ctx, u.client, &atproto.ServerDeleteAccount_Input{
+		Did:      u.did,
+		Password: "password",
+		Token:    token,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPLC(t *testing.T) *plc.FakeDid {
 	// TODO: just do in memory...
 	tdir, err := os.MkdirTemp("", "plcserv")
@@ -0,0 +1,23 @@
+package promutil
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterShared registers a collector with the default registry (like promauto), or returns the existing collector if one of the same name and type was already registered.
+//
+// This is for metrics which are defined by more than one package that can be linked in to the same program: the relay's forks of `indigo:events` (`cmd/relay/stream`) define the same stream and scheduler metrics as the originals, and both are used by services importing `cmd/relay/slurper` along with `indigo:events`.
+func RegisterShared[T prometheus.Collector](c T) T {
+	if err := prometheus.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,25 @@
+package promutil
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterShared(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := prometheus.CounterOpts{Name: "promutil_test_total", Help: "Test counter"}
+	first := RegisterShared(prometheus.NewCounterVec(opts, []string{"label"}))
+	defer prometheus.Unregister(first)
+
+	// a second definition gets the collector which was registered first
+	second := RegisterShared(prometheus.NewCounterVec(opts, []string{"label"}))
+	assert.Same(first, second)
+
+	// conflicting definitions still panic
+	assert.Panics(func() {
+		RegisterShared(prometheus.NewGauge(prometheus.GaugeOpts{Name: "promutil_test_total", Help: "Test counter"}))
+	})
+}